@@ -119,8 +119,14 @@ func Register() *App {
 	VLAN{}.Commands(app)
 	Address{}.Commands(app)
 	Forward{}.Commands(app)
+	Trace{}.Commands(app)
 	SNAT{}.Commands(app)
 	DNAT{}.Commands(app)
+	StaticNAT{}.Commands(app)
+	Conntrack{}.Commands(app)
+	VRF{}.Commands(app)
+	Config{}.Commands(app)
+	Apply{}.Commands(app)
 
 	return app
 }
@@ -0,0 +1,53 @@
+package sub
+
+import (
+	"os"
+
+	"github.com/luscis/openvrr/pkg/schema"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
+)
+
+type Apply struct {
+	Cmd
+}
+
+func (u Apply) Url(prefix string) string {
+	return prefix + "/api/config"
+}
+
+func (u Apply) Apply(c *cli.Context) error {
+	url := u.Url(c.String("url"))
+	if c.Bool("dry-run") {
+		url += "?dryRun=true"
+	}
+
+	contents, err := os.ReadFile(c.String("file"))
+	if err != nil {
+		return err
+	}
+	data := &schema.Config{}
+	if err := yaml.UnmarshalStrict(contents, data); err != nil {
+		return err
+	}
+
+	var diff schema.ConfigDiff
+	clt := u.NewHttp(c.String("token"))
+	if err := clt.PostJSON(url, data, &diff); err != nil {
+		return err
+	}
+
+	return u.Out(diff, c.String("format"))
+}
+
+func (u Apply) Commands(app *App) {
+	app.Command(&cli.Command{
+		Name:  "apply",
+		Usage: "Converge to the configuration of a file",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Required: true},
+			&cli.BoolFlag{Name: "dry-run", Usage: "only print the plan"},
+		},
+		Action: u.Apply,
+	})
+}
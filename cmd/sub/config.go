@@ -0,0 +1,58 @@
+package sub
+
+import (
+	"github.com/luscis/openvrr/pkg/schema"
+	"github.com/urfave/cli/v2"
+)
+
+type Config struct {
+	Cmd
+}
+
+func (u Config) Url(prefix string) string {
+	return prefix + "/api/config"
+}
+
+func (u Config) Save(c *cli.Context) error {
+	url := u.Url(c.String("url")) + "/save"
+
+	var data schema.Config
+	clt := u.NewHttp(c.String("token"))
+	if err := clt.PostJSON(url, nil, &data); err != nil {
+		return err
+	}
+
+	return u.Out(data, c.String("format"))
+}
+
+func (u Config) Show(c *cli.Context) error {
+	url := u.Url(c.String("url"))
+
+	var data schema.Config
+	clt := u.NewHttp(c.String("token"))
+	if err := clt.GetJSON(url, &data); err != nil {
+		return err
+	}
+
+	return u.Out(data, c.String("format"))
+}
+
+func (u Config) Commands(app *App) {
+	app.Command(&cli.Command{
+		Name:   "config",
+		Usage:  "Persistent configuration",
+		Action: u.Show,
+		Subcommands: []*cli.Command{
+			{
+				Name:   "save",
+				Usage:  "Save the running configuration",
+				Action: u.Save,
+			},
+			{
+				Name:   "show",
+				Usage:  "Show the saved configuration",
+				Action: u.Show,
+			},
+		},
+	})
+}
@@ -0,0 +1,171 @@
+package sub
+
+import (
+	"net/url"
+
+	"github.com/luscis/openvrr/pkg/schema"
+	"github.com/urfave/cli/v2"
+)
+
+type Conntrack struct {
+	Cmd
+}
+
+func (u Conntrack) Url(prefix string) string {
+	return prefix + "/api/conntrack"
+}
+
+func (u Conntrack) Filter(c *cli.Context) schema.ConntrackFilter {
+	return schema.ConntrackFilter{
+		Protocol: c.String("protocol"),
+		Source:   c.String("source"),
+		Dest:     c.String("dest"),
+	}
+}
+
+func (u Conntrack) List(c *cli.Context) error {
+	filter := u.Filter(c)
+	query := url.Values{}
+	for key, value := range map[string]string{
+		"protocol":    filter.Protocol,
+		"source":      filter.Source,
+		"destination": filter.Dest,
+	} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	url := u.Url(c.String("url"))
+	if len(query) > 0 {
+		url += "?" + query.Encode()
+	}
+
+	var items []schema.Conntrack
+	clt := u.NewHttp(c.String("token"))
+	if err := clt.GetJSON(url, &items); err != nil {
+		return err
+	}
+
+	return u.Out(items, c.String("format"))
+}
+
+func (u Conntrack) Flush(c *cli.Context) error {
+	url := u.Url(c.String("url"))
+
+	data := u.Filter(c)
+	var items []schema.Conntrack
+	clt := u.NewHttp(c.String("token"))
+	if err := clt.DeleteJSON(url, &data, &items); err != nil {
+		return err
+	}
+
+	return u.Out(items, c.String("format"))
+}
+
+func (u Conntrack) Limit(c *cli.Context) schema.ConntrackLimit {
+	return schema.ConntrackLimit{
+		Zone:      c.Int("zone"),
+		Source:    c.String("source"),
+		Interface: c.String("interface"),
+		Limit:     c.Uint64("limit"),
+	}
+}
+
+func (u Conntrack) ListLimits(c *cli.Context) error {
+	url := u.Url(c.String("url")) + "/limit"
+
+	var items []schema.ConntrackLimit
+	clt := u.NewHttp(c.String("token"))
+	if err := clt.GetJSON(url, &items); err != nil {
+		return err
+	}
+
+	return u.Out(items, c.String("format"))
+}
+
+func (u Conntrack) SetLimit(c *cli.Context) error {
+	url := u.Url(c.String("url")) + "/limit"
+
+	data := u.Limit(c)
+	var item schema.ConntrackLimit
+	clt := u.NewHttp(c.String("token"))
+	if err := clt.PostJSON(url, &data, &item); err != nil {
+		return err
+	}
+
+	return u.Out(item, c.String("format"))
+}
+
+func (u Conntrack) DelLimit(c *cli.Context) error {
+	url := u.Url(c.String("url")) + "/limit"
+
+	data := u.Limit(c)
+	clt := u.NewHttp(c.String("token"))
+	if err := clt.DeleteJSON(url, &data, nil); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (u Conntrack) Commands(app *App) {
+	flags := func() []cli.Flag {
+		return []cli.Flag{
+			&cli.StringFlag{Name: "protocol", Usage: "tcp, udp, icmp or icmp6"},
+			&cli.StringFlag{Name: "source", Usage: "original source address or prefix"},
+			&cli.StringFlag{Name: "dest", Usage: "original destination address or prefix"},
+		}
+	}
+	app.Command(&cli.Command{
+		Name:   "conntrack",
+		Usage:  "Sessions of the NAT zone",
+		Action: u.List,
+		Subcommands: []*cli.Command{
+			{
+				Name:   "list",
+				Usage:  "List the sessions",
+				Flags:  flags(),
+				Action: u.List,
+			},
+			{
+				Name:   "flush",
+				Usage:  "Flush the sessions, all of them without a filter",
+				Flags:  flags(),
+				Action: u.Flush,
+			},
+			{
+				Name:   "limit",
+				Usage:  "Limits of the sessions of zones, sources or VLANs",
+				Action: u.ListLimits,
+				Subcommands: []*cli.Command{
+					{
+						Name:   "list",
+						Usage:  "List the limits with the sessions counted",
+						Action: u.ListLimits,
+					},
+					{
+						Name:  "set",
+						Usage: "Limit a zone, or the sessions of a source prefix or VLAN interface",
+						Flags: []cli.Flag{
+							&cli.IntFlag{Name: "zone", Usage: "zone, one is given to a quota without it"},
+							&cli.StringFlag{Name: "source", Usage: "source prefix of a quota"},
+							&cli.StringFlag{Name: "interface", Usage: "VLAN interface of a quota"},
+							&cli.Uint64Flag{Name: "limit", Required: true, Usage: "sessions of the zone, shared by all the hosts of a quota"},
+						},
+						Action: u.SetLimit,
+					},
+					{
+						Name:  "remove",
+						Usage: "Remove the limit of a zone, or a quota",
+						Flags: []cli.Flag{
+							&cli.IntFlag{Name: "zone"},
+							&cli.StringFlag{Name: "source"},
+							&cli.StringFlag{Name: "interface"},
+						},
+						Action: u.DelLimit,
+					},
+				},
+			},
+		},
+	})
+}
@@ -25,6 +25,18 @@ func (u Forward) List(c *cli.Context) error {
 	return u.Out(items, c.String("format"))
 }
 
+func (u Forward) Queue(c *cli.Context) error {
+	url := u.Url(c.String("url")) + "/queue"
+
+	var item schema.FlowQueue
+	clt := u.NewHttp(c.String("token"))
+	if err := clt.GetJSON(url, &item); err != nil {
+		return err
+	}
+
+	return u.Out(item, c.String("format"))
+}
+
 func (u Forward) Commands(app *App) {
 	app.Command(&cli.Command{
 		Name:   "forward",
@@ -36,6 +48,11 @@ func (u Forward) Commands(app *App) {
 				Usage:  "List all ip forward route",
 				Action: u.List,
 			},
+			{
+				Name:   "queue",
+				Usage:  "Show the queue programming routes",
+				Action: u.Queue,
+			},
 		},
 	})
 }
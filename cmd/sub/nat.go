@@ -1,6 +1,10 @@
 package sub
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+
 	"github.com/luscis/openvrr/pkg/schema"
 	"github.com/urfave/cli/v2"
 )
@@ -17,8 +21,12 @@ func (u SNAT) Add(c *cli.Context) error {
 	url := u.Url(c.String("url"))
 
 	data := &schema.SNAT{
-		Source:   c.String("source"),
-		SourceTo: c.String("source-to"),
+		Source:     c.String("source"),
+		SourceTo:   c.String("source-to"),
+		Interface:  c.String("interface"),
+		Ports:      c.String("ports"),
+		Mode:       c.String("mode"),
+		Persistent: c.Bool("persistent"),
 	}
 
 	clt := u.NewHttp(c.String("token"))
@@ -67,7 +75,11 @@ func (u SNAT) Commands(app *App) {
 				Usage: "Add a snat",
 				Flags: []cli.Flag{
 					&cli.StringFlag{Name: "source", Required: true},
-					&cli.StringFlag{Name: "source-to", Required: true},
+					&cli.StringFlag{Name: "source-to", Usage: "address or range A-B of addresses"},
+					&cli.StringFlag{Name: "interface", Usage: "masquerade behind the address of a VLAN interface, instead of source-to"},
+					&cli.StringFlag{Name: "ports", Usage: "range P1-P2 of source ports"},
+					&cli.StringFlag{Name: "mode", Usage: "random or hash selection of the address and port"},
+					&cli.BoolFlag{Name: "persistent", Usage: "same address for all the sessions of a client"},
 				},
 				Action: u.Add,
 			},
@@ -100,9 +112,24 @@ func (u DNAT) Add(c *cli.Context) error {
 	url := u.Url(c.String("url"))
 
 	data := &schema.DNAT{
-		Dest:     c.String("dest"),
-		DestTo:   c.String("dest-to"),
-		Protocol: c.String("protocol"),
+		Dest:      c.String("dest"),
+		DestTo:    c.String("dest-to"),
+		Protocol:  c.String("protocol"),
+		Check:     c.String("check"),
+		CheckPath: c.String("check-path"),
+	}
+	// A backend is given as ip:port[@weight].
+	for _, value := range c.StringSlice("backend") {
+		addr, weight, found := strings.Cut(value, "@")
+		backend := schema.Backend{Address: addr}
+		if found {
+			w, err := strconv.Atoi(weight)
+			if err != nil {
+				return fmt.Errorf("invalid weight of %s", value)
+			}
+			backend.Weight = w
+		}
+		data.Backends = append(data.Backends, backend)
 	}
 
 	clt := u.NewHttp(c.String("token"))
@@ -151,9 +178,12 @@ func (u DNAT) Commands(app *App) {
 				Name:  "add",
 				Usage: "Add a dnat",
 				Flags: []cli.Flag{
-					&cli.StringFlag{Name: "protocol", Value: "tcp"},
-					&cli.StringFlag{Name: "dest", Required: true},
-					&cli.StringFlag{Name: "dest-to", Required: true},
+					&cli.StringFlag{Name: "protocol", Value: "tcp", Usage: "tcp, udp, icmp, or ip for all protocols"},
+					&cli.StringFlag{Name: "dest", Required: true, Usage: "ip:port or ip:start-end, ip for icmp and ip"},
+					&cli.StringFlag{Name: "dest-to", Usage: "ip[:start], the ports are kept without start"},
+					&cli.StringSliceFlag{Name: "backend", Usage: "ip:port[@weight], instead of dest-to"},
+					&cli.StringFlag{Name: "check", Usage: "health check of the backends, tcp or http"},
+					&cli.StringFlag{Name: "check-path", Usage: "path of the http check"},
 				},
 				Action: u.Add,
 			},
@@ -174,3 +204,92 @@ func (u DNAT) Commands(app *App) {
 		},
 	})
 }
+
+type StaticNAT struct {
+	Cmd
+}
+
+func (u StaticNAT) Url(prefix string) string {
+	return prefix + "/api/staticnat"
+}
+
+func (u StaticNAT) Add(c *cli.Context) error {
+	url := u.Url(c.String("url"))
+
+	data := &schema.StaticNAT{
+		External: c.String("external"),
+		Internal: c.String("internal"),
+	}
+
+	clt := u.NewHttp(c.String("token"))
+	if err := clt.PostJSON(url, data, nil); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (u StaticNAT) Remove(c *cli.Context) error {
+	url := u.Url(c.String("url"))
+
+	data := &schema.StaticNAT{
+		External: c.String("external"),
+	}
+
+	clt := u.NewHttp(c.String("token"))
+	if err := clt.DeleteJSON(url, data, nil); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (u StaticNAT) List(c *cli.Context) error {
+	url := u.Url(c.String("url"))
+
+	var items []schema.StaticNAT
+	clt := u.NewHttp(c.String("token"))
+	if err := clt.GetJSON(url, &items); err != nil {
+		return err
+	}
+
+	return u.Out(items, c.String("format"))
+}
+
+func (u StaticNAT) Commands(app *App) {
+	app.Command(&cli.Command{
+		Name:  "nat",
+		Usage: "Network address translation",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "static",
+				Usage:  "One to one NAT of an external address to an internal host",
+				Action: u.List,
+				Subcommands: []*cli.Command{
+					{
+						Name:  "add",
+						Usage: "Add a static nat",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "external", Required: true},
+							&cli.StringFlag{Name: "internal", Required: true},
+						},
+						Action: u.Add,
+					},
+					{
+						Name:  "remove",
+						Usage: "Remove a static nat",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "external", Required: true},
+						},
+						Action: u.Remove,
+					},
+					{
+						Name:   "list",
+						Usage:  "List all static nats",
+						Action: u.List,
+					},
+				},
+			},
+		},
+	})
+}
@@ -0,0 +1,50 @@
+package sub
+
+import (
+	"github.com/luscis/openvrr/pkg/schema"
+	"github.com/urfave/cli/v2"
+)
+
+type Trace struct {
+	Cmd
+}
+
+func (u Trace) Url(prefix string) string {
+	return prefix + "/api/trace"
+}
+
+func (u Trace) Trace(c *cli.Context) error {
+	url := u.Url(c.String("url"))
+
+	data := &schema.Packet{
+		InPort:   c.String("in-port"),
+		Vlan:     c.Int("vlan"),
+		Source:   c.String("src"),
+		Dest:     c.String("dst"),
+		Protocol: c.String("proto"),
+		DestPort: c.Int("dport"),
+	}
+	var item schema.Trace
+	clt := u.NewHttp(c.String("token"))
+	if err := clt.PostJSON(url, data, &item); err != nil {
+		return err
+	}
+
+	return u.Out(item, c.String("format"))
+}
+
+func (u Trace) Commands(app *App) {
+	app.Command(&cli.Command{
+		Name:  "trace",
+		Usage: "Trace a packet through the pipeline",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "in-port", Required: true},
+			&cli.IntFlag{Name: "vlan", Usage: "tag of the packet on a trunk"},
+			&cli.StringFlag{Name: "src", Required: true},
+			&cli.StringFlag{Name: "dst", Required: true},
+			&cli.StringFlag{Name: "proto", Usage: "ip, tcp, udp or icmp"},
+			&cli.IntFlag{Name: "dport"},
+		},
+		Action: u.Trace,
+	})
+}
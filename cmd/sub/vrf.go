@@ -0,0 +1,93 @@
+package sub
+
+import (
+	"github.com/luscis/openvrr/pkg/schema"
+	"github.com/urfave/cli/v2"
+)
+
+type VRF struct {
+	Cmd
+}
+
+func (u VRF) Url(prefix string) string {
+	return prefix + "/api/vrf"
+}
+
+func (u VRF) Add(c *cli.Context) error {
+	url := u.Url(c.String("url"))
+
+	data := &schema.VRF{
+		Name:       c.String("name"),
+		Table:      c.Int("table"),
+		Interfaces: c.StringSlice("interface"),
+	}
+
+	clt := u.NewHttp(c.String("token"))
+	if err := clt.PostJSON(url, data, nil); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (u VRF) Remove(c *cli.Context) error {
+	url := u.Url(c.String("url"))
+
+	data := &schema.VRF{
+		Name:       c.String("name"),
+		Interfaces: c.StringSlice("interface"),
+	}
+
+	clt := u.NewHttp(c.String("token"))
+	if err := clt.DeleteJSON(url, data, nil); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (u VRF) List(c *cli.Context) error {
+	url := u.Url(c.String("url"))
+
+	var items []schema.VRF
+	clt := u.NewHttp(c.String("token"))
+	if err := clt.GetJSON(url, &items); err != nil {
+		return err
+	}
+
+	return u.Out(items, c.String("format"))
+}
+
+func (u VRF) Commands(app *App) {
+	app.Command(&cli.Command{
+		Name:   "vrf",
+		Usage:  "Virtual routing and forwarding",
+		Action: u.List,
+		Subcommands: []*cli.Command{
+			{
+				Name:  "add",
+				Usage: "Add a vrf, or assign interfaces to it",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "name", Required: true},
+					&cli.IntFlag{Name: "table"},
+					&cli.StringSliceFlag{Name: "interface"},
+				},
+				Action: u.Add,
+			},
+			{
+				Name:  "remove",
+				Usage: "Remove a vrf, or release interfaces from it",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "name", Required: true},
+					&cli.StringSliceFlag{Name: "interface"},
+				},
+				Action: u.Remove,
+			},
+			{
+				Name:   "list",
+				Usage:  "List all vrfs",
+				Action: u.List,
+			},
+		},
+	})
+}
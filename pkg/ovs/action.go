@@ -195,6 +195,8 @@ const (
 	patResubmitPortTable           = "resubmit(%s,%s)"
 	patLearn                       = "learn(%s)"
 	patClearCt                     = "ct_clear"
+	patGroup                       = "group:%d"
+	patMeter                       = "meter:%d"
 )
 
 func ClearCt() Action {
@@ -766,3 +768,48 @@ func (a *popAction) MarshalText() ([]byte, error) {
 
 	return bprintf("pop:%s", a.dst), nil
 }
+
+// GotoGroup sends a packet to the OpenFlow group with the specified ID.
+func GotoGroup(id uint32) Action {
+	return &groupAction{
+		id: id,
+	}
+}
+
+// A groupAction is an Action which is used by GotoGroup.
+type groupAction struct {
+	id uint32
+}
+
+// GoString implements Action.
+func (a *groupAction) GoString() string {
+	return fmt.Sprintf("ovs.GotoGroup(%d)", a.id)
+}
+
+// MarshalText implements Action.
+func (a *groupAction) MarshalText() ([]byte, error) {
+	return bprintf(patGroup, a.id), nil
+}
+
+// ApplyMeter applies the OpenFlow meter with the specified ID to a packet,
+// it must be the first action of a flow.
+func ApplyMeter(id uint32) Action {
+	return &meterAction{
+		id: id,
+	}
+}
+
+// A meterAction is an Action which is used by ApplyMeter.
+type meterAction struct {
+	id uint32
+}
+
+// GoString implements Action.
+func (a *meterAction) GoString() string {
+	return fmt.Sprintf("ovs.ApplyMeter(%d)", a.id)
+}
+
+// MarshalText implements Action.
+func (a *meterAction) MarshalText() ([]byte, error) {
+	return bprintf(patMeter, a.id), nil
+}
@@ -814,6 +814,14 @@ func TestActionGoString(t *testing.T) {
 			a: OutputField("in_port"),
 			s: `ovs.OutputField("in_port")`,
 		},
+		{
+			a: GotoGroup(3),
+			s: `ovs.GotoGroup(3)`,
+		},
+		{
+			a: ApplyMeter(1),
+			s: `ovs.ApplyMeter(1)`,
+		},
 		{
 			a: Learn(&LearnedFlow{
 				DeleteLearned:  true,
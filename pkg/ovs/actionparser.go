@@ -325,6 +325,30 @@ func parseAction(s string) (Action, error) {
 		}
 	}
 
+	// ActionGroup, with its group ID
+	if strings.HasPrefix(s, patGroup[:len(patGroup)-2]) {
+		var id uint32
+		n, err := fmt.Sscanf(s, patGroup, &id)
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			return GotoGroup(id), nil
+		}
+	}
+
+	// ActionMeter, with its meter ID
+	if strings.HasPrefix(s, patMeter[:len(patMeter)-2]) {
+		var id uint32
+		n, err := fmt.Sscanf(s, patMeter, &id)
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			return ApplyMeter(id), nil
+		}
+	}
+
 	// ActionResubmit, with both port number and table number
 	if ss := resubmitRe.FindAllStringSubmatch(s, 1); len(ss) > 0 && len(ss[0]) == 3 {
 		var (
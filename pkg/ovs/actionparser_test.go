@@ -257,6 +257,18 @@ func Test_parseAction(t *testing.T) {
 			s: "resubmit(,25)",
 			a: Resubmit(0, 25),
 		},
+		{
+			s:       "group:foo",
+			invalid: true,
+		},
+		{
+			s: "group:7",
+			a: GotoGroup(7),
+		},
+		{
+			s: "meter:1",
+			a: ApplyMeter(1),
+		},
 		{
 			s:       "load:->NXM_OF_ARP_OP[]",
 			invalid: true,
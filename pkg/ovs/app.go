@@ -16,6 +16,7 @@ package ovs
 
 import (
 	"fmt"
+	"net"
 	"strings"
 )
 
@@ -61,6 +62,61 @@ func (a *AppService) ProtoTrace(bridge string, protocol Protocol, matches []Matc
 	return pt, nil
 }
 
+// DumpConntrack returns the connections of a zone, as listed by
+// 'ovs-appctl dpctl/dump-conntrack zone=N'.
+func (a *AppService) DumpConntrack(zone int) ([]*ConntrackEntry, error) {
+	out, err := a.exec("dpctl/dump-conntrack", fmt.Sprintf("zone=%d", zone))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*ConntrackEntry
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		entry := &ConntrackEntry{}
+		if err := entry.UnmarshalText([]byte(line)); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// FlushConntrack deletes the connection of the original tuple of an
+// entry from a zone, or all connections of the zone if entry is nil.
+func (a *AppService) FlushConntrack(zone int, entry *ConntrackEntry) error {
+	args := []string{"dpctl/flush-conntrack", fmt.Sprintf("zone=%d", zone)}
+	if entry != nil {
+		tuple, err := entry.MarshalText()
+		if err != nil {
+			return err
+		}
+		args = append(args, string(tuple))
+	}
+	_, err := a.exec(args...)
+	return err
+}
+
+// FlushConntrackReply deletes at once the connections of a zone whose
+// reply goes to an address, as those translated to it. The original
+// tuple is left empty to match any, partial tuples need Open vSwitch 3.1.
+func (a *AppService) FlushConntrackReply(zone int, destination string) error {
+	ip := net.ParseIP(destination)
+	if ip == nil {
+		return fmt.Errorf("invalid address %q", destination)
+	}
+	nw := "nw"
+	if ip.To4() == nil {
+		nw = "ipv6"
+	}
+	_, err := a.exec("dpctl/flush-conntrack", fmt.Sprintf("zone=%d", zone),
+		"", fmt.Sprintf("ct_%s_dst=%s", nw, destination))
+	return err
+}
+
 // exec executes 'ovs-appctl' + args passed in
 func (a *AppService) exec(args ...string) ([]byte, error) {
 	return a.c.exec("ovs-appctl", args...)
@@ -57,6 +57,12 @@ type Client struct {
 
 	// Implementation of PipeFunc.
 	pipeFunc PipeFunc
+
+	// Used by VSwitch instead of 'ovs-vsctl' if set.
+	ovsdb *OVSDBClient
+
+	// Used by OpenFlow instead of 'ovs-ofctl' for flows if set.
+	ofdir string
 }
 
 // An ExecFunc is a function which accepts input arguments and returns raw
@@ -287,6 +293,23 @@ func Pipe(fn PipeFunc) OptionFunc {
 	}
 }
 
+// NativeOpenFlow returns an OptionFunc which makes the OpenFlowService
+// program flows over the management sockets of the bridges in dir,
+// instead of running 'ovs-ofctl' for each of them.
+func NativeOpenFlow(dir string) OptionFunc {
+	return func(c *Client) {
+		c.ofdir = dir
+	}
+}
+
+// OVSDB returns an OptionFunc which makes the VSwitchService talk to
+// ovsdb-server with the OVSDBClient instead of running 'ovs-vsctl'.
+func OVSDB(db *OVSDBClient) OptionFunc {
+	return func(c *Client) {
+		c.ovsdb = db
+	}
+}
+
 const (
 	// FlowFormatNXMTableID is a flow format which allows Nicira Extended match
 	// with the ability to place a flow in a specific table.
@@ -0,0 +1,213 @@
+// Copyright 2017 DigitalOcean.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidConntrack is returned when a connection from
+	// 'ovs-appctl dpctl/dump-conntrack' does not match the expected
+	// output format.
+	ErrInvalidConntrack = errors.New("invalid conntrack entry")
+)
+
+// IP protocol numbers of the connections in conntrack tuples.
+var conntrackProtocols = map[string]int{
+	"icmp":  1,
+	"tcp":   6,
+	"udp":   17,
+	"icmp6": 58,
+	"sctp":  132,
+}
+
+// A ConntrackTuple is the addresses and ports of one direction of a
+// connection. ICMP connections have an ID, type and code in place of
+// the ports.
+type ConntrackTuple struct {
+	Source          string
+	Destination     string
+	SourcePort      int
+	DestinationPort int
+	ID              int
+	Type            int
+	Code            int
+}
+
+// UnmarshalText unmarshals a ConntrackTuple from the text between the
+// parentheses of orig=(...) or reply=(...).
+func (c *ConntrackTuple) UnmarshalText(b []byte) error {
+	*c = ConntrackTuple{}
+	for _, field := range strings.Split(string(b), ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return ErrInvalidConntrack
+		}
+
+		var n *int
+		switch kv[0] {
+		case "src":
+			c.Source = kv[1]
+			continue
+		case "dst":
+			c.Destination = kv[1]
+			continue
+		case "sport":
+			n = &c.SourcePort
+		case "dport":
+			n = &c.DestinationPort
+		case "id":
+			n = &c.ID
+		case "type":
+			n = &c.Type
+		case "code":
+			n = &c.Code
+		default:
+			continue
+		}
+		v, err := strconv.Atoi(kv[1])
+		if err != nil {
+			return err
+		}
+		*n = v
+	}
+	return nil
+}
+
+// A ConntrackEntry is a connection tracked by the datapath.
+type ConntrackEntry struct {
+	Protocol string
+	Original ConntrackTuple
+	Reply    ConntrackTuple
+	Zone     int
+	Mark     uint32
+	Timeout  int
+	State    string
+}
+
+// UnmarshalText unmarshals a ConntrackEntry from a line of
+// 'ovs-appctl dpctl/dump-conntrack' output.
+func (c *ConntrackEntry) UnmarshalText(b []byte) error {
+	*c = ConntrackEntry{}
+	fields := splitConntrack(string(b))
+	if len(fields) < 3 || strings.Contains(fields[0], "=") {
+		return ErrInvalidConntrack
+	}
+	c.Protocol = fields[0]
+
+	for _, field := range fields[1:] {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return ErrInvalidConntrack
+		}
+		key, value := kv[0], kv[1]
+
+		switch key {
+		case "orig", "reply", "protoinfo":
+			inner, ok := strings.CutPrefix(value, "(")
+			if inner, ok = strings.CutSuffix(inner, ")"); !ok {
+				return ErrInvalidConntrack
+			}
+			value = inner
+		}
+
+		var err error
+		switch key {
+		case "orig":
+			err = c.Original.UnmarshalText([]byte(value))
+		case "reply":
+			err = c.Reply.UnmarshalText([]byte(value))
+		case "zone":
+			c.Zone, err = strconv.Atoi(value)
+		case "timeout":
+			c.Timeout, err = strconv.Atoi(value)
+		case "mark":
+			var mark uint64
+			mark, err = strconv.ParseUint(value, 0, 32)
+			c.Mark = uint32(mark)
+		case "protoinfo":
+			for _, info := range strings.Split(value, ",") {
+				if state, ok := strings.CutPrefix(info, "state="); ok {
+					c.State = state
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.Original.Source == "" || c.Original.Destination == "" {
+		return ErrInvalidConntrack
+	}
+	return nil
+}
+
+// MarshalText marshals the original tuple of a ConntrackEntry into the
+// form accepted by 'ovs-appctl dpctl/flush-conntrack'.
+func (c *ConntrackEntry) MarshalText() ([]byte, error) {
+	proto, ok := conntrackProtocols[c.Protocol]
+	if !ok {
+		return nil, fmt.Errorf("unsupported conntrack protocol %q", c.Protocol)
+	}
+
+	nw := "nw"
+	if ip := net.ParseIP(c.Original.Source); ip != nil && ip.To4() == nil {
+		nw = "ipv6"
+	}
+	fields := []string{
+		fmt.Sprintf("ct_%s_src=%s", nw, c.Original.Source),
+		fmt.Sprintf("ct_%s_dst=%s", nw, c.Original.Destination),
+		fmt.Sprintf("ct_nw_proto=%d", proto),
+	}
+	switch c.Protocol {
+	case "icmp", "icmp6":
+		fields = append(fields,
+			fmt.Sprintf("icmp_id=%d", c.Original.ID),
+			fmt.Sprintf("icmp_type=%d", c.Original.Type),
+			fmt.Sprintf("icmp_code=%d", c.Original.Code))
+	default:
+		fields = append(fields,
+			fmt.Sprintf("ct_tp_src=%d", c.Original.SourcePort),
+			fmt.Sprintf("ct_tp_dst=%d", c.Original.DestinationPort))
+	}
+	return []byte(strings.Join(fields, ",")), nil
+}
+
+// splitConntrack splits a conntrack entry on the commas out of
+// parentheses.
+func splitConntrack(s string) []string {
+	var fields []string
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				fields = append(fields, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(fields, s[start:])
+}
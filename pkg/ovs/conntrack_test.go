@@ -0,0 +1,151 @@
+// Copyright 2017 DigitalOcean.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClientAppDumpConntrackOK(t *testing.T) {
+	out := `tcp,orig=(src=192.168.1.2,dst=8.8.8.8,sport=51000,dport=443),reply=(src=8.8.8.8,dst=203.0.113.1,sport=443,dport=51000),zone=10,mark=0x10,protoinfo=(state=ESTABLISHED)
+icmp,orig=(src=192.168.1.2,dst=8.8.4.4,id=7,type=8,code=0),reply=(src=8.8.4.4,dst=203.0.113.1,id=7,type=0,code=0),zone=10
+`
+	c := testClient(nil, func(cmd string, args ...string) ([]byte, error) {
+		if want, got := "ovs-appctl", cmd; want != got {
+			t.Fatalf("incorrect command:\n- want: %v\n-  got: %v", want, got)
+		}
+		if want, got := []string{"dpctl/dump-conntrack", "zone=10"}, args; !reflect.DeepEqual(want, got) {
+			t.Fatalf("incorrect arguments:\n- want: %v\n-  got: %v", want, got)
+		}
+		return []byte(out), nil
+	})
+
+	entries, err := c.App.DumpConntrack(10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []*ConntrackEntry{
+		{
+			Protocol: "tcp",
+			Original: ConntrackTuple{Source: "192.168.1.2", Destination: "8.8.8.8", SourcePort: 51000, DestinationPort: 443},
+			Reply:    ConntrackTuple{Source: "8.8.8.8", Destination: "203.0.113.1", SourcePort: 443, DestinationPort: 51000},
+			Zone:     10,
+			Mark:     0x10,
+			State:    "ESTABLISHED",
+		},
+		{
+			Protocol: "icmp",
+			Original: ConntrackTuple{Source: "192.168.1.2", Destination: "8.8.4.4", ID: 7, Type: 8},
+			Reply:    ConntrackTuple{Source: "8.8.4.4", Destination: "203.0.113.1", ID: 7},
+			Zone:     10,
+		},
+	}
+	if got := entries; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected entries:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestClientAppDumpConntrackInvalid(t *testing.T) {
+	c := testClient(nil, func(cmd string, args ...string) ([]byte, error) {
+		return []byte("orig=(src=1.1.1.1,dst=2.2.2.2),zone=10\n"), nil
+	})
+
+	want := ErrInvalidConntrack
+	if _, got := c.App.DumpConntrack(10); !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestClientAppFlushConntrackOK(t *testing.T) {
+	tests := []struct {
+		desc  string
+		entry *ConntrackEntry
+		args  []string
+	}{
+		{
+			desc: "zone",
+			args: []string{"dpctl/flush-conntrack", "zone=10"},
+		},
+		{
+			desc: "tcp",
+			entry: &ConntrackEntry{
+				Protocol: "tcp",
+				Original: ConntrackTuple{Source: "192.168.1.2", Destination: "8.8.8.8", SourcePort: 51000, DestinationPort: 443},
+			},
+			args: []string{"dpctl/flush-conntrack", "zone=10",
+				"ct_nw_src=192.168.1.2,ct_nw_dst=8.8.8.8,ct_nw_proto=6,ct_tp_src=51000,ct_tp_dst=443"},
+		},
+		{
+			desc: "icmp6",
+			entry: &ConntrackEntry{
+				Protocol: "icmp6",
+				Original: ConntrackTuple{Source: "fd00::2", Destination: "fd01::1", ID: 3, Type: 128},
+			},
+			args: []string{"dpctl/flush-conntrack", "zone=10",
+				"ct_ipv6_src=fd00::2,ct_ipv6_dst=fd01::1,ct_nw_proto=58,icmp_id=3,icmp_type=128,icmp_code=0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			c := testClient(nil, func(cmd string, args ...string) ([]byte, error) {
+				if want, got := tt.args, args; !reflect.DeepEqual(want, got) {
+					t.Fatalf("incorrect arguments:\n- want: %v\n-  got: %v", want, got)
+				}
+				return nil, nil
+			})
+
+			if err := c.App.FlushConntrack(10, tt.entry); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestClientAppFlushConntrackReplyOK(t *testing.T) {
+	tests := []struct {
+		desc string
+		addr string
+		args []string
+	}{
+		{
+			desc: "ipv4",
+			addr: "10.10.10.1",
+			args: []string{"dpctl/flush-conntrack", "zone=10", "", "ct_nw_dst=10.10.10.1"},
+		},
+		{
+			desc: "ipv6",
+			addr: "fd00::1",
+			args: []string{"dpctl/flush-conntrack", "zone=10", "", "ct_ipv6_dst=fd00::1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			c := testClient(nil, func(cmd string, args ...string) ([]byte, error) {
+				if want, got := tt.args, args; !reflect.DeepEqual(want, got) {
+					t.Fatalf("incorrect arguments:\n- want: %v\n-  got: %v", want, got)
+				}
+				return nil, nil
+			})
+
+			if err := c.App.FlushConntrackReply(10, tt.addr); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -48,6 +48,17 @@ type ConntrackOutput struct {
 	zoneLimits []CTLimit
 }
 
+// Default returns the default limit of the zones, if it was reported.
+func (c *ConntrackOutput) Default() (uint64, bool) {
+	limit, ok := c.defaultLimit["default"]
+	return limit, ok
+}
+
+// Zones returns the zone, limit and count of the zones reported.
+func (c *ConntrackOutput) Zones() []CTLimit {
+	return c.zoneLimits
+}
+
 // DataPathReader is the interface defining the read operations
 // for the ovs DataPaths
 type DataPathReader interface {
@@ -16,6 +16,7 @@ package ovs
 
 import (
 	"errors"
+	"reflect"
 	"testing"
 )
 
@@ -646,3 +647,28 @@ func TestDelCTLimitsWithBinary(t *testing.T) {
 		})
 	}
 }
+
+func TestClientDataPathCTLimitsZones(t *testing.T) {
+	c := testClient(nil, func(cmd string, args ...string) ([]byte, error) {
+		if want, got := []string{"ct-get-limits", "system@ovs-system", "zone=10,1000"}, args; !reflect.DeepEqual(want, got) {
+			t.Fatalf("incorrect arguments:\n- want: %v\n-  got: %v", want, got)
+		}
+		return []byte("default limit=0\nzone=10,limit=100000,count=42\nzone=1000,limit=500,count=7\n"), nil
+	})
+
+	out, err := c.DataPath.GetCTLimits("system@ovs-system", []uint64{10, 1000})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if limit, ok := out.Default(); !ok || limit != 0 {
+		t.Fatalf("unexpected default limit: %d, %v", limit, ok)
+	}
+	want := []CTLimit{
+		{"zone": 10, "limit": 100000, "count": 42},
+		{"zone": 1000, "limit": 500, "count": 7},
+	}
+	if got := out.Zones(); !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected zones:\n- want: %v\n-  got: %v", want, got)
+	}
+}
@@ -105,6 +105,11 @@ func (e *FlowError) Error() string {
 		e.Str, e.Err)
 }
 
+// Unwrap returns the error that halted flow marshaling or unmarshaling.
+func (e *FlowError) Unwrap() error {
+	return e.Err
+}
+
 // Constants and variables used repeatedly to reduce errors in code.
 const (
 	priorityString = "priority="
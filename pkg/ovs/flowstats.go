@@ -32,6 +32,7 @@ var (
 type FlowStats struct {
 	PacketCount uint64
 	ByteCount   uint64
+	FlowCount   uint64
 }
 
 // UnmarshalText unmarshals a FlowStats from textual form.
@@ -89,7 +90,7 @@ func (f *FlowStats) UnmarshalText(b []byte) error {
 	*f = FlowStats{
 		PacketCount: values[0],
 		ByteCount:   values[1],
-		// Flow count unused.
+		FlowCount:   values[2],
 	}
 
 	return nil
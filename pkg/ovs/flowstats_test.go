@@ -68,6 +68,7 @@ func TestFlowStatsUnmarshalText(t *testing.T) {
 			stats: &FlowStats{
 				PacketCount: 642800,
 				ByteCount:   141379644,
+				FlowCount:   2,
 			},
 			ok: true,
 		},
@@ -77,6 +78,7 @@ func TestFlowStatsUnmarshalText(t *testing.T) {
 			stats: &FlowStats{
 				PacketCount: 1207,
 				ByteCount:   101673,
+				FlowCount:   1,
 			},
 			ok: true,
 		},
@@ -0,0 +1,208 @@
+// Copyright 2017 DigitalOcean.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidGroup is returned when groups from 'ovs-ofctl dump-groups'
+	// do not match the expected output format.
+	ErrInvalidGroup = errors.New("invalid openflow group")
+
+	// errGroupNoType is returned when a Group is marshaled without a type.
+	errGroupNoType = errors.New("no type defined for Group")
+)
+
+// A GroupType is the type of an OpenFlow group, which decides how
+// the buckets of the group are used.
+type GroupType string
+
+// GroupType constants which can be used in OVS group configurations.
+const (
+	// GroupAll executes all buckets.
+	GroupAll GroupType = "all"
+	// GroupSelect executes one bucket, chosen by the selection method.
+	GroupSelect GroupType = "select"
+	// GroupIndirect executes its only bucket.
+	GroupIndirect GroupType = "indirect"
+	// GroupFastFailover executes the first live bucket.
+	GroupFastFailover GroupType = "ff"
+)
+
+// A Group is an OpenFlow group meant for adding groups to a software bridge.
+// It can be marshaled to and from its textual form for use with Open vSwitch.
+type Group struct {
+	ID   uint32
+	Type GroupType
+	// SelectionMethod is the bucket selection method of a select group,
+	// such as "hash" or "dp_hash".  Requires OpenFlow 1.5.
+	SelectionMethod string
+	// Fields are the header fields hashed by the "hash" selection method.
+	Fields  []string
+	Buckets []*Bucket
+}
+
+// A Bucket is a list of actions executed by a Group.
+type Bucket struct {
+	// Weight is the relative weight of the bucket in a select group.
+	Weight  int
+	Actions []Action
+}
+
+// Constants used repeatedly to reduce errors in code.
+const (
+	groupID         = "group_id"
+	groupType       = "type"
+	selectionMethod = "selection_method"
+	groupFields     = "fields"
+	groupBucket     = "bucket"
+	bucketWeight    = "weight"
+)
+
+// fieldsRe is the regex used to match the list of hashed fields.
+var fieldsRe = regexp.MustCompile(`,fields\(([^)]*)\)`)
+
+// MarshalText marshals a Group into its textual form.
+func (g *Group) MarshalText() ([]byte, error) {
+	if g.Type == "" {
+		return nil, errGroupNoType
+	}
+
+	b := []byte(groupID + "=")
+	b = strconv.AppendUint(b, uint64(g.ID), 10)
+	b = append(b, ","+groupType+"="...)
+	b = append(b, g.Type...)
+
+	if g.SelectionMethod != "" {
+		b = append(b, ","+selectionMethod+"="...)
+		b = append(b, g.SelectionMethod...)
+	}
+	if len(g.Fields) > 0 {
+		b = append(b, ","+groupFields+"("+strings.Join(g.Fields, ",")+")"...)
+	}
+
+	for _, bk := range g.Buckets {
+		if len(bk.Actions) == 0 {
+			return nil, &FlowError{
+				Err: errNoActions,
+			}
+		}
+		actions, err := marshalActions(bk.Actions)
+		if err != nil {
+			return nil, err
+		}
+
+		b = append(b, ","+groupBucket+"="...)
+		if bk.Weight > 0 {
+			b = append(b, bucketWeight+":"...)
+			b = strconv.AppendInt(b, int64(bk.Weight), 10)
+			b = append(b, ',')
+		}
+		b = append(b, keyActions+"="+strings.Join(actions, ",")...)
+	}
+
+	return b, nil
+}
+
+// UnmarshalText unmarshals a Group from textual form as output by
+// 'ovs-ofctl dump-groups'.
+func (g *Group) UnmarshalText(b []byte) error {
+	s := strings.TrimSpace(string(b))
+
+	ss := strings.Split(s, ","+groupBucket+"=")
+	head, buckets := ss[0], ss[1:]
+
+	// The hashed fields are the only comma-separated list in the header.
+	if m := fieldsRe.FindStringSubmatch(head); m != nil {
+		g.Fields = strings.Split(m[1], ",")
+		head = strings.Replace(head, m[0], "", 1)
+	}
+
+	var hasID bool
+	for _, kv := range strings.Split(head, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			continue
+		}
+		switch k {
+		case groupID:
+			id, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return err
+			}
+			g.ID = uint32(id)
+			hasID = true
+		case groupType:
+			g.Type = GroupType(v)
+		case selectionMethod:
+			g.SelectionMethod = v
+		case groupFields:
+			g.Fields = []string{v}
+		}
+	}
+	if !hasID || g.Type == "" {
+		return ErrInvalidGroup
+	}
+
+	g.Buckets = make([]*Bucket, 0, len(buckets))
+	for _, s := range buckets {
+		bk := new(Bucket)
+		if err := bk.unmarshalText(s); err != nil {
+			return err
+		}
+		g.Buckets = append(g.Buckets, bk)
+	}
+
+	return nil
+}
+
+// unmarshalText unmarshals a Bucket such as "bucket_id:0,weight:1,actions=...".
+func (bk *Bucket) unmarshalText(s string) error {
+	props, actions, ok := strings.Cut(s, keyActions+"=")
+	if !ok || actions == "" {
+		return &FlowError{
+			Str: s,
+			Err: errNoActions,
+		}
+	}
+
+	for _, kv := range strings.Split(props, ",") {
+		k, v, _ := strings.Cut(kv, ":")
+		if k != bucketWeight {
+			continue
+		}
+		w, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		bk.Weight = w
+	}
+
+	out, _, err := newActionParser(strings.NewReader(actions)).Parse()
+	if err != nil {
+		return &FlowError{
+			Str: actions,
+			Err: errInvalidActions,
+		}
+	}
+	bk.Actions = out
+
+	return nil
+}
@@ -0,0 +1,168 @@
+// Copyright 2017 DigitalOcean.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGroupMarshalText(t *testing.T) {
+	var tests = []struct {
+		desc string
+		g    *Group
+		s    string
+		err  error
+	}{
+		{
+			desc: "no type",
+			g:    &Group{ID: 1},
+			err:  errGroupNoType,
+		},
+		{
+			desc: "empty indirect group",
+			g: &Group{
+				ID:   1,
+				Type: GroupIndirect,
+			},
+			s: "group_id=1,type=indirect",
+		},
+		{
+			desc: "select group with hash",
+			g: &Group{
+				ID:              2,
+				Type:            GroupSelect,
+				SelectionMethod: "hash",
+				Fields:          []string{"nw_src", "nw_dst"},
+				Buckets: []*Bucket{
+					{
+						Weight: 1,
+						Actions: []Action{
+							Load("0xc0a80101", "NXM_NX_REG0[]"),
+							Resubmit(0, 20),
+						},
+					},
+					{
+						Actions: []Action{Output(2)},
+					},
+				},
+			},
+			s: "group_id=2,type=select,selection_method=hash,fields(nw_src,nw_dst)," +
+				"bucket=weight:1,actions=load:0xc0a80101->NXM_NX_REG0[],resubmit(,20)," +
+				"bucket=actions=output:2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			b, err := tt.g.MarshalText()
+			if want, got := tt.err, err; want != got {
+				t.Fatalf("unexpected error:\n- want: %v\n-  got: %v",
+					want, got)
+			}
+			if err != nil {
+				return
+			}
+
+			if want, got := tt.s, string(b); want != got {
+				t.Fatalf("unexpected Group text:\n- want: %q\n-  got: %q",
+					want, got)
+			}
+		})
+	}
+}
+
+func TestGroupUnmarshalText(t *testing.T) {
+	var tests = []struct {
+		desc string
+		s    string
+		g    *Group
+		ok   bool
+	}{
+		{
+			desc: "empty string",
+		},
+		{
+			desc: "no type",
+			s:    "group_id=1",
+		},
+		{
+			desc: "invalid ID",
+			s:    "group_id=foo,type=all",
+		},
+		{
+			desc: "bucket without actions",
+			s:    "group_id=1,type=all,bucket=bucket_id:0",
+		},
+		{
+			desc: "single hashed field",
+			s:    " group_id=3,type=select,selection_method=hash,fields=ip_dst,bucket=bucket_id:0,weight:100,actions=drop",
+			g: &Group{
+				ID:              3,
+				Type:            GroupSelect,
+				SelectionMethod: "hash",
+				Fields:          []string{"ip_dst"},
+				Buckets: []*Bucket{
+					{Weight: 100, Actions: []Action{Drop()}},
+				},
+			},
+			ok: true,
+		},
+		{
+			desc: "multiple buckets",
+			s: " group_id=2,type=select,selection_method=hash,fields(ip_src,ip_dst)," +
+				"bucket=bucket_id:0,weight:1,actions=load:0xc0a80101->NXM_NX_REG0[],resubmit(,20)," +
+				"bucket=bucket_id:1,weight:1,actions=group:4",
+			g: &Group{
+				ID:              2,
+				Type:            GroupSelect,
+				SelectionMethod: "hash",
+				Fields:          []string{"ip_src", "ip_dst"},
+				Buckets: []*Bucket{
+					{
+						Weight: 1,
+						Actions: []Action{
+							Load("0xc0a80101", "NXM_NX_REG0[]"),
+							Resubmit(0, 20),
+						},
+					},
+					{Weight: 1, Actions: []Action{GotoGroup(4)}},
+				},
+			},
+			ok: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			g := new(Group)
+			err := g.UnmarshalText([]byte(tt.s))
+			if err != nil && tt.ok {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err == nil && !tt.ok {
+				t.Fatalf("expected an error, but none occurred")
+			}
+			if !tt.ok {
+				return
+			}
+
+			if want, got := tt.g, g; !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected Group:\n- want: %#v\n-  got: %#v",
+					want, got)
+			}
+		})
+	}
+}
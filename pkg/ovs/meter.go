@@ -0,0 +1,57 @@
+// Copyright 2017 DigitalOcean.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"errors"
+	"strconv"
+)
+
+var (
+	// errMeterNoRate is returned when a Meter is marshaled without a rate.
+	errMeterNoRate = errors.New("no rate defined for Meter")
+)
+
+// A Meter is an OpenFlow meter which drops the packets exceeding its rate,
+// in packets per second.  It can be marshaled to its textual form for use
+// with Open vSwitch.
+type Meter struct {
+	ID    uint32
+	Rate  int
+	Burst int
+}
+
+// MarshalText marshals a Meter into its textual form.
+func (m *Meter) MarshalText() ([]byte, error) {
+	if m.Rate <= 0 {
+		return nil, errMeterNoRate
+	}
+
+	b := []byte("meter=")
+	b = strconv.AppendUint(b, uint64(m.ID), 10)
+	b = append(b, ",pktps"...)
+	if m.Burst > 0 {
+		b = append(b, ",burst"...)
+	}
+
+	b = append(b, ",band=type=drop,rate="...)
+	b = strconv.AppendInt(b, int64(m.Rate), 10)
+	if m.Burst > 0 {
+		b = append(b, ",burst_size="...)
+		b = strconv.AppendInt(b, int64(m.Burst), 10)
+	}
+
+	return b, nil
+}
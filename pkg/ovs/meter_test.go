@@ -0,0 +1,62 @@
+// Copyright 2017 DigitalOcean.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"testing"
+)
+
+func TestMeterMarshalText(t *testing.T) {
+	var tests = []struct {
+		desc string
+		m    *Meter
+		s    string
+		err  error
+	}{
+		{
+			desc: "no rate",
+			m:    &Meter{ID: 1},
+			err:  errMeterNoRate,
+		},
+		{
+			desc: "rate only",
+			m:    &Meter{ID: 1, Rate: 100},
+			s:    "meter=1,pktps,band=type=drop,rate=100",
+		},
+		{
+			desc: "rate and burst",
+			m:    &Meter{ID: 2, Rate: 100, Burst: 20},
+			s:    "meter=2,pktps,burst,band=type=drop,rate=100,burst_size=20",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			b, err := tt.m.MarshalText()
+			if want, got := tt.err, err; want != got {
+				t.Fatalf("unexpected error:\n- want: %v\n-  got: %v",
+					want, got)
+			}
+			if err != nil {
+				return
+			}
+
+			if want, got := tt.s, string(b); want != got {
+				t.Fatalf("unexpected Meter text:\n- want: %q\n-  got: %q",
+					want, got)
+			}
+		})
+	}
+}
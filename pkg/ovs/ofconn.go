@@ -0,0 +1,682 @@
+// Copyright 2017 DigitalOcean.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRunDir is where Open vSwitch keeps its sockets, including the
+	// management socket of each bridge.
+	DefaultRunDir = "/var/run/openvswitch"
+
+	// DefaultOpenFlowTimeout is how long a barrier or a bundle commit is
+	// waited for.
+	DefaultOpenFlowTimeout = 30 * time.Second
+)
+
+// Bundle control types and flags.
+const (
+	ofpbctOpenRequest    = 0
+	ofpbctOpenReply      = 1
+	ofpbctCommitRequest  = 4
+	ofpbctCommitReply    = 5
+	ofpbctDiscardRequest = 6
+	ofpbctDiscardReply   = 7
+
+	ofpbfAtomic  = 1 << 0
+	ofpbfOrdered = 1 << 1
+
+	// Bundles of OpenFlow 1.3 are an ONF extension.
+	onfVendor            = 0x4f4e4600
+	onfetBundleControl   = 2300
+	onfetBundleAddMesage = 2301
+)
+
+// errorTypes names the types of OpenFlow error messages.
+var errorTypes = map[uint16]string{
+	0:      "HELLO_FAILED",
+	1:      "BAD_REQUEST",
+	2:      "BAD_ACTION",
+	3:      "BAD_INSTRUCTION",
+	4:      "BAD_MATCH",
+	5:      "FLOW_MOD_FAILED",
+	6:      "GROUP_MOD_FAILED",
+	12:     "METER_MOD_FAILED",
+	17:     "BUNDLE_FAILED",
+	0xffff: "EXPERIMENTER",
+}
+
+// removedReasons names the reasons of flow removed messages.
+var removedReasons = map[uint8]string{
+	0: "idle_timeout",
+	1: "hard_timeout",
+	2: "delete",
+	3: "group_delete",
+	4: "meter_delete",
+	5: "eviction",
+}
+
+var _ error = &OpenFlowError{}
+
+// An OpenFlowError is an error message sent by Open vSwitch.  Flow is the
+// flow, in its textual form, of the message which caused it.
+type OpenFlowError struct {
+	Flow string
+	Type uint16
+	Code uint16
+}
+
+// Error returns the string representation of an OpenFlowError.
+func (e *OpenFlowError) Error() string {
+	name, ok := errorTypes[e.Type]
+	if !ok {
+		name = fmt.Sprintf("type %d", e.Type)
+	}
+	if e.Flow == "" {
+		return fmt.Sprintf("openflow: %s code %d", name, e.Code)
+	}
+	return fmt.Sprintf("openflow: %s code %d: %s", name, e.Code, e.Flow)
+}
+
+var _ error = &FlowRemovedError{}
+
+// A FlowRemovedError reports a flow removed by Open vSwitch, such as on
+// its idle timeout.  Flow is the flow, in its textual form, as added.
+type FlowRemovedError struct {
+	Flow     string
+	Cookie   uint64
+	Priority int
+	Table    int
+	Reason   string
+	Packets  uint64
+	Bytes    uint64
+}
+
+// Error returns the string representation of a FlowRemovedError.
+func (e *FlowRemovedError) Error() string {
+	flow := e.Flow
+	if flow == "" {
+		flow = fmt.Sprintf("cookie=%#x,table=%d,priority=%d", e.Cookie, e.Table, e.Priority)
+	}
+	return fmt.Sprintf("openflow: flow removed on %s: %s", e.Reason, flow)
+}
+
+// An OFConn is an OpenFlow connection to the management socket of a
+// bridge.  Flows are given in their textual form and sent as flow mods,
+// the connection is made on first use and made again after it is lost.
+type OFConn struct {
+	// Timeout of a barrier or a commit, DefaultOpenFlowTimeout if zero.
+	Timeout time.Duration
+
+	dial func() (net.Conn, error)
+
+	// mu serializes the requests.
+	mu       sync.Mutex
+	xid      uint32
+	bundleId uint32
+
+	// smu guards the state shared with the receiver.
+	smu     sync.Mutex
+	conn    net.Conn
+	version uint8
+	op      *ofOp
+	added   map[string]string
+	errs    chan error
+}
+
+// An ofOp is a request waiting for its reply, errors for the flows sent
+// by it are gathered.
+type ofOp struct {
+	flows map[uint32]string
+	wait  uint32
+	done  chan error
+	errs  []error
+}
+
+// NewOFConn creates an OFConn for the management socket of the bridge in
+// dir, which is DefaultRunDir usually.
+func NewOFConn(dir, bridge string) *OFConn {
+	sock := filepath.Join(dir, bridge+".mgmt")
+	return &OFConn{
+		dial: func() (net.Conn, error) {
+			return net.Dial("unix", sock)
+		},
+		errs: make(chan error, 64),
+	}
+}
+
+// Errors returns the errors not caused by a request, such as flows
+// removed on their timeout.  They are dropped if not read.
+func (c *OFConn) Errors() <-chan error {
+	return c.errs
+}
+
+// AddFlow adds a Flow and waits until it is in place.
+func (c *OFConn) AddFlow(flow *Flow) error {
+	fb, err := flow.MarshalText()
+	if err != nil {
+		return err
+	}
+	return c.flowMods(false, flowDirective{directive: dirAdd, flow: string(fb)})
+}
+
+// DelFlows removes the flows matching MatchFlow, or all flows if it is
+// nil, and waits until they are gone.
+func (c *OFConn) DelFlows(flow *MatchFlow) error {
+	if flow == nil {
+		return c.flowMods(false, flowDirective{directive: dirDelete})
+	}
+	fb, err := flow.MarshalText()
+	if err != nil {
+		return err
+	}
+	return c.flowMods(false, flowDirective{directive: dirDelete, flow: string(fb)})
+}
+
+// AddFlowBundle adds and deletes the flows of a FlowTransaction in one
+// atomic bundle.
+func (c *OFConn) AddFlowBundle(fn func(tx *FlowTransaction) error) error {
+	tx := &FlowTransaction{}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if !tx.committed {
+		return errNotCommitted
+	}
+	return c.flowMods(true, tx.flows...)
+}
+
+// Barrier waits until the flow mods sent before are done.
+func (c *OFConn) Barrier() error {
+	return c.flowMods(false)
+}
+
+// Close closes the connection, the next request opens a new one.
+func (c *OFConn) Close() error {
+	c.smu.Lock()
+	conn := c.conn
+	c.smu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	c.reset(conn, errors.New("openflow: connection closed"))
+	return conn.Close()
+}
+
+// flowMods sends the flow mods of the directives, within a bundle or
+// followed by a barrier.  Nothing is sent if any of them can not be
+// encoded.
+func (c *OFConn) flowMods(bundle bool, flows ...flowDirective) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conn, version, err := c.connect()
+	if err != nil {
+		return err
+	}
+
+	fms := make([]*ofFlowMod, 0, len(flows))
+	for _, f := range flows {
+		fm, err := compileFlowMod(version, f.directive, f.flow)
+		if err != nil {
+			return &FlowError{Str: f.flow, Err: err}
+		}
+		fms = append(fms, fm)
+	}
+
+	op := &ofOp{
+		flows: make(map[uint32]string),
+		done:  make(chan error, 1),
+	}
+	var msgs [][]byte
+	var bundleId uint32
+	if bundle {
+		c.bundleId++
+		bundleId = c.bundleId
+		op.wait = c.nextXid()
+		if err := c.roundtrip(conn, op, c.bundleControl(version, op.wait, bundleId, ofpbctOpenRequest)); err != nil {
+			return err
+		}
+		op.done = make(chan error, 1)
+	}
+
+	for i, fm := range fms {
+		xid := c.nextXid()
+		op.flows[xid] = flows[i].flow
+		msg := fm.marshal(version, xid)
+		if bundle {
+			msg = c.bundleAdd(version, xid, bundleId, msg)
+		}
+		msgs = append(msgs, msg)
+		c.track(flows[i], fm)
+	}
+
+	// Errors of the flows come before the barrier reply.
+	op.wait = c.nextXid()
+	msgs = append(msgs, ofMessage(version, ofptBarrierRequest, op.wait, nil))
+	if err := c.roundtrip(conn, op, msgs...); err != nil {
+		return err
+	}
+	if len(op.errs) > 0 || !bundle {
+		if bundle {
+			op.wait = c.nextXid()
+			op.done = make(chan error, 1)
+			c.roundtrip(conn, op, c.bundleControl(version, op.wait, bundleId, ofpbctDiscardRequest))
+		}
+		return errors.Join(op.errs...)
+	}
+
+	op.wait = c.nextXid()
+	op.done = make(chan error, 1)
+	if err := c.roundtrip(conn, op, c.bundleControl(version, op.wait, bundleId, ofpbctCommitRequest)); err != nil {
+		return errors.Join(append(op.errs, err)...)
+	}
+	return errors.Join(op.errs...)
+}
+
+// roundtrip sends the messages and waits for the reply to op.wait.
+func (c *OFConn) roundtrip(conn net.Conn, op *ofOp, msgs ...[]byte) error {
+	c.smu.Lock()
+	c.op = op
+	c.smu.Unlock()
+	defer func() {
+		c.smu.Lock()
+		c.op = nil
+		c.smu.Unlock()
+	}()
+
+	for _, msg := range msgs {
+		if _, err := conn.Write(msg); err != nil {
+			c.reset(conn, err)
+			conn.Close()
+			return err
+		}
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultOpenFlowTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-op.done:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("openflow: timed out")
+	}
+}
+
+func (c *OFConn) nextXid() uint32 {
+	c.xid++
+	return c.xid
+}
+
+// track keeps the flows which may expire, to tell which one is removed.
+func (c *OFConn) track(f flowDirective, fm *ofFlowMod) {
+	if fm.flags&ofpffSendFlowRem == 0 {
+		return
+	}
+	c.smu.Lock()
+	defer c.smu.Unlock()
+	if c.added == nil {
+		c.added = make(map[string]string)
+	}
+	c.added[flowKey(fm.table, fm.priority, fm.match)] = f.flow
+}
+
+// flowKey identifies a flow by its table, priority and match, the fields
+// of which may be given in any order.
+func flowKey(table uint8, priority uint16, match []byte) string {
+	var fields []string
+	for len(match) >= 4 {
+		size := 4 + int(match[3])
+		if size > len(match) {
+			break
+		}
+		fields = append(fields, string(match[:size]))
+		match = match[size:]
+	}
+	sort.Strings(fields)
+	return fmt.Sprintf("%d/%d/%x", table, priority, fields)
+}
+
+func (c *OFConn) bundleControl(version uint8, xid, id uint32, typ uint16) []byte {
+	b := binary.BigEndian.AppendUint32(nil, id)
+	b = binary.BigEndian.AppendUint16(b, typ)
+	b = binary.BigEndian.AppendUint16(b, ofpbfAtomic|ofpbfOrdered)
+	if version == ofVersion13 {
+		return onfMessage(xid, onfetBundleControl, b)
+	}
+	return ofMessage(version, ofptBundleControl, xid, b)
+}
+
+func (c *OFConn) bundleAdd(version uint8, xid, id uint32, msg []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, id)
+	b = append(b, 0, 0)
+	b = binary.BigEndian.AppendUint16(b, ofpbfAtomic|ofpbfOrdered)
+	b = pad8(append(b, msg...))
+	if version == ofVersion13 {
+		return onfMessage(xid, onfetBundleAddMesage, b)
+	}
+	return ofMessage(version, ofptBundleAddMessage, xid, b)
+}
+
+func ofMessage(version, typ uint8, xid uint32, body []byte) []byte {
+	b := make([]byte, 8, 8+len(body))
+	b = append(b, body...)
+	putHeader(b, version, typ, xid)
+	return b
+}
+
+func onfMessage(xid uint32, typ uint32, body []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, onfVendor)
+	b = binary.BigEndian.AppendUint32(b, typ)
+	return ofMessage(ofVersion13, ofptExperimenter, xid, append(b, body...))
+}
+
+// connect opens the connection if there is none and says hello, c.mu is
+// held.
+func (c *OFConn) connect() (net.Conn, uint8, error) {
+	c.smu.Lock()
+	conn, version := c.conn, c.version
+	c.smu.Unlock()
+	if conn != nil {
+		return conn, version, nil
+	}
+
+	conn, err := c.dial()
+	if err != nil {
+		return nil, 0, err
+	}
+	version, err = c.hello(conn)
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+
+	c.smu.Lock()
+	c.conn, c.version = conn, version
+	c.smu.Unlock()
+
+	go c.receive(conn)
+	return conn, version, nil
+}
+
+// hello negotiates the highest version of 1.3 to 1.5 both ends speak.
+func (c *OFConn) hello(conn net.Conn) (uint8, error) {
+	const bitmap = 1<<ofVersion13 | 1<<ofVersion14 | 1<<ofVersion15
+
+	elem := []byte{0, 1, 0, 8}
+	elem = binary.BigEndian.AppendUint32(elem, bitmap)
+	if _, err := conn.Write(ofMessage(ofVersion15, ofptHello, c.nextXid(), elem)); err != nil {
+		return 0, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(DefaultOpenFlowTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		hdr, body, err := readMessage(conn)
+		if err != nil {
+			return 0, err
+		}
+		if hdr[1] != ofptHello {
+			continue
+		}
+
+		// Without a bitmap the lower version is taken.
+		version := min(hdr[0], ofVersion15)
+		for len(body) >= 8 {
+			typ := binary.BigEndian.Uint16(body[0:])
+			size := int(binary.BigEndian.Uint16(body[2:]))
+			if size < 4 || size > len(body) {
+				break
+			}
+			if typ == 1 {
+				theirs := binary.BigEndian.Uint32(body[4:])
+				version = 0
+				for v := uint8(ofVersion15); v >= ofVersion13; v-- {
+					if theirs&bitmap&(1<<v) != 0 {
+						version = v
+						break
+					}
+				}
+			}
+			body = body[(size+7)/8*8:]
+		}
+		if version < ofVersion13 {
+			return 0, fmt.Errorf("openflow: no common version with %#x", hdr[0])
+		}
+		return version, nil
+	}
+}
+
+func readMessage(r io.Reader) ([]byte, []byte, error) {
+	hdr := make([]byte, 8)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, nil, err
+	}
+	size := int(binary.BigEndian.Uint16(hdr[2:]))
+	if size < 8 {
+		return nil, nil, fmt.Errorf("openflow: invalid message length %d", size)
+	}
+	body := make([]byte, size-8)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, err
+	}
+	return hdr, body, nil
+}
+
+// reset forgets the connection and fails the request waiting on it.
+func (c *OFConn) reset(conn net.Conn, err error) {
+	c.smu.Lock()
+	defer c.smu.Unlock()
+
+	if c.conn != conn {
+		return
+	}
+	c.conn = nil
+	if c.op != nil {
+		c.finish(c.op, err)
+	}
+}
+
+// finish ends the wait of op, c.smu is held.
+func (c *OFConn) finish(op *ofOp, err error) {
+	select {
+	case op.done <- err:
+	default:
+	}
+}
+
+func (c *OFConn) receive(conn net.Conn) {
+	for {
+		hdr, body, err := readMessage(conn)
+		if err != nil {
+			c.reset(conn, err)
+			conn.Close()
+			return
+		}
+		version, typ := hdr[0], hdr[1]
+		xid := binary.BigEndian.Uint32(hdr[4:])
+
+		switch typ {
+		case ofptEchoRequest:
+			conn.Write(ofMessage(version, ofptEchoReply, xid, body))
+		case ofptError:
+			c.onError(xid, body)
+		case ofptBarrierReply:
+			c.onReply(xid)
+		case ofptBundleControl:
+			c.onBundleReply(xid, body)
+		case ofptExperimenter:
+			if len(body) >= 8 && binary.BigEndian.Uint32(body) == onfVendor &&
+				binary.BigEndian.Uint32(body[4:]) == onfetBundleControl {
+				c.onBundleReply(xid, body[8:])
+			}
+		case ofptFlowRemoved:
+			c.onRemoved(version, body)
+		}
+	}
+}
+
+func (c *OFConn) onReply(xid uint32) {
+	c.smu.Lock()
+	defer c.smu.Unlock()
+
+	if op := c.op; op != nil && op.wait == xid {
+		c.finish(op, nil)
+	}
+}
+
+func (c *OFConn) onBundleReply(xid uint32, body []byte) {
+	if len(body) < 8 {
+		return
+	}
+	switch binary.BigEndian.Uint16(body[4:]) {
+	case ofpbctOpenReply, ofpbctCommitReply, ofpbctDiscardReply:
+		c.onReply(xid)
+	}
+}
+
+func (c *OFConn) onError(xid uint32, body []byte) {
+	if len(body) < 4 {
+		return
+	}
+	e := &OpenFlowError{
+		Type: binary.BigEndian.Uint16(body[0:]),
+		Code: binary.BigEndian.Uint16(body[2:]),
+	}
+
+	c.smu.Lock()
+	defer c.smu.Unlock()
+
+	op := c.op
+	switch {
+	case op == nil:
+		c.report(e)
+	case op.wait == xid:
+		c.finish(op, e)
+	default:
+		flow, ok := op.flows[xid]
+		if !ok {
+			c.report(e)
+			return
+		}
+		e.Flow = flow
+		op.errs = append(op.errs, e)
+	}
+}
+
+func (c *OFConn) onRemoved(version uint8, body []byte) {
+	e := &FlowRemovedError{}
+	var match []byte
+	var table uint8
+	var priority uint16
+
+	if version >= ofVersion15 {
+		// table_id, reason, priority, idle, hard, cookie, match, stats.
+		if len(body) < 16 {
+			return
+		}
+		table = body[0]
+		e.Reason = removedReasons[body[1]]
+		priority = binary.BigEndian.Uint16(body[2:])
+		e.Cookie = binary.BigEndian.Uint64(body[8:])
+		match = body[16:]
+	} else {
+		// cookie, priority, reason, table_id, durations, idle, hard,
+		// counters, match.
+		if len(body) < 40 {
+			return
+		}
+		e.Cookie = binary.BigEndian.Uint64(body[0:])
+		priority = binary.BigEndian.Uint16(body[8:])
+		e.Reason = removedReasons[body[10]]
+		table = body[11]
+		e.Packets = binary.BigEndian.Uint64(body[24:])
+		e.Bytes = binary.BigEndian.Uint64(body[32:])
+		match = body[40:]
+	}
+	e.Table, e.Priority = int(table), int(priority)
+
+	var oxm []byte
+	if len(match) >= 4 {
+		size := int(binary.BigEndian.Uint16(match[2:]))
+		if size >= 4 && size <= len(match) {
+			oxm = match[4:size]
+		}
+		if version >= ofVersion15 && (size+7)/8*8 <= len(match) {
+			e.Packets, e.Bytes = flowStats(match[(size+7)/8*8:])
+		}
+	}
+
+	c.smu.Lock()
+	defer c.smu.Unlock()
+
+	key := flowKey(table, priority, oxm)
+	e.Flow = c.added[key]
+	delete(c.added, key)
+	c.report(e)
+}
+
+// flowStats returns the packet and byte counts of an ofp_stats.
+func flowStats(b []byte) (uint64, uint64) {
+	var packets, bytes uint64
+	if len(b) < 4 {
+		return 0, 0
+	}
+	size := int(binary.BigEndian.Uint16(b[2:]))
+	if size > len(b) {
+		return 0, 0
+	}
+	for b = b[4:size]; len(b) >= 4; {
+		class := binary.BigEndian.Uint16(b)
+		field, length := b[2]>>1, int(b[3])
+		if 4+length > len(b) {
+			break
+		}
+		if class == 0x8002 && length == 8 {
+			switch field {
+			case 4:
+				packets = binary.BigEndian.Uint64(b[4:])
+			case 5:
+				bytes = binary.BigEndian.Uint64(b[4:])
+			}
+		}
+		b = b[4+length:]
+	}
+	return packets, bytes
+}
+
+// report passes on an error not caused by a request, c.smu is held.
+func (c *OFConn) report(err error) {
+	select {
+	case c.errs <- err:
+	default:
+	}
+}
@@ -0,0 +1,320 @@
+// Copyright 2017 DigitalOcean.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// An ofStandIn is an in-process switch, which replies to barriers and
+// bundle controls and fails the flow mods for which fail returns true.
+type ofStandIn struct {
+	version uint8
+	fail    func(fm []byte) bool
+
+	mu   sync.Mutex
+	conn net.Conn
+	ops  []string
+}
+
+func (s *ofStandIn) serve(conn net.Conn) {
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+
+	// Pipes are not buffered, the hello of the client is read first.
+	// Without a bitmap, only the version of the header is spoken.
+	if _, _, err := readMessage(conn); err != nil {
+		return
+	}
+	s.record("hello")
+	conn.Write(ofMessage(s.version, ofptHello, 0, nil))
+	for {
+		hdr, body, err := readMessage(conn)
+		if err != nil {
+			return
+		}
+		typ, xid := hdr[1], binary.BigEndian.Uint32(hdr[4:])
+		if typ == ofptExperimenter && len(body) >= 8 {
+			typ = map[uint32]uint8{
+				onfetBundleControl:   ofptBundleControl,
+				onfetBundleAddMesage: ofptBundleAddMessage,
+			}[binary.BigEndian.Uint32(body[4:])]
+			body = body[8:]
+		}
+
+		switch typ {
+		case ofptFlowMod:
+			s.record("flow_mod")
+			s.flowMod(xid, append(hdr, body...))
+		case ofptBarrierRequest:
+			s.record("barrier")
+			conn.Write(ofMessage(s.version, ofptBarrierReply, xid, nil))
+		case ofptBundleAddMessage:
+			s.record("bundle_add")
+			s.flowMod(xid, body[8:])
+		case ofptBundleControl:
+			ctl := binary.BigEndian.Uint16(body[4:])
+			s.record(map[uint16]string{
+				ofpbctOpenRequest:    "open",
+				ofpbctCommitRequest:  "commit",
+				ofpbctDiscardRequest: "discard",
+			}[ctl])
+			reply := append([]byte(nil), body...)
+			binary.BigEndian.PutUint16(reply[4:], ctl+1)
+			if s.version == ofVersion13 {
+				conn.Write(onfMessage(xid, onfetBundleControl, reply))
+				continue
+			}
+			conn.Write(ofMessage(s.version, ofptBundleControl, xid, reply))
+		}
+	}
+}
+
+func (s *ofStandIn) flowMod(xid uint32, fm []byte) {
+	if s.fail == nil || !s.fail(fm) {
+		return
+	}
+	// FLOW_MOD_FAILED, TABLE_FULL.
+	body := []byte{0, 5, 0, 1}
+	s.conn.Write(ofMessage(s.version, ofptError, xid, append(body, fm[:8]...)))
+}
+
+func (s *ofStandIn) record(op string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ops = append(s.ops, op)
+}
+
+func (s *ofStandIn) send(msg []byte) {
+	s.mu.Lock()
+	conn := s.conn
+	s.mu.Unlock()
+	conn.Write(msg)
+}
+
+func testOFConn(s *ofStandIn) *OFConn {
+	c := NewOFConn("", "br0")
+	c.Timeout = time.Second
+	c.dial = func() (net.Conn, error) {
+		client, server := net.Pipe()
+		go s.serve(server)
+		return client, nil
+	}
+	return c
+}
+
+func TestOFConnAddFlowOK(t *testing.T) {
+	s := &ofStandIn{version: ofVersion15}
+	c := testOFConn(s)
+	defer c.Close()
+
+	flow := &Flow{
+		Priority: 10,
+		Protocol: ProtocolIPv4,
+		Actions:  []Action{Output(2)},
+	}
+	if err := c.AddFlow(flow); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want, got := uint8(ofVersion15), c.version; want != got {
+		t.Fatalf("unexpected version:\n- want: %v\n-  got: %v", want, got)
+	}
+	want := []string{"hello", "flow_mod", "barrier"}
+	if got := s.ops; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected messages:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestOFConnAddFlowError(t *testing.T) {
+	s := &ofStandIn{
+		version: ofVersion14,
+		fail: func(fm []byte) bool {
+			return true
+		},
+	}
+	c := testOFConn(s)
+	defer c.Close()
+
+	flow := &Flow{
+		Priority: 10,
+		Protocol: ProtocolIPv4,
+		Actions:  []Action{Output(2)},
+	}
+	err := c.AddFlow(flow)
+
+	var ofErr *OpenFlowError
+	if !errors.As(err, &ofErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fb, _ := flow.MarshalText()
+	want := &OpenFlowError{Flow: string(fb), Type: 5, Code: 1}
+	if got := ofErr; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestOFConnAddFlowBundleOK(t *testing.T) {
+	s := &ofStandIn{version: ofVersion13}
+	c := testOFConn(s)
+	defer c.Close()
+
+	err := c.AddFlowBundle(func(tx *FlowTransaction) error {
+		tx.DeleteStrict(&Flow{Priority: 10, Table: 1})
+		tx.Add(&Flow{Priority: 10, Table: 1, Actions: []Action{Drop()}})
+		return tx.Commit()
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{"hello", "open", "bundle_add", "bundle_add", "barrier", "commit"}
+	if got := s.ops; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected messages:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestOFConnAddFlowBundleDiscard(t *testing.T) {
+	bad := &Flow{Priority: 20, Table: 1, Actions: []Action{Drop()}}
+	s := &ofStandIn{
+		version: ofVersion15,
+		fail: func(fm []byte) bool {
+			// Priority of the flow mod.
+			return binary.BigEndian.Uint16(fm[30:]) == 20
+		},
+	}
+	c := testOFConn(s)
+	defer c.Close()
+
+	err := c.AddFlowBundle(func(tx *FlowTransaction) error {
+		tx.Add(&Flow{Priority: 10, Table: 1, Actions: []Action{Drop()}})
+		tx.Add(bad)
+		return tx.Commit()
+	})
+
+	var ofErr *OpenFlowError
+	if !errors.As(err, &ofErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fb, _ := bad.MarshalText()
+	if want, got := string(fb), ofErr.Flow; want != got {
+		t.Fatalf("unexpected flow:\n- want: %v\n-  got: %v", want, got)
+	}
+	want := []string{"hello", "open", "bundle_add", "bundle_add", "barrier", "discard"}
+	if got := s.ops; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected messages:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestOFConnFlowRemovedOK(t *testing.T) {
+	s := &ofStandIn{version: ofVersion13}
+	c := testOFConn(s)
+	defer c.Close()
+
+	flow := &Flow{
+		Priority:    10,
+		Protocol:    ProtocolIPv4,
+		InPort:      3,
+		Table:       2,
+		IdleTimeout: 5,
+		Actions:     []Action{Drop()},
+	}
+	if err := c.AddFlow(flow); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Fields of the match are not in the order they were added.
+	oxm := []byte{0x80, 0x00, 0x00, 0x04, 0, 0, 0, 3, 0x80, 0x00, 0x0a, 0x02, 0x08, 0x00}
+	body := make([]byte, 40)
+	binary.BigEndian.PutUint16(body[8:], 10)
+	body[11] = 2
+	binary.BigEndian.PutUint64(body[24:], 7)
+	binary.BigEndian.PutUint64(body[32:], 700)
+	body = append(body, 0, 1, 0, byte(4+len(oxm)))
+	body = pad8(append(body, oxm...))
+	s.send(ofMessage(ofVersion13, ofptFlowRemoved, 0, body))
+
+	fb, _ := flow.MarshalText()
+	want := &FlowRemovedError{
+		Flow:     string(fb),
+		Priority: 10,
+		Table:    2,
+		Reason:   "idle_timeout",
+		Packets:  7,
+		Bytes:    700,
+	}
+	select {
+	case err := <-c.Errors():
+		if got := err; !reflect.DeepEqual(want, got) {
+			t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no flow removed")
+	}
+}
+
+func TestClientOpenFlowNativeFallback(t *testing.T) {
+	dir := t.TempDir()
+	l, err := net.Listen("unix", filepath.Join(dir, "br0.mgmt"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer l.Close()
+
+	s := &ofStandIn{version: ofVersion15}
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		s.serve(conn)
+	}()
+
+	var execs int
+	c := testClient([]OptionFunc{NativeOpenFlow(dir)}, func(cmd string, args ...string) ([]byte, error) {
+		execs++
+		return nil, nil
+	})
+	defer c.OpenFlow.Conn("br0").Close()
+
+	if err := c.OpenFlow.AddFlow("br0", &Flow{
+		Priority: 10,
+		Actions:  []Action{Drop()},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want, got := 0, execs; want != got {
+		t.Fatalf("unexpected ovs-ofctl runs:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	if err := c.OpenFlow.AddFlow("br0", &Flow{
+		Priority: 10,
+		Protocol: ProtocolTCPv4,
+		Actions:  []Action{ModTransportDestinationPort(80)},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want, got := 1, execs; want != got {
+		t.Fatalf("unexpected ovs-ofctl runs:\n- want: %v\n-  got: %v", want, got)
+	}
+}
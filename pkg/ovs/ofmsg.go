@@ -0,0 +1,1231 @@
+// Copyright 2017 DigitalOcean.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// The encoding of flows in their textual form, as marshaled for
+// 'ovs-ofctl', to OpenFlow 1.3 to 1.5 flow mods.
+
+// errUnsupported is returned for flows using a match or an action which
+// is not encoded natively, they are left to 'ovs-ofctl'.
+var errUnsupported = errors.New("not supported natively")
+
+// OpenFlow versions spoken natively.
+const (
+	ofVersion13 = 0x04
+	ofVersion14 = 0x05
+	ofVersion15 = 0x06
+)
+
+// OpenFlow message types.
+const (
+	ofptHello            = 0
+	ofptError            = 1
+	ofptEchoRequest      = 2
+	ofptEchoReply        = 3
+	ofptExperimenter     = 4
+	ofptFlowRemoved      = 11
+	ofptFlowMod          = 14
+	ofptBarrierRequest   = 20
+	ofptBarrierReply     = 21
+	ofptBundleControl    = 33
+	ofptBundleAddMessage = 34
+)
+
+// Flow mod commands and flags.
+const (
+	ofpfcAdd          = 0
+	ofpfcDelete       = 3
+	ofpfcDeleteStrict = 4
+
+	ofpffSendFlowRem = 1 << 0
+)
+
+// Special ports, groups and tables.
+const (
+	ofppInPort     = 0xfffffff8
+	ofppNormal     = 0xfffffffa
+	ofppFlood      = 0xfffffffb
+	ofppAll        = 0xfffffffc
+	ofppController = 0xfffffffd
+	ofppLocal      = 0xfffffffe
+	ofppAny        = 0xffffffff
+
+	ofpgAny   = 0xffffffff
+	ofpttAll  = 0xff
+	noBuffer  = 0xffffffff
+	ofpcmlMax = 0xffff
+)
+
+// Instructions and actions.
+const (
+	ofpitGotoTable    = 1
+	ofpitApplyActions = 4
+	ofpitMeter        = 6
+
+	ofpatOutput     = 0
+	ofpatPushVLAN   = 17
+	ofpatPopVLAN    = 18
+	ofpatGroup      = 22
+	ofpatDecNwTTL   = 24
+	ofpatSetField   = 25
+	ofpatMeter      = 29
+	ofpatExperiment = 0xffff
+
+	nxVendor          = 0x00002320
+	nxastRegMove      = 6
+	nxastRegLoad      = 7
+	nxastResubmitTbl  = 14
+	nxastOutputReg    = 15
+	nxastStackPush    = 27
+	nxastStackPop     = 28
+	nxastConntrack    = 35
+	nxastNAT          = 36
+	nxastCtClear      = 43
+	nxCtRecircNone    = 0xff
+	nxCtFlagCommit    = 1 << 0
+	nxCtFlagForce     = 1 << 1
+	nxNatFlagSrc      = 1 << 0
+	nxNatFlagDst      = 1 << 1
+	nxNatPersistent   = 1 << 2
+	nxNatProtoHash    = 1 << 3
+	nxNatProtoRandom  = 1 << 4
+	nxNatAddr4Min     = 1 << 0
+	nxNatAddr4Max     = 1 << 1
+	nxNatAddr6Min     = 1 << 2
+	nxNatAddr6Max     = 1 << 3
+	nxNatProtoMin     = 1 << 4
+	nxNatProtoMax     = 1 << 5
+	ofpvidPresent     = 0x1000
+	oxmClassBasic     = 0x8000
+	oxmClassPacketReg = 0x8001
+	oxmClassNxm0      = 0x0000
+	oxmClassNxm1      = 0x0001
+	oxmClassExp       = 0xffff
+)
+
+// Kinds of field values.
+const (
+	valInt = iota
+	valMAC
+	valIPv4
+	valIPv6
+)
+
+// An ofField is a field of an OXM or NXM match.
+type ofField struct {
+	class uint16
+	field uint8
+	size  int
+	kind  int
+}
+
+// header returns the OXM header of the field, which is followed by the
+// experimenter id for experimenter fields.
+func (f ofField) header(masked bool) []byte {
+	length := f.size
+	if masked {
+		length *= 2
+	}
+	fm := f.field << 1
+	if masked {
+		fm |= 1
+	}
+	if f.class == oxmClassExp {
+		b := []byte{0xff, 0xff, fm, uint8(length + 4), 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[4:], nxVendor)
+		return b
+	}
+	return []byte{uint8(f.class >> 8), uint8(f.class), fm, uint8(length)}
+}
+
+var ofFields = map[string]ofField{
+	"in_port":         {oxmClassBasic, 0, 4, valInt},
+	"nxm_of_in_port":  {oxmClassNxm0, 0, 2, valInt},
+	"metadata":        {oxmClassBasic, 2, 8, valInt},
+	"oxm_of_metadata": {oxmClassBasic, 2, 8, valInt},
+	"dl_dst":          {oxmClassBasic, 3, 6, valMAC},
+	"eth_dst":         {oxmClassBasic, 3, 6, valMAC},
+	"oxm_of_eth_dst":  {oxmClassBasic, 3, 6, valMAC},
+	"nxm_of_eth_dst":  {oxmClassNxm0, 1, 6, valMAC},
+	"dl_src":          {oxmClassBasic, 4, 6, valMAC},
+	"eth_src":         {oxmClassBasic, 4, 6, valMAC},
+	"oxm_of_eth_src":  {oxmClassBasic, 4, 6, valMAC},
+	"nxm_of_eth_src":  {oxmClassNxm0, 2, 6, valMAC},
+	"dl_type":         {oxmClassBasic, 5, 2, valInt},
+	"eth_type":        {oxmClassBasic, 5, 2, valInt},
+	"vlan_tci":        {oxmClassNxm0, 4, 2, valInt},
+	"nxm_of_vlan_tci": {oxmClassNxm0, 4, 2, valInt},
+	"nw_tos":          {oxmClassNxm0, 5, 1, valInt},
+	"ip_dscp":         {oxmClassBasic, 8, 1, valInt},
+	"nw_ecn":          {oxmClassBasic, 9, 1, valInt},
+	"ip_ecn":          {oxmClassBasic, 9, 1, valInt},
+	"nw_proto":        {oxmClassBasic, 10, 1, valInt},
+	"ip_proto":        {oxmClassBasic, 10, 1, valInt},
+	"nw_src":          {oxmClassBasic, 11, 4, valIPv4},
+	"ip_src":          {oxmClassBasic, 11, 4, valIPv4},
+	"oxm_of_ipv4_src": {oxmClassBasic, 11, 4, valIPv4},
+	"nxm_of_ip_src":   {oxmClassNxm0, 7, 4, valIPv4},
+	"nw_dst":          {oxmClassBasic, 12, 4, valIPv4},
+	"ip_dst":          {oxmClassBasic, 12, 4, valIPv4},
+	"oxm_of_ipv4_dst": {oxmClassBasic, 12, 4, valIPv4},
+	"nxm_of_ip_dst":   {oxmClassNxm0, 8, 4, valIPv4},
+	"tcp_src":         {oxmClassBasic, 13, 2, valInt},
+	"tcp_dst":         {oxmClassBasic, 14, 2, valInt},
+	"udp_src":         {oxmClassBasic, 15, 2, valInt},
+	"udp_dst":         {oxmClassBasic, 16, 2, valInt},
+	"sctp_src":        {oxmClassBasic, 17, 2, valInt},
+	"sctp_dst":        {oxmClassBasic, 18, 2, valInt},
+	"icmp_type":       {oxmClassBasic, 19, 1, valInt},
+	"icmp_code":       {oxmClassBasic, 20, 1, valInt},
+	"arp_op":          {oxmClassBasic, 21, 2, valInt},
+	"arp_spa":         {oxmClassBasic, 22, 4, valIPv4},
+	"arp_tpa":         {oxmClassBasic, 23, 4, valIPv4},
+	"arp_sha":         {oxmClassBasic, 24, 6, valMAC},
+	"arp_tha":         {oxmClassBasic, 25, 6, valMAC},
+	"ipv6_src":        {oxmClassBasic, 26, 16, valIPv6},
+	"oxm_of_ipv6_src": {oxmClassBasic, 26, 16, valIPv6},
+	"nxm_nx_ipv6_src": {oxmClassNxm1, 19, 16, valIPv6},
+	"ipv6_dst":        {oxmClassBasic, 27, 16, valIPv6},
+	"oxm_of_ipv6_dst": {oxmClassBasic, 27, 16, valIPv6},
+	"nxm_nx_ipv6_dst": {oxmClassNxm1, 20, 16, valIPv6},
+	"ipv6_label":      {oxmClassBasic, 28, 4, valInt},
+	"icmpv6_type":     {oxmClassBasic, 29, 1, valInt},
+	"icmpv6_code":     {oxmClassBasic, 30, 1, valInt},
+	"nd_target":       {oxmClassBasic, 31, 16, valIPv6},
+	"nd_sll":          {oxmClassBasic, 32, 6, valMAC},
+	"nd_tll":          {oxmClassBasic, 33, 6, valMAC},
+	"tun_id":          {oxmClassNxm1, 16, 8, valInt},
+	"nw_ttl":          {oxmClassNxm1, 29, 1, valInt},
+	"nxm_nx_ip_ttl":   {oxmClassNxm1, 29, 1, valInt},
+	"pkt_mark":        {oxmClassNxm1, 33, 4, valInt},
+	"nxm_nx_pkt_mark": {oxmClassNxm1, 33, 4, valInt},
+	"ct_state":        {oxmClassNxm1, 105, 4, valInt},
+	"ct_zone":         {oxmClassNxm1, 106, 2, valInt},
+	"nxm_nx_ct_zone":  {oxmClassNxm1, 106, 2, valInt},
+	"ct_mark":         {oxmClassNxm1, 107, 4, valInt},
+	"nxm_nx_ct_mark":  {oxmClassNxm1, 107, 4, valInt},
+	"ct_label":        {oxmClassNxm1, 108, 16, valInt},
+	"nxm_nx_ct_label": {oxmClassNxm1, 108, 16, valInt},
+	"vlan_vid":        {oxmClassBasic, 6, 2, valInt},
+	"oxm_of_vlan_vid": {oxmClassBasic, 6, 2, valInt},
+	"nxm_of_ip_proto": {oxmClassNxm0, 6, 1, valInt},
+	"nxm_of_eth_type": {oxmClassNxm0, 3, 2, valInt},
+	"nxm_of_tcp_src":  {oxmClassNxm0, 9, 2, valInt},
+	"nxm_of_tcp_dst":  {oxmClassNxm0, 10, 2, valInt},
+	"nxm_of_udp_src":  {oxmClassNxm0, 11, 2, valInt},
+	"nxm_of_udp_dst":  {oxmClassNxm0, 12, 2, valInt},
+	"oxm_of_ip_proto": {oxmClassBasic, 10, 1, valInt},
+	"oxm_of_eth_type": {oxmClassBasic, 5, 2, valInt},
+	"oxm_of_in_port":  {oxmClassBasic, 0, 4, valInt},
+	"oxm_of_tcp_src":  {oxmClassBasic, 13, 2, valInt},
+	"oxm_of_tcp_dst":  {oxmClassBasic, 14, 2, valInt},
+	"oxm_of_udp_src":  {oxmClassBasic, 15, 2, valInt},
+	"oxm_of_udp_dst":  {oxmClassBasic, 16, 2, valInt},
+	"nxm_nx_ct_state": {oxmClassNxm1, 105, 4, valInt},
+	"nxm_nx_tun_id":   {oxmClassNxm1, 16, 8, valInt},
+}
+
+// nxmFields are the OXM fields which have an NXM header too, the NXM
+// one is in Nicira actions and the OXM one in set_field, as ovs-ofctl
+// does.
+var nxmFields = map[string]string{
+	"oxm_of_eth_dst":  "nxm_of_eth_dst",
+	"oxm_of_eth_src":  "nxm_of_eth_src",
+	"oxm_of_eth_type": "nxm_of_eth_type",
+	"oxm_of_ip_proto": "nxm_of_ip_proto",
+	"oxm_of_ipv4_src": "nxm_of_ip_src",
+	"oxm_of_ipv4_dst": "nxm_of_ip_dst",
+	"oxm_of_tcp_src":  "nxm_of_tcp_src",
+	"oxm_of_tcp_dst":  "nxm_of_tcp_dst",
+	"oxm_of_udp_src":  "nxm_of_udp_src",
+	"oxm_of_udp_dst":  "nxm_of_udp_dst",
+	"oxm_of_ipv6_src": "nxm_nx_ipv6_src",
+	"oxm_of_ipv6_dst": "nxm_nx_ipv6_dst",
+}
+
+// nxm returns the field with its NXM header if it has one.
+func (f ofField) nxm() ofField {
+	for oxm, nxm := range nxmFields {
+		if ofFields[oxm] == f {
+			return ofFields[nxm]
+		}
+	}
+	return f
+}
+
+// oxm returns the field with its OXM header if it has one.
+func (f ofField) oxm() ofField {
+	for oxm, nxm := range nxmFields {
+		if ofFields[nxm] == f {
+			return ofFields[oxm]
+		}
+	}
+	return f
+}
+
+func init() {
+	for i := 0; i < 16; i++ {
+		f := ofField{oxmClassNxm1, uint8(i), 4, valInt}
+		ofFields[fmt.Sprintf("reg%d", i)] = f
+		ofFields[fmt.Sprintf("nxm_nx_reg%d", i)] = f
+	}
+	for i := 0; i < 8; i++ {
+		f := ofField{oxmClassPacketReg, uint8(i), 8, valInt}
+		ofFields[fmt.Sprintf("xreg%d", i)] = f
+		ofFields[fmt.Sprintf("oxm_of_pkt_reg%d", i)] = f
+	}
+	for i := 0; i < 4; i++ {
+		f := ofField{oxmClassExp, uint8(6 + i), 16, valInt}
+		ofFields[fmt.Sprintf("xxreg%d", i)] = f
+		ofFields[fmt.Sprintf("nxm_nx_xxreg%d", i)] = f
+	}
+}
+
+// ctStates are the bits of the ct_state field.
+var ctStates = map[string]uint32{
+	"new":  0x01,
+	"est":  0x02,
+	"rel":  0x04,
+	"rpl":  0x08,
+	"inv":  0x10,
+	"trk":  0x20,
+	"snat": 0x40,
+	"dnat": 0x80,
+}
+
+// protocols are the eth_type and ip_proto a Protocol stands for.
+var protocols = map[string][2]int{
+	"ip":    {0x0800, -1},
+	"ipv6":  {0x86dd, -1},
+	"arp":   {0x0806, -1},
+	"icmp":  {0x0800, 1},
+	"tcp":   {0x0800, 6},
+	"udp":   {0x0800, 17},
+	"sctp":  {0x0800, 132},
+	"icmp6": {0x86dd, 58},
+	"tcp6":  {0x86dd, 6},
+	"udp6":  {0x86dd, 17},
+	"sctp6": {0x86dd, 132},
+}
+
+// An ofFlowMod is a flow mod encoded from a flow in its textual form.
+type ofFlowMod struct {
+	command    uint8
+	table      uint8
+	priority   uint16
+	idle       uint16
+	hard       uint16
+	cookie     uint64
+	cookieMask uint64
+	flags      uint16
+	match      []byte
+	insts      []byte
+}
+
+// compileFlowMod encodes the flow of a directive for the version.
+func compileFlowMod(version uint8, directive, text string) (*ofFlowMod, error) {
+	fm := &ofFlowMod{
+		priority: 0x8000,
+	}
+	switch directive {
+	case dirAdd:
+		fm.command = ofpfcAdd
+	case dirDelete:
+		fm.command = ofpfcDelete
+		fm.table = ofpttAll
+	case dirDeleteStrict:
+		fm.command = ofpfcDeleteStrict
+		fm.table = ofpttAll
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnsupported, directive)
+	}
+
+	matches, actions := text, ""
+	if i := strings.Index(text, "actions="); i >= 0 {
+		matches, actions = strings.TrimSuffix(text[:i], ","), text[i+len("actions="):]
+	}
+
+	m := &ofMatch{}
+	for _, token := range splitTop(matches) {
+		key, value, _ := strings.Cut(token, "=")
+		var err error
+		switch key {
+		case priority:
+			err = parseUint(value, 16, &fm.priority)
+		case table:
+			var t uint64
+			t, err = strconv.ParseUint(value, 0, 8)
+			fm.table = uint8(t)
+		case idleTimeout:
+			err = parseUint(value, 16, &fm.idle)
+		case hardTimeout:
+			err = parseUint(value, 16, &fm.hard)
+		case cookie:
+			err = fm.parseCookie(value)
+		default:
+			err = m.add(key, value)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	match, err := m.encode()
+	if err != nil {
+		return nil, err
+	}
+	fm.match = match
+
+	if fm.command == ofpfcAdd {
+		if fm.insts, err = compileInstructions(version, actions); err != nil {
+			return nil, err
+		}
+		// Flows which may expire are reported when they do.
+		if fm.idle > 0 || fm.hard > 0 {
+			fm.flags |= ofpffSendFlowRem
+		}
+	}
+	return fm, nil
+}
+
+func (fm *ofFlowMod) parseCookie(value string) error {
+	v, mask, found := strings.Cut(value, "/")
+	c, err := strconv.ParseUint(v, 0, 64)
+	if err != nil {
+		return err
+	}
+	fm.cookie = c
+	if !found {
+		return nil
+	}
+	// The mask is ignored when adding a flow.
+	if mask == "-1" {
+		fm.cookieMask = ^uint64(0)
+		return nil
+	}
+	fm.cookieMask, err = strconv.ParseUint(mask, 0, 64)
+	return err
+}
+
+// marshal returns the flow mod message.
+func (fm *ofFlowMod) marshal(version uint8, xid uint32) []byte {
+	b := make([]byte, 48, 48+len(fm.match)+len(fm.insts)+8)
+	binary.BigEndian.PutUint64(b[8:], fm.cookie)
+	binary.BigEndian.PutUint64(b[16:], fm.cookieMask)
+	b[24] = fm.table
+	b[25] = fm.command
+	binary.BigEndian.PutUint16(b[26:], fm.idle)
+	binary.BigEndian.PutUint16(b[28:], fm.hard)
+	binary.BigEndian.PutUint16(b[30:], fm.priority)
+	binary.BigEndian.PutUint32(b[32:], noBuffer)
+	binary.BigEndian.PutUint32(b[36:], ofppAny)
+	binary.BigEndian.PutUint32(b[40:], ofpgAny)
+	binary.BigEndian.PutUint16(b[44:], fm.flags)
+
+	b = append(b, ofpMatch(fm.match)...)
+	b = append(b, fm.insts...)
+	putHeader(b, version, ofptFlowMod, xid)
+	return b
+}
+
+// ofpMatch wraps the OXM fields in an ofp_match.
+func ofpMatch(oxm []byte) []byte {
+	b := []byte{0, 1, 0, 0}
+	binary.BigEndian.PutUint16(b[2:], uint16(4+len(oxm)))
+	b = append(b, oxm...)
+	return pad8(b)
+}
+
+func putHeader(b []byte, version, typ uint8, xid uint32) {
+	b[0] = version
+	b[1] = typ
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+	binary.BigEndian.PutUint32(b[4:], xid)
+}
+
+func pad8(b []byte) []byte {
+	for len(b)%8 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func parseUint(s string, bits int, v *uint16) error {
+	n, err := strconv.ParseUint(s, 0, bits)
+	*v = uint16(n)
+	return err
+}
+
+// splitTop splits s on the commas which are not in parentheses.
+func splitTop(s string) []string {
+	var items []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				if i > start {
+					items = append(items, s[start:i])
+				}
+				start = i + 1
+			}
+		}
+	}
+	if start < len(s) {
+		items = append(items, s[start:])
+	}
+	return items
+}
+
+// An ofMatch gathers the fields of a match, eth_type and ip_proto are
+// encoded first as they are prerequisites of others.
+type ofMatch struct {
+	ethType int
+	ipProto int
+	fields  [][]byte
+	tp      [][2]string
+}
+
+func (m *ofMatch) add(key, value string) error {
+	if p, ok := protocols[key]; ok && value == "" {
+		m.ethType = p[0]
+		if p[1] >= 0 {
+			m.ipProto = p[1]
+		}
+		return nil
+	}
+
+	switch key {
+	case "dl_type", "eth_type":
+		n, err := strconv.ParseUint(value, 0, 16)
+		m.ethType = int(n)
+		return err
+	case "nw_proto", "ip_proto":
+		n, err := strconv.ParseUint(value, 0, 8)
+		m.ipProto = int(n)
+		return err
+	case "tp_src", "tp_dst":
+		// The field depends on ip_proto, which may come later.
+		m.tp = append(m.tp, [2]string{key, value})
+		return nil
+	case "in_port":
+		port, err := ofPort(value)
+		if err != nil {
+			return err
+		}
+		b := ofFields[key].header(false)
+		m.fields = append(m.fields, binary.BigEndian.AppendUint32(b, port))
+		return nil
+	case "dl_vlan":
+		n, err := strconv.ParseUint(value, 0, 12)
+		if err != nil {
+			return err
+		}
+		b := ofFields["vlan_vid"].header(false)
+		m.fields = append(m.fields, binary.BigEndian.AppendUint16(b, uint16(n)|ofpvidPresent))
+		return nil
+	case "ct_state":
+		v, mask, err := parseCtState(value)
+		if err != nil {
+			return err
+		}
+		b := ofFields[key].header(true)
+		b = binary.BigEndian.AppendUint32(b, v)
+		m.fields = append(m.fields, binary.BigEndian.AppendUint32(b, mask))
+		return nil
+	case "icmp_type", "icmp_code":
+		if m.ethType == 0x86dd {
+			key = strings.Replace(key, "icmp", "icmpv6", 1)
+		}
+	}
+
+	f, ok := ofFields[strings.ToLower(key)]
+	if !ok || value == "" {
+		return fmt.Errorf("%w: match %s", errUnsupported, key)
+	}
+	b, err := f.encode(value, true)
+	if err != nil {
+		return err
+	}
+	m.fields = append(m.fields, b)
+	return nil
+}
+
+func (m *ofMatch) encode() ([]byte, error) {
+	var b []byte
+	if m.ethType > 0 {
+		b = binary.BigEndian.AppendUint16(ofFields["eth_type"].header(false), uint16(m.ethType))
+	}
+	if m.ipProto > 0 {
+		b = append(b, ofFields["ip_proto"].header(false)...)
+		b = append(b, uint8(m.ipProto))
+	}
+	for _, tp := range m.tp {
+		var name string
+		switch m.ipProto {
+		case 6:
+			name = "tcp"
+		case 17:
+			name = "udp"
+		case 132:
+			name = "sctp"
+		default:
+			return nil, fmt.Errorf("%w: match %s without protocol", errUnsupported, tp[0])
+		}
+		f, err := ofFields[name+strings.TrimPrefix(tp[0], "tp")].encode(tp[1], true)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, f...)
+	}
+	for _, f := range m.fields {
+		b = append(b, f...)
+	}
+	return b, nil
+}
+
+// encode returns the OXM of the field with the value, which may have a
+// mask if masked is true.
+func (f ofField) encode(value string, masked bool) ([]byte, error) {
+	v, mask, found := strings.Cut(value, "/")
+	if found && !masked {
+		return nil, fmt.Errorf("%w: mask of %s", errUnsupported, value)
+	}
+
+	vb, err := f.value(v)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return append(f.header(false), vb...), nil
+	}
+
+	var mb []byte
+	switch {
+	case f.kind == valIPv4 && !strings.Contains(mask, "."):
+		mb, err = prefixMask(mask, 32)
+	case f.kind == valIPv6 && !strings.Contains(mask, ":"):
+		mb, err = prefixMask(mask, 128)
+	default:
+		mb, err = f.value(mask)
+	}
+	if err != nil {
+		return nil, err
+	}
+	b := append(f.header(true), vb...)
+	return append(b, mb...), nil
+}
+
+// value returns the bytes of a value of the field, a number in hex for
+// any field as load takes it.
+func (f ofField) value(s string) ([]byte, error) {
+	if strings.HasPrefix(s, "0x") {
+		return f.number(s)
+	}
+	switch f.kind {
+	case valMAC:
+		mac, err := net.ParseMAC(s)
+		if err != nil || len(mac) != 6 {
+			return nil, fmt.Errorf("invalid mac address %q", s)
+		}
+		return mac, nil
+	case valIPv4:
+		ip := net.ParseIP(s).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ipv4 address %q", s)
+		}
+		return ip, nil
+	case valIPv6:
+		ip := net.ParseIP(s)
+		if ip == nil || ip.To4() != nil && !strings.Contains(s, ":") {
+			return nil, fmt.Errorf("invalid ipv6 address %q", s)
+		}
+		return ip.To16(), nil
+	}
+	return f.number(s)
+}
+
+func (f ofField) number(s string) ([]byte, error) {
+	n, ok := new(big.Int).SetString(s, 0)
+	if !ok || n.Sign() < 0 || n.BitLen() > f.size*8 {
+		return nil, fmt.Errorf("invalid value %q", s)
+	}
+	return n.FillBytes(make([]byte, f.size)), nil
+}
+
+func prefixMask(s string, bits int) ([]byte, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > bits {
+		return nil, fmt.Errorf("invalid prefix length %q", s)
+	}
+	return net.CIDRMask(n, bits), nil
+}
+
+func parseCtState(s string) (uint32, uint32, error) {
+	var value, mask uint32
+	for s != "" {
+		set := s[0] == '+'
+		if s[0] != '+' && s[0] != '-' {
+			return 0, 0, fmt.Errorf("invalid ct_state %q", s)
+		}
+		s = s[1:]
+		end := strings.IndexAny(s, "+-")
+		if end < 0 {
+			end = len(s)
+		}
+		bit, ok := ctStates[s[:end]]
+		if !ok {
+			return 0, 0, fmt.Errorf("%w: ct_state %s", errUnsupported, s[:end])
+		}
+		mask |= bit
+		if set {
+			value |= bit
+		}
+		s = s[end:]
+	}
+	return value, mask, nil
+}
+
+func ofPort(s string) (uint32, error) {
+	switch strings.ToLower(s) {
+	case "local":
+		return ofppLocal, nil
+	case "in_port":
+		return ofppInPort, nil
+	case "normal":
+		return ofppNormal, nil
+	case "flood":
+		return ofppFlood, nil
+	case "all":
+		return ofppAll, nil
+	case "controller":
+		return ofppController, nil
+	}
+	n, err := strconv.ParseUint(s, 0, 32)
+	return uint32(n), err
+}
+
+// A subfield is a field with a bit range, as in NXM_NX_REG1[0..15].
+type subfield struct {
+	f      ofField
+	offset int
+	nbits  int
+}
+
+func parseSubfield(s string) (subfield, error) {
+	name, bits, _ := strings.Cut(s, "[")
+	f, ok := ofFields[strings.ToLower(name)]
+	if !ok {
+		return subfield{}, fmt.Errorf("%w: field %s", errUnsupported, name)
+	}
+	sf := subfield{f: f, nbits: f.size * 8}
+
+	bits = strings.TrimSuffix(bits, "]")
+	if bits == "" {
+		return sf, nil
+	}
+	start, end, found := strings.Cut(bits, "..")
+	if !found {
+		end = start
+	}
+	a, err := strconv.Atoi(start)
+	if err != nil {
+		return sf, fmt.Errorf("invalid subfield %q", s)
+	}
+	z, err := strconv.Atoi(end)
+	if err != nil || z < a || z >= f.size*8 {
+		return sf, fmt.Errorf("invalid subfield %q", s)
+	}
+	sf.offset, sf.nbits = a, z-a+1
+	return sf, nil
+}
+
+func (sf subfield) whole() bool {
+	return sf.offset == 0 && sf.nbits == sf.f.size*8
+}
+
+func (sf subfield) ofsNbits() uint16 {
+	return uint16(sf.offset<<6 | (sf.nbits - 1))
+}
+
+// compileInstructions encodes the actions of a flow.
+func compileInstructions(version uint8, text string) ([]byte, error) {
+	var meter, gotoTable, actions []byte
+	for _, token := range splitTop(text) {
+		if id, found := strings.CutPrefix(token, "meter:"); found {
+			n, err := strconv.ParseUint(id, 0, 32)
+			if err != nil {
+				return nil, err
+			}
+			meter = binary.BigEndian.AppendUint32(nil, uint32(n))
+			continue
+		}
+		if id, found := strings.CutPrefix(token, "goto_table:"); found {
+			n, err := strconv.ParseUint(id, 0, 8)
+			if err != nil {
+				return nil, err
+			}
+			gotoTable = []byte{0, ofpitGotoTable, 0, 8, uint8(n), 0, 0, 0}
+			continue
+		}
+		b, err := compileAction(version, token)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, b...)
+	}
+
+	var b []byte
+	if meter != nil {
+		// The meter is an action since OpenFlow 1.5.
+		if version >= ofVersion15 {
+			actions = append(ofAction(ofpatMeter, meter), actions...)
+		} else {
+			b = append(b, 0, ofpitMeter, 0, 8)
+			b = append(b, meter...)
+		}
+	}
+	if len(actions) > 0 {
+		inst := []byte{0, ofpitApplyActions, 0, 0, 0, 0, 0, 0}
+		inst = append(inst, actions...)
+		binary.BigEndian.PutUint16(inst[2:], uint16(len(inst)))
+		b = append(b, inst...)
+	}
+	return append(b, gotoTable...), nil
+}
+
+// ofAction returns an action with its body, padded to 8 bytes.
+func ofAction(typ uint16, body []byte) []byte {
+	b := binary.BigEndian.AppendUint16(nil, typ)
+	b = append(b, 0, 0)
+	b = append(b, body...)
+	b = pad8(b)
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+	return b
+}
+
+// nxAction returns a Nicira extension action with its body.
+func nxAction(subtype uint16, body []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, nxVendor)
+	b = binary.BigEndian.AppendUint16(b, subtype)
+	return ofAction(ofpatExperiment, append(b, body...))
+}
+
+func ofOutput(port uint32) []byte {
+	b := binary.BigEndian.AppendUint32(nil, port)
+	maxLen := uint16(0)
+	if port == ofppController {
+		maxLen = ofpcmlMax
+	}
+	b = binary.BigEndian.AppendUint16(b, maxLen)
+	return ofAction(ofpatOutput, append(b, 0, 0, 0, 0, 0, 0))
+}
+
+func ofSetField(f ofField, value string) ([]byte, error) {
+	b, err := f.encode(value, false)
+	if err != nil {
+		return nil, err
+	}
+	return ofAction(ofpatSetField, b), nil
+}
+
+// compileAction encodes an action in its textual form.
+func compileAction(version uint8, token string) ([]byte, error) {
+	name, arg, _ := strings.Cut(token, ":")
+	if i := strings.IndexByte(token, '('); i >= 0 && (strings.IndexByte(token, ':') < 0 || i < strings.IndexByte(token, ':')) {
+		name, arg = token[:i], strings.TrimSuffix(token[i+1:], ")")
+	}
+
+	switch name {
+	case "drop":
+		return nil, nil
+	case "normal", "flood", "all", "local", "in_port", "controller":
+		if arg != "" {
+			break
+		}
+		port, _ := ofPort(name)
+		return ofOutput(port), nil
+	case "output":
+		if strings.ContainsAny(arg, "[") || ofFields[strings.ToLower(arg)].size > 0 {
+			return ofOutputReg(arg)
+		}
+		port, err := ofPort(arg)
+		if err != nil {
+			return nil, err
+		}
+		return ofOutput(port), nil
+	case "group":
+		id, err := strconv.ParseUint(arg, 0, 32)
+		if err != nil {
+			return nil, err
+		}
+		return ofAction(ofpatGroup, binary.BigEndian.AppendUint32(nil, uint32(id))), nil
+	case "resubmit":
+		return ofResubmit(arg)
+	case "strip_vlan", "pop_vlan":
+		return ofAction(ofpatPopVLAN, []byte{0, 0, 0, 0}), nil
+	case "push_vlan":
+		n, err := strconv.ParseUint(arg, 0, 16)
+		if err != nil {
+			return nil, err
+		}
+		return ofAction(ofpatPushVLAN, binary.BigEndian.AppendUint16(nil, uint16(n))), nil
+	case "dec_ttl":
+		if arg != "" {
+			break
+		}
+		return ofAction(ofpatDecNwTTL, []byte{0, 0, 0, 0}), nil
+	case "ct_clear":
+		return nxAction(nxastCtClear, []byte{0, 0, 0, 0, 0, 0}), nil
+	case "ct":
+		return ofConntrack(version, arg)
+	case "load", "set_field":
+		value, dst, found := strings.Cut(arg, "->")
+		if !found {
+			break
+		}
+		return ofLoad(version, value, dst)
+	case "move":
+		src, dst, found := strings.Cut(arg, "->")
+		if !found {
+			break
+		}
+		return ofMove(src, dst)
+	case "push", "pop":
+		return ofStack(name, arg)
+	case "mod_dl_dst":
+		return ofSetField(ofFields["eth_dst"], arg)
+	case "mod_dl_src":
+		return ofSetField(ofFields["eth_src"], arg)
+	case "mod_nw_dst":
+		return ofSetField(ofFields["nw_dst"], arg)
+	case "mod_nw_src":
+		return ofSetField(ofFields["nw_src"], arg)
+	case "mod_vlan_vid":
+		n, err := strconv.ParseUint(arg, 0, 12)
+		if err != nil {
+			return nil, err
+		}
+		return ofSetField(ofFields["vlan_vid"], strconv.FormatUint(n|ofpvidPresent, 10))
+	default:
+		// A bare number is an output port.
+		if port, err := strconv.ParseUint(token, 10, 32); err == nil {
+			return ofOutput(uint32(port)), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: action %s", errUnsupported, token)
+}
+
+func ofResubmit(arg string) ([]byte, error) {
+	port, tbl, found := strings.Cut(arg, ",")
+	if !found {
+		tbl = ""
+	}
+	inPort, t := uint16(ofppInPort&0xffff), uint8(ofpttAll)
+	if port != "" {
+		p, err := strconv.ParseUint(port, 0, 16)
+		if err != nil {
+			return nil, err
+		}
+		inPort = uint16(p)
+	}
+	if tbl != "" {
+		n, err := strconv.ParseUint(tbl, 0, 8)
+		if err != nil {
+			return nil, err
+		}
+		t = uint8(n)
+	}
+	b := binary.BigEndian.AppendUint16(nil, inPort)
+	return nxAction(nxastResubmitTbl, append(b, t, 0, 0, 0)), nil
+}
+
+func ofOutputReg(arg string) ([]byte, error) {
+	sf, err := parseSubfield(arg)
+	if err != nil {
+		return nil, err
+	}
+	if sf.f.class == oxmClassExp {
+		return nil, fmt.Errorf("%w: output of %s", errUnsupported, arg)
+	}
+	b := binary.BigEndian.AppendUint16(nil, sf.ofsNbits())
+	b = append(b, sf.f.nxm().header(false)...)
+	// The length sent to a controller, as ovs-ofctl sets it.
+	b = binary.BigEndian.AppendUint16(b, ofpcmlMax)
+	b = append(b, 0, 0, 0, 0, 0, 0)
+	return nxAction(nxastOutputReg, b), nil
+}
+
+// ofLoad encodes a load as a set_field, of part of the field with a mask
+// since OpenFlow 1.5 and with a Nicira reg_load before.
+func ofLoad(version uint8, value, dst string) ([]byte, error) {
+	sf, err := parseSubfield(dst)
+	if err != nil {
+		return nil, err
+	}
+	if sf.whole() {
+		return ofSetField(sf.f.oxm(), value)
+	}
+	if sf.f.class == oxmClassExp {
+		return nil, fmt.Errorf("%w: load to %s", errUnsupported, dst)
+	}
+	n, ok := new(big.Int).SetString(value, 0)
+	if !ok || n.Sign() < 0 || n.BitLen() > sf.nbits || n.BitLen() > 64 {
+		return nil, fmt.Errorf("invalid value %q", value)
+	}
+
+	if version >= ofVersion15 {
+		f := sf.f.oxm()
+		mask := new(big.Int).Lsh(big.NewInt(1), uint(sf.nbits))
+		mask.Sub(mask, big.NewInt(1))
+		mask.Lsh(mask, uint(sf.offset))
+		n.Lsh(n, uint(sf.offset))
+		b := f.header(true)
+		b = append(b, n.FillBytes(make([]byte, f.size))...)
+		b = append(b, mask.FillBytes(make([]byte, f.size))...)
+		return ofAction(ofpatSetField, b), nil
+	}
+	b := binary.BigEndian.AppendUint16(nil, sf.ofsNbits())
+	b = append(b, sf.f.nxm().header(false)...)
+	b = binary.BigEndian.AppendUint64(b, n.Uint64())
+	return nxAction(nxastRegLoad, b), nil
+}
+
+func ofMove(src, dst string) ([]byte, error) {
+	from, err := parseSubfield(src)
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseSubfield(dst)
+	if err != nil {
+		return nil, err
+	}
+	if from.f.class == oxmClassExp || to.f.class == oxmClassExp {
+		return nil, fmt.Errorf("%w: move of %s", errUnsupported, src)
+	}
+	if from.nbits != to.nbits {
+		return nil, fmt.Errorf("invalid move %s->%s", src, dst)
+	}
+	b := binary.BigEndian.AppendUint16(nil, uint16(from.nbits))
+	b = binary.BigEndian.AppendUint16(b, uint16(from.offset))
+	b = binary.BigEndian.AppendUint16(b, uint16(to.offset))
+	b = append(b, from.f.nxm().header(false)...)
+	b = append(b, to.f.nxm().header(false)...)
+	return nxAction(nxastRegMove, b), nil
+}
+
+func ofStack(name, arg string) ([]byte, error) {
+	sf, err := parseSubfield(arg)
+	if err != nil {
+		return nil, err
+	}
+	subtype := uint16(nxastStackPush)
+	if name == "pop" {
+		subtype = nxastStackPop
+	}
+	b := binary.BigEndian.AppendUint16(nil, uint16(sf.offset))
+	b = append(b, sf.f.nxm().header(false)...)
+	b = binary.BigEndian.AppendUint16(b, uint16(sf.nbits))
+	for len(b) < 14 {
+		b = append(b, 0)
+	}
+	return nxAction(subtype, b), nil
+}
+
+// ofConntrack encodes ct(...) with its nat and exec actions nested.
+func ofConntrack(version uint8, arg string) ([]byte, error) {
+	var flags uint16
+	var zone uint16
+	var alg uint16
+	recirc := uint8(nxCtRecircNone)
+	var nested []byte
+
+	for _, token := range splitTop(arg) {
+		key, value, _ := strings.Cut(token, "=")
+		switch {
+		case key == "commit":
+			flags |= nxCtFlagCommit
+		case key == "force":
+			flags |= nxCtFlagForce
+		case key == "zone":
+			n, err := strconv.ParseUint(value, 0, 16)
+			if err != nil {
+				return nil, fmt.Errorf("%w: ct zone %s", errUnsupported, value)
+			}
+			zone = uint16(n)
+		case key == "table":
+			n, err := strconv.ParseUint(value, 0, 8)
+			if err != nil {
+				return nil, err
+			}
+			recirc = uint8(n)
+		case key == "alg":
+			switch value {
+			case "ftp":
+				alg = 21
+			case "tftp":
+				alg = 69
+			default:
+				return nil, fmt.Errorf("%w: ct alg %s", errUnsupported, value)
+			}
+		case key == "nat" || strings.HasPrefix(token, "nat("):
+			b, err := ofNat(strings.TrimSuffix(strings.TrimPrefix(token, "nat("), ")"), token == "nat")
+			if err != nil {
+				return nil, err
+			}
+			nested = append(nested, b...)
+		case strings.HasPrefix(token, "exec("):
+			for _, a := range splitTop(strings.TrimSuffix(strings.TrimPrefix(token, "exec("), ")")) {
+				b, err := compileAction(version, a)
+				if err != nil {
+					return nil, err
+				}
+				nested = append(nested, b...)
+			}
+		default:
+			return nil, fmt.Errorf("%w: ct %s", errUnsupported, token)
+		}
+	}
+
+	b := binary.BigEndian.AppendUint16(nil, flags)
+	// An immediate zone has no source field.
+	b = append(b, 0, 0, 0, 0)
+	b = binary.BigEndian.AppendUint16(b, zone)
+	b = append(b, recirc, 0, 0, 0)
+	b = binary.BigEndian.AppendUint16(b, alg)
+
+	ct := nxAction(nxastConntrack, b)
+	ct = append(ct, nested...)
+	binary.BigEndian.PutUint16(ct[2:], uint16(len(ct)))
+	return ct, nil
+}
+
+// ofNat encodes nat(src=addr[-addr][:port[-port]],flags...).
+func ofNat(arg string, bare bool) ([]byte, error) {
+	var flags, present uint16
+	var ranges []byte
+
+	if !bare {
+		for _, token := range splitTop(arg) {
+			key, value, _ := strings.Cut(token, "=")
+			switch key {
+			case "src", "dst":
+				if key == "src" {
+					flags |= nxNatFlagSrc
+				} else {
+					flags |= nxNatFlagDst
+				}
+				b, p, err := ofNatRange(value)
+				if err != nil {
+					return nil, err
+				}
+				ranges, present = b, p
+			case "persistent":
+				flags |= nxNatPersistent
+			case "hash":
+				flags |= nxNatProtoHash
+			case "random":
+				flags |= nxNatProtoRandom
+			default:
+				return nil, fmt.Errorf("%w: nat %s", errUnsupported, token)
+			}
+		}
+	}
+
+	b := []byte{0, 0}
+	b = binary.BigEndian.AppendUint16(b, flags)
+	b = binary.BigEndian.AppendUint16(b, present)
+	return nxAction(nxastNAT, append(b, ranges...)), nil
+}
+
+// ofNatRange encodes the addresses and ports of a nat, returning the
+// ranges present.
+func ofNatRange(s string) ([]byte, uint16, error) {
+	var addrs, ports string
+	if strings.HasPrefix(s, "[") {
+		// [fd00::1]-[fd00::9]:80-90
+		end := strings.LastIndex(s, "]")
+		if end < 0 {
+			return nil, 0, fmt.Errorf("invalid nat range %q", s)
+		}
+		addrs, ports = s[:end+1], strings.TrimPrefix(s[end+1:], ":")
+	} else {
+		addrs, ports, _ = strings.Cut(s, ":")
+	}
+
+	var b []byte
+	var present uint16
+	min, max, found := strings.Cut(addrs, "-")
+	lo := net.ParseIP(strings.Trim(min, "[]"))
+	if lo == nil {
+		return nil, 0, fmt.Errorf("invalid nat address %q", min)
+	}
+	if ip := lo.To4(); ip != nil {
+		b = append(b, ip...)
+		present |= nxNatAddr4Min
+		if found {
+			hi := net.ParseIP(max).To4()
+			if hi == nil {
+				return nil, 0, fmt.Errorf("invalid nat address %q", max)
+			}
+			b = append(b, hi...)
+			present |= nxNatAddr4Max
+		}
+	} else {
+		b = append(b, lo.To16()...)
+		present |= nxNatAddr6Min
+		if found {
+			hi := net.ParseIP(strings.Trim(max, "[]"))
+			if hi == nil {
+				return nil, 0, fmt.Errorf("invalid nat address %q", max)
+			}
+			b = append(b, hi.To16()...)
+			present |= nxNatAddr6Max
+		}
+	}
+
+	if ports != "" {
+		min, max, found := strings.Cut(ports, "-")
+		p, err := strconv.ParseUint(min, 10, 16)
+		if err != nil {
+			return nil, 0, err
+		}
+		b = binary.BigEndian.AppendUint16(b, uint16(p))
+		present |= nxNatProtoMin
+		if found {
+			p, err := strconv.ParseUint(max, 10, 16)
+			if err != nil {
+				return nil, 0, err
+			}
+			b = binary.BigEndian.AppendUint16(b, uint16(p))
+			present |= nxNatProtoMax
+		}
+	}
+	return b, present, nil
+}
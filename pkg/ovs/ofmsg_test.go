@@ -0,0 +1,364 @@
+// Copyright 2017 DigitalOcean.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompileFlowModOK(t *testing.T) {
+	flow := &Flow{
+		Priority: 10,
+		Protocol: ProtocolIPv4,
+		InPort:   3,
+		Actions:  []Action{Output(2)},
+	}
+	fb, err := flow.MarshalText()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fm, err := compileFlowMod(ofVersion15, dirAdd, string(fb))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := strings.Join([]string{
+		// Header, cookie and cookie mask.
+		"060e006000000007", "0000000000000000", "0000000000000000",
+		// Table, command, timeouts and priority.
+		"00000000" + "0000" + "000a",
+		// Buffer, output port and group, flags and importance.
+		"ffffffff" + "ffffffff" + "ffffffff" + "00000000",
+		// Match of eth_type and in_port.
+		"00010012" + "80000a020800" + "8000000400000003" + "000000000000",
+		// Apply actions with an output.
+		"00040018" + "00000000" + "00000010000000020000000000000000",
+	}, "")
+	got := hex.EncodeToString(fm.marshal(ofVersion15, 7))
+	if want != got {
+		t.Fatalf("unexpected flow mod:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestCompileFlowModDeleteAllOK(t *testing.T) {
+	fm, err := compileFlowMod(ofVersion13, dirDelete, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want, got := uint8(ofpttAll), fm.table; want != got {
+		t.Fatalf("unexpected table:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := 0, len(fm.match); want != got {
+		t.Fatalf("unexpected match length:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestCompileFlowModConntrackOK(t *testing.T) {
+	flow := &Flow{
+		Priority: 10,
+		Protocol: ProtocolTCPv4,
+		Table:    1,
+		Actions: []Action{
+			ConnectionTracking("commit,nat(dst=1.2.3.4:80),zone=10,table=15"),
+		},
+	}
+	fb, err := flow.MarshalText()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fm, err := compileFlowMod(ofVersion15, dirAdd, string(fb))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := strings.Join([]string{
+		// NXAST_CT with commit, zone 10 and table 15.
+		"ffff0030000023200023" + "0001" + "00000000" + "000a" + "0f000000" + "0000",
+		// NXAST_NAT of the destination address and port.
+		"ffff0018000023200024" + "0000" + "0002" + "0011" + "01020304" + "0050" + "0000",
+	}, "")
+	if got := hex.EncodeToString(fm.insts); !strings.Contains(got, want) {
+		t.Fatalf("unexpected instructions:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestCompileFlowModUnsupported(t *testing.T) {
+	tests := []string{
+		"priority=10,table=0,idle_timeout=0,actions=learn(table=1)",
+		"priority=10,tcp,table=0,idle_timeout=0,actions=mod_tp_dst:80",
+	}
+
+	for _, tt := range tests {
+		t.Run(tt, func(t *testing.T) {
+			_, err := compileFlowMod(ofVersion15, dirAdd, tt)
+			if !errors.Is(err, errUnsupported) {
+				t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", errUnsupported, err)
+			}
+		})
+	}
+}
+
+func TestCompileActionMoveOK(t *testing.T) {
+	b, err := compileAction(ofVersion15, "move:NXM_OF_IP_SRC[]->NXM_NX_REG3[]")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// NXAST_REG_MOVE of 32 bits from ip_src to reg3.
+	want := "ffff0018000023200006" + "0020" + "0000" + "0000" + "00000e04" + "00010604"
+	if got := hex.EncodeToString(b); want != got {
+		t.Fatalf("unexpected action:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+// The flows of the pipeline, encoded as ovs-ofctl -O OpenFlow15 does. The
+// match fields keep the order of the text after their prerequisites.
+func TestCompileFlowModGolden(t *testing.T) {
+	tests := []struct {
+		desc  string
+		flow  string
+		match string
+		insts string
+	}{
+		{
+			desc: "drop",
+			flow: "priority=0,table=250,idle_timeout=0,actions=drop",
+		},
+		{
+			desc:  "resubmit",
+			flow:  "priority=100,ip,table=0,idle_timeout=0,actions=resubmit(,10)",
+			match: "80000a020800",
+			insts: "00040018" + "00000000" + "ffff001000002320000e" + "fff8" + "0a000000",
+		},
+		{
+			desc:  "icmp6 type to normal",
+			flow:  "priority=110,icmp6,icmp_type=133,table=0,idle_timeout=0,actions=normal",
+			match: "80000a0286dd" + "800014013a" + "80003a0185",
+			insts: "00040018" + "00000000" + "00000010" + "fffffffa" + "0000" + "000000000000",
+		},
+		{
+			desc:  "move and ct with nat",
+			flow:  "priority=100,ip,table=10,idle_timeout=0,actions=move:NXM_OF_IP_SRC[]->NXM_NX_REG3[],ct(nat,zone=10,table=12)",
+			match: "80000a020800",
+			insts: "00040048" + "00000000" +
+				"ffff0018000023200006" + "0020" + "0000" + "0000" + "00000e04" + "00010604" +
+				"ffff0028000023200023" + "0000" + "00000000" + "000a" + "0c000000" + "0000" +
+				"ffff0010000023200024" + "0000" + "0000" + "0000",
+		},
+		{
+			desc:  "ct_state",
+			flow:  "priority=200,ip,ct_state=+trk+est,table=12,idle_timeout=0,actions=resubmit(,15)",
+			match: "80000a020800" + "0001d308" + "00000022" + "00000022",
+			insts: "00040018" + "00000000" + "ffff001000002320000e" + "fff8" + "0f000000",
+		},
+		{
+			desc:  "nw_ttl and meter",
+			flow:  "priority=300,ip,nw_ttl=0,dl_dst=00:00:00:00:20:15,table=19,idle_timeout=0,actions=meter:1,resubmit(,30)",
+			match: "80000a020800" + "00013a0100" + "80000606000000002015",
+			insts: "00040020" + "00000000" + "001d0008" + "00000001" + "ffff001000002320000e" + "fff8" + "1e000000",
+		},
+		{
+			desc:  "push and pop of reg0",
+			flow:  "priority=0,ip,table=19,idle_timeout=0,actions=push:OXM_OF_IPV4_DST,pop:reg0,resubmit(,20)",
+			match: "80000a020800",
+			insts: "00040048" + "00000000" +
+				"ffff001800002320001b" + "0000" + "00001004" + "0020" + "000000000000" +
+				"ffff001800002320001c" + "0000" + "00010004" + "0020" + "000000000000" +
+				"ffff001000002320000e" + "fff8" + "14000000",
+		},
+		{
+			desc:  "push and pop of xxreg3",
+			flow:  "priority=0,ipv6,table=21,idle_timeout=0,actions=push:NXM_NX_IPV6_DST,pop:xxreg3,resubmit(,22)",
+			match: "80000a0286dd",
+			insts: "00040048" + "00000000" +
+				"ffff001800002320001b" + "0000" + "00012810" + "0080" + "000000000000" +
+				"ffff001800002320001c" + "0000" + "ffff1214" + "00002320" + "0080" + "0000" +
+				"ffff001000002320000e" + "fff8" + "16000000",
+		},
+		{
+			desc:  "strip_vlan and output to a register",
+			flow:  "priority=1,ip,dl_dst=00:00:00:00:20:15,table=20,idle_timeout=0,actions=meter:2,strip_vlan,output:NXM_NX_REG1[]",
+			match: "80000a020800" + "80000606000000002015",
+			insts: "00040030" + "00000000" + "001d0008" + "00000002" + "00120008" + "00000000" +
+				"ffff001800002320000f" + "001f" + "00010204" + "ffff" + "000000000000",
+		},
+		{
+			desc:  "load of reg0",
+			flow:  "priority=0,ip,table=20,idle_timeout=0,actions=load:0x0->reg0,resubmit(,30)",
+			match: "80000a020800",
+			insts: "00040028" + "00000000" + "00190010" + "00010004" + "00000000" + "00000000" +
+				"ffff001000002320000e" + "fff8" + "1e000000",
+		},
+		{
+			desc:  "load of xxreg3",
+			flow:  "priority=0,ipv6,table=22,idle_timeout=0,actions=load:0x0->xxreg3,resubmit(,30)",
+			match: "80000a0286dd",
+			insts: "00040038" + "00000000" + "00190020" + "ffff1214" + "00002320" +
+				"00000000000000000000000000000000" + "00000000" +
+				"ffff001000002320000e" + "fff8" + "1e000000",
+		},
+		{
+			desc:  "dl_vlan and load of metadata",
+			flow:  "priority=105,ip,dl_vlan=10,table=0,idle_timeout=0,actions=load:0x64->OXM_OF_METADATA[],resubmit(,19)",
+			match: "80000a020800" + "80000c02100a",
+			insts: "00040028" + "00000000" + "00190010" + "80000408" + "0000000000000064" +
+				"ffff001000002320000e" + "fff8" + "13000000",
+		},
+		{
+			desc:  "masked reg3, pkt_mark and ip_dscp",
+			flow:  "priority=32668,ip,reg3=0xc0a80100/0xffffff00,pkt_mark=0x1/0xff,ip_dscp=10,table=15,idle_timeout=0,actions=load:0xc8->OXM_OF_METADATA[],resubmit(,19)",
+			match: "80000a020800" + "00010708" + "c0a80100" + "ffffff00" + "00014308" + "00000001" + "000000ff" + "800010010a",
+			insts: "00040028" + "00000000" + "00190010" + "80000408" + "00000000000000c8" +
+				"ffff001000002320000e" + "fff8" + "13000000",
+		},
+		{
+			desc:  "masked ipv6_src",
+			flow:  "priority=32668,ipv6,ipv6_src=fd00::/64,table=15,idle_timeout=0,actions=resubmit(,21)",
+			match: "80000a0286dd" + "80003520" + "fd000000000000000000000000000000" + "ffffffffffffffff0000000000000000",
+			insts: "00040018" + "00000000" + "ffff001000002320000e" + "fff8" + "15000000",
+		},
+		{
+			desc:  "metadata",
+			flow:  "priority=2,ip,metadata=0xc8,table=19,idle_timeout=0,actions=load:0x0->OXM_OF_METADATA[],resubmit(,19)",
+			match: "80000a020800" + "80000408" + "00000000000000c8",
+			insts: "00040028" + "00000000" + "00190010" + "80000408" + "0000000000000000" +
+				"ffff001000002320000e" + "fff8" + "13000000",
+		},
+		{
+			desc:  "route to a gateway",
+			flow:  "priority=124,ip,metadata=0x0,nw_dst=10.1.0.0/16,dl_dst=00:00:00:00:20:15,table=19,idle_timeout=0,actions=load:0x2->NXM_NX_REG1[],load:0x0a000001->reg0,resubmit(,20)",
+			match: "80000a020800" + "80000408" + "0000000000000000" + "80001908" + "0a010000" + "ffff0000" + "80000606000000002015",
+			insts: "00040038" + "00000000" +
+				"00190010" + "00010204" + "00000002" + "00000000" +
+				"00190010" + "00010004" + "0a000001" + "00000000" +
+				"ffff001000002320000e" + "fff8" + "14000000",
+		},
+		{
+			desc:  "route to a group",
+			flow:  "priority=124,ip,metadata=0x0,nw_dst=10.2.0.0/16,table=19,idle_timeout=0,actions=group:5",
+			match: "80000a020800" + "80000408" + "0000000000000000" + "80001908" + "0a020000" + "ffff0000",
+			insts: "00040010" + "00000000" + "00160008" + "00000005",
+		},
+		{
+			desc:  "host",
+			flow:  "priority=100,ip,metadata=0x0,reg0=0x0a000001,dl_dst=00:00:00:00:20:15,table=20,idle_timeout=0,actions=push:NXM_OF_ETH_DST,pop:NXM_OF_ETH_SRC,load:0x001122334455->NXM_OF_ETH_DST,load:0xa->NXM_OF_VLAN_TCI,load:0x2->NXM_OF_IN_PORT,dec_ttl,resubmit(,30)",
+			match: "80000a020800" + "80000408" + "0000000000000000" + "00010004" + "0a000001" + "80000606000000002015",
+			insts: "00040080" + "00000000" +
+				"ffff001800002320001b" + "0000" + "00000206" + "0030" + "000000000000" +
+				"ffff001800002320001c" + "0000" + "00000406" + "0030" + "000000000000" +
+				"00190010" + "80000606" + "001122334455" + "0000" +
+				"00190010" + "00000802" + "000a" + "000000000000" +
+				"00190010" + "00000002" + "0002" + "000000000000" +
+				"00180008" + "00000000" +
+				"ffff001000002320000e" + "fff8" + "1e000000",
+		},
+		{
+			desc:  "host of xxreg3",
+			flow:  "priority=100,ipv6,metadata=0x0,xxreg3=0xfd000000000000000000000000000001,table=22,idle_timeout=0,actions=dec_ttl,resubmit(,30)",
+			match: "80000a0286dd" + "80000408" + "0000000000000000" + "ffff1214" + "00002320" + "fd000000000000000000000000000001",
+			insts: "00040020" + "00000000" + "00180008" + "00000000" + "ffff001000002320000e" + "fff8" + "1e000000",
+		},
+		{
+			desc:  "snat to a pool",
+			flow:  "priority=50,ip,ct_state=+trk+new,nw_src=192.168.1.0/24,table=12,idle_timeout=0,actions=ct(commit,nat(src=10.0.0.1-10.0.0.4:1024-65535,random,persistent),zone=10,table=15)",
+			match: "80000a020800" + "0001d308" + "00000021" + "00000021" + "80001708" + "c0a80100" + "ffffff00",
+			insts: "00040040" + "00000000" +
+				"ffff0038000023200023" + "0001" + "00000000" + "000a" + "0f000000" + "0000" +
+				"ffff0020000023200024" + "0000" + "0015" + "0033" + "0a000001" + "0a000004" + "0400" + "ffff" + "00000000",
+		},
+		{
+			desc:  "masked tp_dst to a group",
+			flow:  "priority=160,tcp,ct_state=+trk+new,nw_dst=10.0.0.1,tp_dst=0x1000/0xf000,table=12,idle_timeout=0,actions=group:7",
+			match: "80000a020800" + "8000140106" + "80001d04" + "1000" + "f000" + "0001d308" + "00000021" + "00000021" + "800018040a000001",
+			insts: "00040010" + "00000000" + "00160008" + "00000007",
+		},
+		{
+			desc:  "ct_clear",
+			flow:  "priority=202,tcp,ct_state=+trk+rpl,nw_dst=192.168.1.2,nw_src=192.168.1.2,tp_src=8000,table=12,idle_timeout=0,actions=ct_clear,resubmit(,10)",
+			match: "80000a020800" + "8000140106" + "80001a021f40" + "0001d308" + "00000028" + "00000028" + "80001804c0a80102" + "80001604c0a80102",
+			insts: "00040028" + "00000000" + "ffff001000002320002b" + "000000000000" +
+				"ffff001000002320000e" + "fff8" + "0a000000",
+		},
+		{
+			desc:  "mod_dl and partial load",
+			flow:  "priority=10,ip,in_port=3,table=0,idle_timeout=0,actions=mod_dl_src:00:11:22:33:44:55,mod_dl_dst:00:11:22:33:44:66,load:0x1->NXM_NX_REG2[0]",
+			match: "80000a020800" + "8000000400000003",
+			insts: "00040038" + "00000000" +
+				"00190010" + "80000806" + "001122334455" + "0000" +
+				"00190010" + "80000606" + "001122334466" + "0000" +
+				"00190010" + "00010508" + "00000001" + "00000001",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			fm, err := compileFlowMod(ofVersion15, dirAdd, tt.flow)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if want, got := tt.match, hex.EncodeToString(fm.match); want != got {
+				t.Fatalf("unexpected match:\n- want: %v\n-  got: %v", want, got)
+			}
+			if want, got := tt.insts, hex.EncodeToString(fm.insts); want != got {
+				t.Fatalf("unexpected instructions:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
+
+func TestCompileFlowModRegLoadOK(t *testing.T) {
+	fm, err := compileFlowMod(ofVersion13, dirAdd, "priority=10,ip,table=0,idle_timeout=0,actions=load:0x1->NXM_NX_REG2[0]")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// NXAST_REG_LOAD of the bit 0 of reg2, OpenFlow 1.3 has no masked set_field.
+	want := "00040020" + "00000000" + "ffff0018000023200007" + "0000" + "00010404" + "0000000000000001"
+	if got := hex.EncodeToString(fm.insts); want != got {
+		t.Fatalf("unexpected instructions:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestCompileFlowModDeleteCookieOK(t *testing.T) {
+	match := &MatchFlow{Cookie: 0x5000000000001, CookieMask: 0xffffffffffffffff, Table: AnyTable}
+	mb, err := match.MarshalText()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fm, err := compileFlowMod(ofVersion15, dirDelete, string(mb))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want, got := uint64(0x5000000000001), fm.cookie; want != got {
+		t.Fatalf("unexpected cookie:\n- want: %#x\n-  got: %#x", want, got)
+	}
+	if want, got := uint64(0xffffffffffffffff), fm.cookieMask; want != got {
+		t.Fatalf("unexpected cookie mask:\n- want: %#x\n-  got: %#x", want, got)
+	}
+	if want, got := uint8(ofpttAll), fm.table; want != got {
+		t.Fatalf("unexpected table:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := 0, len(fm.match); want != got {
+		t.Fatalf("unexpected match length:\n- want: %v\n-  got: %v", want, got)
+	}
+}
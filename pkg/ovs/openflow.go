@@ -21,6 +21,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strconv"
+	"sync"
 )
 
 var (
@@ -37,6 +39,45 @@ var (
 type OpenFlowService struct {
 	// Wrapped Client for ExecFunc and debugging.
 	c *Client
+
+	mu    sync.Mutex
+	conns map[string]*OFConn
+}
+
+// Conn returns the OFConn to a bridge, or nil if flows are programmed
+// with 'ovs-ofctl'.
+func (o *OpenFlowService) Conn(bridge string) *OFConn {
+	if o.c.ofdir == "" {
+		return nil
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.conns == nil {
+		o.conns = make(map[string]*OFConn)
+	}
+	conn, ok := o.conns[bridge]
+	if !ok {
+		conn = NewOFConn(o.c.ofdir, bridge)
+		o.conns[bridge] = conn
+	}
+	return conn
+}
+
+// native reports whether the flows were handled by the OFConn of a
+// bridge, those which can not be encoded are left to 'ovs-ofctl'.
+func (o *OpenFlowService) native(bridge string, bundle bool, flows ...flowDirective) (bool, error) {
+	conn := o.Conn(bridge)
+	if conn == nil {
+		return false, nil
+	}
+	err := conn.flowMods(bundle, flows...)
+	if errors.Is(err, errUnsupported) {
+		o.c.debugf("openflow: fall back to ovs-ofctl: %v", err)
+		return false, nil
+	}
+	return true, err
 }
 
 // AddFlow adds a Flow to a bridge attached to Open vSwitch.
@@ -45,6 +86,9 @@ func (o *OpenFlowService) AddFlow(bridge string, flow *Flow) error {
 	if err != nil {
 		return err
 	}
+	if ok, err := o.native(bridge, false, flowDirective{directive: dirAdd, flow: string(fb)}); ok {
+		return err
+	}
 
 	args := []string{"add-flow"}
 	args = append(args, o.c.ofctlFlags...)
@@ -71,8 +115,9 @@ type flowDirective struct {
 
 // Possible flowDirective directive values.
 const (
-	dirAdd    = "add"
-	dirDelete = "delete"
+	dirAdd          = "add"
+	dirDelete       = "delete"
+	dirDeleteStrict = "delete_strict"
 )
 
 // Add pushes zero or more Flows on to the transaction, to be added by
@@ -107,6 +152,41 @@ func (tx *FlowTransaction) Delete(flows ...*MatchFlow) {
 	tx.push(dirDelete, tms...)
 }
 
+// DeleteStrict pushes zero or more Flows on to the transaction, to be deleted
+// by Open vSwitch only if both their priority and matches are equal.  Other
+// flows overlapping with them are kept.
+func (tx *FlowTransaction) DeleteStrict(flows ...*Flow) {
+	if tx.err != nil {
+		return
+	}
+
+	tms := make([]encoding.TextMarshaler, 0, len(flows))
+	for _, f := range flows {
+		tms = append(tms, strictFlow{f})
+	}
+
+	tx.push(dirDeleteStrict, tms...)
+}
+
+// A strictFlow marshals a Flow to the form of a strict delete, which is its
+// MatchFlow with the priority.
+type strictFlow struct {
+	f *Flow
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s strictFlow) MarshalText() ([]byte, error) {
+	mb, err := s.f.MatchFlow().MarshalText()
+	if err != nil {
+		return nil, err
+	}
+
+	b := []byte(priority + "=")
+	b = strconv.AppendInt(b, int64(s.f.Priority), 10)
+	b = append(b, ',')
+	return append(b, mb...), nil
+}
+
 // push pushes zero or more encoding.TextMarshalers on to the transaction
 // (typically a Flow or MatchFlow).
 func (tx *FlowTransaction) push(directive string, flows ...encoding.TextMarshaler) {
@@ -164,6 +244,9 @@ func (o *OpenFlowService) AddFlowBundle(bridge string, fn func(tx *FlowTransacti
 	if !tx.committed {
 		return errNotCommitted
 	}
+	if ok, err := o.native(bridge, true, tx.flows...); ok {
+		return err
+	}
 
 	for _, flow := range tx.flows {
 		// Syntax for adding a flow in the file is:
@@ -189,6 +272,9 @@ func (o *OpenFlowService) DelFlows(bridge string, flow *MatchFlow) error {
 	if flow == nil {
 		// This means we'll flush the entire flows
 		// from the specifided bridge.
+		if ok, err := o.native(bridge, false, flowDirective{directive: dirDelete}); ok {
+			return err
+		}
 		_, err := o.exec("del-flows", bridge)
 		return err
 	}
@@ -196,6 +282,9 @@ func (o *OpenFlowService) DelFlows(bridge string, flow *MatchFlow) error {
 	if err != nil {
 		return err
 	}
+	if ok, err := o.native(bridge, false, flowDirective{directive: dirDelete, flow: string(fb)}); ok {
+		return err
+	}
 
 	_, err = o.exec("del-flows", bridge, string(fb))
 	return err
@@ -259,28 +348,156 @@ func (o *OpenFlowService) DumpTables(bridge string) ([]*Table, error) {
 // If a table has no active flows and has not been used for a lookup or matched
 // by an incoming packet, it is filtered from the output.
 func (o *OpenFlowService) DumpFlows(bridge string) ([]*Flow, error) {
-	out, err := o.exec("dump-flows", bridge)
+	lines, err := o.DumpFlowLines(bridge)
 	if err != nil {
 		return nil, err
 	}
 
 	var flows []*Flow
-	err = parseEachLine(out, dumpFlowsPrefix, func(b []byte) error {
-		// Do not attempt to parse NXST_FLOW messages.
-		if bytes.HasPrefix(b, dumpFlowsPrefix) {
+	for _, line := range lines {
+		f := new(Flow)
+		if err := f.UnmarshalText([]byte(line)); err != nil {
+			return nil, err
+		}
+
+		flows = append(flows, f)
+	}
+
+	return flows, nil
+}
+
+// DumpFlowLines retrieves all flows for the specified bridge as printed by
+// 'ovs-ofctl dump-flows', one flow per line, so that flows with matches or
+// actions a Flow cannot parse are kept as they are.
+func (o *OpenFlowService) DumpFlowLines(bridge string) ([]string, error) {
+	args := []string{"dump-flows"}
+	args = append(args, o.c.ofctlFlags...)
+	args = append(args, bridge)
+
+	out, err := o.exec(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	// The reply is named after the OpenFlow version negotiated.
+	prefix := dumpFlowsPrefix
+	if bytes.HasPrefix(out, dumpFlowsOFPrefix) {
+		prefix = dumpFlowsOFPrefix
+	}
+
+	var lines []string
+	err = parseEachLine(out, prefix, func(b []byte) error {
+		// Do not attempt to parse NXST_FLOW or OFPST_FLOW messages.
+		if bytes.HasPrefix(b, prefix) {
 			return nil
 		}
 
-		f := new(Flow)
-		if err := f.UnmarshalText(b); err != nil {
+		lines = append(lines, string(b))
+		return nil
+	})
+
+	return lines, err
+}
+
+// AddGroup adds a Group to a bridge attached to Open vSwitch.
+func (o *OpenFlowService) AddGroup(bridge string, group *Group) error {
+	return o.group("add-group", bridge, group)
+}
+
+// ModGroup replaces the type and buckets of an existing Group on a bridge,
+// without touching the flows that reference it.
+func (o *OpenFlowService) ModGroup(bridge string, group *Group) error {
+	return o.group("mod-group", bridge, group)
+}
+
+// DelGroups removes the groups with the specified IDs from a bridge.
+//
+// If no IDs are specified, all groups will be deleted from the bridge.
+// ovs-ofctl del-groups takes a single group, so each one is deleted in turn.
+func (o *OpenFlowService) DelGroups(bridge string, ids ...uint32) error {
+	if len(ids) == 0 {
+		args := []string{"del-groups", bridge}
+		_, err := o.exec(append(args, groupFlags...)...)
+		return err
+	}
+
+	for _, id := range ids {
+		args := []string{"del-groups", bridge, "group_id=" + strconv.FormatUint(uint64(id), 10)}
+		if _, err := o.exec(append(args, groupFlags...)...); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		flows = append(flows, f)
+// DumpGroups retrieves all groups for the specified bridge.
+func (o *OpenFlowService) DumpGroups(bridge string) ([]*Group, error) {
+	args := []string{"dump-groups", bridge}
+	args = append(args, groupFlags...)
+	out, err := o.exec(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []*Group
+	err = parseEachLine(out, dumpGroupsPrefix, func(b []byte) error {
+		g := new(Group)
+		if err := g.UnmarshalText(b); err != nil {
+			return err
+		}
+
+		groups = append(groups, g)
 		return nil
 	})
 
-	return flows, err
+	return groups, err
+}
+
+// group executes a group modification command for a Group.
+func (o *OpenFlowService) group(cmd string, bridge string, group *Group) error {
+	gb, err := group.MarshalText()
+	if err != nil {
+		return err
+	}
+
+	args := []string{cmd, bridge, string(gb)}
+	args = append(args, groupFlags...)
+
+	_, err = o.exec(args...)
+	return err
+}
+
+// AddMeter adds a Meter to a bridge attached to Open vSwitch.
+func (o *OpenFlowService) AddMeter(bridge string, meter *Meter) error {
+	mb, err := meter.MarshalText()
+	if err != nil {
+		return err
+	}
+
+	args := []string{"add-meter", bridge, string(mb)}
+	args = append(args, meterFlags...)
+
+	_, err = o.exec(args...)
+	return err
+}
+
+// DelMeters removes the meters with the specified IDs from a bridge.
+//
+// If no IDs are specified, all meters will be deleted from the bridge.
+func (o *OpenFlowService) DelMeters(bridge string, ids ...uint32) error {
+	if len(ids) == 0 {
+		args := []string{"del-meters", bridge}
+		_, err := o.exec(append(args, meterFlags...)...)
+		return err
+	}
+
+	for _, id := range ids {
+		args := []string{"del-meters", bridge, "meter=" + strconv.FormatUint(uint64(id), 10)}
+		if _, err := o.exec(append(args, meterFlags...)...); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // DumpAggregate retrieves statistics about the specified flow attached to the
@@ -307,6 +524,22 @@ var (
 	// the output from 'ovs-ofctl dump-flows'.
 	dumpFlowsPrefix = []byte("NXST_FLOW reply")
 
+	// dumpFlowsOFPrefix is the sentinel value of 'ovs-ofctl dump-flows'
+	// from OpenFlow 1.1, as in "OFPST_FLOW reply (OF1.5) (xid=0x2):".
+	dumpFlowsOFPrefix = []byte("OFPST_FLOW")
+
+	// dumpGroupsPrefix is a sentinel value returned at the beginning of
+	// the output from 'ovs-ofctl dump-groups'.
+	dumpGroupsPrefix = []byte("OFPST_GROUP_DESC reply")
+
+	// groupFlags are appended to group commands, as selection methods
+	// and bucket IDs are only available from OpenFlow 1.5.
+	groupFlags = []string{"-O", ProtocolOpenFlow15}
+
+	// meterFlags are appended to meter commands, as meters are only
+	// available from OpenFlow 1.3.
+	meterFlags = []string{"-O", ProtocolOpenFlow13}
+
 	// dumpAggregatePrefix is a sentinel value returned at the beginning of
 	// the output from "ovs-ofctl dump-aggregate"
 	//dumpAggregatePrefix = []byte("NXST_AGGREGATE reply")
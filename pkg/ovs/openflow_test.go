@@ -194,6 +194,53 @@ func TestClientOpenFlowAddFlowBundleOK(t *testing.T) {
 	}
 }
 
+func TestClientOpenFlowAddFlowBundleDeleteStrictOK(t *testing.T) {
+	bridge := "br0"
+
+	flows := []*Flow{
+		{
+			Priority: 0,
+			Table:    20,
+			Actions:  []Action{Normal()},
+		},
+		{
+			Priority: 124,
+			Protocol: ProtocolIPv4,
+			Matches: []Match{
+				NetworkDestination("10.0.0.0/24"),
+			},
+			Cookie:  0x2021,
+			Table:   19,
+			Actions: []Action{Drop()},
+		},
+	}
+
+	pipe := Pipe(func(stdin io.Reader, cmd string, args ...string) ([]byte, error) {
+		b, err := io.ReadAll(stdin)
+		if err != nil {
+			t.Fatalf("failed to read stdin: %v", err)
+		}
+
+		want := "delete_strict priority=0,table=20\n" +
+			"delete_strict priority=124,ip,nw_dst=10.0.0.0/24,cookie=0x0000000000002021/-1,table=19\n"
+		if got := string(b); want != got {
+			t.Fatalf("unexpected flow bundle:\n- want: %v\n-  got: %v",
+				want, got)
+		}
+		return nil, nil
+	})
+
+	c := testClient([]OptionFunc{pipe}, nil)
+
+	err := c.OpenFlow.AddFlowBundle(bridge, func(tx *FlowTransaction) error {
+		tx.DeleteStrict(flows...)
+		return tx.Commit()
+	})
+	if err != nil {
+		t.Fatalf("unexpected error for Client.OpenFlow.AddFlowBundle: %v", err)
+	}
+}
+
 func TestClientOpenFlowAddFlowBundleNotCommitted(t *testing.T) {
 	bridge := "br0"
 
@@ -1058,6 +1105,65 @@ NXST_FLOW reply (xid=0x4):
 	}
 }
 
+func TestClientOpenFlowDumpFlowLines(t *testing.T) {
+	line := " cookie=0x2021, duration=9.5s, table=0, n_packets=0, n_bytes=0, priority=100,ip actions=resubmit(,10)"
+	tests := []struct {
+		name  string
+		flows string
+		want  []string
+		err   error
+	}{
+		{
+			name:  "test nicira reply",
+			flows: "NXST_FLOW reply (xid=0x4):\n" + line + "\n",
+			want:  []string{line},
+		},
+		{
+			name:  "test openflow 1.5 reply",
+			flows: "OFPST_FLOW reply (OF1.5) (xid=0x2):\n" + line + "\n",
+			want:  []string{line},
+		},
+		{
+			name:  "test openflow 1.0 reply",
+			flows: "OFPST_FLOW reply (xid=0x2):\n" + line + "\n",
+			want:  []string{line},
+		},
+		{
+			name:  "test no flows",
+			flows: "OFPST_FLOW reply (OF1.5) (xid=0x2):\n",
+		},
+		{
+			name:  "test unknown reply",
+			flows: "OFPST_PORT reply (xid=0x2): 1 ports\n",
+			err:   io.ErrUnexpectedEOF,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := testClient([]OptionFunc{Protocols([]string{ProtocolOpenFlow10, ProtocolOpenFlow15})}, func(cmd string, args ...string) ([]byte, error) {
+				wantArgs := []string{
+					"dump-flows",
+					"--protocols=OpenFlow10,OpenFlow15",
+					"br0",
+				}
+				if want, got := wantArgs, args; !reflect.DeepEqual(want, got) {
+					t.Fatalf("incorrect arguments\n- want: %v\n-  got: %v",
+						want, got)
+				}
+				return []byte(tt.flows), nil
+			}).OpenFlow.DumpFlowLines("br0")
+			if want, got := tt.err, err; want != got {
+				t.Fatalf("unexpected error:\n- want: %v\n-  got: %v",
+					want, got)
+			}
+			if want, got := tt.want, got; !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected lines:\n- want: %v\n-  got: %v",
+					want, got)
+			}
+		})
+	}
+}
+
 func mustVerifyFlowBundle(t *testing.T, stdin io.Reader, flows []*Flow, matchFlows []*MatchFlow) {
 	s := bufio.NewScanner(stdin)
 	var gotFlows []*Flow
@@ -1119,3 +1225,164 @@ func mustVerifyFlowBundle(t *testing.T, stdin io.Reader, flows []*Flow, matchFlo
 		}
 	}
 }
+
+func TestClientOpenFlowAddGroupOK(t *testing.T) {
+	bridge := "br0"
+	group := &Group{
+		ID:   1,
+		Type: GroupSelect,
+		Buckets: []*Bucket{
+			{Actions: []Action{Output(1)}},
+			{Actions: []Action{Output(2)}},
+		},
+	}
+
+	c := testClient(nil, func(cmd string, args ...string) ([]byte, error) {
+		if want, got := "ovs-ofctl", cmd; want != got {
+			t.Fatalf("incorrect command:\n- want: %v\n-  got: %v",
+				want, got)
+		}
+
+		wantArgs := []string{
+			"add-group",
+			bridge,
+			"group_id=1,type=select,bucket=actions=output:1,bucket=actions=output:2",
+			"-O",
+			"OpenFlow15",
+		}
+		if want, got := wantArgs, args; !reflect.DeepEqual(want, got) {
+			t.Fatalf("incorrect arguments\n- want: %v\n-  got: %v",
+				want, got)
+		}
+
+		return nil, nil
+	})
+
+	if err := c.OpenFlow.AddGroup(bridge, group); err != nil {
+		t.Fatalf("unexpected error for Client.OpenFlow.AddGroup: %v", err)
+	}
+}
+
+func TestClientOpenFlowDelGroupsOK(t *testing.T) {
+	bridge := "br0"
+
+	var calls [][]string
+	c := testClient(nil, func(cmd string, args ...string) ([]byte, error) {
+		calls = append(calls, args)
+		return nil, nil
+	})
+
+	if err := c.OpenFlow.DelGroups(bridge); err != nil {
+		t.Fatalf("unexpected error for Client.OpenFlow.DelGroups: %v", err)
+	}
+	if err := c.OpenFlow.DelGroups(bridge, 3, 5); err != nil {
+		t.Fatalf("unexpected error for Client.OpenFlow.DelGroups: %v", err)
+	}
+
+	want := [][]string{
+		{"del-groups", bridge, "-O", "OpenFlow15"},
+		{"del-groups", bridge, "group_id=3", "-O", "OpenFlow15"},
+		{"del-groups", bridge, "group_id=5", "-O", "OpenFlow15"},
+	}
+	if got := calls; !reflect.DeepEqual(want, got) {
+		t.Fatalf("incorrect arguments\n- want: %v\n-  got: %v",
+			want, got)
+	}
+}
+
+func TestClientOpenFlowDumpGroupsOK(t *testing.T) {
+	want := []*Group{
+		{
+			ID:   1,
+			Type: GroupAll,
+			Buckets: []*Bucket{
+				{Actions: []Action{Output(1)}},
+			},
+		},
+		{
+			ID:              2,
+			Type:            GroupSelect,
+			SelectionMethod: "hash",
+			Fields:          []string{"ip_src", "ip_dst"},
+			Buckets: []*Bucket{
+				{Weight: 1, Actions: []Action{Output(1)}},
+				{Weight: 1, Actions: []Action{Output(2)}},
+			},
+		},
+	}
+
+	bridge := "br0"
+
+	c := testClient(nil, func(cmd string, args ...string) ([]byte, error) {
+		wantArgs := []string{"dump-groups", bridge, "-O", "OpenFlow15"}
+		if want, got := wantArgs, args; !reflect.DeepEqual(want, got) {
+			t.Fatalf("incorrect arguments\n- want: %v\n-  got: %v",
+				want, got)
+		}
+
+		return []byte(`OFPST_GROUP_DESC reply (OF1.5) (xid=0x2):
+ group_id=1,type=all,bucket=bucket_id:0,actions=output:1
+ group_id=2,type=select,selection_method=hash,fields(ip_src,ip_dst),bucket=bucket_id:0,weight:1,actions=output:1,bucket=bucket_id:1,weight:1,actions=output:2
+`), nil
+	})
+
+	got, err := c.OpenFlow.DumpGroups(bridge)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected groups:\n- want: %#v\n-  got: %#v",
+			want, got)
+	}
+}
+
+func TestClientOpenFlowAddMeterOK(t *testing.T) {
+	bridge := "br0"
+
+	c := testClient(nil, func(cmd string, args ...string) ([]byte, error) {
+		wantArgs := []string{
+			"add-meter",
+			bridge,
+			"meter=1,pktps,band=type=drop,rate=100",
+			"-O",
+			"OpenFlow13",
+		}
+		if want, got := wantArgs, args; !reflect.DeepEqual(want, got) {
+			t.Fatalf("incorrect arguments\n- want: %v\n-  got: %v",
+				want, got)
+		}
+
+		return nil, nil
+	})
+
+	if err := c.OpenFlow.AddMeter(bridge, &Meter{ID: 1, Rate: 100}); err != nil {
+		t.Fatalf("unexpected error for Client.OpenFlow.AddMeter: %v", err)
+	}
+}
+
+func TestClientOpenFlowDelMetersOK(t *testing.T) {
+	bridge := "br0"
+
+	var calls [][]string
+	c := testClient(nil, func(cmd string, args ...string) ([]byte, error) {
+		calls = append(calls, args)
+		return nil, nil
+	})
+
+	if err := c.OpenFlow.DelMeters(bridge); err != nil {
+		t.Fatalf("unexpected error for Client.OpenFlow.DelMeters: %v", err)
+	}
+	if err := c.OpenFlow.DelMeters(bridge, 2); err != nil {
+		t.Fatalf("unexpected error for Client.OpenFlow.DelMeters: %v", err)
+	}
+
+	want := [][]string{
+		{"del-meters", bridge, "-O", "OpenFlow13"},
+		{"del-meters", bridge, "meter=2", "-O", "OpenFlow13"},
+	}
+	if got := calls; !reflect.DeepEqual(want, got) {
+		t.Fatalf("incorrect arguments\n- want: %v\n-  got: %v",
+			want, got)
+	}
+}
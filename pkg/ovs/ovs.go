@@ -77,8 +77,12 @@ func (e *Error) Error() string {
 }
 
 // IsPortNotExist checks if err is of type Error and is caused by asking OVS for
-// information regarding a non-existent port.
+// information regarding a non-existent port, through 'ovs-vsctl' or OVSDB.
 func IsPortNotExist(err error) bool {
+	if _, ok := err.(errPortNotExist); ok {
+		return true
+	}
+
 	oerr, ok := err.(*Error)
 	if !ok {
 		return false
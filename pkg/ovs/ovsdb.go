@@ -0,0 +1,906 @@
+// Copyright 2017 DigitalOcean.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultOVSDBSocket is the unix socket ovsdb-server listens on.
+	DefaultOVSDBSocket = "/var/run/openvswitch/db.sock"
+
+	// OVSDBDatabase is the database of Open vSwitch.
+	OVSDBDatabase = "Open_vSwitch"
+
+	// DefaultOVSDBTimeout is how long a request waits for its reply.
+	DefaultOVSDBTimeout = 10 * time.Second
+)
+
+// Operation constants for use with Operation, as described in RFC 7047.
+const (
+	OpInsert = "insert"
+	OpSelect = "select"
+	OpUpdate = "update"
+	OpMutate = "mutate"
+	OpDelete = "delete"
+	OpWait   = "wait"
+)
+
+// An OVSDBClient talks to ovsdb-server with JSON-RPC, as described in
+// RFC 7047.  The connection is made on first use and made again after
+// it is lost, monitors do not survive a reconnect.
+type OVSDBClient struct {
+	// Timeout of a request, DefaultOVSDBTimeout if zero.
+	Timeout time.Duration
+
+	dial func() (net.Conn, error)
+
+	mu       sync.Mutex
+	conn     net.Conn
+	enc      *json.Encoder
+	nextId   int
+	pending  map[int]chan ovsdbReply
+	monitors map[string]*ovsdbMonitor
+}
+
+// NewOVSDBClient creates an OVSDBClient for the address, such as
+// "unix" and DefaultOVSDBSocket.
+func NewOVSDBClient(network, address string) *OVSDBClient {
+	return &OVSDBClient{
+		dial: func() (net.Conn, error) {
+			return net.Dial(network, address)
+		},
+	}
+}
+
+// A Row is a row of a table, its columns hold values in the OVSDB
+// notation.
+type Row map[string]interface{}
+
+// A Condition is a [column, function, value] clause of a where.
+type Condition []interface{}
+
+// NewCondition creates a Condition, such as "name", "==", "br0".
+func NewCondition(column, function string, value interface{}) Condition {
+	return Condition{column, function, value}
+}
+
+// A Mutation is a [column, mutator, value] of a mutate operation.
+type Mutation []interface{}
+
+// NewMutation creates a Mutation, such as "ports", "insert", a set.
+func NewMutation(column, mutator string, value interface{}) Mutation {
+	return Mutation{column, mutator, value}
+}
+
+// RowUUID refers to a row by its UUID.
+func RowUUID(id string) []interface{} {
+	return []interface{}{"uuid", id}
+}
+
+// NamedUUID refers to a row inserted in the same transaction.
+func NamedUUID(name string) []interface{} {
+	return []interface{}{"named-uuid", name}
+}
+
+// OVSDBSet creates a set value of the atoms.
+func OVSDBSet(atoms ...interface{}) []interface{} {
+	if atoms == nil {
+		atoms = []interface{}{}
+	}
+	return []interface{}{"set", atoms}
+}
+
+// OVSDBMap creates a map value of strings.
+func OVSDBMap(m map[string]string) []interface{} {
+	pairs := []interface{}{}
+	for k, v := range m {
+		pairs = append(pairs, []interface{}{k, v})
+	}
+	return []interface{}{"map", pairs}
+}
+
+// An Operation is an operation of a transaction.
+type Operation struct {
+	Op        string
+	Table     string
+	Where     []Condition
+	Row       Row
+	Columns   []string
+	Mutations []Mutation
+	UUIDName  string
+	Timeout   int
+	Until     string
+	Rows      []Row
+}
+
+// MarshalJSON implements json.Marshaler, only the members allowed for
+// the operation are present.
+func (o Operation) MarshalJSON() ([]byte, error) {
+	m := map[string]interface{}{
+		"op": o.Op,
+	}
+	if o.Table != "" {
+		m["table"] = o.Table
+	}
+
+	switch o.Op {
+	case OpSelect, OpUpdate, OpMutate, OpDelete, OpWait:
+		where := o.Where
+		if where == nil {
+			where = []Condition{}
+		}
+		m["where"] = where
+	}
+
+	switch o.Op {
+	case OpInsert, OpUpdate:
+		row := o.Row
+		if row == nil {
+			row = Row{}
+		}
+		m["row"] = row
+	case OpMutate:
+		mutations := o.Mutations
+		if mutations == nil {
+			mutations = []Mutation{}
+		}
+		m["mutations"] = mutations
+	case OpWait:
+		m["timeout"] = o.Timeout
+		m["until"] = o.Until
+		m["rows"] = o.Rows
+	}
+
+	if o.Columns != nil {
+		m["columns"] = o.Columns
+	}
+	if o.UUIDName != "" {
+		m["uuid-name"] = o.UUIDName
+	}
+	return json.Marshal(m)
+}
+
+// An OperationResult is the result of an Operation.
+type OperationResult struct {
+	Count   int    `json:"count"`
+	UUID    string `json:"-"`
+	Rows    []Row  `json:"rows"`
+	Error   string `json:"error"`
+	Details string `json:"details"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler, UUID is the row inserted.
+func (r *OperationResult) UnmarshalJSON(b []byte) error {
+	type result OperationResult
+	var v struct {
+		result
+		UUID interface{} `json:"uuid"`
+	}
+	if err := unmarshalOVSDB(b, &v); err != nil {
+		return err
+	}
+	*r = OperationResult(v.result)
+	r.UUID = atomString(v.UUID)
+	return nil
+}
+
+// A MonitorRequest selects the columns of a table to monitor, all if
+// Columns is empty.
+type MonitorRequest struct {
+	Columns []string `json:"columns,omitempty"`
+}
+
+// A RowUpdate is the old and new values of a row, Old is nil for an
+// insert and New is nil for a delete.
+type RowUpdate struct {
+	Old Row `json:"old"`
+	New Row `json:"new"`
+}
+
+// TableUpdates holds the row updates per table and row UUID.
+type TableUpdates map[string]map[string]RowUpdate
+
+type ovsdbRequest struct {
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+	ID     interface{}   `json:"id"`
+}
+
+type ovsdbResponse struct {
+	Result interface{} `json:"result"`
+	Error  interface{} `json:"error"`
+	ID     interface{} `json:"id"`
+}
+
+type ovsdbMessage struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  json.RawMessage `json:"error"`
+	ID     json.RawMessage `json:"id"`
+}
+
+type ovsdbReply struct {
+	result json.RawMessage
+	err    error
+}
+
+// An ovsdbMonitor queues the updates for its callback, so that the
+// receiver never waits on it.
+type ovsdbMonitor struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []TableUpdates
+	closed bool
+}
+
+func newMonitor() *ovsdbMonitor {
+	m := &ovsdbMonitor{}
+	m.cond = sync.NewCond(&m.mu)
+	return m
+}
+
+func (m *ovsdbMonitor) push(updates TableUpdates) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.closed {
+		m.queue = append(m.queue, updates)
+		m.cond.Signal()
+	}
+}
+
+func (m *ovsdbMonitor) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+	m.cond.Signal()
+}
+
+func (m *ovsdbMonitor) run(fn func(TableUpdates)) {
+	for {
+		m.mu.Lock()
+		for len(m.queue) == 0 && !m.closed {
+			m.cond.Wait()
+		}
+		if len(m.queue) == 0 {
+			m.mu.Unlock()
+			return
+		}
+		updates := m.queue[0]
+		m.queue = m.queue[1:]
+		m.mu.Unlock()
+
+		fn(updates)
+	}
+}
+
+// Transact runs the operations in one transaction of the Open_vSwitch
+// database.  An error is returned if any of them fails.
+func (o *OVSDBClient) Transact(ops ...Operation) ([]OperationResult, error) {
+	params := []interface{}{OVSDBDatabase}
+	for _, op := range ops {
+		params = append(params, op)
+	}
+	out, err := o.call("transact", params...)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []OperationResult
+	if err := unmarshalOVSDB(out, &results); err != nil {
+		return nil, err
+	}
+	for i, r := range results {
+		if r.Error == "" {
+			continue
+		}
+		if i < len(ops) {
+			return results, fmt.Errorf("ovsdb: %s %s: %s: %s", ops[i].Op, ops[i].Table, r.Error, r.Details)
+		}
+		return results, fmt.Errorf("ovsdb: %s: %s", r.Error, r.Details)
+	}
+	return results, nil
+}
+
+// Select returns the rows of the table matching the conditions, with
+// the columns given or all of them.
+func (o *OVSDBClient) Select(table string, columns []string, where ...Condition) ([]Row, error) {
+	results, err := o.Transact(Operation{
+		Op:      OpSelect,
+		Table:   table,
+		Where:   where,
+		Columns: columns,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("ovsdb: select %s: no result", table)
+	}
+	return results[0].Rows, nil
+}
+
+// Monitor returns the rows of the tables requested and calls fn with
+// the changes made later, until MonitorCancel.  fn is called in order
+// from its own goroutine.
+func (o *OVSDBClient) Monitor(requests map[string]MonitorRequest, fn func(TableUpdates)) (string, TableUpdates, error) {
+	o.mu.Lock()
+	o.nextId++
+	id := fmt.Sprintf("monitor-%d", o.nextId)
+	m := newMonitor()
+	if o.monitors == nil {
+		o.monitors = make(map[string]*ovsdbMonitor)
+	}
+	o.monitors[id] = m
+	o.mu.Unlock()
+
+	go m.run(fn)
+
+	out, err := o.call("monitor", OVSDBDatabase, id, requests)
+	if err != nil {
+		o.dropMonitor(id)
+		return "", nil, err
+	}
+	var initial TableUpdates
+	if err := unmarshalOVSDB(out, &initial); err != nil {
+		o.dropMonitor(id)
+		return "", nil, err
+	}
+	return id, initial, nil
+}
+
+// MonitorCancel stops the monitor, fn is not called anymore.
+func (o *OVSDBClient) MonitorCancel(id string) error {
+	o.dropMonitor(id)
+	_, err := o.call("monitor_cancel", id)
+	return err
+}
+
+// Echo checks that ovsdb-server answers.
+func (o *OVSDBClient) Echo() error {
+	_, err := o.call("echo", "ping")
+	return err
+}
+
+// Close closes the connection, the next request opens a new one.
+func (o *OVSDBClient) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.conn == nil {
+		return nil
+	}
+	conn := o.conn
+	o.reset(conn, errors.New("ovsdb: connection closed"))
+	return conn.Close()
+}
+
+func (o *OVSDBClient) call(method string, params ...interface{}) (json.RawMessage, error) {
+	o.mu.Lock()
+	if err := o.connect(); err != nil {
+		o.mu.Unlock()
+		return nil, err
+	}
+	o.nextId++
+	id := o.nextId
+	reply := make(chan ovsdbReply, 1)
+	o.pending[id] = reply
+
+	conn := o.conn
+	if err := o.enc.Encode(ovsdbRequest{Method: method, Params: params, ID: id}); err != nil {
+		o.reset(conn, err)
+		conn.Close()
+		o.mu.Unlock()
+		return nil, err
+	}
+	o.mu.Unlock()
+
+	timer := time.NewTimer(o.timeout())
+	defer timer.Stop()
+
+	select {
+	case r := <-reply:
+		return r.result, r.err
+	case <-timer.C:
+		o.mu.Lock()
+		delete(o.pending, id)
+		o.mu.Unlock()
+		return nil, fmt.Errorf("ovsdb: %s: timed out", method)
+	}
+}
+
+func (o *OVSDBClient) timeout() time.Duration {
+	if o.Timeout == 0 {
+		return DefaultOVSDBTimeout
+	}
+	return o.Timeout
+}
+
+// connect opens the connection if there is none, o.mu is held.
+func (o *OVSDBClient) connect() error {
+	if o.conn != nil {
+		return nil
+	}
+	conn, err := o.dial()
+	if err != nil {
+		return err
+	}
+	o.conn = conn
+	o.enc = json.NewEncoder(conn)
+	o.pending = make(map[int]chan ovsdbReply)
+
+	dec := json.NewDecoder(conn)
+	go o.receive(conn, dec)
+	return nil
+}
+
+// reset fails the requests waiting on conn and stops the monitors, o.mu
+// is held.
+func (o *OVSDBClient) reset(conn net.Conn, err error) {
+	if o.conn != conn {
+		return
+	}
+	for _, reply := range o.pending {
+		reply <- ovsdbReply{err: err}
+	}
+	for id, m := range o.monitors {
+		m.close()
+		delete(o.monitors, id)
+	}
+	o.conn = nil
+	o.enc = nil
+	o.pending = nil
+}
+
+func (o *OVSDBClient) dropMonitor(id string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if m, ok := o.monitors[id]; ok {
+		m.close()
+		delete(o.monitors, id)
+	}
+}
+
+func (o *OVSDBClient) receive(conn net.Conn, dec *json.Decoder) {
+	for {
+		var msg ovsdbMessage
+		if err := dec.Decode(&msg); err != nil {
+			o.mu.Lock()
+			o.reset(conn, err)
+			o.mu.Unlock()
+			conn.Close()
+			return
+		}
+
+		switch msg.Method {
+		case "":
+			o.reply(msg)
+		case "echo":
+			var params interface{}
+			json.Unmarshal(msg.Params, &params)
+			o.mu.Lock()
+			if o.conn == conn {
+				o.enc.Encode(ovsdbResponse{Result: params, ID: msg.ID})
+			}
+			o.mu.Unlock()
+		case "update":
+			o.update(msg.Params)
+		}
+	}
+}
+
+func (o *OVSDBClient) reply(msg ovsdbMessage) {
+	var id int
+	if err := json.Unmarshal(msg.ID, &id); err != nil {
+		return
+	}
+
+	o.mu.Lock()
+	reply, ok := o.pending[id]
+	delete(o.pending, id)
+	o.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	if !isNull(msg.Error) {
+		var e interface{}
+		json.Unmarshal(msg.Error, &e)
+		reply <- ovsdbReply{err: fmt.Errorf("ovsdb: %v", e)}
+		return
+	}
+	reply <- ovsdbReply{result: msg.Result}
+}
+
+func (o *OVSDBClient) update(params json.RawMessage) {
+	var v []json.RawMessage
+	if err := json.Unmarshal(params, &v); err != nil || len(v) != 2 {
+		return
+	}
+	var id string
+	if err := json.Unmarshal(v[0], &id); err != nil {
+		return
+	}
+	var updates TableUpdates
+	if err := unmarshalOVSDB(v[1], &updates); err != nil {
+		return
+	}
+
+	o.mu.Lock()
+	m, ok := o.monitors[id]
+	o.mu.Unlock()
+	if ok {
+		m.push(updates)
+	}
+}
+
+func isNull(b json.RawMessage) bool {
+	return len(b) == 0 || string(b) == "null"
+}
+
+// unmarshalOVSDB keeps the integers of values as json.Number.
+func unmarshalOVSDB(b []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// atoms returns the atoms of a value, a set or a single atom.
+func (r Row) atoms(column string) []interface{} {
+	v, ok := r[column]
+	if !ok || v == nil {
+		return nil
+	}
+	if pair, ok := v.([]interface{}); ok && len(pair) == 2 {
+		if kind, _ := pair[0].(string); kind == "set" {
+			items, _ := pair[1].([]interface{})
+			return items
+		}
+	}
+	return []interface{}{v}
+}
+
+func atomString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return fmt.Sprint(v)
+	case []interface{}:
+		// A uuid or named-uuid.
+		if len(v) == 2 {
+			return atomString(v[1])
+		}
+	}
+	return ""
+}
+
+func atomInt(v interface{}) int64 {
+	switch v := v.(type) {
+	case json.Number:
+		i, _ := v.Int64()
+		return i
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
+
+func (r Row) uuid() string {
+	return r.str("_uuid")
+}
+
+func (r Row) str(column string) string {
+	atoms := r.atoms(column)
+	if len(atoms) == 0 {
+		return ""
+	}
+	return atomString(atoms[0])
+}
+
+func (r Row) strs(column string) []string {
+	var items []string
+	for _, v := range r.atoms(column) {
+		items = append(items, atomString(v))
+	}
+	return items
+}
+
+func (r Row) int(column string) int {
+	atoms := r.atoms(column)
+	if len(atoms) == 0 {
+		return 0
+	}
+	return int(atomInt(atoms[0]))
+}
+
+func (r Row) ints(column string) []int {
+	var items []int
+	for _, v := range r.atoms(column) {
+		items = append(items, int(atomInt(v)))
+	}
+	return items
+}
+
+func (r Row) bool(column string) bool {
+	atoms := r.atoms(column)
+	if len(atoms) == 0 {
+		return false
+	}
+	b, _ := atoms[0].(bool)
+	return b
+}
+
+func (r Row) pairs(column string) [][]interface{} {
+	v, ok := r[column].([]interface{})
+	if !ok || len(v) != 2 {
+		return nil
+	}
+	if kind, _ := v[0].(string); kind != "map" {
+		return nil
+	}
+	items, _ := v[1].([]interface{})
+
+	var pairs [][]interface{}
+	for _, item := range items {
+		if pair, ok := item.([]interface{}); ok && len(pair) == 2 {
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs
+}
+
+func (r Row) strMap(column string) map[string]string {
+	m := make(map[string]string)
+	for _, pair := range r.pairs(column) {
+		m[atomString(pair[0])] = atomString(pair[1])
+	}
+	return m
+}
+
+// A BridgeRow is a row of the Bridge table.
+type BridgeRow struct {
+	UUID        string
+	Name        string
+	Ports       []string
+	Mirrors     []string
+	Controller  []string
+	Protocols   []string
+	FailMode    string
+	DatapathID  string
+	OtherConfig map[string]string
+	ExternalIDs map[string]string
+}
+
+// Bridge decodes the row as a BridgeRow.
+func (r Row) Bridge() BridgeRow {
+	return BridgeRow{
+		UUID:        r.uuid(),
+		Name:        r.str("name"),
+		Ports:       r.strs("ports"),
+		Mirrors:     r.strs("mirrors"),
+		Controller:  r.strs("controller"),
+		Protocols:   r.strs("protocols"),
+		FailMode:    r.str("fail_mode"),
+		DatapathID:  r.str("datapath_id"),
+		OtherConfig: r.strMap("other_config"),
+		ExternalIDs: r.strMap("external_ids"),
+	}
+}
+
+// A PortRow is a row of the Port table.
+type PortRow struct {
+	UUID        string
+	Name        string
+	Interfaces  []string
+	Tag         int
+	Trunks      []int
+	VlanMode    string
+	Mac         string
+	QoS         string
+	OtherConfig map[string]string
+	ExternalIDs map[string]string
+}
+
+// Port decodes the row as a PortRow.
+func (r Row) Port() PortRow {
+	return PortRow{
+		UUID:        r.uuid(),
+		Name:        r.str("name"),
+		Interfaces:  r.strs("interfaces"),
+		Tag:         r.int("tag"),
+		Trunks:      r.ints("trunks"),
+		VlanMode:    r.str("vlan_mode"),
+		Mac:         r.str("mac"),
+		QoS:         r.str("qos"),
+		OtherConfig: r.strMap("other_config"),
+		ExternalIDs: r.strMap("external_ids"),
+	}
+}
+
+// An InterfaceRow is a row of the Interface table.
+type InterfaceRow struct {
+	UUID          string
+	Name          string
+	Type          string
+	OfPort        int
+	OfPortRequest int
+	Mtu           int
+	MtuRequest    int
+	Mac           string
+	MacInUse      string
+	AdminState    string
+	LinkState     string
+	Options       map[string]string
+	Statistics    map[string]int64
+	OtherConfig   map[string]string
+	ExternalIDs   map[string]string
+}
+
+// Interface decodes the row as an InterfaceRow.
+func (r Row) Interface() InterfaceRow {
+	statistics := make(map[string]int64)
+	for _, pair := range r.pairs("statistics") {
+		statistics[atomString(pair[0])] = atomInt(pair[1])
+	}
+	return InterfaceRow{
+		UUID:          r.uuid(),
+		Name:          r.str("name"),
+		Type:          r.str("type"),
+		OfPort:        r.int("ofport"),
+		OfPortRequest: r.int("ofport_request"),
+		Mtu:           r.int("mtu"),
+		MtuRequest:    r.int("mtu_request"),
+		Mac:           r.str("mac"),
+		MacInUse:      r.str("mac_in_use"),
+		AdminState:    r.str("admin_state"),
+		LinkState:     r.str("link_state"),
+		Options:       r.strMap("options"),
+		Statistics:    statistics,
+		OtherConfig:   r.strMap("other_config"),
+		ExternalIDs:   r.strMap("external_ids"),
+	}
+}
+
+// A QoSRow is a row of the QoS table.
+type QoSRow struct {
+	UUID        string
+	Type        string
+	Queues      map[int]string
+	OtherConfig map[string]string
+	ExternalIDs map[string]string
+}
+
+// QoS decodes the row as a QoSRow.
+func (r Row) QoS() QoSRow {
+	queues := make(map[int]string)
+	for _, pair := range r.pairs("queues") {
+		queues[int(atomInt(pair[0]))] = atomString(pair[1])
+	}
+	return QoSRow{
+		UUID:        r.uuid(),
+		Type:        r.str("type"),
+		Queues:      queues,
+		OtherConfig: r.strMap("other_config"),
+		ExternalIDs: r.strMap("external_ids"),
+	}
+}
+
+// A MirrorRow is a row of the Mirror table.
+type MirrorRow struct {
+	UUID          string
+	Name          string
+	SelectAll     bool
+	SelectSrcPort []string
+	SelectDstPort []string
+	SelectVlan    []int
+	OutputPort    string
+	OutputVlan    int
+	ExternalIDs   map[string]string
+}
+
+// Mirror decodes the row as a MirrorRow.
+func (r Row) Mirror() MirrorRow {
+	return MirrorRow{
+		UUID:          r.uuid(),
+		Name:          r.str("name"),
+		SelectAll:     r.bool("select_all"),
+		SelectSrcPort: r.strs("select_src_port"),
+		SelectDstPort: r.strs("select_dst_port"),
+		SelectVlan:    r.ints("select_vlan"),
+		OutputPort:    r.str("output_port"),
+		OutputVlan:    r.int("output_vlan"),
+		ExternalIDs:   r.strMap("external_ids"),
+	}
+}
+
+// Bridges returns the rows of the Bridge table matching the conditions.
+func (o *OVSDBClient) Bridges(where ...Condition) ([]BridgeRow, error) {
+	rows, err := o.Select("Bridge", nil, where...)
+	if err != nil {
+		return nil, err
+	}
+	var items []BridgeRow
+	for _, row := range rows {
+		items = append(items, row.Bridge())
+	}
+	return items, nil
+}
+
+// Ports returns the rows of the Port table matching the conditions.
+func (o *OVSDBClient) Ports(where ...Condition) ([]PortRow, error) {
+	rows, err := o.Select("Port", nil, where...)
+	if err != nil {
+		return nil, err
+	}
+	var items []PortRow
+	for _, row := range rows {
+		items = append(items, row.Port())
+	}
+	return items, nil
+}
+
+// Interfaces returns the rows of the Interface table matching the
+// conditions.
+func (o *OVSDBClient) Interfaces(where ...Condition) ([]InterfaceRow, error) {
+	rows, err := o.Select("Interface", nil, where...)
+	if err != nil {
+		return nil, err
+	}
+	var items []InterfaceRow
+	for _, row := range rows {
+		items = append(items, row.Interface())
+	}
+	return items, nil
+}
+
+// QoSes returns the rows of the QoS table matching the conditions.
+func (o *OVSDBClient) QoSes(where ...Condition) ([]QoSRow, error) {
+	rows, err := o.Select("QoS", nil, where...)
+	if err != nil {
+		return nil, err
+	}
+	var items []QoSRow
+	for _, row := range rows {
+		items = append(items, row.QoS())
+	}
+	return items, nil
+}
+
+// Mirrors returns the rows of the Mirror table matching the conditions.
+func (o *OVSDBClient) Mirrors(where ...Condition) ([]MirrorRow, error) {
+	rows, err := o.Select("Mirror", nil, where...)
+	if err != nil {
+		return nil, err
+	}
+	var items []MirrorRow
+	for _, row := range rows {
+		items = append(items, row.Mirror())
+	}
+	return items, nil
+}
@@ -0,0 +1,487 @@
+// Copyright 2017 DigitalOcean.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// An ovsdbStandIn is an in-process ovsdb-server, which answers each
+// request with fn.
+type ovsdbStandIn struct {
+	mu      sync.Mutex
+	enc     *json.Encoder
+	replies chan ovsdbMessage
+}
+
+func (s *ovsdbStandIn) notify(method string, params ...interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enc.Encode(ovsdbRequest{Method: method, Params: params, ID: nil})
+}
+
+func (s *ovsdbStandIn) request(method string, params ...interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enc.Encode(ovsdbRequest{Method: method, Params: params, ID: method})
+}
+
+type ovsdbFunc func(method string, params []json.RawMessage) (interface{}, interface{})
+
+func testOVSDB(fn ovsdbFunc) (*OVSDBClient, *ovsdbStandIn) {
+	client, server := net.Pipe()
+	s := &ovsdbStandIn{
+		enc:     json.NewEncoder(server),
+		replies: make(chan ovsdbMessage, 1),
+	}
+	go func() {
+		dec := json.NewDecoder(server)
+		for {
+			var msg ovsdbMessage
+			if err := dec.Decode(&msg); err != nil {
+				return
+			}
+			if msg.Method == "" {
+				s.replies <- msg
+				continue
+			}
+			var params []json.RawMessage
+			json.Unmarshal(msg.Params, &params)
+			result, e := fn(msg.Method, params)
+
+			s.mu.Lock()
+			s.enc.Encode(ovsdbResponse{Result: result, Error: e, ID: msg.ID})
+			s.mu.Unlock()
+		}
+	}()
+
+	db := &OVSDBClient{
+		Timeout: time.Second,
+		dial: func() (net.Conn, error) {
+			return client, nil
+		},
+	}
+	return db, s
+}
+
+// transactFunc answers transactions with results, after checking the
+// operations.
+func transactFunc(t *testing.T, want []string, results ...[]interface{}) ovsdbFunc {
+	n := 0
+	return func(method string, params []json.RawMessage) (interface{}, interface{}) {
+		if want, got := "transact", method; want != got {
+			t.Errorf("unexpected method:\n- want: %v\n-  got: %v",
+				want, got)
+			return nil, "unexpected"
+		}
+		if want, got := `"Open_vSwitch"`, string(params[0]); want != got {
+			t.Errorf("unexpected database:\n- want: %v\n-  got: %v",
+				want, got)
+		}
+		if n < len(want) {
+			var ops []string
+			for _, p := range params[1:] {
+				ops = append(ops, string(p))
+			}
+			if want, got := want[n], strings.Join(ops, ","); want != got {
+				t.Errorf("unexpected operations:\n- want: %v\n-  got: %v",
+					want, got)
+			}
+		}
+		if n >= len(results) {
+			return []interface{}{}, nil
+		}
+		n++
+		return results[n-1], nil
+	}
+}
+
+func TestOVSDBTransactOK(t *testing.T) {
+	want := []string{
+		`{"columns":["_uuid","name","protocols"],"op":"select","table":"Bridge","where":[["name","==","br0"]]}`,
+	}
+	db, _ := testOVSDB(transactFunc(t, want, []interface{}{
+		map[string]interface{}{
+			"rows": []interface{}{
+				map[string]interface{}{
+					"_uuid":        []interface{}{"uuid", "b0"},
+					"name":         "br0",
+					"protocols":    []interface{}{"set", []interface{}{"OpenFlow13", "OpenFlow14"}},
+					"other_config": []interface{}{"map", []interface{}{[]interface{}{"hwaddr", "00:00:00:00:20:15"}}},
+				},
+			},
+		},
+	}))
+
+	rows, err := db.Select("Bridge", []string{"_uuid", "name", "protocols"}, NewCondition("name", "==", "br0"))
+	if err != nil {
+		t.Fatalf("unexpected error for OVSDBClient.Select: %v", err)
+	}
+	if want, got := 1, len(rows); want != got {
+		t.Fatalf("unexpected number of rows:\n- want: %v\n-  got: %v",
+			want, got)
+	}
+
+	wantBridge := BridgeRow{
+		UUID:        "b0",
+		Name:        "br0",
+		Protocols:   []string{"OpenFlow13", "OpenFlow14"},
+		OtherConfig: map[string]string{"hwaddr": "00:00:00:00:20:15"},
+		ExternalIDs: map[string]string{},
+	}
+	if want, got := wantBridge, rows[0].Bridge(); !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected bridge:\n- want: %+v\n-  got: %+v",
+			want, got)
+	}
+}
+
+func TestOVSDBTransactInsertOK(t *testing.T) {
+	want := []string{
+		`{"op":"insert","row":{"target":"tcp:127.0.0.1:6653"},"table":"Controller","uuid-name":"controller"}`,
+	}
+	db, _ := testOVSDB(transactFunc(t, want, []interface{}{
+		map[string]interface{}{"uuid": []interface{}{"uuid", "c0"}},
+	}))
+
+	results, err := db.Transact(Operation{
+		Op:       OpInsert,
+		Table:    "Controller",
+		Row:      Row{"target": "tcp:127.0.0.1:6653"},
+		UUIDName: "controller",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error for OVSDBClient.Transact: %v", err)
+	}
+	if want, got := "c0", results[0].UUID; want != got {
+		t.Fatalf("unexpected uuid:\n- want: %v\n-  got: %v",
+			want, got)
+	}
+}
+
+func TestOVSDBTransactError(t *testing.T) {
+	db, _ := testOVSDB(transactFunc(t, nil, []interface{}{
+		map[string]interface{}{"count": 0},
+		map[string]interface{}{"error": "constraint violation", "details": "no row"},
+	}))
+
+	_, err := db.Transact(
+		Operation{Op: OpDelete, Table: "Port"},
+		Operation{Op: OpMutate, Table: "Bridge"},
+	)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if want, got := "ovsdb: mutate Bridge: constraint violation: no row", err.Error(); want != got {
+		t.Fatalf("unexpected error:\n- want: %v\n-  got: %v",
+			want, got)
+	}
+}
+
+func TestOVSDBMonitorOK(t *testing.T) {
+	var monitor string
+	db, s := testOVSDB(func(method string, params []json.RawMessage) (interface{}, interface{}) {
+		if want, got := "monitor", method; want != got {
+			t.Errorf("unexpected method:\n- want: %v\n-  got: %v",
+				want, got)
+			return nil, "unexpected"
+		}
+		json.Unmarshal(params[1], &monitor)
+		if want, got := `{"Port":{"columns":["name","tag"]}}`, string(params[2]); want != got {
+			t.Errorf("unexpected requests:\n- want: %v\n-  got: %v",
+				want, got)
+		}
+		return map[string]interface{}{
+			"Port": map[string]interface{}{
+				"p0": map[string]interface{}{
+					"new": map[string]interface{}{"name": "eth1", "tag": 10},
+				},
+			},
+		}, nil
+	})
+
+	updates := make(chan TableUpdates, 1)
+	id, initial, err := db.Monitor(map[string]MonitorRequest{
+		"Port": {Columns: []string{"name", "tag"}},
+	}, func(u TableUpdates) {
+		updates <- u
+	})
+	if err != nil {
+		t.Fatalf("unexpected error for OVSDBClient.Monitor: %v", err)
+	}
+	if want, got := monitor, id; want != got {
+		t.Fatalf("unexpected monitor id:\n- want: %v\n-  got: %v",
+			want, got)
+	}
+	if want, got := 10, initial["Port"]["p0"].New.Port().Tag; want != got {
+		t.Fatalf("unexpected initial tag:\n- want: %v\n-  got: %v",
+			want, got)
+	}
+
+	s.notify("update", id, map[string]interface{}{
+		"Port": map[string]interface{}{
+			"p0": map[string]interface{}{
+				"old": map[string]interface{}{"tag": 10},
+				"new": map[string]interface{}{"name": "eth1", "tag": 20},
+			},
+		},
+	})
+
+	select {
+	case u := <-updates:
+		if want, got := 20, u["Port"]["p0"].New.Port().Tag; want != got {
+			t.Fatalf("unexpected updated tag:\n- want: %v\n-  got: %v",
+				want, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no update received")
+	}
+}
+
+func TestOVSDBEchoOK(t *testing.T) {
+	db, s := testOVSDB(func(method string, params []json.RawMessage) (interface{}, interface{}) {
+		return params, nil
+	})
+	if err := db.Echo(); err != nil {
+		t.Fatalf("unexpected error for OVSDBClient.Echo: %v", err)
+	}
+
+	s.request("echo", "keepalive")
+	select {
+	case msg := <-s.replies:
+		if want, got := `["keepalive"]`, string(msg.Result); want != got {
+			t.Fatalf("unexpected echo reply:\n- want: %v\n-  got: %v",
+				want, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no echo reply received")
+	}
+}
+
+func TestClientVSwitchOVSDBListPortsOK(t *testing.T) {
+	want := []string{
+		`{"columns":["_uuid","ports"],"op":"select","table":"Bridge","where":[["name","==","br0"]]}`,
+		`{"columns":["_uuid","name"],"op":"select","table":"Port","where":[]}`,
+	}
+	db, _ := testOVSDB(transactFunc(t, want,
+		[]interface{}{
+			map[string]interface{}{
+				"rows": []interface{}{
+					map[string]interface{}{
+						"_uuid": []interface{}{"uuid", "b0"},
+						"ports": []interface{}{"set", []interface{}{
+							[]interface{}{"uuid", "p0"},
+							[]interface{}{"uuid", "p1"},
+							[]interface{}{"uuid", "p2"},
+						}},
+					},
+				},
+			},
+		},
+		[]interface{}{
+			map[string]interface{}{
+				"rows": []interface{}{
+					map[string]interface{}{"_uuid": []interface{}{"uuid", "p0"}, "name": "br0"},
+					map[string]interface{}{"_uuid": []interface{}{"uuid", "p1"}, "name": "eth2"},
+					map[string]interface{}{"_uuid": []interface{}{"uuid", "p2"}, "name": "eth1"},
+					map[string]interface{}{"_uuid": []interface{}{"uuid", "p3"}, "name": "eth3"},
+				},
+			},
+		},
+	))
+
+	c := New(OVSDB(db))
+	ports, err := c.VSwitch.ListPorts("br0")
+	if err != nil {
+		t.Fatalf("unexpected error for Client.VSwitch.ListPorts: %v", err)
+	}
+	if want, got := []string{"eth1", "eth2"}, ports; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected ports:\n- want: %v\n-  got: %v",
+			want, got)
+	}
+}
+
+func TestClientVSwitchOVSDBGetPortOK(t *testing.T) {
+	db, _ := testOVSDB(transactFunc(t, nil,
+		[]interface{}{
+			map[string]interface{}{
+				"rows": []interface{}{
+					map[string]interface{}{
+						"_uuid":     []interface{}{"uuid", "p1"},
+						"name":      "eth1",
+						"tag":       []interface{}{"set", []interface{}{}},
+						"trunks":    []interface{}{"set", []interface{}{10, 20}},
+						"vlan_mode": []interface{}{"set", []interface{}{}},
+					},
+				},
+			},
+		},
+		[]interface{}{
+			map[string]interface{}{
+				"rows": []interface{}{
+					map[string]interface{}{
+						"_uuid":      []interface{}{"uuid", "i1"},
+						"name":       "eth1",
+						"mac_in_use": "52:54:00:12:34:56",
+						"link_state": "up",
+						"ofport":     3,
+						"mtu":        1500,
+					},
+				},
+			},
+		},
+	))
+
+	c := New(OVSDB(db))
+	data, err := c.VSwitch.Get.Port("eth1")
+	if err != nil {
+		t.Fatalf("unexpected error for Client.VSwitch.Get.Port: %v", err)
+	}
+
+	want := PortData{
+		UUID:      "p1",
+		Mac:       "52:54:00:12:34:56",
+		Name:      "eth1",
+		Trunks:    "10, 20",
+		LinkState: "up",
+		OfPort:    3,
+		Mtu:       1500,
+	}
+	if want, got := want, data; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected port:\n- want: %+v\n-  got: %+v",
+			want, got)
+	}
+}
+
+// nextCfg are the operations a change ends with, and their results.
+const nextCfg = `,{"mutations":[["next_cfg","+=",1]],"op":"mutate","table":"Open_vSwitch","where":[]}` +
+	`,{"columns":["next_cfg"],"op":"select","table":"Open_vSwitch","where":[]}`
+
+const curCfg = `{"columns":["cur_cfg"],"op":"select","table":"Open_vSwitch","where":[]}`
+
+func cfgResults(column string, value int) []interface{} {
+	return []interface{}{
+		map[string]interface{}{"count": 1},
+		map[string]interface{}{"rows": []interface{}{
+			map[string]interface{}{column: value},
+		}},
+	}
+}
+
+func TestClientVSwitchOVSDBSetPortOK(t *testing.T) {
+	want := []string{
+		`{"op":"update","row":{"tag":10,"trunks":["set",[20,30]]},"table":"Port","where":[["name","==","eth1"]]}` + nextCfg,
+		curCfg,
+	}
+	db, _ := testOVSDB(transactFunc(t, want,
+		append([]interface{}{map[string]interface{}{"count": 1}}, cfgResults("next_cfg", 7)...),
+		cfgResults("cur_cfg", 7)[1:],
+	))
+
+	c := New(OVSDB(db))
+	if err := c.VSwitch.Set.Port("eth1", PortOptions{Tag: 10, Trunks: "20,30"}); err != nil {
+		t.Fatalf("unexpected error for Client.VSwitch.Set.Port: %v", err)
+	}
+}
+
+func TestClientVSwitchOVSDBSetBridgeOK(t *testing.T) {
+	want := []string{
+		`{"op":"update","row":{"protocols":["set",["OpenFlow13"]]},"table":"Bridge","where":[["name","==","br0"]]},` +
+			`{"mutations":[["other_config","delete",["set",["snat-1"]]],["other_config","insert",["map",[["snat-1","192.168.1.1"]]]]],"op":"mutate","table":"Bridge","where":[["name","==","br0"]]}` + nextCfg,
+		curCfg,
+	}
+	db, _ := testOVSDB(transactFunc(t, want,
+		append([]interface{}{
+			map[string]interface{}{"count": 1},
+			map[string]interface{}{"count": 1},
+		}, cfgResults("next_cfg", 7)...),
+		cfgResults("cur_cfg", 8)[1:],
+	))
+
+	c := New(OVSDB(db))
+	err := c.VSwitch.Set.Bridge("br0", BridgeOptions{
+		Protocols:   []string{ProtocolOpenFlow13},
+		OtherConfig: map[string]string{"snat-1": "192.168.1.1"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error for Client.VSwitch.Set.Bridge: %v", err)
+	}
+}
+
+func TestClientVSwitchOVSDBAddPortWaitOK(t *testing.T) {
+	want := []string{
+		`{"columns":["_uuid"],"op":"select","table":"Port","where":[["name","==","eth1"]]}`,
+		`{"op":"insert","row":{"name":"eth1"},"table":"Interface","uuid-name":"iface"},` +
+			`{"op":"insert","row":{"interfaces":["set",[["named-uuid","iface"]]],"name":"eth1"},"table":"Port","uuid-name":"port"},` +
+			`{"mutations":[["ports","insert",["set",[["named-uuid","port"]]]]],"op":"mutate","table":"Bridge","where":[["name","==","br0"]]}` + nextCfg,
+		curCfg,
+		curCfg,
+	}
+	db, _ := testOVSDB(transactFunc(t, want,
+		[]interface{}{map[string]interface{}{"rows": []interface{}{}}},
+		append([]interface{}{
+			map[string]interface{}{"uuid": []interface{}{"uuid", "i1"}},
+			map[string]interface{}{"uuid": []interface{}{"uuid", "p1"}},
+			map[string]interface{}{"count": 1},
+		}, cfgResults("next_cfg", 3)...),
+		// ovs-vswitchd applies the change after a poll.
+		cfgResults("cur_cfg", 2)[1:],
+		cfgResults("cur_cfg", 3)[1:],
+	))
+
+	c := New(OVSDB(db))
+	if err := c.VSwitch.AddPort("br0", "eth1"); err != nil {
+		t.Fatalf("unexpected error for Client.VSwitch.AddPort: %v", err)
+	}
+}
+
+func TestClientVSwitchOVSDBAddPortWaitTimeout(t *testing.T) {
+	db, _ := testOVSDB(func(method string, params []json.RawMessage) (interface{}, interface{}) {
+		if strings.Contains(string(params[1]), `"Port"`) {
+			return []interface{}{map[string]interface{}{"rows": []interface{}{}}}, nil
+		}
+		if strings.Contains(string(params[1]), `"cur_cfg"`) {
+			return cfgResults("cur_cfg", 2)[1:], nil
+		}
+		return append([]interface{}{
+			map[string]interface{}{},
+			map[string]interface{}{},
+			map[string]interface{}{"count": 1},
+		}, cfgResults("next_cfg", 3)...), nil
+	})
+	db.Timeout = 50 * time.Millisecond
+
+	c := New(OVSDB(db))
+	if err := c.VSwitch.AddPort("br0", "eth1"); err == nil {
+		t.Fatalf("expected an error for Client.VSwitch.AddPort")
+	}
+}
+
+func TestClientVSwitchOVSDBPortToBridgeNotExist(t *testing.T) {
+	db, _ := testOVSDB(transactFunc(t, nil, []interface{}{
+		map[string]interface{}{"rows": []interface{}{}},
+	}))
+
+	c := New(OVSDB(db))
+	_, err := c.VSwitch.PortToBridge("eth9")
+	if !IsPortNotExist(err) {
+		t.Fatalf("expected a port not exist error, got: %v", err)
+	}
+}
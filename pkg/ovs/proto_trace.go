@@ -19,6 +19,7 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -34,6 +35,7 @@ var (
 	traceStartRegexp      = regexp.MustCompile(`bridge\("(.*)"\)`)
 	traceFlowRegexp       = regexp.MustCompile(` *[[:digit:]]+[.] ([[:alpha:]].*)`)
 	traceActionRegexp     = regexp.MustCompile(` +([[:alpha:]].*)`)
+	traceStepRegexp       = regexp.MustCompile(`^ *([[:digit:]]+)[.] (?:(.*), )?priority ([[:digit:]]+)(?:, cookie (0x[[:xdigit:]]+))?`)
 	recircIDRegexp        = regexp.MustCompile(`recirc_id=`)
 	recircRegexp          = regexp.MustCompile(`recirc\(`)
 	ctCommentRegexp       = regexp.MustCompile(`^\s+->`)
@@ -71,6 +73,10 @@ func (d *dataPathActions) UnmarshalText(b []byte) error {
 	return nil
 }
 
+func (d *dataPathActions) String() string {
+	return d.actions
+}
+
 // DataPathFlows represents the initial/final flows passed/returned from ofproto/trace
 type DataPathFlows struct {
 	Protocol Protocol
@@ -132,6 +138,16 @@ func (df *DataPathFlows) UnmarshalText(b []byte) error {
 	return nil
 }
 
+// TraceStep is a flow matched by the packet in ofproto/trace output, with
+// the actions it applied
+type TraceStep struct {
+	Table    int
+	Match    string
+	Priority int
+	Cookie   uint64
+	Actions  []string
+}
+
 // ProtoTrace is a type representing output from ovs-app-ctl ofproto/trace
 type ProtoTrace struct {
 	CommandStr      string
@@ -139,6 +155,7 @@ type ProtoTrace struct {
 	FinalFlow       *DataPathFlows
 	DataPathActions DataPathActions
 	FlowActions     []string
+	Steps           []TraceStep
 }
 
 // UnmarshalText unmarshals ProtoTrace text into a ProtoTrace type.
@@ -192,6 +209,13 @@ func (pt *ProtoTrace) UnmarshalText(b []byte) error {
 		}
 
 		if _, matched := checkMatch(traceFlowRegexp, line); matched {
+			if matches, matched := checkMatch(traceStepRegexp, line); matched {
+				step, err := parseTraceStep(matches)
+				if err != nil {
+					return err
+				}
+				pt.Steps = append(pt.Steps, step)
+			}
 			continue
 		}
 
@@ -211,6 +235,9 @@ func (pt *ProtoTrace) UnmarshalText(b []byte) error {
 
 		if matches, matched := checkMatch(traceActionRegexp, line); matched {
 			pt.FlowActions = append(pt.FlowActions, matches[1])
+			if n := len(pt.Steps); n > 0 {
+				pt.Steps[n-1].Actions = append(pt.Steps[n-1].Actions, matches[1])
+			}
 			continue
 		}
 	}
@@ -218,6 +245,27 @@ func (pt *ProtoTrace) UnmarshalText(b []byte) error {
 	return nil
 }
 
+// parseTraceStep parses the table, match, priority and cookie of a flow
+// line of ofproto/trace output
+func parseTraceStep(matches []string) (TraceStep, error) {
+	step := TraceStep{Match: matches[2]}
+
+	var err error
+	if step.Table, err = strconv.Atoi(matches[1]); err != nil {
+		return step, err
+	}
+	if step.Priority, err = strconv.Atoi(matches[3]); err != nil {
+		return step, err
+	}
+	if matches[4] != "" {
+		if step.Cookie, err = strconv.ParseUint(matches[4], 0, 64); err != nil {
+			return step, err
+		}
+	}
+
+	return step, nil
+}
+
 func checkMatch(re *regexp.Regexp, s string) ([]string, bool) {
 	matches := re.FindStringSubmatch(s)
 	if len(matches) == 0 {
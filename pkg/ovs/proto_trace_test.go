@@ -316,3 +316,58 @@ Datapath actions: drop`,
 		})
 	}
 }
+
+func TestProtoTraceStepsOK(t *testing.T) {
+	output := `Flow: tcp,in_port=3,vlan_tci=0x0000,dl_src=00:00:00:00:00:00,dl_dst=00:00:00:00:20:15,nw_src=192.168.1.2,nw_dst=8.8.8.8,nw_tos=0,nw_ecn=0,nw_ttl=64,tp_src=0,tp_dst=443,tcp_flags=0
+
+bridge("vrr")
+-------------
+ 0. ip, priority 100, cookie 0x2021
+    resubmit(,10)
+10. ip, priority 100, cookie 0x2021
+    ct(table=12,zone=10,nat)
+    nat
+     -> A clone of the packet is forked to recirculate. The forked pipeline will be resumed at table 12.
+
+Final flow: unchanged
+Megaflow: recirc_id=0,eth,ip,in_port=3,nw_frag=no
+Datapath actions: ct(zone=10,nat),recirc(0x1)
+
+===============================================================================
+recirc(0x1) - resume conntrack with default ct_state=trk|new (use --ct-next to customize)
+===============================================================================
+
+Flow: recirc_id=0x1,ct_state=new|trk,ct_zone=10,eth,tcp,in_port=3,vlan_tci=0x0000,dl_src=00:00:00:00:00:00,dl_dst=00:00:00:00:20:15,nw_src=192.168.1.2,nw_dst=8.8.8.8,nw_tos=0,nw_ecn=0,nw_ttl=64,tp_src=0,tp_dst=443,tcp_flags=0
+
+bridge("vrr")
+-------------
+    thaw
+        Resuming from table 12
+12. priority 10
+    resubmit(,15)
+19. ip,metadata=0,nw_dst=0.0.0.0/0, priority 32, cookie 0x1a1b2c3d4e5f6
+    group:1
+
+Final flow: unchanged
+Megaflow: recirc_id=0x1,ct_state=+new-est-rel-rpl+trk,eth,tcp,in_port=3,nw_frag=no
+Datapath actions: set(ipv4(ttl=63)),4`
+
+	pt := &ProtoTrace{}
+	if err := pt.UnmarshalText([]byte(output)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []TraceStep{
+		{Table: 0, Match: "ip", Priority: 100, Cookie: 0x2021, Actions: []string{"resubmit(,10)"}},
+		{Table: 10, Match: "ip", Priority: 100, Cookie: 0x2021, Actions: []string{"ct(table=12,zone=10,nat)", "nat"}},
+		{Table: 12, Priority: 10, Actions: []string{"resubmit(,15)"}},
+		{Table: 19, Match: "ip,metadata=0,nw_dst=0.0.0.0/0", Priority: 32, Cookie: 0x1a1b2c3d4e5f6, Actions: []string{"group:1"}},
+	}
+	if got := pt.Steps; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected steps:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	if want, got := NewDataPathActions("set(ipv4(ttl=63)),4"), pt.DataPathActions; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected datapath actions:\n- want: %v\n-  got: %v", want, got)
+	}
+}
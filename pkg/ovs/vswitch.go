@@ -30,7 +30,8 @@ const (
 	DefaultIngressBurstPolicing = int64(-1)
 )
 
-// A VSwitchService is used in a Client to execute 'ovs-vsctl' commands.
+// A VSwitchService is used in a Client to execute 'ovs-vsctl' commands, or
+// their transactions if the Client has an OVSDBClient.
 type VSwitchService struct {
 	// Get wraps functionality of the 'ovs-vsctl get' subcommand.
 	Get *VSwitchGetService
@@ -45,11 +46,17 @@ type VSwitchService struct {
 // AddBridge attaches a bridge to Open vSwitch.  The bridge may or may
 // not already exist.
 func (v *VSwitchService) AddBridge(bridge string) error {
+	if db := v.c.ovsdb; db != nil {
+		return db.addBridge(bridge)
+	}
 	_, err := v.exec("--may-exist", "add-br", bridge)
 	return err
 }
 
 func (v *VSwitchService) RemoveBridge(bridge, column string, fields ...string) error {
+	if db := v.c.ovsdb; db != nil {
+		return db.removeBridge(bridge, column, fields...)
+	}
 	args := []string{"remove", "bridge", bridge}
 	for _, c := range fields {
 		args = append(args, column, c)
@@ -61,6 +68,12 @@ func (v *VSwitchService) RemoveBridge(bridge, column string, fields ...string) e
 // AddPort attaches a port to a bridge on Open vSwitch.  The port may or may
 // not already exist.
 func (v *VSwitchService) AddPortWith(bridge string, port string, ifs InterfaceOptions) error {
+	if db := v.c.ovsdb; db != nil {
+		if err := db.addPort(bridge, port); err != nil {
+			return err
+		}
+		return db.setInterface(port, ifs)
+	}
 	args := []string{"--may-exist", "add-port", bridge, port, "--", "set", "interface", port}
 	args = append(args, ifs.slice()...)
 
@@ -69,6 +82,9 @@ func (v *VSwitchService) AddPortWith(bridge string, port string, ifs InterfaceOp
 }
 
 func (v *VSwitchService) ClearPort(port, column string) error {
+	if db := v.c.ovsdb; db != nil {
+		return db.clearPort(port, column)
+	}
 	args := []string{"clear", "port", port, column}
 
 	_, err := v.exec(args...)
@@ -78,6 +94,9 @@ func (v *VSwitchService) ClearPort(port, column string) error {
 // AddPort attaches a port to a bridge on Open vSwitch.  The port may or may
 // not already exist.
 func (v *VSwitchService) AddPort(bridge string, port string) error {
+	if db := v.c.ovsdb; db != nil {
+		return db.addPort(bridge, port)
+	}
 	_, err := v.exec("--may-exist", "add-port", bridge, string(port))
 	return err
 }
@@ -85,6 +104,9 @@ func (v *VSwitchService) AddPort(bridge string, port string) error {
 // DeleteBridge detaches a bridge from Open vSwitch.  The bridge may or may
 // not already exist.
 func (v *VSwitchService) DeleteBridge(bridge string) error {
+	if db := v.c.ovsdb; db != nil {
+		return db.deleteBridge(bridge)
+	}
 	_, err := v.exec("--if-exists", "del-br", bridge)
 	return err
 }
@@ -92,12 +114,18 @@ func (v *VSwitchService) DeleteBridge(bridge string) error {
 // DeletePort detaches a port from a bridge on Open vSwitch.  The port may or may
 // not already exist.
 func (v *VSwitchService) DeletePort(bridge string, port string) error {
+	if db := v.c.ovsdb; db != nil {
+		return db.deletePort(bridge, port)
+	}
 	_, err := v.exec("--if-exists", "del-port", bridge, string(port))
 	return err
 }
 
 // ListPorts lists the ports in Open vSwitch.
 func (v *VSwitchService) ListPorts(bridge string) ([]string, error) {
+	if db := v.c.ovsdb; db != nil {
+		return db.listPorts(bridge)
+	}
 	output, err := v.exec("list-ports", bridge)
 	if err != nil {
 		return nil, err
@@ -114,6 +142,9 @@ func (v *VSwitchService) ListPorts(bridge string) ([]string, error) {
 
 // ListBridges lists the bridges in Open vSwitch.
 func (v *VSwitchService) ListBridges() ([]string, error) {
+	if db := v.c.ovsdb; db != nil {
+		return db.listBridges()
+	}
 	output, err := v.exec("list-br")
 	if err != nil {
 		return nil, err
@@ -132,6 +163,9 @@ func (v *VSwitchService) ListBridges() ([]string, error) {
 // If port does not exist, an error will be returned, which can be checked
 // using IsPortNotExist.
 func (v *VSwitchService) PortToBridge(port string) (string, error) {
+	if db := v.c.ovsdb; db != nil {
+		return db.portToBridge(port)
+	}
 	out, err := v.exec("port-to-br", string(port))
 	if err != nil {
 		return "", err
@@ -142,6 +176,9 @@ func (v *VSwitchService) PortToBridge(port string) (string, error) {
 
 // GetFailMode gets the FailMode for the specified bridge.
 func (v *VSwitchService) GetFailMode(bridge string) (FailMode, error) {
+	if db := v.c.ovsdb; db != nil {
+		return db.getFailMode(bridge)
+	}
 	out, err := v.exec("get-fail-mode", bridge)
 	if err != nil {
 		return "", err
@@ -152,6 +189,9 @@ func (v *VSwitchService) GetFailMode(bridge string) (FailMode, error) {
 
 // SetFailMode sets the specified FailMode for the specified bridge.
 func (v *VSwitchService) SetFailMode(bridge string, mode FailMode) error {
+	if db := v.c.ovsdb; db != nil {
+		return db.setFailMode(bridge, mode)
+	}
 	_, err := v.exec("set-fail-mode", bridge, string(mode))
 	return err
 }
@@ -159,12 +199,18 @@ func (v *VSwitchService) SetFailMode(bridge string, mode FailMode) error {
 // SetController sets the controller for this bridge so that ovs-ofctl
 // can use this address to communicate.
 func (v *VSwitchService) SetController(bridge string, address string) error {
+	if db := v.c.ovsdb; db != nil {
+		return db.setController(bridge, address)
+	}
 	_, err := v.exec("set-controller", bridge, address)
 	return err
 }
 
 // GetController gets the controller address for this bridge.
 func (v *VSwitchService) GetController(bridge string) (string, error) {
+	if db := v.c.ovsdb; db != nil {
+		return db.getController(bridge)
+	}
 	address, err := v.exec("get-controller", bridge)
 	if err != nil {
 		return "", err
@@ -214,6 +260,9 @@ func parseMap(s string, result map[string]string) error {
 // Bridge gets configuration for a bridge and returns the values through
 // a BridgeOptions struct.
 func (v *VSwitchGetService) Bridge(bridge string) (BridgeOptions, error) {
+	if db := v.v.c.ovsdb; db != nil {
+		return db.getBridge(bridge)
+	}
 	// We only support the protocol option at this point.
 	options := BridgeOptions{}
 	args := []string{"--format=json", "get", "bridge", bridge, "protocols"}
@@ -284,6 +333,9 @@ func trimSet(value string) string {
 }
 
 func (v *VSwitchGetService) Port(port string) (PortData, error) {
+	if db := v.v.c.ovsdb; db != nil {
+		return db.getPort(port)
+	}
 	data := PortData{}
 
 	args := []string{"list", "port", port}
@@ -338,6 +390,9 @@ type VSwitchSetService struct {
 // Bridge sets configuration for a bridge using the values from a BridgeOptions
 // struct.
 func (v *VSwitchSetService) Bridge(bridge string, options BridgeOptions) error {
+	if db := v.v.c.ovsdb; db != nil {
+		return db.setBridge(bridge, options)
+	}
 	// Prepend command line arguments before expanding options slice
 	// and appending it
 	args := []string{"set", "bridge", bridge}
@@ -375,6 +430,9 @@ func (o BridgeOptions) slice() []string {
 // Interface sets configuration for an interface using the values from an
 // InterfaceOptions struct.
 func (v *VSwitchSetService) Interface(ifi string, options InterfaceOptions) error {
+	if db := v.v.c.ovsdb; db != nil {
+		return db.setInterface(ifi, options)
+	}
 	// Prepend command line arguments before expanding options slice
 	// and appending it
 	args := []string{"set", "interface", ifi}
@@ -540,6 +598,9 @@ func (i PortOptions) slice() []string {
 }
 
 func (v *VSwitchSetService) Port(ifi string, options PortOptions) error {
+	if db := v.v.c.ovsdb; db != nil {
+		return db.setPort(ifi, options)
+	}
 	args := []string{"set", "port", ifi}
 	args = append(args, options.slice()...)
 
@@ -0,0 +1,561 @@
+// Copyright 2017 DigitalOcean.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovs
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The VSwitchService commands done with an OVSDBClient, the way
+// 'ovs-vsctl' does them.
+
+// errPortNotExist is returned when a port is not found, it is checked
+// by IsPortNotExist.
+type errPortNotExist string
+
+func (e errPortNotExist) Error() string {
+	return fmt.Sprintf("ovsdb: no port named %s", string(e))
+}
+
+// mapColumns are the columns of the Bridge, Port and Interface tables
+// which hold a map.
+var mapColumns = map[string]bool{
+	"other_config": true,
+	"external_ids": true,
+	"options":      true,
+	"status":       true,
+	"statistics":   true,
+	"bfd":          true,
+	"bfd_status":   true,
+	"lacp":         true,
+	"lldp":         true,
+}
+
+// cfgPoll is the interval cur_cfg is read at, until ovs-vswitchd has
+// applied a change.
+const cfgPoll = 10 * time.Millisecond
+
+// commit runs the operations in a transaction which increments next_cfg,
+// and waits for ovs-vswitchd to catch up with it in cur_cfg, as
+// 'ovs-vsctl' does without --no-wait. The bridges and ports added are in
+// place on return.
+func (o *OVSDBClient) commit(ops ...Operation) error {
+	ops = append(ops,
+		Operation{
+			Op:    OpMutate,
+			Table: OVSDBDatabase,
+			Mutations: []Mutation{
+				NewMutation("next_cfg", "+=", 1),
+			},
+		},
+		Operation{
+			Op:      OpSelect,
+			Table:   OVSDBDatabase,
+			Columns: []string{"next_cfg"},
+		},
+	)
+	results, err := o.Transact(ops...)
+	if err != nil {
+		return err
+	}
+	if len(results) < len(ops) || len(results[len(ops)-1].Rows) == 0 {
+		return fmt.Errorf("ovsdb: select %s: no result", OVSDBDatabase)
+	}
+	next := results[len(ops)-1].Rows[0].int("next_cfg")
+
+	deadline := time.Now().Add(o.timeout())
+	for {
+		rows, err := o.Select(OVSDBDatabase, []string{"cur_cfg"})
+		if err != nil {
+			return err
+		}
+		if len(rows) > 0 && rows[0].int("cur_cfg") >= next {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("ovsdb: ovs-vswitchd did not apply configuration %d", next)
+		}
+		time.Sleep(cfgPoll)
+	}
+}
+
+func byName(name string) Condition {
+	return NewCondition("name", "==", name)
+}
+
+// lookup returns the row named in the table, nil if there is none.
+func (o *OVSDBClient) lookup(table, name string, columns ...string) (Row, error) {
+	rows, err := o.Select(table, append([]string{"_uuid"}, columns...), byName(name))
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	return rows[0], nil
+}
+
+func (o *OVSDBClient) addBridge(bridge string) error {
+	row, err := o.lookup("Bridge", bridge)
+	if err != nil || row != nil {
+		return err
+	}
+
+	err = o.commit(
+		Operation{
+			Op:       OpInsert,
+			Table:    "Interface",
+			Row:      Row{"name": bridge, "type": string(InterfaceTypeInternal)},
+			UUIDName: "iface",
+		},
+		Operation{
+			Op:       OpInsert,
+			Table:    "Port",
+			Row:      Row{"name": bridge, "interfaces": OVSDBSet(NamedUUID("iface"))},
+			UUIDName: "port",
+		},
+		Operation{
+			Op:       OpInsert,
+			Table:    "Bridge",
+			Row:      Row{"name": bridge, "ports": OVSDBSet(NamedUUID("port"))},
+			UUIDName: "bridge",
+		},
+		Operation{
+			Op:    OpMutate,
+			Table: OVSDBDatabase,
+			Mutations: []Mutation{
+				NewMutation("bridges", "insert", OVSDBSet(NamedUUID("bridge"))),
+			},
+		},
+	)
+	return err
+}
+
+// deleteBridge drops the reference to the bridge, ovsdb-server removes
+// its ports and interfaces with it.
+func (o *OVSDBClient) deleteBridge(bridge string) error {
+	row, err := o.lookup("Bridge", bridge)
+	if err != nil || row == nil {
+		return err
+	}
+
+	err = o.commit(Operation{
+		Op:    OpMutate,
+		Table: OVSDBDatabase,
+		Mutations: []Mutation{
+			NewMutation("bridges", "delete", OVSDBSet(RowUUID(row.uuid()))),
+		},
+	})
+	return err
+}
+
+// removeBridge deletes the values of a set column, or the keys of a map
+// column.
+func (o *OVSDBClient) removeBridge(bridge, column string, fields ...string) error {
+	var values []interface{}
+	for _, f := range fields {
+		values = append(values, f)
+	}
+	err := o.commit(Operation{
+		Op:    OpMutate,
+		Table: "Bridge",
+		Where: []Condition{byName(bridge)},
+		Mutations: []Mutation{
+			NewMutation(column, "delete", OVSDBSet(values...)),
+		},
+	})
+	return err
+}
+
+func (o *OVSDBClient) addPort(bridge, port string) error {
+	row, err := o.lookup("Port", port)
+	if err != nil || row != nil {
+		return err
+	}
+
+	err = o.commit(
+		Operation{
+			Op:       OpInsert,
+			Table:    "Interface",
+			Row:      Row{"name": port},
+			UUIDName: "iface",
+		},
+		Operation{
+			Op:       OpInsert,
+			Table:    "Port",
+			Row:      Row{"name": port, "interfaces": OVSDBSet(NamedUUID("iface"))},
+			UUIDName: "port",
+		},
+		Operation{
+			Op:    OpMutate,
+			Table: "Bridge",
+			Where: []Condition{byName(bridge)},
+			Mutations: []Mutation{
+				NewMutation("ports", "insert", OVSDBSet(NamedUUID("port"))),
+			},
+		},
+	)
+	return err
+}
+
+func (o *OVSDBClient) deletePort(bridge, port string) error {
+	row, err := o.lookup("Port", port)
+	if err != nil || row == nil {
+		return err
+	}
+
+	err = o.commit(Operation{
+		Op:    OpMutate,
+		Table: "Bridge",
+		Where: []Condition{byName(bridge)},
+		Mutations: []Mutation{
+			NewMutation("ports", "delete", OVSDBSet(RowUUID(row.uuid()))),
+		},
+	})
+	return err
+}
+
+// listPorts returns the ports of the bridge sorted, but its local port.
+func (o *OVSDBClient) listPorts(bridge string) ([]string, error) {
+	row, err := o.lookup("Bridge", bridge, "ports")
+	if err != nil {
+		return nil, err
+	}
+	if row == nil {
+		return nil, fmt.Errorf("ovsdb: no bridge named %s", bridge)
+	}
+	uuids := make(map[string]bool)
+	for _, id := range row.strs("ports") {
+		uuids[id] = true
+	}
+
+	rows, err := o.Select("Port", []string{"_uuid", "name"})
+	if err != nil {
+		return nil, err
+	}
+	var ports []string
+	for _, row := range rows {
+		name := row.str("name")
+		if uuids[row.uuid()] && name != bridge {
+			ports = append(ports, name)
+		}
+	}
+	sort.Strings(ports)
+	return ports, nil
+}
+
+func (o *OVSDBClient) listBridges() ([]string, error) {
+	rows, err := o.Select("Bridge", []string{"name"})
+	if err != nil {
+		return nil, err
+	}
+	var bridges []string
+	for _, row := range rows {
+		bridges = append(bridges, row.str("name"))
+	}
+	sort.Strings(bridges)
+	return bridges, nil
+}
+
+func (o *OVSDBClient) portToBridge(port string) (string, error) {
+	row, err := o.lookup("Port", port)
+	if err != nil {
+		return "", err
+	}
+	if row == nil {
+		return "", errPortNotExist(port)
+	}
+
+	rows, err := o.Select("Bridge", []string{"name"},
+		NewCondition("ports", "includes", RowUUID(row.uuid())))
+	if err != nil {
+		return "", err
+	}
+	if len(rows) == 0 {
+		return "", errPortNotExist(port)
+	}
+	return rows[0].str("name"), nil
+}
+
+func (o *OVSDBClient) getFailMode(bridge string) (FailMode, error) {
+	row, err := o.lookup("Bridge", bridge, "fail_mode")
+	if err != nil {
+		return "", err
+	}
+	if row == nil {
+		return "", fmt.Errorf("ovsdb: no bridge named %s", bridge)
+	}
+	return FailMode(row.str("fail_mode")), nil
+}
+
+func (o *OVSDBClient) setFailMode(bridge string, mode FailMode) error {
+	return o.updateRow("Bridge", bridge, Row{"fail_mode": string(mode)})
+}
+
+func (o *OVSDBClient) setController(bridge, address string) error {
+	err := o.commit(
+		Operation{
+			Op:       OpInsert,
+			Table:    "Controller",
+			Row:      Row{"target": address},
+			UUIDName: "controller",
+		},
+		Operation{
+			Op:    OpUpdate,
+			Table: "Bridge",
+			Where: []Condition{byName(bridge)},
+			Row:   Row{"controller": OVSDBSet(NamedUUID("controller"))},
+		},
+	)
+	return err
+}
+
+func (o *OVSDBClient) getController(bridge string) (string, error) {
+	row, err := o.lookup("Bridge", bridge, "controller")
+	if err != nil {
+		return "", err
+	}
+	if row == nil {
+		return "", fmt.Errorf("ovsdb: no bridge named %s", bridge)
+	}
+
+	var targets []string
+	for _, id := range row.strs("controller") {
+		rows, err := o.Select("Controller", []string{"target"},
+			NewCondition("_uuid", "==", RowUUID(id)))
+		if err != nil {
+			return "", err
+		}
+		for _, row := range rows {
+			targets = append(targets, row.str("target"))
+		}
+	}
+	return strings.Join(targets, "\n"), nil
+}
+
+func (o *OVSDBClient) getBridge(bridge string) (BridgeOptions, error) {
+	options := BridgeOptions{}
+	row, err := o.lookup("Bridge", bridge, "protocols", "other_config")
+	if err != nil {
+		return options, err
+	}
+	if row == nil {
+		return options, fmt.Errorf("ovsdb: no bridge named %s", bridge)
+	}
+	options.Protocols = row.strs("protocols")
+	options.OtherConfig = row.strMap("other_config")
+	return options, nil
+}
+
+func (o *OVSDBClient) getPort(port string) (PortData, error) {
+	data := PortData{}
+	ports, err := o.Ports(byName(port))
+	if err != nil {
+		return data, err
+	}
+	if len(ports) == 0 {
+		return data, errPortNotExist(port)
+	}
+
+	p := ports[0]
+	var trunks []string
+	for _, t := range p.Trunks {
+		trunks = append(trunks, strconv.Itoa(t))
+	}
+	data.UUID = p.UUID
+	data.Name = p.Name
+	data.Mac = p.Mac
+	data.Tag = p.Tag
+	data.VlanMode = p.VlanMode
+	data.Trunks = strings.Join(trunks, ", ")
+
+	ifaces, _ := o.Interfaces(byName(port))
+	if len(ifaces) > 0 {
+		i := ifaces[0]
+		if i.MacInUse != "" {
+			data.Mac = i.MacInUse
+		}
+		data.LinkState = i.LinkState
+		data.OfPort = i.OfPort
+		data.Mtu = i.Mtu
+	}
+	return data, nil
+}
+
+func (o *OVSDBClient) setBridge(bridge string, options BridgeOptions) error {
+	row := Row{}
+	if len(options.Protocols) > 0 {
+		var protocols []interface{}
+		for _, p := range options.Protocols {
+			protocols = append(protocols, p)
+		}
+		row["protocols"] = OVSDBSet(protocols...)
+	}
+	return o.set("Bridge", bridge, row, map[string]map[string]string{
+		"other_config": options.OtherConfig,
+	})
+}
+
+func (o *OVSDBClient) setInterface(ifi string, options InterfaceOptions) error {
+	row, maps := options.columns()
+	return o.set("Interface", ifi, row, maps)
+}
+
+func (o *OVSDBClient) setPort(port string, options PortOptions) error {
+	row := Row{}
+	if options.Tag > 0 {
+		row["tag"] = options.Tag
+	}
+	if options.Trunks != "" {
+		var trunks []interface{}
+		for _, t := range strings.Split(options.Trunks, ",") {
+			tag, err := strconv.Atoi(strings.TrimSpace(t))
+			if err != nil {
+				return fmt.Errorf("ovsdb: invalid trunks %s", options.Trunks)
+			}
+			trunks = append(trunks, tag)
+		}
+		row["trunks"] = OVSDBSet(trunks...)
+	}
+	if options.VlanMode != "" {
+		row["vlan_mode"] = options.VlanMode
+	}
+	return o.set("Port", port, row, nil)
+}
+
+func (o *OVSDBClient) clearPort(port, column string) error {
+	value := OVSDBSet()
+	if mapColumns[column] {
+		value = OVSDBMap(nil)
+	}
+	return o.updateRow("Port", port, Row{column: value})
+}
+
+func (o *OVSDBClient) updateRow(table, name string, row Row) error {
+	err := o.commit(Operation{
+		Op:    OpUpdate,
+		Table: table,
+		Where: []Condition{byName(name)},
+		Row:   row,
+	})
+	return err
+}
+
+// set updates the columns of the row and the keys given of its map
+// columns, other keys are kept as 'ovs-vsctl set' does.
+func (o *OVSDBClient) set(table, name string, row Row, maps map[string]map[string]string) error {
+	var ops []Operation
+	if len(row) > 0 {
+		ops = append(ops, Operation{
+			Op:    OpUpdate,
+			Table: table,
+			Where: []Condition{byName(name)},
+			Row:   row,
+		})
+	}
+
+	var mutations []Mutation
+	for column, m := range maps {
+		if len(m) == 0 {
+			continue
+		}
+		var keys []interface{}
+		for k := range m {
+			keys = append(keys, k)
+		}
+		// An insert keeps the value of a key present.
+		mutations = append(mutations,
+			NewMutation(column, "delete", OVSDBSet(keys...)),
+			NewMutation(column, "insert", OVSDBMap(m)))
+	}
+	if len(mutations) > 0 {
+		ops = append(ops, Operation{
+			Op:        OpMutate,
+			Table:     table,
+			Where:     []Condition{byName(name)},
+			Mutations: mutations,
+		})
+	}
+
+	if len(ops) == 0 {
+		return nil
+	}
+	err := o.commit(ops...)
+	return err
+}
+
+// columns returns the columns and the keys of map columns for the
+// non-zero option values, as slice does.
+func (i InterfaceOptions) columns() (Row, map[string]map[string]string) {
+	row := Row{}
+	options := make(map[string]string)
+	bfd := make(map[string]string)
+
+	if i.Type != "" {
+		row["type"] = string(i.Type)
+	}
+	if i.BfdEnable {
+		bfd["enable"] = "true"
+	}
+	if i.Peer != "" {
+		options["peer"] = i.Peer
+	}
+	if i.MTURequest > 0 {
+		row["mtu_request"] = i.MTURequest
+	}
+
+	if i.IngressRatePolicing == DefaultIngressRatePolicing {
+		row["ingress_policing_rate"] = 0
+	} else if i.IngressRatePolicing > 0 {
+		row["ingress_policing_rate"] = i.IngressRatePolicing
+	}
+	if i.IngressBurstPolicing == DefaultIngressBurstPolicing {
+		row["ingress_policing_burst"] = 0
+	} else if i.IngressBurstPolicing > 0 {
+		row["ingress_policing_burst"] = i.IngressBurstPolicing
+	}
+
+	if i.RemoteIP != "" {
+		options["remote_ip"] = i.RemoteIP
+	}
+	if i.Key != "" {
+		options["key"] = i.Key
+	}
+	if i.DfDefault != "" {
+		options["df_default"] = i.DfDefault
+	}
+	if i.LocalIP != "" {
+		options["local_ip"] = i.LocalIP
+	}
+	if i.DstPort > 0 {
+		options["dst_port"] = strconv.Itoa(int(i.DstPort))
+	}
+
+	if i.Mac != "" {
+		row["mac"] = i.Mac
+	}
+	if i.OfportRequest > 0 {
+		row["ofport_request"] = i.OfportRequest
+	}
+
+	return row, map[string]map[string]string{
+		"options": options,
+		"bfd":     bfd,
+	}
+}
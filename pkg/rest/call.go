@@ -9,10 +9,27 @@ type Caller interface {
 	DelInterface(data schema.Interface) error
 	ListInterface() ([]schema.Interface, error)
 	ListForward() ([]schema.IPForward, error)
+	ShowQueue() (schema.FlowQueue, error)
+	ListMetrics() ([]schema.Metric, error)
+	Trace(data schema.Packet) (schema.Trace, error)
+	ListConntrack(filter schema.ConntrackFilter) ([]schema.Conntrack, error)
+	FlushConntrack(filter schema.ConntrackFilter) ([]schema.Conntrack, error)
+	SetLimit(data schema.ConntrackLimit) (schema.ConntrackLimit, error)
+	DelLimit(data schema.ConntrackLimit) error
+	ListLimits() ([]schema.ConntrackLimit, error)
 	AddSNAT(data schema.SNAT) error
 	DelSNAT(data schema.SNAT) error
 	ListSNAT() ([]schema.SNAT, error)
 	AddDNAT(data schema.DNAT) error
 	DelDNAT(data schema.DNAT) error
 	ListDNAT() ([]schema.DNAT, error)
+	AddStaticNAT(data schema.StaticNAT) error
+	DelStaticNAT(data schema.StaticNAT) error
+	ListStaticNAT() ([]schema.StaticNAT, error)
+	AddVrf(data schema.VRF) error
+	DelVrf(data schema.VRF) error
+	ListVrf() ([]schema.VRF, error)
+	SaveConfig() (schema.Config, error)
+	ShowConfig() (schema.Config, error)
+	ApplyConfig(data schema.Config, dryRun bool) (schema.ConfigDiff, error)
 }
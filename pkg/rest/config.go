@@ -0,0 +1,49 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/luscis/openvrr/pkg/schema"
+)
+
+type Config struct {
+	call Caller
+}
+
+func (l Config) Router(r *mux.Router) {
+	r.HandleFunc("/api/config", l.Show).Methods("GET")
+	r.HandleFunc("/api/config", l.Apply).Methods("POST")
+	r.HandleFunc("/api/config/save", l.Save).Methods("POST")
+}
+
+func (l Config) Show(w http.ResponseWriter, r *http.Request) {
+	if data, err := l.call.ShowConfig(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	} else {
+		ResponseJson(w, data)
+	}
+}
+
+func (l Config) Save(w http.ResponseWriter, r *http.Request) {
+	if data, err := l.call.SaveConfig(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	} else {
+		ResponseJson(w, data)
+	}
+}
+
+func (l Config) Apply(w http.ResponseWriter, r *http.Request) {
+	data := schema.Config{}
+	if err := GetData(r, &data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dryRun := GetQueryOne(r, "dryRun") == "true"
+	if diff, err := l.call.ApplyConfig(data, dryRun); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	} else {
+		ResponseJson(w, diff)
+	}
+}
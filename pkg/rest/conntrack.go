@@ -0,0 +1,83 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/luscis/openvrr/pkg/schema"
+)
+
+type Conntrack struct {
+	call Caller
+}
+
+func (l Conntrack) Router(r *mux.Router) {
+	r.HandleFunc("/api/conntrack", l.List).Methods("GET")
+	r.HandleFunc("/api/conntrack", l.Flush).Methods("DELETE")
+	r.HandleFunc("/api/conntrack/limit", l.ListLimits).Methods("GET")
+	r.HandleFunc("/api/conntrack/limit", l.SetLimit).Methods("POST")
+	r.HandleFunc("/api/conntrack/limit", l.DelLimit).Methods("DELETE")
+}
+
+func (l Conntrack) List(w http.ResponseWriter, r *http.Request) {
+	filter := schema.ConntrackFilter{
+		Protocol: GetQueryOne(r, "protocol"),
+		Source:   GetQueryOne(r, "source"),
+		Dest:     GetQueryOne(r, "destination"),
+	}
+	if items, err := l.call.ListConntrack(filter); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	} else {
+		ResponseJson(w, items)
+	}
+}
+
+func (l Conntrack) Flush(w http.ResponseWriter, r *http.Request) {
+	filter := schema.ConntrackFilter{}
+	if err := GetData(r, &filter); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if items, err := l.call.FlushConntrack(filter); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	} else {
+		ResponseJson(w, items)
+	}
+}
+
+func (l Conntrack) ListLimits(w http.ResponseWriter, r *http.Request) {
+	if items, err := l.call.ListLimits(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	} else {
+		ResponseJson(w, items)
+	}
+}
+
+func (l Conntrack) SetLimit(w http.ResponseWriter, r *http.Request) {
+	data := schema.ConntrackLimit{}
+	if err := GetData(r, &data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if item, err := l.call.SetLimit(data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	} else {
+		ResponseJson(w, item)
+	}
+}
+
+func (l Conntrack) DelLimit(w http.ResponseWriter, r *http.Request) {
+	data := schema.ConntrackLimit{}
+	if err := GetData(r, &data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := l.call.DelLimit(data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ResponseJson(w, "success")
+}
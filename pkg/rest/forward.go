@@ -12,9 +12,15 @@ type Forward struct {
 
 func (l Forward) Router(r *mux.Router) {
 	r.HandleFunc("/api/forward", l.List).Methods("GET")
+	r.HandleFunc("/api/forward/queue", l.Queue).Methods("GET")
 }
 
 func (l Forward) List(w http.ResponseWriter, r *http.Request) {
 	items, _ := l.call.ListForward()
 	ResponseJson(w, items)
 }
+
+func (l Forward) Queue(w http.ResponseWriter, r *http.Request) {
+	item, _ := l.call.ShowQueue()
+	ResponseJson(w, item)
+}
@@ -0,0 +1,61 @@
+package rest
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+type Metrics struct {
+	call Caller
+}
+
+func (l Metrics) Router(r *mux.Router) {
+	r.HandleFunc("/metrics", l.Get).Methods("GET")
+}
+
+// Get writes the metrics in the text exposition format of Prometheus.
+func (l Metrics) Get(w http.ResponseWriter, r *http.Request) {
+	items, err := l.call.ListMetrics()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	buf := bytes.NewBuffer(nil)
+	for _, item := range items {
+		fmt.Fprintf(buf, "# HELP %s %s\n", item.Name, item.Help)
+		fmt.Fprintf(buf, "# TYPE %s %s\n", item.Name, item.Type)
+		for _, sample := range item.Samples {
+			fmt.Fprintf(buf, "%s%s%s %s\n", item.Name, sample.Suffix, FormatLabels(sample.Labels),
+				strconv.FormatFloat(sample.Value, 'g', -1, 64))
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// FormatLabels returns the labels sorted by name, as {name="value",...}.
+func FormatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(labels[name])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
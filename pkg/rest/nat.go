@@ -100,3 +100,50 @@ func (l DNAT) Remove(w http.ResponseWriter, r *http.Request) {
 	}
 	ResponseJson(w, "success")
 }
+
+type StaticNAT struct {
+	call Caller
+}
+
+func (l StaticNAT) Router(r *mux.Router) {
+	r.HandleFunc("/api/staticnat", l.List).Methods("GET")
+	r.HandleFunc("/api/staticnat", l.Add).Methods("POST")
+	r.HandleFunc("/api/staticnat", l.Remove).Methods("DELETE")
+}
+
+func (l StaticNAT) List(w http.ResponseWriter, r *http.Request) {
+	if items, err := l.call.ListStaticNAT(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else {
+		ResponseJson(w, items)
+	}
+}
+
+func (l StaticNAT) Add(w http.ResponseWriter, r *http.Request) {
+	data := schema.StaticNAT{}
+	if err := GetData(r, &data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := l.call.AddStaticNAT(data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ResponseJson(w, "success")
+}
+
+func (l StaticNAT) Remove(w http.ResponseWriter, r *http.Request) {
+	data := schema.StaticNAT{}
+	if err := GetData(r, &data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := l.call.DelStaticNAT(data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ResponseJson(w, "success")
+}
@@ -0,0 +1,30 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/luscis/openvrr/pkg/schema"
+)
+
+type Trace struct {
+	call Caller
+}
+
+func (l Trace) Router(r *mux.Router) {
+	r.HandleFunc("/api/trace", l.Trace).Methods("POST")
+}
+
+func (l Trace) Trace(w http.ResponseWriter, r *http.Request) {
+	data := schema.Packet{}
+	if err := GetData(r, &data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if item, err := l.call.Trace(data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	} else {
+		ResponseJson(w, item)
+	}
+}
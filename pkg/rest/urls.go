@@ -8,4 +8,10 @@ func Add(r *mux.Router, call Caller) {
 	Forward{call: call}.Router(r)
 	SNAT{call: call}.Router(r)
 	DNAT{call: call}.Router(r)
+	StaticNAT{call: call}.Router(r)
+	VRF{call: call}.Router(r)
+	Config{call: call}.Router(r)
+	Metrics{call: call}.Router(r)
+	Trace{call: call}.Router(r)
+	Conntrack{call: call}.Router(r)
 }
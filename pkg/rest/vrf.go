@@ -0,0 +1,51 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/luscis/openvrr/pkg/schema"
+)
+
+type VRF struct {
+	call Caller
+}
+
+func (l VRF) Router(r *mux.Router) {
+	r.HandleFunc("/api/vrf", l.List).Methods("GET")
+	r.HandleFunc("/api/vrf", l.Add).Methods("POST")
+	r.HandleFunc("/api/vrf", l.Remove).Methods("DELETE")
+}
+
+func (l VRF) List(w http.ResponseWriter, r *http.Request) {
+	items, _ := l.call.ListVrf()
+	ResponseJson(w, items)
+}
+
+func (l VRF) Add(w http.ResponseWriter, r *http.Request) {
+	data := schema.VRF{}
+	if err := GetData(r, &data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := l.call.AddVrf(data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ResponseJson(w, "success")
+}
+
+func (l VRF) Remove(w http.ResponseWriter, r *http.Request) {
+	data := schema.VRF{}
+	if err := GetData(r, &data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := l.call.DelVrf(data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ResponseJson(w, "success")
+}
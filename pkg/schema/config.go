@@ -0,0 +1,17 @@
+package schema
+
+type Config struct {
+	Interfaces []Interface      `json:"interfaces,omitempty" yaml:"interfaces,omitempty"`
+	VRFs       []VRF            `json:"vrfs,omitempty" yaml:"vrfs,omitempty"`
+	SNAT       []SNAT           `json:"snat,omitempty" yaml:"snat,omitempty"`
+	DNAT       []DNAT           `json:"dnat,omitempty" yaml:"dnat,omitempty"`
+	StaticNAT  []StaticNAT      `json:"staticNat,omitempty" yaml:"staticNat,omitempty"`
+	Limits     []ConntrackLimit `json:"limits,omitempty" yaml:"limits,omitempty"`
+}
+
+// ConfigDiff is the plan to converge to a desired configuration, the
+// objects are removed before the others are added.
+type ConfigDiff struct {
+	Remove Config `json:"remove" yaml:"remove"`
+	Add    Config `json:"add" yaml:"add"`
+}
@@ -0,0 +1,28 @@
+package schema
+
+type Conntrack struct {
+	Protocol string `json:"protocol" yaml:"protocol"`
+	Source   string `json:"source" yaml:"source"`
+	Dest     string `json:"destination" yaml:"destination"`
+	SourceTo string `json:"sourceTo,omitempty" yaml:"sourceTo,omitempty"`
+	DestTo   string `json:"destinationTo,omitempty" yaml:"destinationTo,omitempty"`
+	State    string `json:"state,omitempty" yaml:"state,omitempty"`
+	Mark     uint32 `json:"mark,omitempty" yaml:"mark,omitempty"`
+}
+
+type ConntrackFilter struct {
+	Protocol string `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+	Source   string `json:"source,omitempty" yaml:"source,omitempty"`
+	Dest     string `json:"destination,omitempty" yaml:"destination,omitempty"`
+}
+
+// A ConntrackLimit limits the sessions of a Zone. A quota of a Source
+// prefix or of the prefixes of an Interface counts the sessions of all
+// their hosts together, one host can take the whole Limit.
+type ConntrackLimit struct {
+	Zone      int    `json:"zone" yaml:"zone"`
+	Source    string `json:"source,omitempty" yaml:"source,omitempty"`
+	Interface string `json:"interface,omitempty" yaml:"interface,omitempty"`
+	Limit     uint64 `json:"limit" yaml:"limit"`
+	Count     uint64 `json:"count,omitempty" yaml:"count,omitempty"`
+}
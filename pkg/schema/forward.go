@@ -1,8 +1,25 @@
 package schema
 
 type IPForward struct {
+	Table     int    `json:"table,omitempty" yaml:"table,omitempty"`
+	Type      string `json:"type,omitempty" yaml:"type,omitempty"`
 	Prefix    string `json:"prefix" yaml:"prefix"`
 	NextHop   string `json:"nexthop,omitempty" yaml:"nexthop,omitempty"`
+	NhId      uint32 `json:"nhid,omitempty" yaml:"nhid,omitempty"`
 	Interface string `json:"interface,omitempty" yaml:"interface,omitempty"`
 	LLAddr    string `json:"lladdr,omitempty" yaml:"lladdr,omitempty"`
+	State     string `json:"state,omitempty" yaml:"state,omitempty"`
+}
+
+type FlowQueue struct {
+	Depth      int    `json:"depth" yaml:"depth"`
+	Window     string `json:"window" yaml:"window"`
+	Batches    uint64 `json:"batches" yaml:"batches"`
+	Flows      uint64 `json:"flows" yaml:"flows"`
+	Coalesced  uint64 `json:"coalesced" yaml:"coalesced"`
+	Errors     uint64 `json:"errors" yaml:"errors"`
+	Failed     int    `json:"failed" yaml:"failed"`
+	LastError  string `json:"lastError,omitempty" yaml:"lastError,omitempty"`
+	Latency    string `json:"latency,omitempty" yaml:"latency,omitempty"`
+	MaxLatency string `json:"maxLatency,omitempty" yaml:"maxLatency,omitempty"`
 }
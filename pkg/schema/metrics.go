@@ -0,0 +1,15 @@
+package schema
+
+type Sample struct {
+	// Suffix of the name, such as _sum and _count of a summary.
+	Suffix string            `json:"suffix,omitempty" yaml:"suffix,omitempty"`
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Value  float64           `json:"value" yaml:"value"`
+}
+
+type Metric struct {
+	Name    string   `json:"name" yaml:"name"`
+	Help    string   `json:"help" yaml:"help"`
+	Type    string   `json:"type" yaml:"type"`
+	Samples []Sample `json:"samples" yaml:"samples"`
+}
@@ -1,12 +1,44 @@
 package schema
 
+// A SNAT rule translates its source to SourceTo, an address or a range
+// A-B of them, or masquerades it behind the address of an Interface. The
+// source ports are taken from Ports P1-P2 if given, Mode is random or
+// hash, and Persistent gives a client the same address in all sessions.
 type SNAT struct {
-	Source   string `json:"source" yaml:"source"`
-	SourceTo string `json:"sourceTo" yaml:"sourceTo"`
+	Source     string `json:"source" yaml:"source"`
+	SourceTo   string `json:"sourceTo,omitempty" yaml:"sourceTo,omitempty"`
+	Interface  string `json:"interface,omitempty" yaml:"interface,omitempty"`
+	Ports      string `json:"ports,omitempty" yaml:"ports,omitempty"`
+	Mode       string `json:"mode,omitempty" yaml:"mode,omitempty"`
+	Persistent bool   `json:"persistent,omitempty" yaml:"persistent,omitempty"`
+	Packets    uint64 `json:"packets,omitempty" yaml:"packets,omitempty"`
+	Bytes      uint64 `json:"bytes,omitempty" yaml:"bytes,omitempty"`
+}
+
+// A StaticNAT maps an External address to an Internal host one to one,
+// for all protocols and in both directions.
+type StaticNAT struct {
+	External string `json:"external" yaml:"external"`
+	Internal string `json:"internal" yaml:"internal"`
+	Packets  uint64 `json:"packets,omitempty" yaml:"packets,omitempty"`
+	Bytes    uint64 `json:"bytes,omitempty" yaml:"bytes,omitempty"`
+}
+
+// A Backend is a destination among which a DNAT rule balances the
+// sessions, State is up or down when it is health checked.
+type Backend struct {
+	Address string `json:"address" yaml:"address"`
+	Weight  int    `json:"weight,omitempty" yaml:"weight,omitempty"`
+	State   string `json:"state,omitempty" yaml:"state,omitempty"`
 }
 
 type DNAT struct {
-	Protocol string `json:"protocol" yaml:"protocol"`
-	Dest     string `json:"destination" yaml:"destination"`
-	DestTo   string `json:"destinationTo" yaml:"destinationTo"`
+	Protocol  string    `json:"protocol" yaml:"protocol"`
+	Dest      string    `json:"destination" yaml:"destination"`
+	DestTo    string    `json:"destinationTo,omitempty" yaml:"destinationTo,omitempty"`
+	Backends  []Backend `json:"backends,omitempty" yaml:"backends,omitempty"`
+	Check     string    `json:"check,omitempty" yaml:"check,omitempty"`
+	CheckPath string    `json:"checkPath,omitempty" yaml:"checkPath,omitempty"`
+	Packets   uint64    `json:"packets,omitempty" yaml:"packets,omitempty"`
+	Bytes     uint64    `json:"bytes,omitempty" yaml:"bytes,omitempty"`
 }
@@ -0,0 +1,28 @@
+package schema
+
+type Packet struct {
+	InPort   string `json:"inPort" yaml:"inPort"`
+	Vlan     int    `json:"vlan,omitempty" yaml:"vlan,omitempty"`
+	Source   string `json:"source" yaml:"source"`
+	Dest     string `json:"destination" yaml:"destination"`
+	Protocol string `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+	DestPort int    `json:"destinationPort,omitempty" yaml:"destinationPort,omitempty"`
+}
+
+type TraceStep struct {
+	Stage    string   `json:"stage" yaml:"stage"`
+	Table    int      `json:"table" yaml:"table"`
+	Priority int      `json:"priority" yaml:"priority"`
+	Match    string   `json:"match,omitempty" yaml:"match,omitempty"`
+	Owner    string   `json:"owner,omitempty" yaml:"owner,omitempty"`
+	Actions  []string `json:"actions,omitempty" yaml:"actions,omitempty"`
+}
+
+type Trace struct {
+	Packet   Packet      `json:"packet" yaml:"packet"`
+	Steps    []TraceStep `json:"steps" yaml:"steps"`
+	Route    *IPForward  `json:"route,omitempty" yaml:"route,omitempty"`
+	Neighbor *IPForward  `json:"neighbor,omitempty" yaml:"neighbor,omitempty"`
+	NAT      string      `json:"nat,omitempty" yaml:"nat,omitempty"`
+	Actions  string      `json:"datapathActions" yaml:"datapathActions"`
+}
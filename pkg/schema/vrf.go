@@ -0,0 +1,7 @@
+package schema
+
+type VRF struct {
+	Name       string   `json:"name" yaml:"name"`
+	Table      int      `json:"table,omitempty" yaml:"table,omitempty"`
+	Interfaces []string `json:"interfaces,omitempty" yaml:"interfaces,omitempty"`
+}
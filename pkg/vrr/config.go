@@ -0,0 +1,244 @@
+package vrr
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/luscis/openvrr/pkg/schema"
+	"gopkg.in/yaml.v2"
+)
+
+// A ConfigStore keeps the declarative configuration of the gateway
+// in a file, which is reapplied at startup.
+type ConfigStore struct {
+	file string
+}
+
+func (c *ConfigStore) Load() (schema.Config, error) {
+	data := schema.Config{}
+
+	contents, err := os.ReadFile(c.file)
+	if os.IsNotExist(err) {
+		return data, nil
+	} else if err != nil {
+		return data, err
+	}
+	if err := yaml.Unmarshal(contents, &data); err != nil {
+		return data, err
+	}
+	return data, nil
+}
+
+func (c *ConfigStore) Save(data schema.Config) error {
+	contents, err := yaml.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	// Replace the file at once, a crash never leaves half of it.
+	tmp := c.file + ".tmp"
+	if err := os.WriteFile(tmp, contents, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.file)
+}
+
+// portConfig returns an interface as it is compared between the running
+// and the desired configurations. The tag of a VLAN interface comes from
+// its name, and trunks are listed without spaces.
+func portConfig(port schema.Interface) schema.Interface {
+	vlanid := 0
+	if fmt.Sscanf(port.Name, "vlan%d", &vlanid); vlanid > 0 {
+		return schema.Interface{Name: port.Name}
+	}
+	port.Trunks = strings.ReplaceAll(port.Trunks, " ", "")
+	return port
+}
+
+// diffConfig returns the plan from the running configuration to the
+// desired one. A port listed for removal with a tag or trunks only
+// loses them, otherwise it leaves the bridge.
+func diffConfig(have, want schema.Config) schema.ConfigDiff {
+	diff := schema.ConfigDiff{}
+
+	ports := make(map[string]schema.Interface)
+	for _, port := range have.Interfaces {
+		ports[port.Name] = portConfig(port)
+	}
+	for _, port := range want.Interfaces {
+		port = portConfig(port)
+		old, ok := ports[port.Name]
+		delete(ports, port.Name)
+		if !ok {
+			diff.Add.Interfaces = append(diff.Add.Interfaces, port)
+			continue
+		}
+		if old.Tag == port.Tag && old.Trunks == port.Trunks {
+			continue
+		}
+		drop := schema.Interface{Name: port.Name}
+		if port.Tag == 0 {
+			drop.Tag = old.Tag
+		}
+		if port.Trunks == "" {
+			drop.Trunks = old.Trunks
+		}
+		if drop.Tag > 0 || drop.Trunks != "" {
+			diff.Remove.Interfaces = append(diff.Remove.Interfaces, drop)
+		}
+		if port.Tag > 0 || port.Trunks != "" {
+			diff.Add.Interfaces = append(diff.Add.Interfaces, port)
+		}
+	}
+	for _, port := range have.Interfaces {
+		if _, ok := ports[port.Name]; ok {
+			diff.Remove.Interfaces = append(diff.Remove.Interfaces, schema.Interface{Name: port.Name})
+		}
+	}
+
+	vrfs := make(map[string]schema.VRF)
+	for _, vrf := range have.VRFs {
+		vrfs[vrf.Name] = vrf
+	}
+	for _, vrf := range want.VRFs {
+		old, ok := vrfs[vrf.Name]
+		delete(vrfs, vrf.Name)
+		if !ok || (vrf.Table > 0 && old.Table != vrf.Table) {
+			if ok {
+				diff.Remove.VRFs = append(diff.Remove.VRFs, schema.VRF{Name: old.Name})
+			}
+			diff.Add.VRFs = append(diff.Add.VRFs, vrf)
+			continue
+		}
+		gone := schema.VRF{Name: vrf.Name}
+		for _, name := range old.Interfaces {
+			if !slices.Contains(vrf.Interfaces, name) {
+				gone.Interfaces = append(gone.Interfaces, name)
+			}
+		}
+		if len(gone.Interfaces) > 0 {
+			diff.Remove.VRFs = append(diff.Remove.VRFs, gone)
+		}
+		added := schema.VRF{Name: vrf.Name}
+		for _, name := range vrf.Interfaces {
+			if !slices.Contains(old.Interfaces, name) {
+				added.Interfaces = append(added.Interfaces, name)
+			}
+		}
+		if len(added.Interfaces) > 0 {
+			diff.Add.VRFs = append(diff.Add.VRFs, added)
+		}
+	}
+	for _, vrf := range have.VRFs {
+		if _, ok := vrfs[vrf.Name]; ok {
+			diff.Remove.VRFs = append(diff.Remove.VRFs, schema.VRF{Name: vrf.Name})
+		}
+	}
+
+	snats := make(map[string]schema.SNAT)
+	for _, snat := range have.SNAT {
+		snats[snat.Source] = snat
+	}
+	for _, snat := range want.SNAT {
+		old, ok := snats[snat.Source]
+		delete(snats, snat.Source)
+		if ok && old == snat {
+			continue
+		}
+		if ok {
+			diff.Remove.SNAT = append(diff.Remove.SNAT, old)
+		}
+		diff.Add.SNAT = append(diff.Add.SNAT, snat)
+	}
+	for _, snat := range have.SNAT {
+		if _, ok := snats[snat.Source]; ok {
+			diff.Remove.SNAT = append(diff.Remove.SNAT, snat)
+		}
+	}
+
+	dnats := make(map[string]schema.DNAT)
+	for _, dnat := range have.DNAT {
+		dnats[dnat.Protocol+dnat.Dest] = dnat
+	}
+	for _, dnat := range want.DNAT {
+		key := dnat.Protocol + dnat.Dest
+		old, ok := dnats[key]
+		delete(dnats, key)
+		if ok && reflect.DeepEqual(old, dnat) {
+			continue
+		}
+		if ok {
+			diff.Remove.DNAT = append(diff.Remove.DNAT, old)
+		}
+		diff.Add.DNAT = append(diff.Add.DNAT, dnat)
+	}
+	for _, dnat := range have.DNAT {
+		if _, ok := dnats[dnat.Protocol+dnat.Dest]; ok {
+			diff.Remove.DNAT = append(diff.Remove.DNAT, dnat)
+		}
+	}
+
+	statics := make(map[string]schema.StaticNAT)
+	for _, static := range have.StaticNAT {
+		statics[static.External] = static
+	}
+	for _, static := range want.StaticNAT {
+		old, ok := statics[static.External]
+		delete(statics, static.External)
+		if ok && old == static {
+			continue
+		}
+		if ok {
+			diff.Remove.StaticNAT = append(diff.Remove.StaticNAT, old)
+		}
+		diff.Add.StaticNAT = append(diff.Add.StaticNAT, static)
+	}
+	for _, static := range have.StaticNAT {
+		if _, ok := statics[static.External]; ok {
+			diff.Remove.StaticNAT = append(diff.Remove.StaticNAT, static)
+		}
+	}
+
+	limits := make(map[string]schema.ConntrackLimit)
+	for _, limit := range have.Limits {
+		limits[limitName(limit)] = limit
+	}
+	for _, limit := range want.Limits {
+		name := limitName(limit)
+		old, ok := limits[name]
+		delete(limits, name)
+		// A quota without a zone keeps the one it was given.
+		if ok && isQuota(limit) && limit.Zone == 0 {
+			limit.Zone = old.Zone
+		}
+		if ok && old == limit {
+			continue
+		}
+		if ok {
+			diff.Remove.Limits = append(diff.Remove.Limits, old)
+		}
+		diff.Add.Limits = append(diff.Add.Limits, limit)
+	}
+	for _, limit := range have.Limits {
+		if _, ok := limits[limitName(limit)]; ok {
+			diff.Remove.Limits = append(diff.Remove.Limits, limit)
+		}
+	}
+
+	return diff
+}
+
+// limitName identifies a quota by its source or interface, and other
+// limits by their zone.
+func limitName(limit schema.ConntrackLimit) string {
+	switch {
+	case limit.Source != "":
+		return "source " + limit.Source
+	case limit.Interface != "":
+		return "interface " + limit.Interface
+	}
+	return fmt.Sprintf("zone %d", limit.Zone)
+}
@@ -0,0 +1,167 @@
+package vrr
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/luscis/openvrr/pkg/schema"
+)
+
+func TestDiffConfig(t *testing.T) {
+	tests := []struct {
+		desc string
+		have schema.Config
+		want schema.Config
+		diff schema.ConfigDiff
+	}{
+		{
+			desc: "same",
+			have: schema.Config{
+				Interfaces: []schema.Interface{{Name: "eth1", Tag: 10}, {Name: "vlan10"}},
+				VRFs:       []schema.VRF{{Name: "red", Table: 100, Interfaces: []string{"vlan10"}}},
+				SNAT:       []schema.SNAT{{Source: "192.168.1.0/24", SourceTo: "10.0.0.1"}},
+			},
+			want: schema.Config{
+				Interfaces: []schema.Interface{{Name: "eth1", Tag: 10}, {Name: "vlan10"}},
+				VRFs:       []schema.VRF{{Name: "red", Table: 100, Interfaces: []string{"vlan10"}}},
+				SNAT:       []schema.SNAT{{Source: "192.168.1.0/24", SourceTo: "10.0.0.1"}},
+			},
+		},
+		{
+			desc: "tag of a VLAN interface",
+			have: schema.Config{
+				Interfaces: []schema.Interface{{Name: "vlan10"}},
+			},
+			want: schema.Config{
+				Interfaces: []schema.Interface{{Name: "vlan10", Tag: 10}},
+			},
+		},
+		{
+			desc: "spaces of trunks",
+			have: schema.Config{
+				Interfaces: []schema.Interface{{Name: "eth2", Trunks: "10,20"}},
+			},
+			want: schema.Config{
+				Interfaces: []schema.Interface{{Name: "eth2", Trunks: "10, 20"}},
+			},
+		},
+		{
+			desc: "interfaces",
+			have: schema.Config{
+				Interfaces: []schema.Interface{{Name: "eth1", Tag: 10}, {Name: "eth2", Trunks: "10,20"}, {Name: "vlan20"}},
+			},
+			want: schema.Config{
+				Interfaces: []schema.Interface{{Name: "eth1", Tag: 11}, {Name: "eth2"}, {Name: "vlan30"}},
+			},
+			diff: schema.ConfigDiff{
+				Add: schema.Config{
+					Interfaces: []schema.Interface{{Name: "eth1", Tag: 11}, {Name: "vlan30"}},
+				},
+				Remove: schema.Config{
+					Interfaces: []schema.Interface{{Name: "eth2", Trunks: "10,20"}, {Name: "vlan20"}},
+				},
+			},
+		},
+		{
+			desc: "vrfs",
+			have: schema.Config{
+				VRFs: []schema.VRF{
+					{Name: "red", Table: 100, Interfaces: []string{"vlan10", "vlan11"}},
+					{Name: "blue", Table: 200},
+					{Name: "green", Table: 300},
+				},
+			},
+			want: schema.Config{
+				VRFs: []schema.VRF{
+					{Name: "red", Interfaces: []string{"vlan10", "vlan12"}},
+					{Name: "blue", Table: 201},
+				},
+			},
+			diff: schema.ConfigDiff{
+				Add: schema.Config{
+					VRFs: []schema.VRF{
+						{Name: "red", Interfaces: []string{"vlan12"}},
+						{Name: "blue", Table: 201},
+					},
+				},
+				Remove: schema.Config{
+					VRFs: []schema.VRF{
+						{Name: "red", Interfaces: []string{"vlan11"}},
+						{Name: "blue"},
+						{Name: "green"},
+					},
+				},
+			},
+		},
+		{
+			desc: "nat",
+			have: schema.Config{
+				SNAT: []schema.SNAT{
+					{Source: "192.168.1.0/24", SourceTo: "10.0.0.1"},
+					{Source: "192.168.2.0/24", Interface: "vlan10"},
+				},
+				DNAT: []schema.DNAT{
+					{Protocol: "tcp", Dest: "10.0.0.1:80", DestTo: "192.168.1.10"},
+					{Protocol: "udp", Dest: "10.0.0.1:53", DestTo: "192.168.1.11"},
+				},
+				StaticNAT: []schema.StaticNAT{{External: "10.0.0.5", Internal: "192.168.1.5"}},
+			},
+			want: schema.Config{
+				SNAT: []schema.SNAT{
+					{Source: "192.168.1.0/24", SourceTo: "10.0.0.2"},
+					{Source: "192.168.2.0/24", Interface: "vlan10"},
+				},
+				DNAT: []schema.DNAT{
+					{Protocol: "tcp", Dest: "10.0.0.1:80", Backends: []schema.Backend{{Address: "192.168.1.10:80"}}},
+				},
+				StaticNAT: []schema.StaticNAT{{External: "10.0.0.5", Internal: "192.168.1.5"}},
+			},
+			diff: schema.ConfigDiff{
+				Add: schema.Config{
+					SNAT: []schema.SNAT{{Source: "192.168.1.0/24", SourceTo: "10.0.0.2"}},
+					DNAT: []schema.DNAT{
+						{Protocol: "tcp", Dest: "10.0.0.1:80", Backends: []schema.Backend{{Address: "192.168.1.10:80"}}},
+					},
+				},
+				Remove: schema.Config{
+					SNAT: []schema.SNAT{{Source: "192.168.1.0/24", SourceTo: "10.0.0.1"}},
+					DNAT: []schema.DNAT{
+						{Protocol: "tcp", Dest: "10.0.0.1:80", DestTo: "192.168.1.10"},
+						{Protocol: "udp", Dest: "10.0.0.1:53", DestTo: "192.168.1.11"},
+					},
+				},
+			},
+		},
+		{
+			desc: "limits",
+			have: schema.Config{
+				Limits: []schema.ConntrackLimit{
+					{Source: "192.168.1.0/24", Zone: 1001, Limit: 100},
+					{Zone: 20, Limit: 1000},
+				},
+			},
+			want: schema.Config{
+				Limits: []schema.ConntrackLimit{
+					{Source: "192.168.1.0/24", Limit: 100},
+					{Zone: 20, Limit: 2000},
+				},
+			},
+			diff: schema.ConfigDiff{
+				Add: schema.Config{
+					Limits: []schema.ConntrackLimit{{Zone: 20, Limit: 2000}},
+				},
+				Remove: schema.Config{
+					Limits: []schema.ConntrackLimit{{Zone: 20, Limit: 1000}},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if want, got := tt.diff, diffConfig(tt.have, tt.want); !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected diff:\n- want: %+v\n-  got: %+v", want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package vrr
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+
+	"github.com/luscis/openvrr/pkg/ovs"
+	"github.com/luscis/openvrr/pkg/schema"
+)
+
+// addrFilter matches an address to the IP or prefix of a filter, an
+// empty filter matches all.
+type addrFilter string
+
+func (f addrFilter) Valid() bool {
+	if f == "" || net.ParseIP(string(f)) != nil {
+		return true
+	}
+	_, _, err := net.ParseCIDR(string(f))
+	return err == nil
+}
+
+func (f addrFilter) Match(addr string) bool {
+	if f == "" {
+		return true
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	if _, ipnet, err := net.ParseCIDR(string(f)); err == nil {
+		return ipnet.Contains(ip)
+	}
+	return ip.Equal(net.ParseIP(string(f)))
+}
+
+// findConntrack returns the connections of the NAT zone matching a
+// filter on the protocol and the original addresses.
+func (a *Composer) findConntrack(filter schema.ConntrackFilter) ([]*ovs.ConntrackEntry, error) {
+	source, dest := addrFilter(filter.Source), addrFilter(filter.Dest)
+	if !source.Valid() || !dest.Valid() {
+		return nil, fmt.Errorf("invalid source %q or destination %q", filter.Source, filter.Dest)
+	}
+
+	entries, err := a.client.App.DumpConntrack(ZoneNat)
+	if err != nil {
+		log.Printf("Composer.findConntrack: %v", err)
+		return nil, err
+	}
+
+	var items []*ovs.ConntrackEntry
+	for _, entry := range entries {
+		if filter.Protocol != "" && filter.Protocol != entry.Protocol {
+			continue
+		}
+		if !source.Match(entry.Original.Source) || !dest.Match(entry.Original.Destination) {
+			continue
+		}
+		items = append(items, entry)
+	}
+	return items, nil
+}
+
+// FlushConntrack deletes the connections of the NAT zone matching a
+// filter, or all of them without one.
+func (a *Composer) FlushConntrack(filter schema.ConntrackFilter) ([]*ovs.ConntrackEntry, error) {
+	entries, err := a.findConntrack(filter)
+	if err != nil {
+		return nil, err
+	}
+	if filter == (schema.ConntrackFilter{}) {
+		return entries, a.client.App.FlushConntrack(ZoneNat, nil)
+	}
+
+	var items []*ovs.ConntrackEntry
+	for _, entry := range entries {
+		if err := a.client.App.FlushConntrack(ZoneNat, entry); err != nil {
+			// The connection may have expired meanwhile.
+			log.Printf("Composer.FlushConntrack: %v", err)
+			continue
+		}
+		items = append(items, entry)
+	}
+	return items, nil
+}
+
+// endpoint formats the address and port of one side of a connection.
+func endpoint(protocol, addr string, port int) string {
+	switch protocol {
+	case "icmp", "icmp6":
+		return addr
+	}
+	return net.JoinHostPort(addr, strconv.Itoa(port))
+}
+
+// toConntrack returns a connection with the addresses it is translated
+// to, which differ from the original ones in the reply.
+func toConntrack(entry *ovs.ConntrackEntry) schema.Conntrack {
+	orig, reply := entry.Original, entry.Reply
+	item := schema.Conntrack{
+		Protocol: entry.Protocol,
+		Source:   endpoint(entry.Protocol, orig.Source, orig.SourcePort),
+		Dest:     endpoint(entry.Protocol, orig.Destination, orig.DestinationPort),
+		State:    entry.State,
+		Mark:     entry.Mark,
+	}
+	if to := endpoint(entry.Protocol, reply.Destination, reply.DestinationPort); to != item.Source {
+		item.SourceTo = to
+	}
+	if to := endpoint(entry.Protocol, reply.Source, reply.SourcePort); to != item.Dest {
+		item.DestTo = to
+	}
+	return item
+}
+
+func (v *Gateway) ListConntrack(filter schema.ConntrackFilter) ([]schema.Conntrack, error) {
+	entries, err := v.scomo.findConntrack(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]schema.Conntrack, 0, len(entries))
+	for _, entry := range entries {
+		items = append(items, toConntrack(entry))
+	}
+	return items, nil
+}
+
+// FlushConntrack deletes the connections matching a filter, so those of
+// a changed NAT rule are translated again, and returns them.
+func (v *Gateway) FlushConntrack(filter schema.ConntrackFilter) ([]schema.Conntrack, error) {
+	entries, err := v.scomo.FlushConntrack(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]schema.Conntrack, 0, len(entries))
+	for _, entry := range entries {
+		items = append(items, toConntrack(entry))
+	}
+	return items, nil
+}
@@ -0,0 +1,109 @@
+package vrr
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"github.com/luscis/openvrr/pkg/ovs"
+)
+
+// The cookie of a flow owned by an object holds the kind of the object
+// in its upper bits and the id of the object in the others, so all flows
+// of one object are counted and deleted by their cookie. Flows of the
+// pipeline itself have a kind of zero.
+const (
+	CookieKindShift = 48
+	CookieIdMask    = 1<<CookieKindShift - 1
+	CookieKindMask  = ^uint64(CookieIdMask)
+	CookieFullMask  = ^uint64(0)
+)
+
+// Kinds of the objects owning flows.
+const (
+	KindRoute  = 0x1
+	KindHost   = 0x2
+	KindLocal  = 0x3
+	KindSNAT   = 0x4
+	KindDNAT   = 0x5
+	KindQuota  = 0x6
+	KindStatic = 0x7
+)
+
+// Cookies allocates a cookie to each object. The id is a hash of the key
+// of the object, so a restarted daemon gives the same cookies to the
+// flows it reconciles, and the next free one on a collision.
+type Cookies struct {
+	ids  map[string]uint64
+	used map[uint64]string
+}
+
+func (c *Cookies) key(kind uint64, key string) string {
+	return fmt.Sprintf("%x-%s", kind, key)
+}
+
+// Get returns the cookie of an object, which is allocated on first use.
+func (c *Cookies) Get(kind uint64, key string) uint64 {
+	if c.ids == nil {
+		c.ids = make(map[string]uint64)
+		c.used = make(map[uint64]string)
+	}
+	name := c.key(kind, key)
+	if cookie, ok := c.ids[name]; ok {
+		return cookie
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	id := h.Sum64() & CookieIdMask
+	for {
+		if id == 0 {
+			id = 1
+		}
+		cookie := kind<<CookieKindShift | id
+		if _, ok := c.used[cookie]; !ok {
+			c.ids[name] = cookie
+			c.used[cookie] = key
+			return cookie
+		}
+		id = (id + 1) & CookieIdMask
+	}
+}
+
+// Find returns the cookie of an object, if it has one.
+func (c *Cookies) Find(kind uint64, key string) (uint64, bool) {
+	cookie, ok := c.ids[c.key(kind, key)]
+	return cookie, ok
+}
+
+// Release frees the cookie of an object, once its flows are deleted.
+func (c *Cookies) Release(kind uint64, key string) {
+	name := c.key(kind, key)
+	if cookie, ok := c.ids[name]; ok {
+		delete(c.used, cookie)
+		delete(c.ids, name)
+	}
+}
+
+// Owner returns the kind of the object owning a flow and its key.
+func (c *Cookies) Owner(cookie uint64) (uint64, string) {
+	return cookie >> CookieKindShift, c.used[cookie]
+}
+
+// cookieMatch matches all flows of an object in any table.
+func cookieMatch(cookie uint64) *ovs.MatchFlow {
+	return &ovs.MatchFlow{
+		Cookie:     cookie,
+		CookieMask: CookieFullMask,
+		Table:      ovs.AnyTable,
+	}
+}
+
+// ownerStats returns the packets and bytes through the flows of an
+// object.
+func (a *Composer) ownerStats(kind uint64, key string) (*ovs.FlowStats, error) {
+	cookie, ok := a.cookies.Find(kind, key)
+	if !ok {
+		return &ovs.FlowStats{}, nil
+	}
+	return a.ofctl.DumpAggregate(a.brname, cookieMatch(cookie))
+}
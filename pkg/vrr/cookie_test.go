@@ -0,0 +1,136 @@
+package vrr
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/luscis/openvrr/pkg/schema"
+)
+
+func TestComposerCookies(t *testing.T) {
+	tests := []struct {
+		desc string
+		add  func(a *Composer) error
+		kind uint64
+		key  string
+		want []string
+	}{
+		{
+			desc: "route",
+			add: func(a *Composer) error {
+				return a.AddRoute(0, "10.1.0.0/16", "192.168.1.254", "vlan10")
+			},
+			kind: KindRoute,
+			key:  "0-10.1.0.0/16-00:00:00:00:20:15",
+			want: []string{
+				"priority=116,ip,metadata=0x0,nw_dst=10.1.0.0/16,dl_dst=00:00:00:00:20:15,table=19,idle_timeout=0,actions=load:0xc0a801fe->reg0,resubmit(,20)",
+			},
+		},
+		{
+			desc: "host",
+			add: func(a *Composer) error {
+				return a.AddHost(0, "192.168.1.10", "00:11:22:33:44:55", "vlan10")
+			},
+			kind: KindHost,
+			key:  "0-192.168.1.10-vlan10",
+			want: []string{
+				"priority=100,ip,metadata=0x0,reg0=0xc0a8010a,dl_dst=00:00:00:00:20:15,table=20,idle_timeout=0,actions=push:NXM_OF_ETH_DST,pop:NXM_OF_ETH_SRC,load:0x001122334455->NXM_OF_ETH_DST,load:0xa->NXM_OF_VLAN_TCI,load:0x800a->NXM_OF_IN_PORT,dec_ttl,resubmit(,30)",
+			},
+		},
+		{
+			desc: "local",
+			add: func(a *Composer) error {
+				return a.AddLocal(0, "192.168.1.1/24")
+			},
+			kind: KindLocal,
+			key:  "0-192.168.1.1",
+			want: []string{
+				"priority=150,ip,nw_dst=192.168.1.1,table=12,idle_timeout=0,actions=resubmit(,19)",
+				"priority=310,ip,metadata=0x0,nw_dst=192.168.1.1,table=19,idle_timeout=0,actions=resubmit(,30)",
+			},
+		},
+		{
+			desc: "snat",
+			add: func(a *Composer) error {
+				return a.AddSNAT(schema.SNAT{Source: "192.168.2.0/24", SourceTo: "10.0.0.1"})
+			},
+			kind: KindSNAT,
+			key:  "snat-192.168.2.0-24",
+			want: []string{
+				"priority=50,ip,ct_state=+trk+new,nw_src=192.168.2.0/24,table=12,idle_timeout=0,actions=ct(commit,nat(src=10.0.0.1),zone=10,table=15)",
+			},
+		},
+		{
+			desc: "dnat",
+			add: func(a *Composer) error {
+				return a.AddDNAT(schema.DNAT{Protocol: "udp", Dest: "10.0.0.1:53", DestTo: "192.168.1.10"})
+			},
+			kind: KindDNAT,
+			key:  "dnat-udp-10.0.0.1-53",
+			want: []string{
+				"priority=160,udp,ct_state=+trk+new,nw_dst=10.0.0.1,tp_dst=53,table=12,idle_timeout=0,actions=ct(commit,nat(dst=192.168.1.10),zone=10,table=15)",
+				"priority=162,udp,ct_state=+trk+new,nw_dst=10.0.0.1,nw_src=192.168.1.10,tp_dst=53,table=12,idle_timeout=0,actions=ct(commit,nat(dst=192.168.1.10),zone=10),resubmit(,12)",
+				"priority=202,udp,ct_state=+trk+est,nw_dst=192.168.1.10,nw_src=192.168.1.10,tp_dst=53,table=12,idle_timeout=0,actions=ct_clear,resubmit(,10)",
+				"priority=202,udp,ct_state=+trk+rpl,nw_dst=192.168.1.10,nw_src=192.168.1.10,tp_src=53,table=12,idle_timeout=0,actions=ct_clear,resubmit(,10)",
+			},
+		},
+		{
+			desc: "quota",
+			add: func(a *Composer) error {
+				_, err := a.SetLimit(schema.ConntrackLimit{Zone: 1, Source: "192.168.2.0/24", Limit: 10000})
+				return err
+			},
+			kind: KindQuota,
+			key:  "ctlimit-1",
+			want: []string{
+				"priority=36864,ip,reg2=0x0/0x1,ct_state=+trk+rpl,nw_dst=192.168.2.0/24,table=15,idle_timeout=0,actions=load:0x1->NXM_NX_REG2[0],ct(zone=1,table=15)",
+				"priority=36864,ip,reg2=0x0/0x1,nw_src=192.168.2.0/24,table=10,idle_timeout=0,actions=load:0x1->NXM_NX_REG2[0],ct(commit,zone=1,table=10)",
+			},
+		},
+		{
+			desc: "static nat",
+			add: func(a *Composer) error {
+				return a.AddStaticNAT(schema.StaticNAT{External: "10.0.0.2", Internal: "192.168.1.11"})
+			},
+			kind: KindStatic,
+			key:  "staticnat-10.0.0.2",
+			want: []string{
+				"priority=155,ip,ct_state=+trk+new,nw_dst=10.0.0.2,table=12,idle_timeout=0,actions=ct(commit,nat(dst=192.168.1.11),zone=10,table=15)",
+				"priority=162,ip,ct_state=+trk+new,nw_dst=10.0.0.2,nw_src=192.168.1.11,table=12,idle_timeout=0,actions=ct(commit,nat(dst=192.168.1.11),zone=10),resubmit(,12)",
+				"priority=202,ip,ct_state=+trk+est,nw_dst=192.168.1.11,nw_src=192.168.1.11,table=12,idle_timeout=0,actions=ct_clear,resubmit(,10)",
+				"priority=202,ip,ct_state=+trk+rpl,nw_dst=192.168.1.11,nw_src=192.168.1.11,table=12,idle_timeout=0,actions=ct_clear,resubmit(,10)",
+				"priority=60,ip,ct_state=+trk+new,nw_src=192.168.1.11,table=12,idle_timeout=0,actions=ct(commit,nat(src=10.0.0.2),zone=10,table=15)",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			a := newTestComposer(t)
+			if want, got := tt.want, addedFlows(t, a, tt.add); !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected flows:\n- want: %v\n-  got: %v", want, got)
+			}
+
+			// All flows of the object have its cookie, and go with it.
+			cookies := make(map[uint64]bool)
+			for _, flow := range a.flows {
+				cookies[flow.Cookie] = true
+			}
+			if want, got := 1, len(cookies); want != got {
+				t.Fatalf("unexpected cookies:\n- want: %v\n-  got: %v", want, cookies)
+			}
+			for cookie := range cookies {
+				kind, key := a.cookies.Owner(cookie)
+				if tt.kind != kind || tt.key != key {
+					t.Fatalf("unexpected owner:\n- want: %x %s\n-  got: %x %s", tt.kind, tt.key, kind, key)
+				}
+				if err := a.withdraw(cookie); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			if want, got := 0, len(a.flows); want != got {
+				t.Fatalf("unexpected flows:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
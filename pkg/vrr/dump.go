@@ -0,0 +1,182 @@
+package vrr
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/luscis/openvrr/pkg/ovs"
+)
+
+// The flows in place are compared with the ones wanted in the form both
+// take once written back: ovs-ofctl orders the matches, the ct arguments
+// and the state flags its own way, prints numbers another way and leaves
+// out the default values. A form still different only replaces the flows
+// of the cookie.
+
+// flowSig returns the cookie and the compared form of a flow wanted.
+func flowSig(flow *ovs.Flow) (uint64, string, error) {
+	b, err := flow.MarshalText()
+	if err != nil {
+		return 0, "", err
+	}
+	return textSig(string(b))
+}
+
+// dumpSig returns the cookie and the compared form of a line of
+// 'ovs-ofctl dump-flows', as
+// " cookie=0x2021, duration=9.5s, table=0, n_packets=0, n_bytes=0, priority=100,ip actions=resubmit(,10)".
+func dumpSig(line string) (uint64, string, error) {
+	text := strings.ReplaceAll(strings.TrimSpace(line), ", ", ",")
+	return textSig(strings.Replace(text, " actions=", ",actions=", 1))
+}
+
+func textSig(text string) (uint64, string, error) {
+	head, actions, found := strings.Cut(text, ",actions=")
+	if !found {
+		return 0, "", fmt.Errorf("no actions in %q", text)
+	}
+
+	var cookie uint64
+	var fields []string
+	for _, field := range strings.Split(head, ",") {
+		key, value, found := strings.Cut(field, "=")
+		switch key {
+		case "duration", "n_packets", "n_bytes", "idle_age", "hard_age":
+			continue
+		case "cookie":
+			n, err := strconv.ParseUint(value, 0, 64)
+			if err != nil {
+				return 0, "", fmt.Errorf("cookie of %q: %v", text, err)
+			}
+			cookie = n
+			continue
+		case "table", "idle_timeout", "hard_timeout":
+			if value == "0" {
+				continue
+			}
+		case "priority":
+			if value == "32768" {
+				continue
+			}
+		case "ct_state":
+			value = sigFlags(value)
+		default:
+			value = sigValue(value)
+		}
+		if found {
+			field = key + "=" + value
+		}
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	acts := splitTop(actions)
+	for i, act := range acts {
+		acts[i] = sigAction(act)
+	}
+	return cookie, strings.Join(fields, ",") + ",actions=" + strings.Join(acts, ","), nil
+}
+
+// sigValue prints the numbers of a value, masked or not, in hex.
+func sigValue(value string) string {
+	parts := strings.Split(value, "/")
+	for i, part := range parts {
+		if n, err := strconv.ParseUint(part, 0, 64); err == nil {
+			parts[i] = "0x" + strconv.FormatUint(n, 16)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// sigFlags sorts the flags of a ct_state, as "+trk+new".
+func sigFlags(value string) string {
+	var flags []string
+	for i := 0; i < len(value); {
+		j := i + 1
+		for j < len(value) && value[j] != '+' && value[j] != '-' {
+			j++
+		}
+		flags = append(flags, value[i:j])
+		i = j
+	}
+	sort.Strings(flags)
+	return strings.Join(flags, "")
+}
+
+// sigAction prints an action the way ovs-ofctl does from OpenFlow 1.3.
+func sigAction(act string) string {
+	switch {
+	case act == "strip_vlan":
+		return "pop_vlan"
+	case strings.HasPrefix(act, "ct(") && strings.HasSuffix(act, ")"):
+		args := splitTop(act[len("ct(") : len(act)-1])
+		sort.Strings(args)
+		return "ct(" + strings.Join(args, ",") + ")"
+	case strings.HasPrefix(act, "load:"):
+		if field, ok := loadField(act); ok {
+			return field
+		}
+	case strings.HasPrefix(act, "set_field:"):
+		value, field, found := strings.Cut(act[len("set_field:"):], "->")
+		if found {
+			return "set_field:" + sigValue(value) + "->" + field
+		}
+	}
+	return act
+}
+
+// loadField returns a load to a register, as "load:0x1->NXM_NX_REG2[0]",
+// as the set_field ovs-ofctl prints for it, "set_field:0x1/0x1->reg2".
+func loadField(act string) (string, bool) {
+	value, dst, found := strings.Cut(act[len("load:"):], "->")
+	if !found || !strings.HasPrefix(dst, "NXM_NX_REG") || !strings.HasSuffix(dst, "]") {
+		return "", false
+	}
+	reg, bits, found := strings.Cut(dst[len("NXM_NX_REG"):len(dst)-1], "[")
+	if !found {
+		return "", false
+	}
+	n, err := strconv.ParseUint(value, 0, 32)
+	if err != nil {
+		return "", false
+	}
+	if bits == "" {
+		return "set_field:" + sigValue(value) + "->reg" + reg, true
+	}
+	first, last, found := strings.Cut(bits, "..")
+	if !found {
+		last = first
+	}
+	lo, err := strconv.Atoi(first)
+	if err != nil {
+		return "", false
+	}
+	hi, err := strconv.Atoi(last)
+	if err != nil || hi < lo || hi > 31 {
+		return "", false
+	}
+	mask := (uint64(1)<<(hi-lo+1) - 1) << lo
+	return fmt.Sprintf("set_field:0x%x/0x%x->reg%s", n<<lo, mask, reg), true
+}
+
+// splitTop splits a list at the commas out of parentheses.
+func splitTop(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
@@ -1,34 +1,43 @@
 package vrr
 
 import (
+	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/signal"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/luscis/openvrr/pkg/schema"
 	"github.com/vishvananda/netlink"
 	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
 )
 
 type IPForwards map[string]schema.IPForward
 
 func (f IPForwards) Add(value schema.IPForward) {
-	f[value.Prefix] = value
+	f[routeKey(value.Table, IPPrefix(value.Prefix))] = value
 }
 
-func (f IPForwards) Remove(prefix string) {
-	delete(f, prefix)
+func (f IPForwards) Remove(vrf int, prefix string) {
+	delete(f, routeKey(vrf, IPPrefix(prefix)))
 }
 
 type Gateway struct {
 	kernel    *KernelRegister
 	scomo     *Composer
 	http      *Http
+	config    *ConfigStore
 	forward   IPForwards
 	linkAttrs map[int]*netlink.LinkAttrs
+	neighbors map[string]bool
+	nexthops  map[uint32]Nexthop
+	paths     map[uint32][]NextHop
 	mutex     sync.RWMutex
 	ns        netns.NsHandle
 }
@@ -36,12 +45,23 @@ type Gateway struct {
 const (
 	vrname     = "vrr"
 	tokenFile  = "/etc/openvrr/token"
+	configFile = "/etc/openvrr/config.yaml"
 	httpListen = "127.0.0.1:10001"
+	// Interval of the checks of the vswitchd.
+	watchInterval = 5 * time.Second
+	// Longest wait before a failed replay is tried again.
+	watchBackoff = time.Minute
 )
 
+// StateIncomplete marks a route whose next-hop is not resolved yet.
+const StateIncomplete = "incomplete"
+
 func (v *Gateway) Init() {
 	v.forward = make(map[string]schema.IPForward)
 	v.linkAttrs = make(map[int]*netlink.LinkAttrs)
+	v.neighbors = make(map[string]bool)
+	v.nexthops = make(map[uint32]Nexthop)
+	v.paths = make(map[uint32][]NextHop)
 
 	ns, err := netns.GetFromName(vrname)
 	if err != nil {
@@ -51,8 +71,9 @@ func (v *Gateway) Init() {
 	}
 
 	v.scomo = &Composer{
-		brname: vrname,
-		ns:     v.ns,
+		brname:   vrname,
+		ns:       v.ns,
+		OnHealth: v.OnHealth,
 	}
 	v.scomo.Init()
 
@@ -61,9 +82,15 @@ func (v *Gateway) Init() {
 		OnAddress:  v.OnAddress,
 		OnRoute:    v.OnRoute,
 		OnNeighbor: v.OnNeighbor,
+		OnRule:     v.OnRule,
+		OnNexthop:  v.OnNexthop,
 	}
 	v.kernel.Init()
 
+	v.config = &ConfigStore{
+		file: configFile,
+	}
+
 	v.http = &Http{
 		listen:    httpListen,
 		adminFile: tokenFile,
@@ -73,9 +100,48 @@ func (v *Gateway) Init() {
 }
 
 func (v *Gateway) Start() {
+	v.syncVrf()
+	// The interfaces are in place before the routes on them are read.
+	v.restore()
 	v.kernel.Start()
 	v.scomo.Start()
+	v.mutex.Lock()
+	err := v.scomo.Reconcile()
+	v.mutex.Unlock()
 	v.http.Start()
+	go v.watch(err != nil)
+}
+
+// watch replays the pipeline to a restarted vswitchd, which lost all
+// flows, and adds again the ports lost with the ovsdb. A failed replay,
+// or reconcile at start, is tried again, later each time, until it
+// succeeds.
+func (v *Gateway) watch(failed bool) {
+	wait := watchInterval
+	for {
+		time.Sleep(wait)
+
+		var err error
+		v.mutex.Lock()
+		lost := failed || !v.scomo.Alive()
+		if lost {
+			log.Printf("Gateway.watch: flows lost, replay them")
+			err = v.scomo.Replay()
+		}
+		v.mutex.Unlock()
+
+		if err != nil {
+			log.Printf("Gateway.watch: replay %v", err)
+			failed = true
+			wait = min(2*wait, watchBackoff)
+			continue
+		}
+		failed = false
+		wait = watchInterval
+		if lost {
+			v.restore()
+		}
+	}
 }
 
 func (v *Gateway) Wait() {
@@ -90,6 +156,10 @@ func (v *Gateway) AddVlan(data schema.Interface) error {
 	v.mutex.Lock()
 	defer v.mutex.Unlock()
 
+	return v.addVlan(data)
+}
+
+func (v *Gateway) addVlan(data schema.Interface) error {
 	if data.Tag > 0 {
 		if err := v.scomo.addVlanTag(data.Name, data.Tag); err != nil {
 			return err
@@ -107,6 +177,10 @@ func (v *Gateway) DelVlan(data schema.Interface) error {
 	v.mutex.Lock()
 	defer v.mutex.Unlock()
 
+	return v.delVlan(data)
+}
+
+func (v *Gateway) delVlan(data schema.Interface) error {
 	if data.Tag == 4095 {
 		if err := v.scomo.delVlanTag(data.Name); err != nil {
 			return err
@@ -139,6 +213,10 @@ func (v *Gateway) ListInterface() ([]schema.Interface, error) {
 	v.mutex.RLock()
 	defer v.mutex.RUnlock()
 
+	return v.listInterface()
+}
+
+func (v *Gateway) listInterface() ([]schema.Interface, error) {
 	ports, err := v.scomo.listPorts()
 	if err != nil {
 		return nil, err
@@ -161,10 +239,6 @@ func (v *Gateway) OnNeighbor(update uint16, host netlink.Neigh) error {
 	v.mutex.Lock()
 	defer v.mutex.Unlock()
 
-	if host.Family == netlink.FAMILY_V6 {
-		return nil
-	}
-
 	log.Printf("Gateway.OnNeighbor: Type=%d, Host=%+v", update, host)
 
 	attr := v.findLinkAttr(host.LinkIndex)
@@ -173,24 +247,39 @@ func (v *Gateway) OnNeighbor(update uint16, host netlink.Neigh) error {
 	}
 
 	port := attr.Name
+	vrf := v.findVrf(attr)
 	ipdst := host.IP.String()
 	ethdst := host.HardwareAddr.String()
+	if update != UpdateNeighDel && ethdst == "" {
+		// Resolution failed or is still going on, so the next-hop
+		// is left to the glean path.
+		update = UpdateNeighDel
+	}
+	if host.State&(netlink.NUD_FAILED|netlink.NUD_INCOMPLETE) != 0 {
+		update = UpdateNeighDel
+	}
 	switch update {
 	case UpdateNeighNew, UpdateNeighAdd:
-		if ethdst == "" || host.IP.IsMulticast() {
+		if host.IP.IsMulticast() {
 			return nil
 		}
 
-		v.scomo.AddHost(IPAddr(ipdst), HWAddr(ethdst), port)
+		v.neighbors[port+"-"+ipdst] = true
+		v.scomo.AddHost(vrf, IPAddr(ipdst), HWAddr(ethdst), port)
 		v.forward.Add(schema.IPForward{
+			Table:     vrf,
 			Prefix:    ipdst,
 			NextHop:   ipdst,
 			LLAddr:    ethdst,
 			Interface: port,
 		})
 	case UpdateNeighDel:
-		v.scomo.DelHost(IPAddr(ipdst), port)
-		v.forward.Remove(ipdst)
+		if !v.neighbors[port+"-"+ipdst] {
+			return nil
+		}
+		delete(v.neighbors, port+"-"+ipdst)
+		v.scomo.DelHost(vrf, IPAddr(ipdst), port)
+		v.forward.Remove(vrf, ipdst)
 	}
 
 	return nil
@@ -214,56 +303,517 @@ func (v *Gateway) findLinkAttr(index int) *netlink.LinkAttrs {
 	return v.linkAttrs[index]
 }
 
-func (v *Gateway) OnRoute(update uint16, rule netlink.Route) error {
+// findVrf returns the table of the VRF enslaving a link, and zero
+// for the links of the main table.
+func (v *Gateway) findVrf(attr *netlink.LinkAttrs) int {
+	if attr.MasterIndex == 0 {
+		return 0
+	}
+	h, err := HandleAt(v.ns)
+	if err != nil {
+		return 0
+	}
+	defer h.Close()
+
+	if link, err := h.LinkByIndex(attr.MasterIndex); err == nil {
+		if vrf, ok := link.(*netlink.Vrf); ok {
+			return int(vrf.Table)
+		}
+	}
+	return 0
+}
+
+// vrfOf maps a kernel table to the VRF metadata in the pipeline.
+func vrfOf(table int) int {
+	if table == unix.RT_TABLE_MAIN {
+		return 0
+	}
+	return table
+}
+
+func (v *Gateway) OnRoute(update uint16, rule Route) error {
 	v.mutex.Lock()
 	defer v.mutex.Unlock()
 
-	if rule.Family == netlink.FAMILY_V6 {
+	if rule.Dst == nil {
+		return nil
+	}
+	if rule.Dst.IP.IsLinkLocalUnicast() || rule.Dst.IP.IsMulticast() {
+		return nil
+	}
+	if rule.Table == unix.RT_TABLE_LOCAL {
 		return nil
 	}
 
 	log.Printf("Gateway.OnRoute: Type=%d, Rule=%+v", update, rule)
 
+	switch rule.Type {
+	case unix.RTN_BLACKHOLE, unix.RTN_UNREACHABLE, unix.RTN_PROHIBIT:
+		return v.onReject(update, rule)
+	case unix.RTN_UNICAST:
+	default:
+		return nil
+	}
+	if rule.NhId > 0 {
+		return v.onNexthopRoute(update, rule)
+	}
+	if len(rule.MultiPath) > 0 {
+		return v.onMultiPath(update, rule)
+	}
+
 	attr := v.findLinkAttr(rule.LinkIndex)
 	if attr == nil || !strings.HasPrefix(attr.Name, "vlan") {
 		return nil
 	}
 
 	port := attr.Name
+	vrf := vrfOf(rule.Table)
 	ipdst := rule.Dst.String()
 	ipgw := rule.Gw.String()
 	switch update {
 	case UpdateRouteAdd, UpdateRouteNew:
-		v.scomo.AddRoute(IPPrefix(ipdst), IPAddr(ipgw), port)
+		v.scomo.AddRoute(vrf, IPPrefix(ipdst), IPAddr(ipgw), port)
 		v.forward.Add(schema.IPForward{
+			Table:     vrf,
+			Type:      RouteUnicast,
 			Prefix:    ipdst,
 			NextHop:   ipgw,
 			Interface: port,
 		})
 	case UpdateRouteDel:
-		v.scomo.DelRoute(IPPrefix(ipdst), port)
-		v.forward.Remove(ipdst)
+		v.scomo.DelRoute(vrf, IPPrefix(ipdst), port)
+		v.forward.Remove(vrf, ipdst)
+	}
+
+	return nil
+}
+
+func (v *Gateway) onReject(update uint16, rule Route) error {
+	typ := RouteBlackhole
+	switch rule.Type {
+	case unix.RTN_UNREACHABLE:
+		typ = RouteUnreachable
+	case unix.RTN_PROHIBIT:
+		typ = RouteProhibit
+	}
+
+	vrf := vrfOf(rule.Table)
+	ipdst := rule.Dst.String()
+	switch update {
+	case UpdateRouteAdd, UpdateRouteNew:
+		v.scomo.AddReject(vrf, IPPrefix(ipdst), typ)
+		v.forward.Add(schema.IPForward{
+			Table:  vrf,
+			Type:   typ,
+			Prefix: ipdst,
+		})
+	case UpdateRouteDel:
+		v.scomo.DelReject(vrf, IPPrefix(ipdst))
+		v.forward.Remove(vrf, ipdst)
 	}
 
 	return nil
 }
 
+func (v *Gateway) onMultiPath(update uint16, rule Route) error {
+	var hops []NextHop
+	var gws, ports []string
+	for _, path := range rule.MultiPath {
+		attr := v.findLinkAttr(path.LinkIndex)
+		if attr == nil || !strings.HasPrefix(attr.Name, "vlan") {
+			continue
+		}
+		hops = append(hops, NextHop{
+			Gw:     IPAddr(path.Gw.String()),
+			Port:   attr.Name,
+			Weight: path.Hops + 1,
+		})
+		gws = append(gws, path.Gw.String())
+		ports = append(ports, attr.Name)
+	}
+	if len(hops) == 0 {
+		return nil
+	}
+
+	vrf := vrfOf(rule.Table)
+	ipdst := rule.Dst.String()
+	switch update {
+	case UpdateRouteAdd, UpdateRouteNew:
+		v.scomo.AddMultiRoute(vrf, IPPrefix(ipdst), hops)
+		v.forward.Add(schema.IPForward{
+			Table:     vrf,
+			Type:      RouteUnicast,
+			Prefix:    ipdst,
+			NextHop:   strings.Join(gws, ","),
+			Interface: strings.Join(ports, ","),
+		})
+	case UpdateRouteDel:
+		v.scomo.DelRoute(vrf, IPPrefix(ipdst), hops[0].Port)
+		v.forward.Remove(vrf, ipdst)
+	}
+
+	return nil
+}
+
+func (v *Gateway) onNexthopRoute(update uint16, rule Route) error {
+	vrf := vrfOf(rule.Table)
+	ipdst := rule.Dst.String()
+	switch update {
+	case UpdateRouteAdd, UpdateRouteNew:
+		v.scomo.AddNexthopRoute(vrf, IPPrefix(ipdst), rule.NhId)
+		v.forward.Add(schema.IPForward{
+			Table:  vrf,
+			Type:   RouteUnicast,
+			Prefix: ipdst,
+			NhId:   rule.NhId,
+		})
+	case UpdateRouteDel:
+		v.scomo.DelNexthopRoute(vrf, IPPrefix(ipdst))
+		v.forward.Remove(vrf, ipdst)
+	}
+
+	return nil
+}
+
+func (v *Gateway) OnNexthop(update uint16, nh Nexthop) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	log.Printf("Gateway.OnNexthop: Type=%d, Nexthop=%+v", update, nh)
+
+	switch update {
+	case UpdateNexthopAdd:
+		v.nexthops[nh.ID] = nh
+		v.addNexthop(nh)
+	case UpdateNexthopDel:
+		delete(v.nexthops, nh.ID)
+		delete(v.paths, nh.ID)
+		v.scomo.DelNexthop(nh.ID)
+	}
+	// A link failure changes or removes one object, and the groups
+	// holding it follow, without it once it is removed.
+	for _, group := range v.nexthops {
+		for _, member := range group.Group {
+			if member.ID == nh.ID {
+				v.addNexthop(group)
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func (v *Gateway) addNexthop(nh Nexthop) {
+	hops := v.nextHops(nh)
+	v.paths[nh.ID] = hops
+	v.scomo.AddNexthop(nh.ID, hops)
+}
+
+// nextHops returns the paths of a nexthop object on the VLAN interfaces.
+func (v *Gateway) nextHops(nh Nexthop) []NextHop {
+	if nh.Blackhole {
+		return nil
+	}
+
+	members := nh.Group
+	if len(members) == 0 {
+		members = []NexthopMember{{ID: nh.ID, Weight: 1}}
+	}
+	var hops []NextHop
+	for _, member := range members {
+		path, ok := v.nexthops[member.ID]
+		if !ok || path.Blackhole {
+			continue
+		}
+		attr := v.findLinkAttr(path.LinkIndex)
+		if attr == nil || !strings.HasPrefix(attr.Name, "vlan") {
+			continue
+		}
+		hops = append(hops, NextHop{
+			Gw:     IPAddr(path.Gw.String()),
+			Port:   attr.Name,
+			Weight: member.Weight,
+		})
+	}
+	return hops
+}
+
+func (v *Gateway) OnRule(rules []netlink.Rule) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	var items []PolicyRule
+	for _, rule := range rules {
+		switch rule.Table {
+		case unix.RT_TABLE_UNSPEC, unix.RT_TABLE_MAIN, unix.RT_TABLE_DEFAULT, unix.RT_TABLE_LOCAL:
+			continue
+		}
+		// Only the rules of the routed traffic from VLANs can be offloaded.
+		if rule.Invert || rule.OifName != "" {
+			continue
+		}
+		if rule.IifName != "" && !strings.HasPrefix(rule.IifName, "vlan") {
+			continue
+		}
+
+		item := PolicyRule{
+			Priority: rule.Priority,
+			Family:   FamilyV4,
+			Mark:     rule.Mark,
+			Tos:      rule.Tos,
+			Iif:      rule.IifName,
+			Table:    rule.Table,
+		}
+		if rule.Family == unix.AF_INET6 {
+			item.Family = FamilyV6
+		}
+		if rule.Src != nil {
+			item.Src = rule.Src.String()
+		}
+		if rule.Dst != nil {
+			item.Dst = rule.Dst.String()
+		}
+		if rule.Mask != nil {
+			item.Mask = *rule.Mask
+		} else if rule.Mark != 0 {
+			item.Mask = 0xffffffff
+		}
+		items = append(items, item)
+	}
+
+	log.Printf("Gateway.OnRule: %d of %d rules", len(items), len(rules))
+	return v.scomo.SetRules(items)
+}
+
 func (v *Gateway) ListForward() ([]schema.IPForward, error) {
 	v.mutex.RLock()
 	defer v.mutex.RUnlock()
 
 	var items []schema.IPForward
 	for _, value := range v.forward {
-		items = append(items, value)
+		items = append(items, v.expand(value))
 	}
 	return items, nil
 }
 
+// expand fills the gateways of a route on a next-hop group and its state.
+func (v *Gateway) expand(value schema.IPForward) schema.IPForward {
+	if value.NhId > 0 {
+		var gws, ports []string
+		for _, hop := range v.paths[value.NhId] {
+			gws = append(gws, hop.Gw.Str())
+			ports = append(ports, hop.Port)
+		}
+		value.NextHop = strings.Join(gws, ",")
+		value.Interface = strings.Join(ports, ",")
+	}
+	if !v.resolved(value) {
+		value.State = StateIncomplete
+	}
+	return value
+}
+
+func (v *Gateway) SetLimit(data schema.ConntrackLimit) (schema.ConntrackLimit, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	return v.scomo.SetLimit(data)
+}
+
+func (v *Gateway) DelLimit(data schema.ConntrackLimit) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	return v.scomo.DelLimit(data)
+}
+
+// ListLimits returns the conntrack limits with the sessions counted in
+// their zones.
+func (v *Gateway) ListLimits() ([]schema.ConntrackLimit, error) {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+
+	return v.scomo.ListLimits(true), nil
+}
+
+// ShowQueue returns the state of the queue programming the routes and
+// hosts, which is not behind the lock of the Gateway.
+func (v *Gateway) ShowQueue() (schema.FlowQueue, error) {
+	return v.scomo.queue.Stats(), nil
+}
+
+// resolved reports whether every gateway of a route has a neighbor.
+func (v *Gateway) resolved(value schema.IPForward) bool {
+	if value.Type != RouteUnicast {
+		return true
+	}
+	gws := strings.Split(value.NextHop, ",")
+	ports := strings.Split(value.Interface, ",")
+	for i, gw := range gws {
+		if gw == "" || gw == "<nil>" || i >= len(ports) {
+			continue
+		}
+		if !v.neighbors[ports[i]+"-"+gw] {
+			return false
+		}
+	}
+	return true
+}
+
+func (v *Gateway) listVrf() ([]schema.VRF, error) {
+	links, err := LinkListAt(v.ns)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []schema.VRF
+	for _, link := range links {
+		vrf, ok := link.(*netlink.Vrf)
+		if !ok {
+			continue
+		}
+		item := schema.VRF{
+			Name:  vrf.Name,
+			Table: int(vrf.Table),
+		}
+		for _, slave := range links {
+			if slave.Attrs().MasterIndex == vrf.Index {
+				item.Interfaces = append(item.Interfaces, slave.Attrs().Name)
+			}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// syncVrf restores the VRF bindings of the interfaces found in the kernel.
+func (v *Gateway) syncVrf() {
+	items, err := v.listVrf()
+	if err != nil {
+		log.Printf("Gateway.syncVrf: %v", err)
+		return
+	}
+	for _, item := range items {
+		for _, name := range item.Interfaces {
+			v.scomo.SetVrf(name, item.Table)
+		}
+	}
+}
+
+func (v *Gateway) ListVrf() ([]schema.VRF, error) {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+
+	return v.listVrf()
+}
+
+func (v *Gateway) AddVrf(data schema.VRF) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	return v.addVrf(data)
+}
+
+func (v *Gateway) addVrf(data schema.VRF) error {
+	h, err := HandleAt(v.ns)
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+
+	link, err := h.LinkByName(data.Name)
+	if err != nil {
+		if data.Table == 0 {
+			return fmt.Errorf("table of %s is required", data.Name)
+		}
+		vrf := &netlink.Vrf{
+			LinkAttrs: netlink.LinkAttrs{Name: data.Name},
+			Table:     uint32(data.Table),
+		}
+		if err := h.LinkAdd(vrf); err != nil {
+			return err
+		}
+		if err := h.LinkSetUp(vrf); err != nil {
+			return err
+		}
+		link = vrf
+	}
+	vrf, ok := link.(*netlink.Vrf)
+	if !ok {
+		return fmt.Errorf("%s is not a vrf", data.Name)
+	}
+
+	for _, name := range data.Interfaces {
+		slave, err := h.LinkByName(name)
+		if err != nil {
+			return err
+		}
+		if err := h.LinkSetMaster(slave, vrf); err != nil {
+			return err
+		}
+		v.scomo.SetVrf(name, int(vrf.Table))
+	}
+	return nil
+}
+
+func (v *Gateway) DelVrf(data schema.VRF) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	return v.delVrf(data)
+}
+
+func (v *Gateway) delVrf(data schema.VRF) error {
+	h, err := HandleAt(v.ns)
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+
+	link, err := h.LinkByName(data.Name)
+	if err != nil {
+		return err
+	}
+	if _, ok := link.(*netlink.Vrf); !ok {
+		return fmt.Errorf("%s is not a vrf", data.Name)
+	}
+
+	// Only release the interfaces if some are given.
+	names := data.Interfaces
+	if len(names) == 0 {
+		links, err := h.LinkList()
+		if err != nil {
+			return err
+		}
+		for _, slave := range links {
+			if slave.Attrs().MasterIndex == link.Attrs().Index {
+				names = append(names, slave.Attrs().Name)
+			}
+		}
+	}
+	for _, name := range names {
+		slave, err := h.LinkByName(name)
+		if err != nil {
+			return err
+		}
+		if err := h.LinkSetNoMaster(slave); err != nil {
+			return err
+		}
+		v.scomo.SetVrf(name, 0)
+	}
+
+	if len(data.Interfaces) == 0 {
+		return h.LinkDel(link)
+	}
+	return nil
+}
+
 func (v *Gateway) AddSNAT(data schema.SNAT) error {
 	v.mutex.Lock()
 	defer v.mutex.Unlock()
 
-	return v.scomo.AddSNAT(data.Source, data.SourceTo)
+	return v.scomo.AddSNAT(data)
 }
 
 func (v *Gateway) DelSNAT(data schema.SNAT) error {
@@ -277,44 +827,340 @@ func (v *Gateway) ListSNAT() ([]schema.SNAT, error) {
 	v.mutex.RLock()
 	defer v.mutex.RUnlock()
 
+	return v.listSNAT(true), nil
+}
+
+// listSNAT returns the SNAT rules, with the traffic through their flows
+// and the address masqueraded to if stats.
+func (v *Gateway) listSNAT(stats bool) []schema.SNAT {
 	var results []schema.SNAT
 	for key, value := range v.scomo.ListSNAT() {
-		results = append(results, schema.SNAT{
-			Source:   SplitSNAT(key),
-			SourceTo: value,
-		})
+		item := snatRule(key, value)
+		if stats {
+			if item.Interface != "" {
+				item.SourceTo = v.scomo.masqAddr(item.Interface)
+			}
+			if flows, err := v.scomo.ownerStats(KindSNAT, "snat-"+key); err == nil {
+				item.Packets, item.Bytes = flows.PacketCount, flows.ByteCount
+			}
+		}
+		results = append(results, item)
 	}
-	return results, nil
+	return results
 }
 
 func (v *Gateway) AddDNAT(data schema.DNAT) error {
 	v.mutex.Lock()
 	defer v.mutex.Unlock()
 
-	return v.scomo.AddDNAT(data.Protocol, data.Dest, data.DestTo)
+	return v.scomo.AddDNAT(data)
 }
 
 func (v *Gateway) DelDNAT(data schema.DNAT) error {
 	v.mutex.Lock()
 	defer v.mutex.Unlock()
 
-	return v.scomo.DelDNAT(data.Protocol, data.Dest)
+	return v.scomo.DelDNAT(data)
+}
+
+// OnHealth updates the rotation of a DNAT rule once one of its backends
+// went up or down.
+func (v *Gateway) OnHealth(key string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.scomo.Rebalance(key)
 }
 
 func (v *Gateway) ListDNAT() ([]schema.DNAT, error) {
 	v.mutex.RLock()
 	defer v.mutex.RUnlock()
 
+	return v.listDNAT(true), nil
+}
+
+// listDNAT returns the DNAT rules, with the traffic through their flows
+// and the state of their backends if stats.
+func (v *Gateway) listDNAT(stats bool) []schema.DNAT {
 	var results []schema.DNAT
 	for key, value := range v.scomo.ListDNAT() {
-		protocol, dest := SplitDNAT(key)
-		results = append(results, schema.DNAT{
-			Dest:     dest,
-			DestTo:   value,
-			Protocol: protocol,
-		})
+		item := dnatRule(key, value)
+		if stats {
+			v.scomo.backendStates("dnat-"+key, item.Backends)
+			if flows, err := v.scomo.ownerStats(KindDNAT, "dnat-"+key); err == nil {
+				item.Packets, item.Bytes = flows.PacketCount, flows.ByteCount
+			}
+		}
+		results = append(results, item)
 	}
-	return results, nil
+	return results
+}
+
+func (v *Gateway) AddStaticNAT(data schema.StaticNAT) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	return v.scomo.AddStaticNAT(data)
+}
+
+func (v *Gateway) DelStaticNAT(data schema.StaticNAT) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	return v.scomo.DelStaticNAT(data)
+}
+
+func (v *Gateway) ListStaticNAT() ([]schema.StaticNAT, error) {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+
+	return v.scomo.ListStaticNAT(true), nil
+}
+
+// restore reapplies the saved configuration, objects in place are
+// left untouched.
+func (v *Gateway) restore() {
+	data, err := v.config.Load()
+	if err != nil {
+		log.Printf("Gateway.restore: %v", err)
+		return
+	}
+
+	v.mutex.Lock()
+	for _, port := range data.Interfaces {
+		v.addPort(port)
+	}
+	v.mutex.Unlock()
+	for _, vrf := range data.VRFs {
+		if err := v.AddVrf(vrf); err != nil {
+			log.Printf("Gateway.restore: vrf %s: %v", vrf.Name, err)
+		}
+	}
+	for _, snat := range data.SNAT {
+		v.AddSNAT(snat)
+	}
+	for _, dnat := range data.DNAT {
+		v.AddDNAT(dnat)
+	}
+	for _, static := range data.StaticNAT {
+		v.AddStaticNAT(static)
+	}
+	for _, limit := range data.Limits {
+		if _, err := v.SetLimit(limit); err != nil {
+			log.Printf("Gateway.restore: limit %d: %v", limit.Zone, err)
+		}
+	}
+}
+
+// addPort adds a VLAN interface, or a port with its tag and trunks. The
+// lock is held by the caller.
+func (v *Gateway) addPort(port schema.Interface) error {
+	if v.scomo.findVlanId(port.Name) > 0 {
+		if v.scomo.hasPort(port.Name) {
+			return nil
+		}
+		return v.scomo.addVlanPort(port.Name)
+	}
+	if err := v.scomo.addPort(port.Name); err != nil {
+		return err
+	}
+	return v.addVlan(port)
+}
+
+// running returns the configuration in use, the lock is held by the
+// caller.
+func (v *Gateway) running() (schema.Config, error) {
+	data := schema.Config{}
+
+	ports, err := v.listInterface()
+	if err != nil {
+		return data, err
+	}
+	for _, port := range ports {
+		data.Interfaces = append(data.Interfaces, portConfig(schema.Interface{
+			Name:   port.Name,
+			Tag:    port.Tag,
+			Trunks: port.Trunks,
+		}))
+	}
+	sort.Slice(data.Interfaces, func(i, j int) bool {
+		return data.Interfaces[i].Name < data.Interfaces[j].Name
+	})
+
+	if data.VRFs, err = v.listVrf(); err != nil {
+		return data, err
+	}
+	sort.Slice(data.VRFs, func(i, j int) bool {
+		return data.VRFs[i].Name < data.VRFs[j].Name
+	})
+
+	data.SNAT = v.listSNAT(false)
+	data.DNAT = v.listDNAT(false)
+	data.StaticNAT = v.scomo.ListStaticNAT(false)
+	data.Limits = v.scomo.ListLimits(false)
+	sort.Slice(data.SNAT, func(i, j int) bool {
+		return data.SNAT[i].Source < data.SNAT[j].Source
+	})
+	sort.Slice(data.DNAT, func(i, j int) bool {
+		return data.DNAT[i].Protocol+data.DNAT[i].Dest < data.DNAT[j].Protocol+data.DNAT[j].Dest
+	})
+	return data, nil
+}
+
+func (v *Gateway) SaveConfig() (schema.Config, error) {
+	v.mutex.RLock()
+	data, err := v.running()
+	v.mutex.RUnlock()
+	if err != nil {
+		return data, err
+	}
+	if err := v.config.Save(data); err != nil {
+		log.Printf("Gateway.SaveConfig: %v", err)
+		return data, err
+	}
+	return data, nil
+}
+
+func (v *Gateway) ShowConfig() (schema.Config, error) {
+	return v.config.Load()
+}
+
+// ApplyConfig converges the running configuration to the desired one,
+// and only returns the plan on a dry run. It holds the lock all along and
+// stops at the first step failing, the steps applied are returned and
+// named in the error.
+func (v *Gateway) ApplyConfig(data schema.Config, dryRun bool) (schema.ConfigDiff, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	have, err := v.running()
+	if err != nil {
+		return schema.ConfigDiff{}, err
+	}
+	diff := diffConfig(have, data)
+	if dryRun {
+		return diff, nil
+	}
+
+	var applied schema.ConfigDiff
+	var done []string
+	fail := func(step string, err error) (schema.ConfigDiff, error) {
+		err = fmt.Errorf("%s: %v, applied before: [%s]", step, err, strings.Join(done, ", "))
+		log.Printf("Gateway.ApplyConfig: %v", err)
+		return applied, err
+	}
+
+	for _, limit := range diff.Remove.Limits {
+		step := fmt.Sprintf("remove limit %d", limit.Zone)
+		if err := v.scomo.DelLimit(limit); err != nil {
+			return fail(step, err)
+		}
+		applied.Remove.Limits = append(applied.Remove.Limits, limit)
+		done = append(done, step)
+	}
+	for _, static := range diff.Remove.StaticNAT {
+		step := "remove static nat " + static.External
+		if err := v.scomo.DelStaticNAT(static); err != nil {
+			return fail(step, err)
+		}
+		applied.Remove.StaticNAT = append(applied.Remove.StaticNAT, static)
+		done = append(done, step)
+	}
+	for _, dnat := range diff.Remove.DNAT {
+		step := "remove dnat " + dnat.Protocol + " " + dnat.Dest
+		if err := v.scomo.DelDNAT(dnat); err != nil {
+			return fail(step, err)
+		}
+		applied.Remove.DNAT = append(applied.Remove.DNAT, dnat)
+		done = append(done, step)
+	}
+	for _, snat := range diff.Remove.SNAT {
+		step := "remove snat " + snat.Source
+		if err := v.scomo.DelSNAT(snat.Source); err != nil {
+			return fail(step, err)
+		}
+		applied.Remove.SNAT = append(applied.Remove.SNAT, snat)
+		done = append(done, step)
+	}
+	for _, vrf := range diff.Remove.VRFs {
+		step := "remove vrf " + vrf.Name
+		if err := v.delVrf(vrf); err != nil {
+			return fail(step, err)
+		}
+		applied.Remove.VRFs = append(applied.Remove.VRFs, vrf)
+		done = append(done, step)
+	}
+	for _, port := range diff.Remove.Interfaces {
+		step := "remove interface " + port.Name
+		if port.Tag == 0 && port.Trunks == "" {
+			err = v.scomo.delPort(port.Name)
+		} else {
+			// Clear the tag or trunks only.
+			drop := schema.Interface{Name: port.Name}
+			if port.Tag > 0 {
+				drop.Tag = 4095
+			}
+			if port.Trunks != "" {
+				drop.Trunks = "all"
+			}
+			err = v.delVlan(drop)
+		}
+		if err != nil {
+			return fail(step, err)
+		}
+		applied.Remove.Interfaces = append(applied.Remove.Interfaces, port)
+		done = append(done, step)
+	}
+
+	for _, port := range diff.Add.Interfaces {
+		step := "add interface " + port.Name
+		if err := v.addPort(port); err != nil {
+			return fail(step, err)
+		}
+		applied.Add.Interfaces = append(applied.Add.Interfaces, port)
+		done = append(done, step)
+	}
+	for _, vrf := range diff.Add.VRFs {
+		step := "add vrf " + vrf.Name
+		if err := v.addVrf(vrf); err != nil {
+			return fail(step, err)
+		}
+		applied.Add.VRFs = append(applied.Add.VRFs, vrf)
+		done = append(done, step)
+	}
+	for _, snat := range diff.Add.SNAT {
+		step := "add snat " + snat.Source
+		if err := v.scomo.AddSNAT(snat); err != nil {
+			return fail(step, err)
+		}
+		applied.Add.SNAT = append(applied.Add.SNAT, snat)
+		done = append(done, step)
+	}
+	for _, dnat := range diff.Add.DNAT {
+		step := "add dnat " + dnat.Protocol + " " + dnat.Dest
+		if err := v.scomo.AddDNAT(dnat); err != nil {
+			return fail(step, err)
+		}
+		applied.Add.DNAT = append(applied.Add.DNAT, dnat)
+		done = append(done, step)
+	}
+	for _, static := range diff.Add.StaticNAT {
+		step := "add static nat " + static.External
+		if err := v.scomo.AddStaticNAT(static); err != nil {
+			return fail(step, err)
+		}
+		applied.Add.StaticNAT = append(applied.Add.StaticNAT, static)
+		done = append(done, step)
+	}
+	for _, limit := range diff.Add.Limits {
+		step := fmt.Sprintf("add limit %d", limit.Zone)
+		if _, err := v.scomo.SetLimit(limit); err != nil {
+			return fail(step, err)
+		}
+		applied.Add.Limits = append(applied.Add.Limits, limit)
+		done = append(done, step)
+	}
+	return applied, nil
 }
 
 func (v *Gateway) OnAddress(data netlink.AddrUpdate) error {
@@ -328,11 +1174,20 @@ func (v *Gateway) OnAddress(data netlink.AddrUpdate) error {
 		return nil
 	}
 
+	vrf := v.findVrf(attr)
+	addr := data.LinkAddress
+	subnet := &net.IPNet{IP: addr.IP.Mask(addr.Mask), Mask: addr.Mask}
 	switch data.NewAddr {
 	case true:
-		v.scomo.AddLocal(data.LinkAddress.String())
+		v.scomo.AddLocal(vrf, addr.String())
+		if !addr.IP.IsLinkLocalUnicast() {
+			v.scomo.AddSubnet(attr.Name, subnet.String())
+			v.scomo.AddAddress(attr.Name, addr.IP)
+		}
 	case false:
-		v.scomo.DelLocal(data.LinkAddress.String())
+		v.scomo.DelLocal(vrf, addr.String())
+		v.scomo.DelSubnet(attr.Name, subnet.String())
+		v.scomo.DelAddress(attr.Name, addr.IP)
 	}
 
 	return nil
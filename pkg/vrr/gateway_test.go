@@ -0,0 +1,203 @@
+package vrr
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/luscis/openvrr/pkg/ovs"
+	"github.com/luscis/openvrr/pkg/schema"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+func TestGatewayOnNexthopDel(t *testing.T) {
+	groups := make(map[string]string)
+	v := &Gateway{
+		scomo: newTestComposer(t, ovs.Exec(func(cmd string, args ...string) ([]byte, error) {
+			if len(args) > 2 && args[0] == "mod-group" {
+				id, _, _ := strings.Cut(args[2], ",")
+				groups[id] = args[2]
+			}
+			return nil, nil
+		})),
+		linkAttrs: map[int]*netlink.LinkAttrs{
+			40010: {Name: "vlan10"},
+			40011: {Name: "vlan11"},
+		},
+		nexthops: make(map[uint32]Nexthop),
+		paths:    make(map[uint32][]NextHop),
+		ns:       netns.None(),
+	}
+	for _, nh := range []Nexthop{
+		{ID: 1, Family: 2, LinkIndex: 40010, Gw: net.ParseIP("192.168.10.254")},
+		{ID: 2, Family: 2, LinkIndex: 40011, Gw: net.ParseIP("192.168.11.254")},
+		{ID: 10, Family: 2, Group: []NexthopMember{{ID: 1, Weight: 1}, {ID: 2, Weight: 1}}},
+	} {
+		v.OnNexthop(UpdateNexthopAdd, nh)
+	}
+	gid := v.scomo.groups[nexthopKey(10)]
+	id := fmt.Sprintf("group_id=%d", gid)
+	if want, got := 2, strings.Count(groups[id], "bucket="); want != got {
+		t.Fatalf("unexpected buckets:\n- want: %v\n-  got: %v", want, groups[id])
+	}
+
+	// The group holding the object removed is left with the other one.
+	v.OnNexthop(UpdateNexthopDel, Nexthop{ID: 2, Family: 2})
+	if want, got := 1, strings.Count(groups[id], "bucket="); want != got {
+		t.Fatalf("unexpected buckets:\n- want: %v\n-  got: %v", want, groups[id])
+	}
+	if !strings.Contains(groups[id], "load:0xc0a80afe->reg0") {
+		t.Fatalf("unexpected bucket: %v", groups[id])
+	}
+	if want, got := 1, len(v.paths[10]); want != got {
+		t.Fatalf("unexpected paths:\n- want: %v\n-  got: %v", want, v.paths[10])
+	}
+}
+
+func TestGatewayOnNeighbor(t *testing.T) {
+	reachable := netlink.Neigh{
+		LinkIndex:    40010,
+		State:        netlink.NUD_REACHABLE,
+		IP:           net.ParseIP("192.168.10.254"),
+		HardwareAddr: net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+	}
+	tests := []struct {
+		desc  string
+		neigh func(v *Gateway)
+		want  []string
+	}{
+		{
+			desc: "reachable",
+			neigh: func(v *Gateway) {
+				v.OnNeighbor(UpdateNeighNew, reachable)
+			},
+			want: []string{
+				"priority=100,ip,metadata=0x0,reg0=0xc0a80afe,dl_dst=00:00:00:00:20:15,table=20,idle_timeout=0,actions=push:NXM_OF_ETH_DST,pop:NXM_OF_ETH_SRC,load:0x001122334455->NXM_OF_ETH_DST,load:0xa->NXM_OF_VLAN_TCI,load:0x800a->NXM_OF_IN_PORT,dec_ttl,resubmit(,30)",
+			},
+		},
+		{
+			// Left to the glean path until it is resolved.
+			desc: "incomplete",
+			neigh: func(v *Gateway) {
+				v.OnNeighbor(UpdateNeighNew, netlink.Neigh{
+					LinkIndex: 40010,
+					State:     netlink.NUD_INCOMPLETE,
+					IP:        net.ParseIP("192.168.10.254"),
+				})
+			},
+		},
+		{
+			desc: "failed once reachable",
+			neigh: func(v *Gateway) {
+				v.OnNeighbor(UpdateNeighNew, reachable)
+				failed := reachable
+				failed.State = netlink.NUD_FAILED
+				v.OnNeighbor(UpdateNeighNew, failed)
+			},
+		},
+		{
+			desc: "multicast",
+			neigh: func(v *Gateway) {
+				multicast := reachable
+				multicast.IP = net.ParseIP("224.0.0.5")
+				v.OnNeighbor(UpdateNeighNew, multicast)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			v := &Gateway{
+				scomo:     newTestComposer(t),
+				forward:   make(IPForwards),
+				linkAttrs: map[int]*netlink.LinkAttrs{40010: {Name: "vlan10"}},
+				neighbors: make(map[string]bool),
+				ns:        netns.None(),
+			}
+			add := func(a *Composer) error {
+				tt.neigh(v)
+				return nil
+			}
+			if want, got := tt.want, addedFlows(t, v.scomo, add); !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected flows:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
+
+func TestGatewayRestore(t *testing.T) {
+	tests := []struct {
+		desc string
+		data schema.Config
+		want []string
+	}{
+		{
+			desc: "snat",
+			data: schema.Config{
+				SNAT: []schema.SNAT{{Source: "192.168.2.0/24", SourceTo: "10.0.0.1"}},
+			},
+			want: []string{
+				"priority=50,ip,ct_state=+trk+new,nw_src=192.168.2.0/24,table=12,idle_timeout=0,actions=ct(commit,nat(src=10.0.0.1),zone=10,table=15)",
+			},
+		},
+		{
+			desc: "dnat",
+			data: schema.Config{
+				DNAT: []schema.DNAT{{Protocol: "tcp", Dest: "10.0.0.1:80", DestTo: "192.168.1.10:8080"}},
+			},
+			want: []string{
+				"priority=160,tcp,ct_state=+trk+new,nw_dst=10.0.0.1,tp_dst=80,table=12,idle_timeout=0,actions=ct(commit,nat(dst=192.168.1.10:8080),zone=10,table=15)",
+				"priority=162,tcp,ct_state=+trk+new,nw_dst=10.0.0.1,nw_src=192.168.1.10,tp_dst=80,table=12,idle_timeout=0,actions=ct(commit,nat(dst=192.168.1.10:8080),zone=10),resubmit(,12)",
+				"priority=202,tcp,ct_state=+trk+est,nw_dst=192.168.1.10,nw_src=192.168.1.10,tp_dst=8080,table=12,idle_timeout=0,actions=ct_clear,resubmit(,10)",
+				"priority=202,tcp,ct_state=+trk+rpl,nw_dst=192.168.1.10,nw_src=192.168.1.10,tp_src=8080,table=12,idle_timeout=0,actions=ct_clear,resubmit(,10)",
+			},
+		},
+		{
+			desc: "static nat",
+			data: schema.Config{
+				StaticNAT: []schema.StaticNAT{{External: "10.0.0.2", Internal: "192.168.1.11"}},
+			},
+			want: []string{
+				"priority=155,ip,ct_state=+trk+new,nw_dst=10.0.0.2,table=12,idle_timeout=0,actions=ct(commit,nat(dst=192.168.1.11),zone=10,table=15)",
+				"priority=162,ip,ct_state=+trk+new,nw_dst=10.0.0.2,nw_src=192.168.1.11,table=12,idle_timeout=0,actions=ct(commit,nat(dst=192.168.1.11),zone=10),resubmit(,12)",
+				"priority=202,ip,ct_state=+trk+est,nw_dst=192.168.1.11,nw_src=192.168.1.11,table=12,idle_timeout=0,actions=ct_clear,resubmit(,10)",
+				"priority=202,ip,ct_state=+trk+rpl,nw_dst=192.168.1.11,nw_src=192.168.1.11,table=12,idle_timeout=0,actions=ct_clear,resubmit(,10)",
+				"priority=60,ip,ct_state=+trk+new,nw_src=192.168.1.11,table=12,idle_timeout=0,actions=ct(commit,nat(src=10.0.0.2),zone=10,table=15)",
+			},
+		},
+		{
+			desc: "limit",
+			data: schema.Config{
+				Limits: []schema.ConntrackLimit{{Zone: 1, Source: "192.168.2.0/24", Limit: 10000}},
+			},
+			want: []string{
+				"priority=36864,ip,reg2=0x0/0x1,ct_state=+trk+rpl,nw_dst=192.168.2.0/24,table=15,idle_timeout=0,actions=load:0x1->NXM_NX_REG2[0],ct(zone=1,table=15)",
+				"priority=36864,ip,reg2=0x0/0x1,nw_src=192.168.2.0/24,table=10,idle_timeout=0,actions=load:0x1->NXM_NX_REG2[0],ct(commit,zone=1,table=10)",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			v := &Gateway{
+				scomo:  newTestComposer(t),
+				config: &ConfigStore{file: filepath.Join(t.TempDir(), "openvrr.yaml")},
+				ns:     netns.None(),
+			}
+			if err := v.config.Save(tt.data); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			add := func(a *Composer) error {
+				v.restore()
+				return nil
+			}
+			if want, got := tt.want, addedFlows(t, v.scomo, add); !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected flows:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
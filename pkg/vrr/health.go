@@ -0,0 +1,155 @@
+package vrr
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/vishvananda/netns"
+)
+
+const (
+	// Period and timeout of the health checks of DNAT backends.
+	CheckInterval = 5 * time.Second
+	CheckTimeout  = 2 * time.Second
+	// Failures in a row before a backend is taken out of rotation, and
+	// successes before it is back.
+	CheckFall = 3
+	CheckRise = 2
+)
+
+// A Checker probes the backends of a DNAT rule from the namespace of the
+// gateway with a TCP connect or an HTTP GET, and calls On with the key of
+// the rule when one of them goes up or down.
+type Checker struct {
+	ns    netns.NsHandle
+	Key   string
+	Check string
+	Path  string
+	Addrs []string
+	On    func(key string)
+	mutex sync.RWMutex
+	up    map[string]bool
+	count map[string]int
+	done  chan struct{}
+}
+
+// Start probes the backends in the background, they are up until they
+// fail enough checks.
+func (c *Checker) Start() {
+	c.up = make(map[string]bool)
+	c.count = make(map[string]int)
+	for _, addr := range c.Addrs {
+		c.up[addr] = true
+	}
+	c.done = make(chan struct{})
+	go c.run()
+}
+
+func (c *Checker) Stop() {
+	close(c.done)
+}
+
+func (c *Checker) Up(addr string) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.up[addr]
+}
+
+func (c *Checker) probe(addr string) error {
+	if c.Check != "http" {
+		conn, err := DialAt(c.ns, "tcp", addr, CheckTimeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	client := &http.Client{
+		Timeout: CheckTimeout,
+		Transport: &http.Transport{
+			DialContext: func(_ context.Context, network, address string) (net.Conn, error) {
+				return DialAt(c.ns, network, address, CheckTimeout)
+			},
+			DisableKeepAlives: true,
+		},
+	}
+	path := c.Path
+	if path == "" {
+		path = "/"
+	}
+	resp, err := client.Get("http://" + addr + path)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+func (c *Checker) run() {
+	ticker := time.NewTicker(CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		if !c.round() {
+			continue
+		}
+		select {
+		case <-c.done:
+			return
+		default:
+			c.On(c.Key)
+		}
+	}
+}
+
+// round probes all the backends at once, and reports whether one of them
+// went up or down.
+func (c *Checker) round() bool {
+	errs := make([]error, len(c.Addrs))
+	var wg sync.WaitGroup
+	for i, addr := range c.Addrs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = c.probe(addr)
+		}()
+	}
+	wg.Wait()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	changed := false
+	for i, addr := range c.Addrs {
+		up := errs[i] == nil
+		if up == c.up[addr] {
+			c.count[addr] = 0
+			continue
+		}
+		c.count[addr]++
+		if (up && c.count[addr] < CheckRise) || (!up && c.count[addr] < CheckFall) {
+			continue
+		}
+		if up {
+			log.Printf("Checker.round: %s backend %s up", c.Key, addr)
+		} else {
+			log.Printf("Checker.round: %s backend %s down: %v", c.Key, addr, errs[i])
+		}
+		c.up[addr] = up
+		c.count[addr] = 0
+		changed = true
+	}
+	return changed
+}
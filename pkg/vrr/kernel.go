@@ -2,12 +2,18 @@ package vrr
 
 import (
 	"log"
+	"net"
 	"os"
 	"os/signal"
+	"runtime"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
 	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
 )
 
 const (
@@ -25,10 +31,10 @@ func NeighListAt(ns netns.NsHandle) ([]netlink.Neigh, error) {
 			return nil, err
 		} else {
 			defer h.Close()
-			return h.NeighList(0, syscall.AF_INET)
+			return h.NeighList(0, netlink.FAMILY_ALL)
 		}
 	}
-	return netlink.NeighList(0, syscall.AF_INET)
+	return netlink.NeighList(0, netlink.FAMILY_ALL)
 }
 
 type KernelNeighbor struct {
@@ -79,21 +85,74 @@ func (n *KernelNeighbor) watch() {
 	}
 }
 
-func RouteListAt(ns netns.NsHandle) ([]netlink.Route, error) {
+// RouteListAt lists the routes of all tables, VRFs have their own.
+func RouteListAt(ns netns.NsHandle) ([]Route, error) {
+	msgs, err := dumpAt(ns, unix.RTM_GETROUTE, make([]byte, unix.SizeofRtMsg))
+	if err != nil {
+		return nil, err
+	}
+
+	var items []Route
+	for _, m := range msgs {
+		route, err := deserializeRoute(m)
+		if err != nil {
+			return nil, err
+		}
+		if route.Flags&unix.RTM_F_CLONED != 0 {
+			continue
+		}
+		items = append(items, route)
+	}
+	return items, nil
+}
+
+func LinkListAt(ns netns.NsHandle) ([]netlink.Link, error) {
 	if ns != netns.None() {
 		if h, err := netlink.NewHandleAt(ns); err != nil {
 			return nil, err
 		} else {
 			defer h.Close()
-			return h.RouteList(nil, syscall.AF_INET)
+			return h.LinkList()
 		}
 	}
-	return netlink.RouteList(nil, syscall.AF_INET)
+	return netlink.LinkList()
+}
+
+func HandleAt(ns netns.NsHandle) (*netlink.Handle, error) {
+	if ns != netns.None() {
+		return netlink.NewHandleAt(ns)
+	}
+	return netlink.NewHandle()
+}
+
+// DialAt connects to an address from a network namespace. The socket is
+// opened on a thread moved to the namespace, and stays in it afterwards.
+func DialAt(ns netns.NsHandle, network, address string, timeout time.Duration) (net.Conn, error) {
+	if ns == netns.None() {
+		return net.DialTimeout(network, address, timeout)
+	}
+	runtime.LockOSThread()
+	origin, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return nil, err
+	}
+	defer origin.Close()
+	if err := netns.Set(ns); err != nil {
+		runtime.UnlockOSThread()
+		return nil, err
+	}
+	conn, err := net.DialTimeout(network, address, timeout)
+	// A thread left in the namespace ends with the goroutine.
+	if netns.Set(origin) == nil {
+		runtime.UnlockOSThread()
+	}
+	return conn, err
 }
 
 type KernelRoute struct {
 	ns netns.NsHandle
-	On func(uint16, netlink.Route) error
+	On func(uint16, Route) error
 }
 
 func (r *KernelRoute) Init() {
@@ -115,27 +174,106 @@ func (r *KernelRoute) Start() {
 	go r.watch()
 }
 
+// watch decodes the updates itself, netlink drops the nexthop object
+// of routes.
 func (r *KernelRoute) watch() {
-	routeCh := make(chan netlink.RouteUpdate)
-	doneCh := make(chan struct{})
-
-	err := netlink.RouteSubscribeAt(r.ns, routeCh, doneCh)
+	s, err := nl.SubscribeAt(r.ns, netns.None(), unix.NETLINK_ROUTE,
+		unix.RTNLGRP_IPV4_ROUTE, unix.RTNLGRP_IPV6_ROUTE)
 	if err != nil {
 		log.Fatalf("KernelRoute.watch: subscribe %v", err)
 	}
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		s.Close()
+	}()
 
 	for {
-		select {
-		case update := <-routeCh:
-			r.On(update.Type, update.Route)
+		msgs, _, err := s.Receive()
+		if err != nil {
+			log.Printf("KernelRoute.watch: receive %v", err)
+			return
+		}
+		for _, m := range msgs {
+			if m.Header.Type != unix.RTM_NEWROUTE && m.Header.Type != unix.RTM_DELROUTE {
+				continue
+			}
+			route, err := deserializeRoute(m.Data)
+			if err != nil {
+				log.Printf("KernelRoute.watch: %v", err)
+				continue
+			}
+			r.On(m.Header.Type, route)
+		}
+	}
+}
 
-		case <-sigCh:
-			close(doneCh)
+type KernelNexthop struct {
+	ns netns.NsHandle
+	On func(uint16, Nexthop) error
+}
+
+func (n *KernelNexthop) Init() {
+}
+
+func (n *KernelNexthop) list() {
+	nexthops, err := NexthopListAt(n.ns)
+	if err != nil {
+		// Kernels before 5.3 have no nexthop objects.
+		log.Printf("KernelNexthop.list: %v", err)
+		return
+	}
+
+	// Groups refer to the other objects, so list them last.
+	for _, nh := range nexthops {
+		if len(nh.Group) == 0 {
+			n.On(UpdateNexthopAdd, nh)
+		}
+	}
+	for _, nh := range nexthops {
+		if len(nh.Group) > 0 {
+			n.On(UpdateNexthopAdd, nh)
+		}
+	}
+}
+
+func (n *KernelNexthop) Start() {
+	n.list()
+	go n.watch()
+}
+
+func (n *KernelNexthop) watch() {
+	s, err := nl.SubscribeAt(n.ns, netns.None(), unix.NETLINK_ROUTE, unix.RTNLGRP_NEXTHOP)
+	if err != nil {
+		log.Fatalf("KernelNexthop.watch: subscribe %v", err)
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		s.Close()
+	}()
+
+	for {
+		msgs, _, err := s.Receive()
+		if err != nil {
+			log.Printf("KernelNexthop.watch: receive %v", err)
 			return
 		}
+		for _, m := range msgs {
+			if m.Header.Type != UpdateNexthopAdd && m.Header.Type != UpdateNexthopDel {
+				continue
+			}
+			nh, err := deserializeNexthop(m.Data)
+			if err != nil {
+				log.Printf("KernelNexthop.watch: %v", err)
+				continue
+			}
+			n.On(m.Header.Type, nh)
+		}
 	}
 }
 
@@ -145,10 +283,10 @@ func AddrListAt(ns netns.NsHandle) ([]netlink.Addr, error) {
 			return nil, err
 		} else {
 			defer h.Close()
-			return h.AddrList(nil, syscall.AF_INET)
+			return h.AddrList(nil, netlink.FAMILY_ALL)
 		}
 	}
-	return netlink.AddrList(nil, syscall.AF_INET)
+	return netlink.AddrList(nil, netlink.FAMILY_ALL)
 }
 
 type KernelAddr struct {
@@ -203,35 +341,144 @@ func (r *KernelAddr) watch() {
 	}
 }
 
+func RuleListAt(ns netns.NsHandle) ([]netlink.Rule, error) {
+	if ns != netns.None() {
+		if h, err := netlink.NewHandleAt(ns); err != nil {
+			return nil, err
+		} else {
+			defer h.Close()
+			return h.RuleList(netlink.FAMILY_ALL)
+		}
+	}
+	return netlink.RuleList(netlink.FAMILY_ALL)
+}
+
+type KernelRule struct {
+	ns netns.NsHandle
+	On func([]netlink.Rule) error
+}
+
+func (r *KernelRule) Init() {
+}
+
+func (r *KernelRule) list() {
+	rules, err := RuleListAt(r.ns)
+	if err != nil {
+		log.Printf("KernelRule.list: %v", err)
+		return
+	}
+
+	r.On(rules)
+}
+
+func (r *KernelRule) Start() {
+	r.list()
+	go r.watch()
+}
+
+// watch lists all rules again on every change, netlink has no decoder
+// of rule updates.
+func (r *KernelRule) watch() {
+	s, err := nl.SubscribeAt(r.ns, netns.None(), unix.NETLINK_ROUTE,
+		unix.RTNLGRP_IPV4_RULE, unix.RTNLGRP_IPV6_RULE)
+	if err != nil {
+		log.Fatalf("KernelRule.watch: subscribe %v", err)
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		s.Close()
+	}()
+
+	for {
+		msgs, _, err := s.Receive()
+		if err != nil {
+			log.Printf("KernelRule.watch: receive %v", err)
+			return
+		}
+		if len(msgs) > 0 {
+			r.list()
+		}
+	}
+}
+
 type KernelRegister struct {
 	ns         netns.NsHandle
 	neighbor   *KernelNeighbor
 	route      *KernelRoute
 	addr       *KernelAddr
+	rule       *KernelRule
+	nexthop    *KernelNexthop
 	OnAddress  func(netlink.AddrUpdate) error
-	OnRoute    func(uint16, netlink.Route) error
+	OnRoute    func(uint16, Route) error
 	OnNeighbor func(uint16, netlink.Neigh) error
+	OnRule     func([]netlink.Rule) error
+	OnNexthop  func(uint16, Nexthop) error
+	// Events received by kind, the initial dumps included.
+	events map[string]*atomic.Uint64
 }
 
 func (r *KernelRegister) Init() {
+	r.events = make(map[string]*atomic.Uint64)
+	for _, kind := range []string{"address", "neighbor", "route", "rule", "nexthop"} {
+		r.events[kind] = &atomic.Uint64{}
+	}
+
 	r.addr = &KernelAddr{
 		ns: r.ns,
-		On: r.OnAddress,
+		On: func(update netlink.AddrUpdate) error {
+			r.events["address"].Add(1)
+			return r.OnAddress(update)
+		},
 	}
 	r.neighbor = &KernelNeighbor{
 		ns: r.ns,
-		On: r.OnNeighbor,
+		On: func(update uint16, neigh netlink.Neigh) error {
+			r.events["neighbor"].Add(1)
+			return r.OnNeighbor(update, neigh)
+		},
 	}
 	r.route = &KernelRoute{
 		ns: r.ns,
-		On: r.OnRoute,
+		On: func(update uint16, route Route) error {
+			r.events["route"].Add(1)
+			return r.OnRoute(update, route)
+		},
+	}
+	r.rule = &KernelRule{
+		ns: r.ns,
+		On: func(rules []netlink.Rule) error {
+			r.events["rule"].Add(1)
+			return r.OnRule(rules)
+		},
+	}
+	r.nexthop = &KernelNexthop{
+		ns: r.ns,
+		On: func(update uint16, nexthop Nexthop) error {
+			r.events["nexthop"].Add(1)
+			return r.OnNexthop(update, nexthop)
+		},
+	}
+}
+
+// Events returns the number of events received by kind.
+func (r *KernelRegister) Events() map[string]uint64 {
+	counts := make(map[string]uint64)
+	for kind, count := range r.events {
+		counts[kind] = count.Load()
 	}
+	return counts
 }
 
 func (r *KernelRegister) Start() {
 	r.neighbor.Start()
+	// Routes refer to the nexthop objects.
+	r.nexthop.Start()
 	r.route.Start()
 	r.addr.Start()
+	r.rule.Start()
 }
 
 func (r *KernelRegister) Stop() {
@@ -0,0 +1,293 @@
+package vrr
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/luscis/openvrr/pkg/ovs"
+	"github.com/luscis/openvrr/pkg/schema"
+)
+
+const (
+	// DataPath holds the conntrack zones of the bridge.
+	DataPath = "system@ovs-system"
+	// ZoneQuota is the first zone given to a quota without one.
+	ZoneQuota = 1000
+	// RegQuota marks a packet committed in the zone of its quota.
+	RegQuota = "NXM_NX_REG2[0]"
+)
+
+func limitKey(zone int) string {
+	return fmt.Sprintf("ctlimit-%d", zone)
+}
+
+// isQuota reports whether a limit is the quota of a source prefix or
+// of the prefixes of a VLAN interface.
+func isQuota(limit schema.ConntrackLimit) bool {
+	return limit.Source != "" || limit.Interface != ""
+}
+
+func sameQuota(a, b schema.ConntrackLimit) bool {
+	return a.Source == b.Source && a.Interface == b.Interface
+}
+
+// quotaZone returns the zone of a quota, a free one if it has none yet.
+func (a *Composer) quotaZone(limit schema.ConntrackLimit) int {
+	for zone, value := range a.limits {
+		if isQuota(value) && sameQuota(value, limit) {
+			return zone
+		}
+	}
+	zone := ZoneQuota
+	for {
+		if _, ok := a.limits[zone]; !ok {
+			return zone
+		}
+		zone++
+	}
+}
+
+// addQuota commits the sessions from the prefixes of a quota in its
+// zone too, where they are counted against its limit. It is done in the
+// CT table on the source before SNAT, so a session over the limit is
+// dropped before it is committed in the NAT zone. The replies go through
+// the zone in the PBR table, once they are translated back. The zone, so
+// the limit, is shared by all the hosts of the prefixes.
+func (a *Composer) addQuota(limit schema.ConntrackLimit) error {
+	cookie := a.cookies.Get(KindQuota, limitKey(limit.Zone))
+	if err := a.delFlows(cookieMatch(cookie)); err != nil {
+		return err
+	}
+
+	prefixes := []string{limit.Source}
+	if limit.Interface != "" {
+		prefixes = a.subnets[limit.Interface]
+	}
+	for _, prefix := range prefixes {
+		family := IPPrefix(prefix).Family()
+		// Ahead of the NAT of the CT table.
+		if err := a.addFlow(&ovs.Flow{
+			Priority: 0x9000,
+			Cookie:   cookie,
+			Table:    TableCt,
+			Protocol: family.Protocol,
+			Matches: []ovs.Match{
+				ovs.FieldMatch("reg2", "0x0/0x1"),
+				family.Source(prefix),
+			},
+			Actions: []ovs.Action{
+				ovs.Load("0x1", RegQuota),
+				ovs.ConnectionTracking(fmt.Sprintf("commit,zone=%d,table=%d", limit.Zone, TableCt)),
+			},
+		}); err != nil {
+			return err
+		}
+		// Ahead of the rules of the PBR table.
+		if err := a.addFlow(&ovs.Flow{
+			Priority: 0x9000,
+			Cookie:   cookie,
+			Table:    TablePbr,
+			Protocol: family.Protocol,
+			Matches: []ovs.Match{
+				ovs.FieldMatch("reg2", "0x0/0x1"),
+				ovs.ConnectionTrackingState(
+					ovs.SetState(ovs.CTStateTracked),
+					ovs.SetState(ovs.CTStateReply),
+				),
+				family.Destination(prefix),
+			},
+			Actions: []ovs.Action{
+				ovs.Load("0x1", RegQuota),
+				ovs.ConnectionTracking(fmt.Sprintf("zone=%d,table=%d", limit.Zone, TablePbr)),
+			},
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Composer) setLimit(limit schema.ConntrackLimit) error {
+	log.Printf("Compose.setLimit: %+v", limit)
+	if _, err := a.client.DataPath.SetCTLimits(DataPath, map[string]uint64{
+		"zone":  uint64(limit.Zone),
+		"limit": limit.Limit,
+	}); err != nil {
+		log.Printf("Composer.setLimit: %v", err)
+		return err
+	}
+	if isQuota(limit) {
+		if err := a.addQuota(limit); err != nil {
+			return err
+		}
+	}
+	a.limits[limit.Zone] = limit
+	return nil
+}
+
+// SetLimit limits the sessions of a zone, or of a source prefix or VLAN
+// interface in a zone of their own, and returns it with its zone. The
+// limit of a quota covers the whole prefix, not each of its hosts.
+func (a *Composer) SetLimit(limit schema.ConntrackLimit) (schema.ConntrackLimit, error) {
+	limit.Count = 0
+	if limit.Source != "" && limit.Interface != "" {
+		return limit, fmt.Errorf("quota of both a source and an interface")
+	}
+	if limit.Source != "" {
+		_, ipnet, err := net.ParseCIDR(limit.Source)
+		if err != nil {
+			return limit, err
+		}
+		limit.Source = ipnet.String()
+	}
+	if limit.Interface != "" && a.findVlanId(limit.Interface) == 0 {
+		return limit, fmt.Errorf("%s is not a VLAN interface", limit.Interface)
+	}
+	if isQuota(limit) {
+		if limit.Zone == ZoneNat {
+			return limit, fmt.Errorf("zone %d is the NAT zone", ZoneNat)
+		}
+		if zone := a.quotaZone(limit); limit.Zone == 0 {
+			limit.Zone = zone
+		} else if _, ok := a.limits[zone]; ok && zone != limit.Zone {
+			return limit, fmt.Errorf("quota already in zone %d", zone)
+		}
+	}
+	if old, ok := a.limits[limit.Zone]; ok && !sameQuota(old, limit) {
+		return limit, fmt.Errorf("zone %d already limited", limit.Zone)
+	}
+
+	if err := a.setLimit(limit); err != nil {
+		return limit, err
+	}
+	options := map[string]string{"limit": strconv.FormatUint(limit.Limit, 10)}
+	if limit.Source != "" {
+		options["source"] = limit.Source
+	}
+	if limit.Interface != "" {
+		options["interface"] = limit.Interface
+	}
+	a.vsctl.Set.Bridge(a.brname, ovs.BridgeOptions{
+		OtherConfig: map[string]string{limitKey(limit.Zone): EncodeOptions(options)},
+	})
+	return limit, nil
+}
+
+// DelLimit removes the limit of a zone, or the quota of a source prefix
+// or VLAN interface, whose sessions are flushed with their zone.
+func (a *Composer) DelLimit(limit schema.ConntrackLimit) error {
+	zone := limit.Zone
+	if isQuota(limit) {
+		if limit.Source != "" {
+			if _, ipnet, err := net.ParseCIDR(limit.Source); err == nil {
+				limit.Source = ipnet.String()
+			}
+		}
+		zone = a.quotaZone(limit)
+	}
+	old, ok := a.limits[zone]
+	if !ok {
+		return nil
+	}
+	log.Printf("Compose.DelLimit: %+v", old)
+
+	if _, err := a.client.DataPath.DelCTLimits(DataPath, []uint64{uint64(zone)}); err != nil {
+		log.Printf("Composer.DelLimit: %v", err)
+		return err
+	}
+	if isQuota(old) {
+		key := limitKey(zone)
+		if cookie, ok := a.cookies.Find(KindQuota, key); ok {
+			if err := a.delFlows(cookieMatch(cookie)); err != nil {
+				return err
+			}
+			a.cookies.Release(KindQuota, key)
+		}
+		if err := a.client.App.FlushConntrack(zone, nil); err != nil {
+			log.Printf("Composer.DelLimit: %v", err)
+		}
+	}
+	delete(a.limits, zone)
+	a.vsctl.RemoveBridge(a.brname, "other_config", limitKey(zone))
+	return nil
+}
+
+// ListLimits returns the limits with the sessions in their zones.
+func (a *Composer) ListLimits(count bool) []schema.ConntrackLimit {
+	var zones []uint64
+	for zone := range a.limits {
+		zones = append(zones, uint64(zone))
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i] < zones[j] })
+
+	counts := make(map[uint64]uint64)
+	if count && len(zones) > 0 {
+		if out, err := a.client.DataPath.GetCTLimits(DataPath, zones); err == nil {
+			for _, zone := range out.Zones() {
+				counts[zone["zone"]] = zone["count"]
+			}
+		} else {
+			log.Printf("Composer.ListLimits: %v", err)
+		}
+	}
+
+	var items []schema.ConntrackLimit
+	for _, zone := range zones {
+		item := a.limits[int(zone)]
+		item.Count = counts[zone]
+		items = append(items, item)
+	}
+	return items
+}
+
+// restoreLimit sets again a limit of the other_config of the bridge.
+func (a *Composer) restoreLimit(key, value string) {
+	zone, err := strconv.Atoi(strings.TrimPrefix(key, "ctlimit-"))
+	if err != nil {
+		return
+	}
+	options := DecodeOptions(value)
+	limit, err := strconv.ParseUint(options["limit"], 10, 64)
+	if err != nil {
+		return
+	}
+	a.setLimit(schema.ConntrackLimit{
+		Zone:      zone,
+		Source:    options["source"],
+		Interface: options["interface"],
+		Limit:     limit,
+	})
+}
+
+// AddSubnet follows a connected prefix of a VLAN interface in its quota.
+func (a *Composer) AddSubnet(vlanif, prefix string) error {
+	if slices.Contains(a.subnets[vlanif], prefix) {
+		return nil
+	}
+	a.subnets[vlanif] = append(a.subnets[vlanif], prefix)
+	return a.requota(vlanif)
+}
+
+func (a *Composer) DelSubnet(vlanif, prefix string) error {
+	i := slices.Index(a.subnets[vlanif], prefix)
+	if i < 0 {
+		return nil
+	}
+	a.subnets[vlanif] = slices.Delete(a.subnets[vlanif], i, i+1)
+	return a.requota(vlanif)
+}
+
+// requota programs again the quota of a VLAN interface.
+func (a *Composer) requota(vlanif string) error {
+	for _, limit := range a.limits {
+		if limit.Interface == vlanif {
+			return a.addQuota(limit)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package vrr
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/luscis/openvrr/pkg/schema"
+)
+
+func TestComposerAddQuota(t *testing.T) {
+	a := newTestComposer(t)
+	a.subnets["vlan10"] = []string{"192.168.1.0/24", "fd00::/64"}
+	limit := schema.ConntrackLimit{Interface: "vlan10", Zone: 1000, Limit: 100}
+	if err := a.addQuota(limit); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got []string
+	for _, flow := range a.flows {
+		b, err := flow.MarshalText()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// The cookie is a hash of the zone.
+		text := string(b)
+		got = append(got, text[:strings.Index(text, ",cookie=")]+text[strings.Index(text, ",actions="):])
+	}
+	sort.Strings(got)
+	want := []string{
+		// Committed on the source before SNAT.
+		"priority=36864,ip,reg2=0x0/0x1,nw_src=192.168.1.0/24,table=10,idle_timeout=0,actions=load:0x1->NXM_NX_REG2[0],ct(commit,zone=1000,table=10)",
+		// The replies once translated back, not committed.
+		"priority=36864,ip,reg2=0x0/0x1,ct_state=+trk+rpl,nw_dst=192.168.1.0/24,table=15,idle_timeout=0,actions=load:0x1->NXM_NX_REG2[0],ct(zone=1000,table=15)",
+		"priority=36864,ipv6,reg2=0x0/0x1,ipv6_src=fd00::/64,table=10,idle_timeout=0,actions=load:0x1->NXM_NX_REG2[0],ct(commit,zone=1000,table=10)",
+		"priority=36864,ipv6,reg2=0x0/0x1,ct_state=+trk+rpl,ipv6_dst=fd00::/64,table=15,idle_timeout=0,actions=load:0x1->NXM_NX_REG2[0],ct(zone=1000,table=15)",
+	}
+	sort.Strings(want)
+	if strings.Join(want, "\n") != strings.Join(got, "\n") {
+		t.Fatalf("unexpected flows:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// Programmed again with a subnet less.
+	a.subnets["vlan10"] = a.subnets["vlan10"][:1]
+	if err := a.addQuota(limit); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want, got := 2, len(a.flows); want != got {
+		t.Fatalf("unexpected flows:\n- want: %v\n-  got: %v", want, got)
+	}
+}
@@ -0,0 +1,80 @@
+package vrr
+
+import (
+	"log"
+	"net"
+	"slices"
+
+	"github.com/luscis/openvrr/pkg/ovs"
+	"github.com/luscis/openvrr/pkg/schema"
+)
+
+// masqAddr returns the address a VLAN interface masquerades to, its
+// first one.
+func (a *Composer) masqAddr(vlanif string) string {
+	if addrs := a.addrs[vlanif]; len(addrs) > 0 {
+		return addrs[0]
+	}
+	return ""
+}
+
+// masquerade translates the source of a rule to the address of its VLAN
+// interface, and drops its new sessions while the interface has none, so
+// none goes out untranslated.
+func (a *Composer) masquerade(data schema.SNAT) error {
+	if data.SourceTo = a.masqAddr(data.Interface); data.SourceTo != "" {
+		return a.addSNAT(data)
+	}
+	log.Printf("Compose.masquerade: %s without address for %s", data.Interface, data.Source)
+	// In place of the flow of the rule, under its cookie.
+	flow := a.snatFlow(data.Source)
+	flow.Actions = []ovs.Action{ovs.Drop()}
+	return a.addFlow(flow)
+}
+
+// remasquerade rewrites the SNAT rules masquerading behind a VLAN
+// interface, and reports whether there are any.
+func (a *Composer) remasquerade(vlanif string) bool {
+	found := false
+	for key, value := range a.ListSNAT() {
+		if data := snatRule(key, value); data.Interface == vlanif {
+			a.masquerade(data)
+			found = true
+		}
+	}
+	return found
+}
+
+// AddAddress follows an IPv4 address of a VLAN interface in the SNAT
+// rules masquerading behind it.
+func (a *Composer) AddAddress(vlanif string, ip net.IP) {
+	if ip.To4() == nil {
+		return
+	}
+	addr := ip.String()
+	if slices.Contains(a.addrs[vlanif], addr) {
+		return
+	}
+	a.addrs[vlanif] = append(a.addrs[vlanif], addr)
+	a.remasquerade(vlanif)
+}
+
+// DelAddress moves the SNAT rules masquerading behind a VLAN interface to
+// its next address, the sessions translated to the old one are flushed.
+func (a *Composer) DelAddress(vlanif string, ip net.IP) {
+	addr := ip.String()
+	i := slices.Index(a.addrs[vlanif], addr)
+	if i < 0 {
+		return
+	}
+	a.addrs[vlanif] = slices.Delete(a.addrs[vlanif], i, i+1)
+	if !a.remasquerade(vlanif) {
+		return
+	}
+
+	// All at once by their replies, those from the old address are gone
+	// with it too.
+	if err := a.client.App.FlushConntrackReply(ZoneNat, addr); err != nil {
+		log.Printf("Composer.DelAddress: %v", err)
+	}
+}
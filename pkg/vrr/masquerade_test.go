@@ -0,0 +1,58 @@
+package vrr
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/luscis/openvrr/pkg/ovs"
+	"github.com/luscis/openvrr/pkg/schema"
+)
+
+func TestComposerMasquerade(t *testing.T) {
+	var calls [][]string
+	a := newTestComposer(t, ovs.Exec(func(cmd string, args ...string) ([]byte, error) {
+		calls = append(calls, args)
+		return nil, nil
+	}))
+	data := schema.SNAT{Source: "192.168.2.0/24", Interface: "vlan11"}
+	a.others[snatKey(data.Source)] = snatValue(data)
+	actions := func() string {
+		if want, got := 1, len(a.flows); want != got {
+			t.Fatalf("unexpected flows:\n- want: %v\n-  got: %v", want, got)
+		}
+		for _, flow := range a.flows {
+			b, err := flow.MarshalText()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			_, actions, _ := strings.Cut(string(b), "actions=")
+			return actions
+		}
+		return ""
+	}
+
+	// No session goes out untranslated.
+	if err := a.masquerade(data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want, got := "drop", actions(); want != got {
+		t.Fatalf("unexpected actions:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	a.AddAddress("vlan11", net.ParseIP("10.10.10.1"))
+	if want, got := "ct(commit,nat(src=10.10.10.1),zone=10,table=15)", actions(); want != got {
+		t.Fatalf("unexpected actions:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	// The sessions to the old address are flushed in one call.
+	a.DelAddress("vlan11", net.ParseIP("10.10.10.1"))
+	if want, got := "drop", actions(); want != got {
+		t.Fatalf("unexpected actions:\n- want: %v\n-  got: %v", want, got)
+	}
+	want := [][]string{{"dpctl/flush-conntrack", "zone=10", "", "ct_nw_dst=10.10.10.1"}}
+	if !reflect.DeepEqual(want, calls) {
+		t.Fatalf("unexpected calls:\n- want: %v\n-  got: %v", want, calls)
+	}
+}
@@ -0,0 +1,240 @@
+package vrr
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/luscis/openvrr/pkg/ovs"
+	"github.com/luscis/openvrr/pkg/schema"
+)
+
+// metrics builds the families exported on /metrics, the samples of a
+// family are in the order they are added.
+type metrics struct {
+	items []schema.Metric
+	index map[string]int
+}
+
+func (m *metrics) add(name, typ, help string, sample schema.Sample, labels ...string) {
+	if m.index == nil {
+		m.index = make(map[string]int)
+	}
+	name = "openvrr_" + name
+	i, ok := m.index[name]
+	if !ok {
+		i = len(m.items)
+		m.index[name] = i
+		m.items = append(m.items, schema.Metric{Name: name, Help: help, Type: typ})
+	}
+
+	if len(labels) > 0 {
+		sample.Labels = make(map[string]string)
+		for j := 0; j+1 < len(labels); j += 2 {
+			sample.Labels[labels[j]] = labels[j+1]
+		}
+	}
+	m.items[i].Samples = append(m.items[i].Samples, sample)
+}
+
+func (m *metrics) gauge(name, help string, value float64, labels ...string) {
+	m.add(name, "gauge", help, schema.Sample{Value: value}, labels...)
+}
+
+func (m *metrics) counter(name, help string, value float64, labels ...string) {
+	m.add(name, "counter", help, schema.Sample{Value: value}, labels...)
+}
+
+func (m *metrics) summary(name, help string, sum float64, count uint64) {
+	m.add(name, "summary", help, schema.Sample{Suffix: "_sum", Value: sum})
+	m.add(name, "summary", help, schema.Sample{Suffix: "_count", Value: float64(count)})
+}
+
+// tableStats exports the lookups of the tables and the traffic of the
+// flows in those of the pipeline.
+func (a *Composer) tableStats(m *metrics) {
+	tables, err := a.ofctl.DumpTables(a.brname)
+	if err != nil {
+		log.Printf("Composer.tableStats: %v", err)
+	}
+	for _, table := range tables {
+		id := fmt.Sprint(table.ID)
+		m.gauge("table_active_flows", "Flows in a table.", float64(table.Active), "table", id)
+		m.counter("table_lookups_total", "Packets looked up in a table.", float64(table.Lookup), "table", id)
+		m.counter("table_matched_total", "Packets matching a flow of a table.", float64(table.Matched), "table", id)
+	}
+
+	for _, table := range tables {
+		if !pipeline(table.ID) {
+			continue
+		}
+		stats, err := a.ofctl.DumpAggregate(a.brname, &ovs.MatchFlow{Table: table.ID})
+		if err != nil {
+			log.Printf("Composer.tableStats: %v", err)
+			continue
+		}
+		id := fmt.Sprint(table.ID)
+		m.counter("table_packets_total", "Packets through the flows of a table.", float64(stats.PacketCount), "table", id)
+		m.counter("table_bytes_total", "Bytes through the flows of a table.", float64(stats.ByteCount), "table", id)
+	}
+}
+
+func pipeline(id int) bool {
+	for _, table := range tables {
+		if table == id {
+			return true
+		}
+	}
+	return false
+}
+
+// portStats exports the counters of the ports by their name.
+func (a *Composer) portStats(m *metrics) {
+	names := make(map[int]string)
+	if ports, err := a.listPorts(); err == nil {
+		for _, port := range ports {
+			names[port.OfPort] = port.Name
+		}
+	}
+
+	stats, err := a.ofctl.DumpPorts(a.brname)
+	if err != nil {
+		log.Printf("Composer.portStats: %v", err)
+		return
+	}
+	for _, port := range stats {
+		name, ok := names[port.PortID]
+		if !ok {
+			name = fmt.Sprint(port.PortID)
+		}
+		rx, tx := port.Received, port.Transmitted
+		m.counter("port_rx_packets_total", "Packets received on a port.", float64(rx.Packets), "port", name)
+		m.counter("port_rx_bytes_total", "Bytes received on a port.", float64(rx.Bytes), "port", name)
+		m.counter("port_rx_dropped_total", "Packets dropped on receive on a port.", float64(rx.Dropped), "port", name)
+		m.counter("port_rx_errors_total", "Receive errors on a port.", float64(rx.Errors), "port", name)
+		m.counter("port_tx_packets_total", "Packets transmitted on a port.", float64(tx.Packets), "port", name)
+		m.counter("port_tx_bytes_total", "Bytes transmitted on a port.", float64(tx.Bytes), "port", name)
+		m.counter("port_tx_dropped_total", "Packets dropped on transmit on a port.", float64(tx.Dropped), "port", name)
+		m.counter("port_tx_errors_total", "Transmit errors on a port.", float64(tx.Errors), "port", name)
+	}
+}
+
+// A natRule is a NAT rule exported by the cookie of its flows.
+type natRule struct {
+	typ    string
+	rule   string
+	cookie uint64
+}
+
+// natRules returns the NAT rules with their cookies, which are dumped
+// out of the lock of the Gateway.
+func (a *Composer) natRules() []natRule {
+	var keys []string
+	for key := range a.others {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var rules []natRule
+	for _, key := range keys {
+		var kind uint64
+		var typ, rule string
+		if short, found := strings.CutPrefix(key, "snat-"); found {
+			kind, typ, rule = KindSNAT, "snat", SplitSNAT(short)
+		} else if short, found := strings.CutPrefix(key, "dnat-"); found {
+			protocol, dest := SplitDNAT(short)
+			kind, typ, rule = KindDNAT, "dnat", protocol+"/"+dest
+		} else if external, found := strings.CutPrefix(key, "staticnat-"); found {
+			kind, typ, rule = KindStatic, "static", external
+		} else {
+			continue
+		}
+
+		cookie, _ := a.cookies.Find(kind, key)
+		rules = append(rules, natRule{typ: typ, rule: rule, cookie: cookie})
+	}
+	return rules
+}
+
+// natStats exports the traffic through the flows of each NAT rule, by
+// the cookie of the rule.
+func (a *Composer) natStats(m *metrics, rules []natRule) {
+	for _, rule := range rules {
+		stats := &ovs.FlowStats{}
+		if rule.cookie != 0 {
+			var err error
+			stats, err = a.ofctl.DumpAggregate(a.brname, cookieMatch(rule.cookie))
+			if err != nil {
+				log.Printf("Composer.natStats: %v", err)
+				continue
+			}
+		}
+		m.counter("nat_rule_packets_total", "Packets through the flows of a NAT rule.",
+			float64(stats.PacketCount), "type", rule.typ, "rule", rule.rule)
+		m.counter("nat_rule_bytes_total", "Bytes through the flows of a NAT rule.",
+			float64(stats.ByteCount), "type", rule.typ, "rule", rule.rule)
+	}
+}
+
+// ListMetrics returns the metrics of the gateway, which are read from
+// the bridge on each call.
+func (v *Gateway) ListMetrics() ([]schema.Metric, error) {
+	m := &metrics{}
+
+	queue := v.scomo.queue.Stats()
+	total, batches := v.scomo.queue.Latency()
+	m.gauge("flow_queue_depth", "Updates of flows waiting in the queue.", float64(queue.Depth))
+	m.counter("flow_queue_updates_total", "Updates of flows committed by the queue.", float64(queue.Flows))
+	m.counter("flow_queue_coalesced_total", "Updates of flows replaced in the queue.", float64(queue.Coalesced))
+	m.gauge("flow_queue_failed", "Flows whose last commit failed.", float64(queue.Failed))
+	m.summary("flow_commit_seconds", "Time to commit a batch of the queue.", total.Seconds(), batches)
+
+	for kind, count := range v.kernel.Events() {
+		m.counter("netlink_events_total", "Events received from netlink.", float64(count), "kind", kind)
+	}
+	sortSamples(m, "openvrr_netlink_events_total", "kind")
+
+	// The state is read under the lock, the bridge is dumped after.
+	v.mutex.RLock()
+	m.counter("flow_errors_total", "Flows failed to be programmed.", float64(queue.Errors), "path", "queue")
+	m.counter("flow_errors_total", "Flows failed to be programmed.", float64(v.scomo.fails), "path", "direct")
+
+	routes := make(map[int]int)
+	for _, value := range v.forward {
+		if value.LLAddr == "" {
+			routes[value.Table]++
+		}
+	}
+	var vrfs []int
+	for vrf := range routes {
+		vrfs = append(vrfs, vrf)
+	}
+	sort.Ints(vrfs)
+	for _, vrf := range vrfs {
+		m.gauge("routes", "Routes in the forwarding table.", float64(routes[vrf]), "table", fmt.Sprint(vrf))
+	}
+	m.gauge("hosts", "Resolved neighbors.", float64(len(v.neighbors)))
+
+	snat, dnat := v.scomo.ListSNAT(), v.scomo.ListDNAT()
+	m.gauge("nat_rules", "NAT rules configured.", float64(len(snat)), "type", "snat")
+	m.gauge("nat_rules", "NAT rules configured.", float64(len(dnat)), "type", "dnat")
+	m.gauge("nat_rules", "NAT rules configured.", float64(len(v.scomo.ListStaticNAT(false))), "type", "static")
+	rules := v.scomo.natRules()
+	v.mutex.RUnlock()
+
+	v.scomo.tableStats(m)
+	v.scomo.portStats(m)
+	v.scomo.natStats(m, rules)
+	return m.items, nil
+}
+
+// sortSamples orders the samples of a family by a label.
+func sortSamples(m *metrics, name, label string) {
+	if i, ok := m.index[name]; ok {
+		samples := m.items[i].Samples
+		sort.Slice(samples, func(x, y int) bool {
+			return samples[x].Labels[label] < samples[y].Labels[label]
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package vrr
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/luscis/openvrr/pkg/schema"
+)
+
+// An Endpoint is the address, or range of addresses, and the port, or
+// range of ports, of a NAT rule as in a[-b][:p[-q]].
+type Endpoint struct {
+	Addr    string
+	AddrMax string
+	Port    uint16
+	PortMax uint16
+}
+
+func parsePort(data string) (uint16, error) {
+	port, err := strconv.ParseUint(data, 10, 16)
+	if err != nil || port == 0 {
+		return 0, fmt.Errorf("invalid port: %s", data)
+	}
+	return uint16(port), nil
+}
+
+// parsePorts parses a port or a range of ports p[-q], the last one is 0
+// without a range.
+func parsePorts(data string) (uint16, uint16, error) {
+	lo, hi, ranged := strings.Cut(data, "-")
+	first, err := parsePort(lo)
+	if err != nil || !ranged {
+		return first, 0, err
+	}
+	last, err := parsePort(hi)
+	if err != nil || last < first {
+		return 0, 0, fmt.Errorf("invalid port range: %s", data)
+	}
+	return first, last, nil
+}
+
+// ParseEndpoint parses an IPv4 endpoint, its ports are ignored for ICMP.
+func ParseEndpoint(protocol, data string) (Endpoint, error) {
+	var ep Endpoint
+	addrs, ports, found := strings.Cut(data, ":")
+	ep.Addr, ep.AddrMax, _ = strings.Cut(addrs, "-")
+	first := net.ParseIP(ep.Addr).To4()
+	if first == nil {
+		return ep, fmt.Errorf("invalid address: %s", data)
+	}
+	if ep.AddrMax != "" {
+		last := net.ParseIP(ep.AddrMax).To4()
+		if last == nil || bytes.Compare(first, last) > 0 {
+			return ep, fmt.Errorf("invalid address range: %s", addrs)
+		}
+	}
+	if !found || protocol == "icmp" {
+		return ep, nil
+	}
+
+	var err error
+	ep.Port, ep.PortMax, err = parsePorts(ports)
+	return ep, err
+}
+
+// parseDest parses the destination of a DNAT rule, an address with a
+// port or a range of ports for TCP and UDP.
+func parseDest(protocol, data string) (Endpoint, error) {
+	ep, err := ParseEndpoint(protocol, data)
+	if err != nil {
+		return ep, err
+	}
+	if ep.AddrMax != "" || (protocol == "ip" && ep.Port != 0) {
+		return ep, fmt.Errorf("invalid destination: %s", data)
+	}
+	if (protocol == "tcp" || protocol == "udp") && ep.Port == 0 {
+		return ep, fmt.Errorf("invalid destination: %s", data)
+	}
+	return ep, nil
+}
+
+// parseTo parses an address a DNAT rule translates to, with the port the
+// destination ports are moved to, or kept without it.
+func parseTo(protocol, data string) (Endpoint, error) {
+	ep, err := ParseEndpoint(protocol, data)
+	if err != nil {
+		return ep, err
+	}
+	if ep.AddrMax != "" || ep.PortMax != 0 || (protocol == "ip" && ep.Port != 0) {
+		return ep, fmt.Errorf("invalid destination to: %s", data)
+	}
+	return ep, nil
+}
+
+// String formats an endpoint as in the nat action.
+func (e Endpoint) String() string {
+	value := e.Addr
+	if e.AddrMax != "" {
+		value += "-" + e.AddrMax
+	}
+	if e.Port > 0 {
+		value += fmt.Sprintf(":%d", e.Port)
+	}
+	if e.PortMax > 0 {
+		value += fmt.Sprintf("-%d", e.PortMax)
+	}
+	return value
+}
+
+// Keys of the NAT rules in the other_config of the bridge.
+func snatKey(source string) string {
+	return "snat-" + strings.Replace(source, "/", "-", 1)
+}
+
+func dnatKey(data schema.DNAT) string {
+	dest := data.Dest
+	if data.Protocol == "icmp" {
+		dest, _, _ = strings.Cut(dest, ":")
+	}
+	return "dnat-" + data.Protocol + "-" + strings.Replace(dest, ":", "-", 1)
+}
+
+func SplitDNAT(key string) (string, string) {
+	values := strings.SplitN(key, "-", 2)
+	protocol, dest := values[0], values[1]
+	return protocol, strings.Replace(dest, "-", ":", 1)
+}
+
+func SplitSNAT(key string) string {
+	return strings.Replace(key, "-", "/", 1)
+}
+
+// snatRule returns a SNAT rule of its key and value in the other_config.
+func snatRule(short, value string) schema.SNAT {
+	data := schema.SNAT{Source: SplitSNAT(short)}
+	if !strings.Contains(value, "=") {
+		data.SourceTo = value
+		return data
+	}
+	options := DecodeOptions(value)
+	data.SourceTo = options["to"]
+	data.Interface = options["interface"]
+	data.Ports = options["ports"]
+	data.Mode = options["mode"]
+	data.Persistent = options["persistent"] == "true"
+	return data
+}
+
+// snatValue returns the value of a SNAT rule in the other_config, a rule
+// to a single address keeps it as is, only the interface of a masquerade
+// is kept.
+func snatValue(data schema.SNAT) string {
+	if data.Interface == "" && data.Ports == "" && data.Mode == "" && !data.Persistent {
+		return data.SourceTo
+	}
+	options := make(map[string]string)
+	for key, value := range map[string]string{
+		"to":        data.SourceTo,
+		"interface": data.Interface,
+		"ports":     data.Ports,
+		"mode":      data.Mode,
+	} {
+		if value != "" {
+			options[key] = value
+		}
+	}
+	if data.Persistent {
+		options["persistent"] = "true"
+	}
+	return EncodeOptions(options)
+}
+
+// checkSNAT validates the source, ports and mode of a SNAT rule.
+func checkSNAT(data schema.SNAT) error {
+	if _, _, err := net.ParseCIDR(data.Source); err != nil && net.ParseIP(data.Source).To4() == nil {
+		return fmt.Errorf("invalid source: %s", data.Source)
+	}
+	if data.Ports != "" {
+		if _, _, err := parsePorts(data.Ports); err != nil {
+			return err
+		}
+	}
+	switch data.Mode {
+	case "", "random", "hash":
+		return nil
+	}
+	return fmt.Errorf("unknown mode %q", data.Mode)
+}
+
+// snatNat returns the arguments of the nat action of a SNAT rule, whose
+// address to is known.
+func snatNat(data schema.SNAT) (string, error) {
+	if err := checkSNAT(data); err != nil {
+		return "", err
+	}
+	to, err := ParseEndpoint("ip", data.SourceTo)
+	if err != nil || strings.Contains(data.SourceTo, ":") {
+		return "", fmt.Errorf("invalid source to: %s", data.SourceTo)
+	}
+	to.Port, to.PortMax, _ = parsePorts(data.Ports)
+	nat := "src=" + to.String()
+	if data.Mode != "" {
+		nat += "," + data.Mode
+	}
+	if data.Persistent {
+		nat += ",persistent"
+	}
+	return nat, nil
+}
+
+// dnatRule returns a DNAT rule of its key and value in the other_config.
+func dnatRule(short, value string) schema.DNAT {
+	protocol, dest := SplitDNAT(short)
+	data := schema.DNAT{Protocol: protocol, Dest: dest}
+	if !strings.Contains(value, "=") {
+		data.DestTo = value
+		return data
+	}
+	options := DecodeOptions(value)
+	for _, item := range strings.Fields(options["backends"]) {
+		addr, weight, _ := strings.Cut(item, "@")
+		backend := schema.Backend{Address: addr}
+		backend.Weight, _ = strconv.Atoi(weight)
+		data.Backends = append(data.Backends, backend)
+	}
+	data.Check = options["check"]
+	data.CheckPath = options["path"]
+	return data
+}
+
+// dnatValue returns the value of a DNAT rule in the other_config, a rule
+// to a single destination keeps it as is.
+func dnatValue(data schema.DNAT) string {
+	if len(data.Backends) == 0 {
+		return data.DestTo
+	}
+	var backends []string
+	for _, backend := range data.Backends {
+		item := backend.Address
+		if backend.Weight > 0 {
+			item += "@" + strconv.Itoa(backend.Weight)
+		}
+		backends = append(backends, item)
+	}
+	options := map[string]string{"backends": strings.Join(backends, " ")}
+	if data.Check != "" {
+		options["check"] = data.Check
+	}
+	if data.CheckPath != "" {
+		options["path"] = data.CheckPath
+	}
+	return EncodeOptions(options)
+}
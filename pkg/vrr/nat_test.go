@@ -0,0 +1,79 @@
+package vrr
+
+import (
+	"testing"
+)
+
+func TestParseDest(t *testing.T) {
+	tests := []struct {
+		protocol string
+		data     string
+		want     Endpoint
+		err      bool
+	}{
+		{protocol: "tcp", data: "10.0.0.1:80", want: Endpoint{Addr: "10.0.0.1", Port: 80}},
+		{protocol: "udp", data: "10.0.0.1:10000-10999", want: Endpoint{Addr: "10.0.0.1", Port: 10000, PortMax: 10999}},
+		{protocol: "ip", data: "10.0.0.3", want: Endpoint{Addr: "10.0.0.3"}},
+		// The ports are ignored for ICMP.
+		{protocol: "icmp", data: "10.0.0.1:7", want: Endpoint{Addr: "10.0.0.1"}},
+		{protocol: "tcp", data: "10.0.0.1", err: true},
+		{protocol: "ip", data: "10.0.0.1:80", err: true},
+		{protocol: "tcp", data: "10.0.0.1-10.0.0.2:80", err: true},
+		{protocol: "tcp", data: "10.0.0.1:90-80", err: true},
+		{protocol: "tcp", data: "fd00::1", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.protocol+"/"+tt.data, func(t *testing.T) {
+			got, err := parseDest(tt.protocol, tt.data)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.want != got {
+				t.Fatalf("unexpected destination:\n- want: %v\n-  got: %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseTo(t *testing.T) {
+	tests := []struct {
+		protocol string
+		data     string
+		want     Endpoint
+		err      bool
+	}{
+		{protocol: "tcp", data: "192.168.1.2:8000", want: Endpoint{Addr: "192.168.1.2", Port: 8000}},
+		// The ports of the destination are kept.
+		{protocol: "tcp", data: "192.168.1.2", want: Endpoint{Addr: "192.168.1.2"}},
+		{protocol: "ip", data: "192.168.1.4", want: Endpoint{Addr: "192.168.1.4"}},
+		{protocol: "tcp", data: "192.168.1.2:8000-8010", err: true},
+		{protocol: "tcp", data: "192.168.1.2-192.168.1.3", err: true},
+		{protocol: "ip", data: "192.168.1.4:80", err: true},
+		{protocol: "tcp", data: "192.168.1.2:0", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.protocol+"/"+tt.data, func(t *testing.T) {
+			got, err := parseTo(tt.protocol, tt.data)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.want != got {
+				t.Fatalf("unexpected destination to:\n- want: %v\n-  got: %v", tt.want, got)
+			}
+		})
+	}
+}
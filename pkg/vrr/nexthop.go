@@ -0,0 +1,234 @@
+package vrr
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	UpdateNexthopAdd = unix.RTM_NEWNEXTHOP
+	UpdateNexthopDel = unix.RTM_DELNEXTHOP
+)
+
+const (
+	// rtaNhId is the attribute of a route holding its nexthop object.
+	rtaNhId = 30
+	// sizeofNhMsg is the size of the struct nhmsg header.
+	sizeofNhMsg = 8
+	// sizeofNhGrp is the size of the struct nexthop_grp entry.
+	sizeofNhGrp = 8
+)
+
+// A Nexthop is a kernel nexthop object, which is either a gateway on a
+// link or a group of other nexthop objects.
+type Nexthop struct {
+	ID        uint32
+	Family    int
+	LinkIndex int
+	Gw        net.IP
+	Blackhole bool
+	Group     []NexthopMember
+}
+
+// A NexthopMember is a nexthop object of a group.
+type NexthopMember struct {
+	ID     uint32
+	Weight int
+}
+
+// A Route is a kernel route with its nexthop object, which is not
+// decoded by netlink.
+type Route struct {
+	netlink.Route
+	NhId uint32
+}
+
+func deserializeNexthop(m []byte) (Nexthop, error) {
+	if len(m) < sizeofNhMsg {
+		return Nexthop{}, fmt.Errorf("nexthop message too short")
+	}
+	nh := Nexthop{
+		Family: int(m[0]),
+	}
+
+	attrs, err := nl.ParseRouteAttr(m[sizeofNhMsg:])
+	if err != nil {
+		return nh, err
+	}
+	native := nl.NativeEndian()
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case unix.NHA_ID:
+			nh.ID = native.Uint32(attr.Value[0:4])
+		case unix.NHA_OIF:
+			nh.LinkIndex = int(native.Uint32(attr.Value[0:4]))
+		case unix.NHA_GATEWAY:
+			nh.Gw = net.IP(attr.Value)
+		case unix.NHA_BLACKHOLE:
+			nh.Blackhole = true
+		case unix.NHA_GROUP:
+			for v := attr.Value; len(v) >= sizeofNhGrp; v = v[sizeofNhGrp:] {
+				// The kernel keeps the weight minus one.
+				nh.Group = append(nh.Group, NexthopMember{
+					ID:     native.Uint32(v[0:4]),
+					Weight: int(v[4]) + 1,
+				})
+			}
+		}
+	}
+	return nh, nil
+}
+
+// deserializeRoute decodes the attributes of a route used by the
+// gateway, including the nexthop object.
+func deserializeRoute(m []byte) (Route, error) {
+	msg := nl.DeserializeRtMsg(m)
+	attrs, err := nl.ParseRouteAttr(m[msg.Len():])
+	if err != nil {
+		return Route{}, err
+	}
+	route := Route{
+		Route: netlink.Route{
+			Scope:    netlink.Scope(msg.Scope),
+			Protocol: netlink.RouteProtocol(int(msg.Protocol)),
+			Table:    int(msg.Table),
+			Type:     int(msg.Type),
+			Tos:      int(msg.Tos),
+			Flags:    int(msg.Flags),
+			Family:   int(msg.Family),
+		},
+	}
+
+	native := nl.NativeEndian()
+	for _, attr := range attrs {
+		switch attr.Attr.Type {
+		case unix.RTA_GATEWAY:
+			route.Gw = net.IP(attr.Value)
+		case unix.RTA_PREFSRC:
+			route.Src = net.IP(attr.Value)
+		case unix.RTA_DST:
+			route.Dst = &net.IPNet{
+				IP:   attr.Value,
+				Mask: net.CIDRMask(int(msg.Dst_len), 8*len(attr.Value)),
+			}
+		case unix.RTA_OIF:
+			route.LinkIndex = int(native.Uint32(attr.Value[0:4]))
+		case unix.RTA_PRIORITY:
+			route.Priority = int(native.Uint32(attr.Value[0:4]))
+		case unix.RTA_TABLE:
+			route.Table = int(native.Uint32(attr.Value[0:4]))
+		case rtaNhId:
+			route.NhId = native.Uint32(attr.Value[0:4])
+		case unix.RTA_MULTIPATH:
+			paths, err := deserializeMultiPath(attr.Value)
+			if err != nil {
+				return route, err
+			}
+			route.MultiPath = paths
+		}
+	}
+
+	// The default route has no destination.
+	if route.Dst == nil {
+		switch msg.Family {
+		case unix.AF_INET:
+			route.Dst = &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 8*net.IPv4len)}
+		case unix.AF_INET6:
+			route.Dst = &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 8*net.IPv6len)}
+		}
+	}
+	return route, nil
+}
+
+func deserializeMultiPath(value []byte) ([]*netlink.NexthopInfo, error) {
+	var paths []*netlink.NexthopInfo
+	for len(value) >= unix.SizeofRtNexthop {
+		nh := nl.DeserializeRtNexthop(value)
+		size := int(nh.RtNexthop.Len)
+		if size < unix.SizeofRtNexthop || len(value) < size {
+			return nil, fmt.Errorf("multipath message too short")
+		}
+		info := &netlink.NexthopInfo{
+			LinkIndex: int(nh.RtNexthop.Ifindex),
+			Hops:      int(nh.RtNexthop.Hops),
+			Flags:     int(nh.RtNexthop.Flags),
+		}
+		attrs, err := nl.ParseRouteAttr(value[unix.SizeofRtNexthop:size])
+		if err != nil {
+			return nil, err
+		}
+		for _, attr := range attrs {
+			if attr.Attr.Type == unix.RTA_GATEWAY {
+				info.Gw = net.IP(attr.Value)
+			}
+		}
+		paths = append(paths, info)
+		// Entries are aligned to four bytes.
+		size = (size + unix.NLMSG_ALIGNTO - 1) &^ (unix.NLMSG_ALIGNTO - 1)
+		if size > len(value) {
+			break
+		}
+		value = value[size:]
+	}
+	return paths, nil
+}
+
+// dumpAt sends a dump request in the namespace and returns the payloads
+// of the messages received.
+func dumpAt(ns netns.NsHandle, proto int, header []byte) ([][]byte, error) {
+	s, err := nl.GetNetlinkSocketAt(ns, netns.None(), unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	req := nl.NewNetlinkRequest(proto, unix.NLM_F_DUMP)
+	req.AddRawData(header)
+	if err := s.Send(req); err != nil {
+		return nil, err
+	}
+
+	var res [][]byte
+	for {
+		msgs, _, err := s.Receive()
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			switch m.Header.Type {
+			case unix.NLMSG_DONE:
+				return res, nil
+			case unix.NLMSG_ERROR:
+				errno := int32(nl.NativeEndian().Uint32(m.Data[0:4]))
+				if errno == 0 {
+					return res, nil
+				}
+				return nil, syscall.Errno(-errno)
+			}
+			res = append(res, m.Data)
+		}
+	}
+}
+
+func NexthopListAt(ns netns.NsHandle) ([]Nexthop, error) {
+	msgs, err := dumpAt(ns, unix.RTM_GETNEXTHOP, make([]byte, sizeofNhMsg))
+	if err != nil {
+		return nil, err
+	}
+
+	var items []Nexthop
+	for _, m := range msgs {
+		nh, err := deserializeNexthop(m)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, nh)
+	}
+	return items, nil
+}
@@ -0,0 +1,271 @@
+package vrr
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/luscis/openvrr/pkg/ovs"
+	"github.com/luscis/openvrr/pkg/schema"
+)
+
+const (
+	// Window in which the updates of routes and hosts are gathered
+	// before they are committed.
+	QueueWindow = 50 * time.Millisecond
+	// Updates committed in one bundle at most, a full table is split.
+	QueueBatch = 4096
+)
+
+// A flowUpdate is the last update of a flow, which is added again or
+// deleted strictly.
+type flowUpdate struct {
+	flow   *ovs.Flow
+	delete bool
+	// Error of the last commit of the update.
+	err error
+}
+
+// A FlowQueue coalesces the updates of the flows of routes and hosts by
+// their key and commits them in bundles, so a flapping route or a table
+// dump is programmed at once and out of the lock of the Gateway.
+type FlowQueue struct {
+	brname string
+	ofctl  *ovs.OpenFlowService
+	window time.Duration
+	batch  int
+	mutex  sync.Mutex
+	keys   []string
+	items  map[string]*flowUpdate
+	// Updates whose last commit failed, until the flow is committed or
+	// dropped.
+	failed map[string]*flowUpdate
+	// Groups no flow refers to once the pending updates are committed.
+	groups []uint32
+	timer  *time.Timer
+	// A full batch is being committed.
+	full bool
+	// commit serializes the bundles.
+	commit sync.Mutex
+	stats  schema.FlowQueue
+	max    time.Duration
+	// Time spent committing all batches.
+	total time.Duration
+}
+
+func NewFlowQueue(brname string, ofctl *ovs.OpenFlowService) *FlowQueue {
+	return &FlowQueue{
+		brname: brname,
+		ofctl:  ofctl,
+		window: QueueWindow,
+		batch:  QueueBatch,
+		items:  make(map[string]*flowUpdate),
+		failed: make(map[string]*flowUpdate),
+	}
+}
+
+// Add queues a flow, which replaces the update pending for it.
+func (q *FlowQueue) Add(flow *ovs.Flow) {
+	q.push(flowKey(flow), &flowUpdate{flow: flow})
+}
+
+// Delete queues the removal of a flow.
+func (q *FlowQueue) Delete(flow *ovs.Flow) {
+	q.push(flowKey(flow), &flowUpdate{flow: flow, delete: true})
+}
+
+// Release queues the removal of groups after the flows using them.
+func (q *FlowQueue) Release(ids ...uint32) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.groups = append(q.groups, ids...)
+	q.schedule()
+}
+
+// Drop forgets the updates of the flows covered by a match, which are
+// deleted at once.
+func (q *FlowQueue) Drop(match *ovs.MatchFlow) {
+	// The flows of a bundle being committed are in place first.
+	q.commit.Lock()
+	defer q.commit.Unlock()
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	keys := q.keys[:0]
+	for _, key := range q.keys {
+		if match == nil || covers(match, q.items[key].flow) {
+			delete(q.items, key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	q.keys = keys
+	for key, update := range q.failed {
+		if match == nil || covers(match, update.flow) {
+			delete(q.failed, key)
+		}
+	}
+}
+
+// Err returns the error of the last commit of a flow, which is nil
+// once the flow is committed.
+func (q *FlowQueue) Err(key string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if update, ok := q.failed[key]; ok {
+		return update.err
+	}
+	return nil
+}
+
+func (q *FlowQueue) push(key string, update *flowUpdate) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if _, ok := q.items[key]; ok {
+		q.stats.Coalesced++
+	} else {
+		q.keys = append(q.keys, key)
+	}
+	q.items[key] = update
+	q.schedule()
+}
+
+// schedule commits the queue at the end of the window, or at once when
+// a batch is full. The mutex is held.
+func (q *FlowQueue) schedule() {
+	if q.full {
+		return
+	}
+	if len(q.keys) >= q.batch {
+		q.full = true
+		if q.timer != nil {
+			q.timer.Stop()
+			q.timer = nil
+		}
+		go q.Flush()
+		return
+	}
+	if q.timer == nil {
+		q.timer = time.AfterFunc(q.window, func() { q.Flush() })
+	}
+}
+
+// Flush commits the pending updates in one bundle, a batch at most, and
+// returns the errors of the flows and groups which failed.
+func (q *FlowQueue) Flush() error {
+	q.commit.Lock()
+	defer q.commit.Unlock()
+
+	q.mutex.Lock()
+	if q.timer != nil {
+		q.timer.Stop()
+		q.timer = nil
+	}
+	q.full = false
+	keys := q.keys[:min(len(q.keys), q.batch)]
+	var updates []*flowUpdate
+	for _, key := range keys {
+		updates = append(updates, q.items[key])
+		delete(q.items, key)
+	}
+	q.keys = append([]string(nil), q.keys[len(keys):]...)
+	// The groups go after the last flows using them.
+	var groups []uint32
+	if len(q.keys) == 0 {
+		groups, q.groups = q.groups, nil
+	} else {
+		q.schedule()
+	}
+	q.mutex.Unlock()
+
+	if len(updates) == 0 && len(groups) == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	var errs []error
+	if err := q.bundle(updates); err != nil {
+		log.Printf("FlowQueue.Flush: %v", err)
+		// One bad flow fails the bundle, so the others are
+		// committed one by one.
+		for _, update := range updates {
+			update.err = q.bundle([]*flowUpdate{update})
+			if update.err != nil {
+				log.Printf("FlowQueue.Flush: %v", update.err)
+				errs = append(errs, update.err)
+			}
+		}
+	}
+	if len(groups) > 0 {
+		if err := q.ofctl.DelGroups(q.brname, groups...); err != nil {
+			log.Printf("FlowQueue.Flush: %v", err)
+			errs = append(errs, err)
+		}
+	}
+	latency := time.Since(start)
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for _, update := range updates {
+		key := flowKey(update.flow)
+		if update.err == nil {
+			delete(q.failed, key)
+			continue
+		}
+		q.failed[key] = update
+		q.stats.Errors++
+	}
+	err := errors.Join(errs...)
+	if err != nil {
+		q.stats.LastError = err.Error()
+	}
+	q.stats.Batches++
+	q.stats.Flows += uint64(len(updates))
+	q.stats.Latency = latency.String()
+	q.total += latency
+	if latency > q.max {
+		q.max = latency
+		q.stats.MaxLatency = latency.String()
+	}
+	return err
+}
+
+func (q *FlowQueue) bundle(updates []*flowUpdate) error {
+	if len(updates) == 0 {
+		return nil
+	}
+	return q.ofctl.AddFlowBundle(q.brname, func(tx *ovs.FlowTransaction) error {
+		for _, update := range updates {
+			if update.delete {
+				tx.DeleteStrict(update.flow)
+			} else {
+				tx.Add(update.flow)
+			}
+		}
+		return tx.Commit()
+	})
+}
+
+// Stats returns the depth of the queue and the counters of the commits.
+func (q *FlowQueue) Stats() schema.FlowQueue {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	stats := q.stats
+	stats.Depth = len(q.keys)
+	stats.Failed = len(q.failed)
+	stats.Window = q.window.String()
+	return stats
+}
+
+// Latency returns the time spent committing and the number of batches.
+func (q *FlowQueue) Latency() (time.Duration, uint64) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return q.total, q.stats.Batches
+}
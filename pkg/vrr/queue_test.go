@@ -0,0 +1,224 @@
+package vrr
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/luscis/openvrr/pkg/ovs"
+)
+
+// fakeOfctl records the bundles piped to ovs-ofctl and fails the ones
+// with a flow to a bad destination.
+type fakeOfctl struct {
+	mutex   sync.Mutex
+	bad     string
+	bundles [][]string
+	groups  [][]string
+}
+
+func (f *fakeOfctl) queue(t *testing.T, batch int) *FlowQueue {
+	t.Helper()
+	c := ovs.New(
+		ovs.Exec(func(cmd string, args ...string) ([]byte, error) {
+			f.mutex.Lock()
+			defer f.mutex.Unlock()
+			f.groups = append(f.groups, args)
+			return nil, nil
+		}),
+		ovs.Pipe(func(stdin io.Reader, cmd string, args ...string) ([]byte, error) {
+			b, err := io.ReadAll(stdin)
+			if err != nil {
+				return nil, err
+			}
+			f.mutex.Lock()
+			defer f.mutex.Unlock()
+			if f.bad != "" && strings.Contains(string(b), f.bad) {
+				return nil, errors.New("ovs-ofctl: bad flow")
+			}
+			f.bundles = append(f.bundles, strings.Split(strings.TrimSpace(string(b)), "\n"))
+			return nil, nil
+		}),
+	)
+	q := NewFlowQueue("br0", c.OpenFlow)
+	// Only Flush or a full batch commits.
+	q.window = time.Hour
+	q.batch = batch
+	return q
+}
+
+func queueFlow(cookie uint64, dst string) *ovs.Flow {
+	return &ovs.Flow{
+		Priority: 100,
+		Cookie:   cookie,
+		Table:    TableRib,
+		Protocol: ovs.ProtocolIPv4,
+		Matches:  []ovs.Match{ovs.NetworkDestination(dst)},
+		Actions:  []ovs.Action{ovs.Resubmit(0, TableFib)},
+	}
+}
+
+func flowLines(t *testing.T, directive string, flows ...*ovs.Flow) []string {
+	t.Helper()
+	var lines []string
+	for _, flow := range flows {
+		if directive != "add" {
+			// A strict delete is the key of the flow.
+			lines = append(lines, directive+" "+flowKey(flow))
+			continue
+		}
+		b, err := flow.MarshalText()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		lines = append(lines, directive+" "+string(b))
+	}
+	return lines
+}
+
+func TestFlowQueueCoalesce(t *testing.T) {
+	f := &fakeOfctl{}
+	q := f.queue(t, QueueBatch)
+
+	route := queueFlow(0x1, "10.0.0.1")
+	host := queueFlow(0x2, "10.0.0.2")
+	q.Add(route)
+	q.Add(host)
+	q.Add(route)
+	q.Delete(host)
+	q.Release(7)
+	if err := q.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := [][]string{append(flowLines(t, "add", route), flowLines(t, "delete_strict", host)...)}
+	if !reflect.DeepEqual(want, f.bundles) {
+		t.Fatalf("unexpected bundles:\n- want: %v\n-  got: %v", want, f.bundles)
+	}
+	if want, got := 1, len(f.groups); want != got {
+		t.Fatalf("unexpected group deletes:\n- want: %v\n-  got: %v", want, got)
+	}
+	stats := q.Stats()
+	if want, got := uint64(2), stats.Coalesced; want != got {
+		t.Fatalf("unexpected coalesced:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := uint64(2), stats.Flows; want != got {
+		t.Fatalf("unexpected flows:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestFlowQueueDrop(t *testing.T) {
+	f := &fakeOfctl{}
+	q := f.queue(t, QueueBatch)
+
+	snat := uint64(KindSNAT<<CookieKindShift | 1)
+	route := queueFlow(0x1, "10.0.0.1")
+	q.Add(queueFlow(snat, "10.0.0.2"))
+	q.Add(route)
+	q.Delete(queueFlow(snat, "10.0.0.3"))
+	q.Drop(cookieMatch(snat))
+	if want, got := 1, q.Stats().Depth; want != got {
+		t.Fatalf("unexpected depth:\n- want: %v\n-  got: %v", want, got)
+	}
+	if err := q.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := [][]string{flowLines(t, "add", route)}
+	if !reflect.DeepEqual(want, f.bundles) {
+		t.Fatalf("unexpected bundles:\n- want: %v\n-  got: %v", want, f.bundles)
+	}
+
+	q.Add(route)
+	q.Drop(nil)
+	if err := q.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want, got := 1, len(f.bundles); want != got {
+		t.Fatalf("unexpected bundles:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestFlowQueueBatch(t *testing.T) {
+	f := &fakeOfctl{}
+	q := f.queue(t, 2)
+
+	flows := []*ovs.Flow{
+		queueFlow(0x1, "10.0.0.1"),
+		queueFlow(0x2, "10.0.0.2"),
+		queueFlow(0x3, "10.0.0.3"),
+	}
+	q.Release(7)
+	for _, flow := range flows {
+		q.Add(flow)
+	}
+	// The full batch is committed in the background, the rest by these.
+	for range 2 {
+		if err := q.Flush(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	want := [][]string{flowLines(t, "add", flows[:2]...), flowLines(t, "add", flows[2:]...)}
+	if !reflect.DeepEqual(want, f.bundles) {
+		t.Fatalf("unexpected bundles:\n- want: %v\n-  got: %v", want, f.bundles)
+	}
+	// The groups go after the last batch.
+	if want, got := 1, len(f.groups); want != got {
+		t.Fatalf("unexpected group deletes:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestFlowQueueErrors(t *testing.T) {
+	f := &fakeOfctl{bad: "nw_dst=10.0.0.2"}
+	q := f.queue(t, QueueBatch)
+
+	good := queueFlow(0x1, "10.0.0.1")
+	bad := queueFlow(0x2, "10.0.0.2")
+	q.Add(good)
+	q.Add(bad)
+	if err := q.Flush(); err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	// The bundle failed, the good flow is committed alone.
+	want := [][]string{flowLines(t, "add", good)}
+	if !reflect.DeepEqual(want, f.bundles) {
+		t.Fatalf("unexpected bundles:\n- want: %v\n-  got: %v", want, f.bundles)
+	}
+	if err := q.Err(flowKey(good)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := q.Err(flowKey(bad)); err == nil {
+		t.Fatalf("expected an error of %s", flowKey(bad))
+	}
+	stats := q.Stats()
+	if want, got := uint64(1), stats.Errors; want != got {
+		t.Fatalf("unexpected errors:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := 1, stats.Failed; want != got {
+		t.Fatalf("unexpected failed:\n- want: %v\n-  got: %v", want, got)
+	}
+	if stats.LastError == "" {
+		t.Fatalf("expected the last error")
+	}
+
+	// Committed again, the flow is no longer failed.
+	f.bad = ""
+	q.Add(bad)
+	if err := q.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := q.Err(flowKey(bad)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want, got := 0, q.Stats().Failed; want != got {
+		t.Fatalf("unexpected failed:\n- want: %v\n-  got: %v", want, got)
+	}
+}
@@ -0,0 +1,153 @@
+package vrr
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/luscis/openvrr/pkg/ovs"
+	"github.com/luscis/openvrr/pkg/schema"
+)
+
+func staticKey(external string) string {
+	return "staticnat-" + external
+}
+
+// checkStatic validates the addresses of a static NAT rule, the internal
+// host of which has a single external address.
+func (a *Composer) checkStatic(data schema.StaticNAT) error {
+	for _, addr := range []string{data.External, data.Internal} {
+		if _, err := ParseEndpoint("ip", addr); err != nil || strings.ContainsAny(addr, ":-") {
+			return fmt.Errorf("invalid address: %s", addr)
+		}
+	}
+	for key, value := range a.others {
+		external, found := strings.CutPrefix(key, "staticnat-")
+		if found && value == data.Internal && external != data.External {
+			return fmt.Errorf("%s already translated to %s", data.Internal, external)
+		}
+	}
+	return nil
+}
+
+// addStatic translates the destination of the sessions to the external
+// address to the internal one, and the source of the sessions from the
+// internal address to the external one. It is ahead of the SNAT rules,
+// and behind the DNAT ones for a port of the same address.
+func (a *Composer) addStatic(data schema.StaticNAT) error {
+	log.Printf("Compose.addStatic: %s <-> %s", data.External, data.Internal)
+	cookie := a.cookies.Get(KindStatic, staticKey(data.External))
+	state := ovs.ConnectionTrackingState(
+		ovs.SetState(ovs.CTStateTracked),
+		ovs.SetState(ovs.CTStateNew),
+	)
+	if err := a.addFlow(&ovs.Flow{
+		Priority: 155,
+		Cookie:   cookie,
+		Table:    TableNat,
+		Protocol: ovs.ProtocolIPv4,
+		Matches: []ovs.Match{
+			state,
+			ovs.NetworkDestination(data.External),
+		},
+		Actions: []ovs.Action{
+			ovs.ConnectionTracking(fmt.Sprintf("commit,nat(dst=%s),zone=%d,table=%d", data.Internal, ZoneNat, TablePbr)),
+		},
+	}); err != nil {
+		return err
+	}
+	if err := a.addFlow(&ovs.Flow{
+		Priority: 60,
+		Cookie:   cookie,
+		Table:    TableNat,
+		Protocol: ovs.ProtocolIPv4,
+		Matches: []ovs.Match{
+			state,
+			ovs.NetworkSource(data.Internal),
+		},
+		Actions: []ovs.Action{
+			ovs.ConnectionTracking(fmt.Sprintf("commit,nat(src=%s),zone=%d,table=%d", data.External, ZoneNat, TablePbr)),
+		},
+	}); err != nil {
+		return err
+	}
+	hairpin, err := hairpinFlows(cookie, "ip", data.External, data.Internal, portSpan{})
+	if err != nil {
+		return err
+	}
+	return a.addFlows(hairpin...)
+}
+
+// AddStaticNAT maps an external address to an internal host one to one,
+// for all protocols and in both directions.
+func (a *Composer) AddStaticNAT(data schema.StaticNAT) error {
+	if err := a.checkStatic(data); err != nil {
+		return err
+	}
+	key := staticKey(data.External)
+	if value, ok := a.others[key]; ok && value != data.Internal {
+		// The flows of the old internal host are replaced.
+		if err := a.delStatic(key); err != nil {
+			return err
+		}
+	}
+	err := a.addStatic(data)
+	if err == nil {
+		a.vsctl.Set.Bridge(a.brname, ovs.BridgeOptions{
+			OtherConfig: map[string]string{key: data.Internal},
+		})
+		a.others[key] = data.Internal
+	}
+	return err
+}
+
+func (a *Composer) delStatic(key string) error {
+	cookie, ok := a.cookies.Find(KindStatic, key)
+	if !ok {
+		return nil
+	}
+	if err := a.delFlows(cookieMatch(cookie)); err != nil {
+		return err
+	}
+	a.cookies.Release(KindStatic, key)
+	return nil
+}
+
+func (a *Composer) DelStaticNAT(data schema.StaticNAT) error {
+	log.Printf("Compose.DelStaticNAT: %s", data.External)
+
+	key := staticKey(data.External)
+	if _, ok := a.others[key]; !ok {
+		return nil
+	}
+	err := a.delStatic(key)
+	if err == nil {
+		a.vsctl.RemoveBridge(a.brname, "other_config", key)
+		delete(a.others, key)
+	}
+	return err
+}
+
+// ListStaticNAT returns the static NAT rules, with the traffic through
+// their flows if stats.
+func (a *Composer) ListStaticNAT(stats bool) []schema.StaticNAT {
+	var results []schema.StaticNAT
+	for key, value := range a.others {
+		external, found := strings.CutPrefix(key, "staticnat-")
+		if !found {
+			continue
+		}
+		item := schema.StaticNAT{External: external, Internal: value}
+		if stats {
+			if flows, err := a.ownerStats(KindStatic, key); err == nil {
+				item.Packets, item.Bytes = flows.PacketCount, flows.ByteCount
+			}
+		}
+		results = append(results, item)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].External < results[j].External
+	})
+	return results
+}
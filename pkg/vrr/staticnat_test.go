@@ -0,0 +1,85 @@
+package vrr
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/luscis/openvrr/pkg/schema"
+)
+
+func TestComposerStaticNAT(t *testing.T) {
+	tests := []struct {
+		desc   string
+		before []schema.StaticNAT
+		data   schema.StaticNAT
+		want   []string
+		err    bool
+	}{
+		{
+			desc: "rule",
+			data: schema.StaticNAT{External: "10.0.0.2", Internal: "192.168.1.11"},
+			want: []string{
+				"priority=155,ip,ct_state=+trk+new,nw_dst=10.0.0.2,table=12,idle_timeout=0,actions=ct(commit,nat(dst=192.168.1.11),zone=10,table=15)",
+				"priority=162,ip,ct_state=+trk+new,nw_dst=10.0.0.2,nw_src=192.168.1.11,table=12,idle_timeout=0,actions=ct(commit,nat(dst=192.168.1.11),zone=10),resubmit(,12)",
+				"priority=202,ip,ct_state=+trk+est,nw_dst=192.168.1.11,nw_src=192.168.1.11,table=12,idle_timeout=0,actions=ct_clear,resubmit(,10)",
+				"priority=202,ip,ct_state=+trk+rpl,nw_dst=192.168.1.11,nw_src=192.168.1.11,table=12,idle_timeout=0,actions=ct_clear,resubmit(,10)",
+				"priority=60,ip,ct_state=+trk+new,nw_src=192.168.1.11,table=12,idle_timeout=0,actions=ct(commit,nat(src=10.0.0.2),zone=10,table=15)",
+			},
+		},
+		{
+			// None of the flows of the old internal host are left.
+			desc:   "moved to another host",
+			before: []schema.StaticNAT{{External: "10.0.0.2", Internal: "192.168.1.11"}},
+			data:   schema.StaticNAT{External: "10.0.0.2", Internal: "192.168.1.12"},
+			want: []string{
+				"priority=155,ip,ct_state=+trk+new,nw_dst=10.0.0.2,table=12,idle_timeout=0,actions=ct(commit,nat(dst=192.168.1.12),zone=10,table=15)",
+				"priority=162,ip,ct_state=+trk+new,nw_dst=10.0.0.2,nw_src=192.168.1.12,table=12,idle_timeout=0,actions=ct(commit,nat(dst=192.168.1.12),zone=10),resubmit(,12)",
+				"priority=202,ip,ct_state=+trk+est,nw_dst=192.168.1.12,nw_src=192.168.1.12,table=12,idle_timeout=0,actions=ct_clear,resubmit(,10)",
+				"priority=202,ip,ct_state=+trk+rpl,nw_dst=192.168.1.12,nw_src=192.168.1.12,table=12,idle_timeout=0,actions=ct_clear,resubmit(,10)",
+				"priority=60,ip,ct_state=+trk+new,nw_src=192.168.1.12,table=12,idle_timeout=0,actions=ct(commit,nat(src=10.0.0.2),zone=10,table=15)",
+			},
+		},
+		{
+			desc:   "host already translated",
+			before: []schema.StaticNAT{{External: "10.0.0.2", Internal: "192.168.1.11"}},
+			data:   schema.StaticNAT{External: "10.0.0.3", Internal: "192.168.1.11"},
+			err:    true,
+		},
+		{
+			desc: "range of addresses",
+			data: schema.StaticNAT{External: "10.0.0.2-10.0.0.3", Internal: "192.168.1.11"},
+			err:  true,
+		},
+		{
+			desc: "address with a port",
+			data: schema.StaticNAT{External: "10.0.0.2", Internal: "192.168.1.11:80"},
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			a := newTestComposer(t)
+			for _, data := range tt.before {
+				if err := a.AddStaticNAT(data); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			if tt.err {
+				if err := a.AddStaticNAT(tt.data); err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			add := func(a *Composer) error {
+				return a.AddStaticNAT(tt.data)
+			}
+			if want, got := tt.want, addedFlows(t, a, add); !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected flows:\n- want: %v\n-  got: %v", want, got)
+			}
+			if want, got := len(tt.want), len(a.flows); want != got {
+				t.Fatalf("unexpected flows left:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
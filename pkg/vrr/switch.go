@@ -3,39 +3,74 @@ package vrr
 import (
 	"fmt"
 	"log"
+	"maps"
 	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 
 	"github.com/luscis/openvrr/pkg/ovs"
+	"github.com/luscis/openvrr/pkg/schema"
 	"github.com/vishvananda/netlink"
 	"github.com/vishvananda/netns"
 )
 
 const (
-	TableIn  = 0
-	TableCt  = 10
-	TableNat = 12
-	TableRib = 19
-	TableFib = 20
-	TableFdb = 30
+	TableIn   = 0
+	TableCt   = 10
+	TableNat  = 12
+	TablePbr  = 15
+	TableRib  = 19
+	TableFib  = 20
+	TableRib6 = 21
+	TableFib6 = 22
+	TableFdb  = 30
+	// TableWatch holds a flow never hit, which is lost when the
+	// vswitchd restarts.
+	TableWatch = 250
 )
 
 const (
-	CookieIn = 0x2021
+	CookieIn    = 0x2021
+	CookieVrf   = 0x2022
+	CookiePbr   = 0x2023
+	CookieWatch = 0x2024
 )
 
 const (
 	DefaultVlanMac = "00:00:00:00:20:15"
 )
 
-func SplitDNAT(key string) (string, string) {
-	values := strings.SplitN(key, "-", 2)
-	protocol, dest := values[0], values[1]
-	return protocol, strings.Replace(dest, "-", ":", 1)
-}
+// ZoneNat is the conntrack zone of the connections through the gateway.
+const ZoneNat = 10
+
+// DNATShiftMax is the most ports of a DNAT range moved to other ports,
+// which takes flows for each port.
+const DNATShiftMax = 1024
+
+const (
+	MeterPunt  = 1
+	MeterGlean = 2
+	// Packets per second punted to the kernel for ICMP errors,
+	// or for the resolution of next-hops.
+	PuntRate  = 100
+	PuntBurst = 20
+)
+
+const (
+	// RegSource holds the IPv4 source before NAT, which policy rules
+	// match as the kernel routes before the source is translated.
+	RegSource = "NXM_NX_REG3[]"
+)
 
-func SplitSNAT(key string) string {
-	return strings.Replace(key, "-", "/", 1)
+// OpenFlow versions of the bridge, meters need OpenFlow13 and the hash
+// selection method of ECMP groups needs OpenFlow15.
+var Protocols = []string{
+	ovs.ProtocolOpenFlow10,
+	ovs.ProtocolOpenFlow13,
+	ovs.ProtocolOpenFlow15,
 }
 
 type Composer struct {
@@ -45,18 +80,104 @@ type Composer struct {
 	vsctl  *ovs.VSwitchService
 	ns     netns.NsHandle
 	others map[string]string
+	groups map[string]uint32
+	nextId uint32
+	vrfs   map[string]int
+	rules  []PolicyRule
+	// Flows and groups wanted, which are replayed to a new vswitchd.
+	flows map[string]*ovs.Flow
+	specs map[uint32]*ovs.Group
+	// Flows are only kept in memory until the bridge is reconciled.
+	staging bool
+	// Groups installed before Init.
+	stale []uint32
+	// Updates of routes and hosts are committed in batches.
+	queue *FlowQueue
+	// Flows and groups failed to be programmed directly.
+	fails uint64
+	// Cookies of the routes, hosts and NAT rules, and the keys of the
+	// flows wanted by each cookie.
+	cookies Cookies
+	owned   map[uint64]map[string]bool
+	// Conntrack limits by their zone, and the connected prefixes of
+	// the VLAN interfaces their quotas follow.
+	limits  map[int]schema.ConntrackLimit
+	subnets map[string][]string
+	// Health checks of the DNAT backends by the key of their rule,
+	// OnHealth is called when a backend goes up or down.
+	checks   map[string]*Checker
+	OnHealth func(key string)
+	// IPv4 addresses of the VLAN interfaces, masqueraded by SNAT rules.
+	addrs map[string][]string
 }
 
-func (a *Composer) Init() {
+// Tables of the pipeline.
+var tables = []int{
+	TableIn, TableCt, TableNat, TablePbr,
+	TableRib, TableFib, TableRib6, TableFib6, TableFdb, TableWatch,
+}
+
+// initMaps makes the maps the Composer keeps its state in.
+func (a *Composer) initMaps() {
 	a.others = make(map[string]string)
+	a.groups = make(map[string]uint32)
+	a.vrfs = make(map[string]int)
+	a.flows = make(map[string]*ovs.Flow)
+	a.owned = make(map[uint64]map[string]bool)
+	a.limits = make(map[int]schema.ConntrackLimit)
+	a.subnets = make(map[string][]string)
+	a.checks = make(map[string]*Checker)
+	a.addrs = make(map[string][]string)
+	a.specs = make(map[uint32]*ovs.Group)
+}
 
-	// ovs client.
-	a.client = ovs.New()
+func (a *Composer) Init() {
+	a.initMaps()
+	// The flows in place keep forwarding until Reconcile.
+	a.staging = true
+
+	// ovs client, talks to ovsdb-server and the bridge directly when
+	// they are local.
+	options := []ovs.OptionFunc{ovs.Protocols(Protocols)}
+	if _, err := os.Stat(ovs.DefaultOVSDBSocket); err == nil {
+		options = append(options, ovs.OVSDB(ovs.NewOVSDBClient("unix", ovs.DefaultOVSDBSocket)))
+	}
+	if _, err := os.Stat(filepath.Join(ovs.DefaultRunDir, a.brname+".mgmt")); err == nil {
+		options = append(options, ovs.NativeOpenFlow(ovs.DefaultRunDir))
+	}
+	a.client = ovs.New(options...)
 	a.vsctl = a.client.VSwitch
 	a.ofctl = a.client.OpenFlow
+	a.queue = NewFlowQueue(a.brname, a.ofctl)
 
-	a.addBr(a.brname)
-	a.delFlows(nil)
+	if err := a.setup(); err != nil {
+		log.Fatalf("Composer.Init: %v", err)
+	}
+	// Routes keep the groups in place until Reconcile, new ones are
+	// given other ids.
+	if groups, err := a.ofctl.DumpGroups(a.brname); err == nil {
+		for _, group := range groups {
+			a.stale = append(a.stale, group.ID)
+			a.nextId = max(a.nextId, group.ID)
+		}
+	} else {
+		log.Printf("Composer.Init: %v", err)
+		a.delGroups()
+	}
+	a.pipeline()
+}
+
+// pipeline adds the flows of the tables not owned by an object.
+func (a *Composer) pipeline() {
+	// table=250 WATCH
+	a.addFlow(&ovs.Flow{
+		Priority: 0,
+		Cookie:   CookieWatch,
+		Table:    TableWatch,
+		Actions: []ovs.Action{
+			ovs.Drop(),
+		},
+	})
 
 	// table=0 IN
 	a.addFlow(&ovs.Flow{
@@ -68,6 +189,30 @@ func (a *Composer) Init() {
 			ovs.Resubmit(0, TableCt),
 		},
 	})
+	a.addFlow(&ovs.Flow{
+		Priority: 100,
+		Cookie:   CookieIn,
+		Protocol: ovs.ProtocolIPv6,
+		Table:    TableIn,
+		Actions: []ovs.Action{
+			ovs.Resubmit(0, TableCt),
+		},
+	})
+	// RS, RA, NS and NA are left to the kernel.
+	for _, typ := range []uint8{133, 134, 135, 136} {
+		a.addFlow(&ovs.Flow{
+			Priority: 110,
+			Cookie:   CookieIn,
+			Protocol: ovs.ProtocolICMPv6,
+			Table:    TableIn,
+			Matches: []ovs.Match{
+				ovs.ICMP6Type(typ),
+			},
+			Actions: []ovs.Action{
+				ovs.Normal(),
+			},
+		})
+	}
 	// table=0 IN
 	a.addFlow(&ovs.Flow{
 		Priority: 0,
@@ -84,7 +229,17 @@ func (a *Composer) Init() {
 		Table:    TableCt,
 		Protocol: ovs.ProtocolIPv4,
 		Actions: []ovs.Action{
-			ovs.ConnectionTracking(fmt.Sprintf("nat,zone=10,table=%d", TableNat)),
+			ovs.Move("NXM_OF_IP_SRC[]", RegSource),
+			ovs.ConnectionTracking(fmt.Sprintf("nat,zone=%d,table=%d", ZoneNat, TableNat)),
+		},
+	})
+	a.addFlow(&ovs.Flow{
+		Priority: 100,
+		Cookie:   CookieIn,
+		Table:    TableCt,
+		Protocol: ovs.ProtocolIPv6,
+		Actions: []ovs.Action{
+			ovs.ConnectionTracking(fmt.Sprintf("zone=%d,table=%d", ZoneNat, TableNat)),
 		},
 	})
 	// table=12 NAT
@@ -100,7 +255,7 @@ func (a *Composer) Init() {
 			),
 		},
 		Actions: []ovs.Action{
-			ovs.Resubmit(0, TableRib),
+			ovs.Resubmit(0, TablePbr),
 		},
 	})
 	a.addFlow(&ovs.Flow{
@@ -115,7 +270,7 @@ func (a *Composer) Init() {
 			),
 		},
 		Actions: []ovs.Action{
-			ovs.Resubmit(0, TableRib),
+			ovs.Resubmit(0, TablePbr),
 		},
 	})
 	a.addFlow(&ovs.Flow{
@@ -124,31 +279,94 @@ func (a *Composer) Init() {
 		Table:    TableNat,
 		Protocol: ovs.ProtocolIPv4,
 		Actions: []ovs.Action{
-			ovs.Resubmit(0, TableRib),
-		},
-	})
-	// table=19 RIB
-	a.addFlow(&ovs.Flow{
-		Priority: 0,
-		Cookie:   CookieIn,
-		Table:    TableRib,
-		Protocol: ovs.ProtocolIPv4,
-		Actions: []ovs.Action{
-			ovs.Push("OXM_OF_IPV4_DST"),
-			ovs.Pop("reg0"),
-			ovs.Resubmit(0, TableFib),
+			ovs.Resubmit(0, TablePbr),
 		},
 	})
-	// table=20 FIB
 	a.addFlow(&ovs.Flow{
-		Priority: 0,
+		Priority: 10,
 		Cookie:   CookieIn,
-		Table:    TableFib,
+		Table:    TableNat,
+		Protocol: ovs.ProtocolIPv6,
 		Actions: []ovs.Action{
-			ovs.Load("0x0", "reg0"),
-			ovs.Resubmit(0, TableFdb),
+			ovs.Resubmit(0, TablePbr),
 		},
 	})
+	for _, family := range []IPFamily{FamilyV4, FamilyV6} {
+		// table=15 PBR
+		a.addFlow(&ovs.Flow{
+			Priority: 0,
+			Cookie:   CookieIn,
+			Table:    TablePbr,
+			Protocol: family.Protocol,
+			Actions: []ovs.Action{
+				ovs.Resubmit(0, family.Rib),
+			},
+		})
+		// table=19,21 RIB
+		for _, ttl := range []int{0, 1} {
+			// TTL exceeded.
+			a.addFlow(&ovs.Flow{
+				Priority: 300,
+				Cookie:   CookieIn,
+				Table:    family.Rib,
+				Protocol: family.Protocol,
+				Matches: []ovs.Match{
+					ovs.NetworkTTL(ttl),
+					ovs.DataLinkDestination(DefaultVlanMac),
+				},
+				Actions: a.punt(),
+			})
+		}
+		// No route to host.
+		a.addFlow(&ovs.Flow{
+			Priority: 1,
+			Cookie:   CookieIn,
+			Table:    family.Rib,
+			Protocol: family.Protocol,
+			Matches: []ovs.Match{
+				ovs.DataLinkDestination(DefaultVlanMac),
+			},
+			Actions: a.punt(),
+		})
+		a.addFlow(&ovs.Flow{
+			Priority: 0,
+			Cookie:   CookieIn,
+			Table:    family.Rib,
+			Protocol: family.Protocol,
+			Actions: []ovs.Action{
+				ovs.Push(family.Dst),
+				ovs.Pop(family.Reg),
+				ovs.Resubmit(0, family.Fib),
+			},
+		})
+		// table=20,22 FIB
+		// The next-hop is not resolved yet, glean it by sending the
+		// packet to the kernel on its ingress interface, which routes
+		// it, resolves the next-hop and forwards it. On the egress
+		// one, it would be dropped by a strict rp_filter.
+		a.addFlow(&ovs.Flow{
+			Priority: 1,
+			Cookie:   CookieIn,
+			Table:    family.Fib,
+			Protocol: family.Protocol,
+			Matches: []ovs.Match{
+				ovs.DataLinkDestination(DefaultVlanMac),
+			},
+			Actions: []ovs.Action{
+				ovs.ApplyMeter(MeterGlean),
+				ovs.Resubmit(0, TableFdb),
+			},
+		})
+		a.addFlow(&ovs.Flow{
+			Priority: 0,
+			Cookie:   CookieIn,
+			Table:    family.Fib,
+			Actions: []ovs.Action{
+				ovs.Load("0x0", family.Reg),
+				ovs.Resubmit(0, TableFdb),
+			},
+		})
+	}
 	// table=30 FDB
 	a.addFlow(&ovs.Flow{
 		Priority: 0,
@@ -160,6 +378,55 @@ func (a *Composer) Init() {
 	})
 }
 
+// setup adds the bridge and the objects not owned by flows.
+func (a *Composer) setup() error {
+	if err := a.addBr(a.brname); err != nil {
+		return err
+	}
+	if err := a.setProtocols(); err != nil {
+		return err
+	}
+	for _, id := range []uint32{MeterPunt, MeterGlean} {
+		a.addMeter(&ovs.Meter{
+			ID:    id,
+			Rate:  PuntRate,
+			Burst: PuntBurst,
+		})
+	}
+	return nil
+}
+
+// Alive reports whether the flows are still installed, a restarted
+// vswitchd starts with none. A failed dump tells nothing, the vswitchd
+// may be busy or restarting.
+func (a *Composer) Alive() bool {
+	stats, err := a.ofctl.DumpAggregate(a.brname, &ovs.MatchFlow{
+		Cookie: CookieWatch,
+		Table:  TableWatch,
+	})
+	if err != nil {
+		log.Printf("Composer.Alive: %v", err)
+		return true
+	}
+	return stats.FlowCount > 0
+}
+
+// Replay installs again the bridge, groups and flows wanted.
+func (a *Composer) Replay() error {
+	log.Printf("Composer.Replay: %d groups, %d flows", len(a.specs), len(a.flows))
+
+	if err := a.setup(); err != nil {
+		return err
+	}
+	for _, group := range a.specs {
+		if err := a.ofctl.AddGroup(a.brname, group); err != nil {
+			a.ofctl.ModGroup(a.brname, group)
+		}
+	}
+	a.staging = true
+	return a.Reconcile()
+}
+
 func (a *Composer) Start() {
 	log.Printf("Composer.Start")
 	options, err := a.vsctl.Get.Bridge(a.brname)
@@ -169,21 +436,24 @@ func (a *Composer) Start() {
 	}
 	for key, value := range options.OtherConfig {
 		if short, found := strings.CutPrefix(key, "snat-"); found {
-			source := SplitSNAT(short)
-			a.addSNAT(source, value)
+			if data := snatRule(short, value); data.Interface != "" {
+				a.masquerade(data)
+			} else {
+				a.addSNAT(data)
+			}
 			a.others[key] = value
 		} else if short, found := strings.CutPrefix(key, "dnat-"); found {
-			protocol, dest := SplitDNAT(short)
-			daddr, dport, err := ParseBind(protocol, dest)
-			if err != nil {
+			if err := a.addDNAT(key, dnatRule(short, value)); err != nil {
 				continue
 			}
-			toaddr, toport, err := ParseBind(protocol, value)
-			if err != nil {
+			a.others[key] = value
+		} else if external, found := strings.CutPrefix(key, "staticnat-"); found {
+			if err := a.addStatic(schema.StaticNAT{External: external, Internal: value}); err != nil {
 				continue
 			}
-			a.addDNAT(protocol, daddr, dport, toaddr, toport)
 			a.others[key] = value
+		} else if strings.HasPrefix(key, "ctlimit-") {
+			a.restoreLimit(key, value)
 		}
 	}
 
@@ -223,6 +493,17 @@ func (a *Composer) hasPort(name string) bool {
 	return false
 }
 
+func (a *Composer) addPort(port string) error {
+	if a.hasPort(port) {
+		return nil
+	}
+	if err := a.vsctl.AddPort(a.brname, port); err != nil {
+		log.Printf("Composer.addPort: %v", err)
+		return err
+	}
+	return nil
+}
+
 func (a *Composer) addVlanTag(port string, tag int) error {
 	if !a.hasPort(port) {
 		if err := a.vsctl.AddPort(a.brname, port); err != nil {
@@ -236,7 +517,7 @@ func (a *Composer) addVlanTag(port string, tag int) error {
 		log.Printf("Composer.addVlanTag.set: %v", err)
 		return err
 	}
-	return nil
+	return a.reclassify()
 }
 
 func (a *Composer) delVlanTag(port string) error {
@@ -248,7 +529,7 @@ func (a *Composer) delVlanTag(port string) error {
 		log.Printf("Composer.delVlanTag: %v", err)
 		return err
 	}
-	return nil
+	return a.reclassify()
 }
 
 func (a *Composer) addVlanTrunks(port, trunks string) error {
@@ -312,6 +593,181 @@ func (a *Composer) addVlanPort(vlan string) error {
 	return nil
 }
 
+// SetVrf binds the routed traffic received on a VLAN interface to the
+// RIB of a kernel table, and zero unbinds it back to the main table.
+func (a *Composer) SetVrf(vlanif string, vrf int) error {
+	log.Printf("Compose.SetVrf: %s in %d", vlanif, vrf)
+	if vrf == 0 {
+		delete(a.vrfs, vlanif)
+	} else {
+		a.vrfs[vlanif] = vrf
+	}
+	return a.classify()
+}
+
+// classify marks packets of a VRF with its metadata on the ingress,
+// both for the tagged frames of trunks and for the access ports. The
+// VRF traffic goes to the RIB directly, NAT is only for the main table.
+func (a *Composer) classify() error {
+	if err := a.delFlows(&ovs.MatchFlow{
+		Cookie: CookieVrf,
+		Table:  TableIn,
+	}); err != nil {
+		return err
+	}
+	if len(a.vrfs) == 0 {
+		return nil
+	}
+
+	ports, err := a.listPorts()
+	if err != nil {
+		return err
+	}
+	for vlanif, vrf := range a.vrfs {
+		metadata := fmt.Sprintf("0x%x", vrf)
+		for _, flow := range a.ingress(vlanif, ports) {
+			for _, family := range []IPFamily{FamilyV4, FamilyV6} {
+				a.addFlow(&ovs.Flow{
+					Priority: 105,
+					Cookie:   CookieVrf,
+					Table:    TableIn,
+					Protocol: family.Protocol,
+					InPort:   flow.InPort,
+					Matches:  flow.Matches,
+					Actions: []ovs.Action{
+						ovs.Load(metadata, "OXM_OF_METADATA[]"),
+						ovs.Resubmit(0, family.Rib),
+					},
+				})
+			}
+		}
+	}
+	return nil
+}
+
+// reclassify follows the access ports of VLANs after their tag changed.
+func (a *Composer) reclassify() error {
+	if err := a.classify(); err != nil {
+		return err
+	}
+	return a.steer()
+}
+
+// ingress returns the in_port or VLAN matches of packets received by a
+// VLAN interface: the tagged frames of trunks and the access ports.
+func (a *Composer) ingress(vlanif string, ports []ovs.PortData) []*ovs.Flow {
+	vlanid := a.findVlanId(vlanif)
+
+	flows := []*ovs.Flow{{
+		Matches: []ovs.Match{ovs.DataLinkVLAN(vlanid)},
+	}}
+	for _, port := range ports {
+		if port.Tag != vlanid || port.Name == vlanif {
+			continue
+		}
+		flows = append(flows, &ovs.Flow{InPort: port.OfPort})
+	}
+	return flows
+}
+
+// A PolicyRule is a kernel ip rule looking up a table other than main.
+type PolicyRule struct {
+	Priority int
+	Family   IPFamily
+	Src      string
+	Dst      string
+	Mark     uint32
+	Mask     uint32
+	Tos      uint
+	Iif      string
+	Table    int
+}
+
+// SetRules replaces the policy rules of the PBR table.
+func (a *Composer) SetRules(rules []PolicyRule) error {
+	log.Printf("Compose.SetRules: %+v", rules)
+	a.rules = rules
+	return a.steer()
+}
+
+// steer programs the PBR table, where a matched rule selects the RIB
+// of its table by the metadata. Rules are ordered as in the kernel, the
+// lowest preference comes first, and a table without a route to the
+// destination falls back to the main one.
+func (a *Composer) steer() error {
+	if err := a.delFlows(cookieMatch(CookiePbr)); err != nil {
+		return err
+	}
+	if len(a.rules) == 0 {
+		return nil
+	}
+
+	ports, err := a.listPorts()
+	if err != nil {
+		return err
+	}
+	for _, rule := range a.rules {
+		family := rule.Family
+		var matches []ovs.Match
+		if rule.Src != "" {
+			matches = append(matches, family.Origin(rule.Src))
+		}
+		if rule.Dst != "" {
+			matches = append(matches, family.Destination(rule.Dst))
+		}
+		if rule.Mark != 0 || rule.Mask != 0 {
+			matches = append(matches, ovs.FieldMatch("pkt_mark", fmt.Sprintf("0x%x/0x%x", rule.Mark, rule.Mask)))
+		}
+		if rule.Tos != 0 {
+			matches = append(matches, ovs.FieldMatch("ip_dscp", fmt.Sprintf("%d", rule.Tos>>2)))
+		}
+
+		flows := []*ovs.Flow{{}}
+		if rule.Iif != "" {
+			flows = a.ingress(rule.Iif, ports)
+		}
+		for _, flow := range flows {
+			a.addFlow(&ovs.Flow{
+				Priority: 0x8000 - min(rule.Priority, 0x7fff),
+				Cookie:   CookiePbr,
+				Table:    TablePbr,
+				Protocol: family.Protocol,
+				InPort:   flow.InPort,
+				Matches:  append(flow.Matches, matches...),
+				Actions: []ovs.Action{
+					ovs.Load(fmt.Sprintf("0x%x", rule.Table), "OXM_OF_METADATA[]"),
+					ovs.Resubmit(0, family.Rib),
+				},
+			})
+		}
+	}
+
+	vrfs := slices.Collect(maps.Values(a.vrfs))
+	fallbacks := make(map[[2]int]bool)
+	for _, rule := range a.rules {
+		family := rule.Family
+		// The tables of VRFs are isolated from the main one.
+		if fallbacks[[2]int{rule.Table, family.Rib}] || slices.Contains(vrfs, rule.Table) {
+			continue
+		}
+		fallbacks[[2]int{rule.Table, family.Rib}] = true
+		a.addFlow(&ovs.Flow{
+			Priority: 2,
+			Cookie:   CookiePbr,
+			Table:    family.Rib,
+			Protocol: family.Protocol,
+			Matches: []ovs.Match{
+				ovs.Metadata(uint64(rule.Table)),
+			},
+			Actions: []ovs.Action{
+				ovs.Load("0x0", "OXM_OF_METADATA[]"),
+				ovs.Resubmit(0, family.Rib),
+			},
+		})
+	}
+	return nil
+}
+
 func (a *Composer) delPort(vlan string) error {
 	if err := a.vsctl.DeletePort(a.brname, vlan); err != nil {
 		log.Printf("Composer.delPort: %v", err)
@@ -322,12 +778,40 @@ func (a *Composer) delPort(vlan string) error {
 
 func (a *Composer) addBr(name string) error {
 	if err := a.vsctl.AddBridge(name); err != nil {
-		log.Fatalf("Composer.addBr: %v", err)
+		log.Printf("Composer.addBr: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (a *Composer) setProtocols() error {
+	options := ovs.BridgeOptions{Protocols: Protocols}
+	if err := a.vsctl.Set.Bridge(a.brname, options); err != nil {
+		log.Printf("Composer.setProtocols: %v", err)
+		return err
+	}
+	return nil
+}
+
+// addMeter keeps a meter in place, the flows using it would be deleted
+// with it.
+func (a *Composer) addMeter(meter *ovs.Meter) error {
+	if err := a.ofctl.AddMeter(a.brname, meter); err != nil {
+		log.Printf("Composer.addMeter: %v", err)
 		return err
 	}
 	return nil
 }
 
+// punt returns the actions sending a routed packet to the kernel, which
+// answers with ICMP errors. It is rate limited by the punt meter.
+func (a *Composer) punt() []ovs.Action {
+	return []ovs.Action{
+		ovs.ApplyMeter(MeterPunt),
+		ovs.Resubmit(0, TableFdb),
+	}
+}
+
 func (a *Composer) findPortId(name string) int {
 	if strings.HasPrefix(name, "vlan") {
 		vlanid := 0
@@ -350,20 +834,22 @@ func (a *Composer) findVlanId(name string) int {
 	return vlanid
 }
 
-func (a *Composer) AddHost(ipdst IPAddr, ethdst HWAddr, vlanif string) error {
+func (a *Composer) AddHost(vrf int, ipdst IPAddr, ethdst HWAddr, vlanif string) error {
 	// table=20 FIB
-	log.Printf("Compose.AddHost: %s -> %s on %s", ipdst, ethdst, vlanif)
+	log.Printf("Compose.AddHost: %s -> %s on %s in %d", ipdst, ethdst, vlanif, vrf)
 	ethsrc := a.findPortAddr(vlanif)
 	vlanid := fmt.Sprintf("0x%x", a.findVlanId(vlanif))
 	portid := fmt.Sprintf("0x%x", a.findPortId(vlanif))
+	family := ipdst.Family()
 
-	return a.addFlow(&ovs.Flow{
+	a.program(&ovs.Flow{
 		Priority: 100,
-		Cookie:   CookieIn,
-		Table:    TableFib,
-		Protocol: ovs.ProtocolIPv4,
+		Cookie:   a.cookies.Get(KindHost, hostKey(vrf, ipdst, vlanif)),
+		Table:    family.Fib,
+		Protocol: family.Protocol,
 		Matches: []ovs.Match{
-			ovs.FieldMatch("reg0", ipdst.Hex()),
+			ovs.Metadata(uint64(vrf)),
+			ovs.FieldMatch(family.Reg, ipdst.Hex()),
 			ovs.DataLinkDestination(ethsrc),
 		},
 		Actions: []ovs.Action{
@@ -376,392 +862,1059 @@ func (a *Composer) AddHost(ipdst IPAddr, ethdst HWAddr, vlanif string) error {
 			ovs.Resubmit(0, TableFdb),
 		},
 	})
+	return nil
 }
 
-func (a *Composer) DelHost(ipdst IPAddr, vlanif string) error {
-	log.Printf("Compose.DelHost: %s on %s", ipdst, vlanif)
-	ethsrc := a.findPortAddr(vlanif)
+func (a *Composer) DelHost(vrf int, ipdst IPAddr, vlanif string) error {
+	log.Printf("Compose.DelHost: %s on %s in %d", ipdst, vlanif, vrf)
+	key := hostKey(vrf, ipdst, vlanif)
+	cookie, ok := a.cookies.Find(KindHost, key)
+	if !ok {
+		return nil
+	}
+	err := a.withdraw(cookie)
+	a.cookies.Release(KindHost, key)
+	return err
+}
 
-	return a.delFlows(&ovs.MatchFlow{
-		Cookie:   CookieIn,
-		Table:    TableFib,
-		Protocol: ovs.ProtocolIPv4,
-		Matches: []ovs.Match{
-			ovs.FieldMatch("reg0", ipdst.Hex()),
-			ovs.DataLinkDestination(ethsrc),
-		},
-	})
+func hostKey(vrf int, ipdst IPAddr, vlanif string) string {
+	return fmt.Sprintf("%d-%s-%s", vrf, ipdst, vlanif)
+}
+
+// keep records a flow wanted, which is indexed by its cookie, part of
+// its key.
+func (a *Composer) keep(key string, flow *ovs.Flow) {
+	a.flows[key] = flow
+	if a.owned[flow.Cookie] == nil {
+		a.owned[flow.Cookie] = make(map[string]bool)
+	}
+	a.owned[flow.Cookie][key] = true
+}
+
+// forget drops a flow no longer wanted.
+func (a *Composer) forget(key string) {
+	flow, ok := a.flows[key]
+	if !ok {
+		return
+	}
+	delete(a.flows, key)
+	delete(a.owned[flow.Cookie], key)
+	if len(a.owned[flow.Cookie]) == 0 {
+		delete(a.owned, flow.Cookie)
+	}
 }
 
 func (a *Composer) addFlow(flow *ovs.Flow) error {
+	a.keep(flowKey(flow), flow)
+	if a.staging {
+		return nil
+	}
 	err := a.ofctl.AddFlow(a.brname, flow)
 	if err != nil {
 		log.Printf("Composer.addFlow: %v", err)
+		a.fails++
+	}
+	return err
+}
+
+// addFlows adds many flows of an object in one bundle.
+func (a *Composer) addFlows(flows ...*ovs.Flow) error {
+	for _, flow := range flows {
+		a.keep(flowKey(flow), flow)
+	}
+	if a.staging || len(flows) == 0 {
+		return nil
+	}
+	err := a.ofctl.AddFlowBundle(a.brname, func(tx *ovs.FlowTransaction) error {
+		tx.Add(flows...)
+		return tx.Commit()
+	})
+	if err != nil {
+		log.Printf("Composer.addFlows: %v", err)
+		a.fails++
 	}
 	return err
 }
 
 func (a *Composer) delFlows(match *ovs.MatchFlow) error {
+	// A match of a whole cookie only looks at the flows of it.
+	if match != nil && match.Cookie > 0 && (match.CookieMask == 0 || match.CookieMask == CookieFullMask) {
+		for key := range a.owned[match.Cookie] {
+			if covers(match, a.flows[key]) {
+				a.forget(key)
+			}
+		}
+	} else {
+		for key, flow := range a.flows {
+			if match == nil || covers(match, flow) {
+				a.forget(key)
+			}
+		}
+	}
+	if a.staging {
+		return nil
+	}
+	// Queued updates would bring the flows back.
+	a.queue.Drop(match)
 	err := a.ofctl.DelFlows(a.brname, match)
 	if err != nil {
 		log.Printf("Composer.delFlow: %v", err)
+		a.fails++
 	}
 	return err
 }
 
-func (a *Composer) AddRoute(ipdst IPPrefix, ipgw IPAddr, vlanif string) error {
-	// table=19 RIB
-	log.Printf("Compose.AddRoute: %s -> %s on %s", ipdst, ipgw, vlanif)
-	ethsrc := a.findPortAddr(vlanif)
+// program queues a flow of a route or a host, the updates of one flow
+// within the window of the queue are coalesced. It does not wait for the
+// commit, a flow failing to commit is counted by the queue and logged.
+func (a *Composer) program(flow *ovs.Flow) {
+	key := flowKey(flow)
+	a.keep(key, flow)
+	if a.staging {
+		return
+	}
+	a.queue.Add(flow)
+}
 
-	var actions []ovs.Action
-	if ipgw == "<nil>" {
-		actions = []ovs.Action{
-			ovs.Push("OXM_OF_IPV4_DST"),
-			ovs.Pop("reg0"),
-			ovs.Resubmit(0, TableFib),
-		}
-	} else {
-		actions = []ovs.Action{
-			ovs.Load(ipgw.Hex(), "reg0"),
-			ovs.Resubmit(0, TableFib),
+// withdraw queues the removal of the flows programmed for the object of
+// a cookie.
+func (a *Composer) withdraw(cookie uint64) error {
+	for key := range a.owned[cookie] {
+		flow := a.flows[key]
+		a.forget(key)
+		if !a.staging {
+			a.queue.Delete(flow)
 		}
 	}
-	a.addFlow(&ovs.Flow{
-		Priority: 100 + ipdst.Prefixlen(),
-		Cookie:   CookieIn,
-		Table:    TableRib,
-		Protocol: ovs.ProtocolIPv4,
-		Matches: []ovs.Match{
-			ovs.NetworkDestination(ipdst.Str()),
-			ovs.DataLinkDestination(ethsrc),
-		},
-		Actions: actions,
-	})
 	return nil
 }
 
-func (a *Composer) DelRoute(ipdst IPPrefix, vlanif string) error {
-	// table=19 RIB
-	log.Printf("Compose.DelRoute: %s on %s", ipdst, vlanif)
-	ethsrc := a.findPortAddr(vlanif)
+// retire removes groups once no queued flow refers to them anymore.
+func (a *Composer) retire(ids ...uint32) {
+	if a.staging {
+		a.delGroups(ids...)
+		return
+	}
+	for _, id := range ids {
+		delete(a.specs, id)
+	}
+	a.queue.Release(ids...)
+}
 
-	return a.delFlows(&ovs.MatchFlow{
-		Cookie:   CookieIn,
-		Table:    TableRib,
-		Protocol: ovs.ProtocolIPv4,
-		Matches: []ovs.Match{
-			ovs.NetworkDestination(ipdst.Str()),
-			ovs.DataLinkDestination(ethsrc),
-		},
-	})
+// flowKey returns the priority and matches of a flow, which are unique
+// in a bridge.
+func flowKey(flow *ovs.Flow) string {
+	mb, err := flow.MatchFlow().MarshalText()
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("priority=%d,%s", flow.Priority, mb)
 }
 
-func (a *Composer) addSNAT(source, sourceTo string) error {
-	log.Printf("Compose.addSNAT: %s -> %s", source, sourceTo)
-	return a.addFlow(&ovs.Flow{
-		Priority: 50,
-		Cookie:   CookieIn,
-		Table:    TableNat,
-		Protocol: ovs.ProtocolIPv4,
-		Matches: []ovs.Match{
-			ovs.ConnectionTrackingState(
-				ovs.SetState(ovs.CTStateTracked),
-				ovs.SetState(ovs.CTStateNew),
-			),
-			ovs.NetworkSource(source),
-		},
-		Actions: []ovs.Action{
-			ovs.ConnectionTracking(fmt.Sprintf("commit,nat(src=%s),zone=10,table=%d", sourceTo, TableRib)),
-		},
-	})
+// covers reports whether a flow is deleted by a non-strict match.
+func covers(match *ovs.MatchFlow, flow *ovs.Flow) bool {
+	if match.Table != ovs.AnyTable && match.Table != flow.Table {
+		return false
+	}
+	if match.Cookie > 0 {
+		mask := match.CookieMask
+		if mask == 0 {
+			mask = ^uint64(0)
+		}
+		if match.Cookie&mask != flow.Cookie&mask {
+			return false
+		}
+	}
+	if match.Protocol != "" && match.Protocol != flow.Protocol {
+		return false
+	}
+	if match.InPort != 0 && match.InPort != flow.InPort {
+		return false
+	}
+
+	if len(match.Matches) == 0 {
+		return true
+	}
+	have := make(map[string]bool)
+	for _, m := range flow.Matches {
+		if mb, err := m.MarshalText(); err == nil {
+			have[string(mb)] = true
+		}
+	}
+	for _, m := range match.Matches {
+		mb, err := m.MarshalText()
+		if err != nil || !have[string(mb)] {
+			return false
+		}
+	}
+	return true
 }
 
-func (a *Composer) AddSNAT(source, sourceTo string) error {
-	err := a.addSNAT(source, sourceTo)
-	if err == nil {
-		a.vsctl.Set.Bridge(a.brname, ovs.BridgeOptions{
-			OtherConfig: map[string]string{ToKey("snat", source): sourceTo},
-		})
+// Reconcile replaces the flows of the bridge by the ones wanted in one
+// bundle. The cookies with the same flows are kept in place, so the traffic
+// is not dropped while the daemon restarts. The flows are left alone when
+// the ones in place are unknown, Reconcile is tried again later.
+func (a *Composer) Reconcile() error {
+	if !a.staging {
+		return nil
+	}
+
+	lines, err := a.ofctl.DumpFlowLines(a.brname)
+	if err != nil {
+		log.Printf("Composer.Reconcile: %v", err)
+		return err
+	}
+	present := make(map[uint64]map[string]bool)
+	for _, line := range lines {
+		cookie, sig, err := dumpSig(line)
+		if err != nil {
+			log.Printf("Composer.Reconcile: %v", err)
+			return err
+		}
+		if present[cookie] == nil {
+			present[cookie] = make(map[string]bool)
+		}
+		present[cookie][sig] = true
+	}
+
+	staged := make(map[uint64]map[string]bool)
+	owners := make(map[uint64][]*ovs.Flow)
+	for _, flow := range a.flows {
+		cookie, sig, err := flowSig(flow)
+		if err != nil {
+			log.Printf("Composer.Reconcile: %v", err)
+			return err
+		}
+		if staged[cookie] == nil {
+			staged[cookie] = make(map[string]bool)
+		}
+		staged[cookie][sig] = true
+		owners[cookie] = append(owners[cookie], flow)
+	}
+
+	// A cookie with other flows in place is replaced as a whole.
+	var adds []*ovs.Flow
+	var dels []uint64
+	for cookie, flows := range owners {
+		old, ok := present[cookie]
+		if ok && maps.Equal(old, staged[cookie]) {
+			continue
+		}
+		if ok {
+			dels = append(dels, cookie)
+		}
+		adds = append(adds, flows...)
+	}
+	for cookie := range present {
+		if _, ok := staged[cookie]; !ok {
+			dels = append(dels, cookie)
+		}
+	}
+	log.Printf("Composer.Reconcile: %d added, %d cookies removed, %d kept",
+		len(adds), len(dels), len(a.flows)-len(adds))
+
+	if len(adds) > 0 || len(dels) > 0 {
+		if err := a.ofctl.AddFlowBundle(a.brname, func(tx *ovs.FlowTransaction) error {
+			for _, cookie := range dels {
+				tx.Delete(cookieMatch(cookie))
+			}
+			tx.Add(adds...)
+			return tx.Commit()
+		}); err != nil {
+			log.Printf("Composer.Reconcile: %v", err)
+			return err
+		}
+	}
+	a.staging = false
+
+	// No flow refers to the groups before Init anymore.
+	if len(a.stale) > 0 {
+		a.delGroups(a.stale...)
+		a.stale = nil
+	}
+	return nil
+}
+
+func (a *Composer) addGroup(group *ovs.Group) error {
+	a.specs[group.ID] = group
+	return a.ofctl.AddGroup(a.brname, group)
+}
+
+func (a *Composer) modGroup(group *ovs.Group) error {
+	a.specs[group.ID] = group
+	return a.ofctl.ModGroup(a.brname, group)
+}
+
+func (a *Composer) delGroups(ids ...uint32) error {
+	if len(ids) == 0 {
+		clear(a.specs)
+	}
+	for _, id := range ids {
+		delete(a.specs, id)
+	}
+	err := a.ofctl.DelGroups(a.brname, ids...)
+	if err != nil {
+		log.Printf("Composer.delGroups: %v", err)
+		a.fails++
 	}
 	return err
 }
 
-func (a *Composer) DelSNAT(source string) error {
-	log.Printf("Compose.DelSNAT: %s", source)
+func (a *Composer) nexthop(vrf int, family IPFamily, ipgw IPAddr, vlanif string) []ovs.Action {
+	var actions []ovs.Action
+	// Hosts are in the VRF of their interface, which differs from the
+	// one of the route for the tables of policy rules.
+	if egress := a.vrfs[vlanif]; egress != vrf {
+		actions = append(actions, ovs.Load(fmt.Sprintf("0x%x", egress), "OXM_OF_METADATA[]"))
+	}
+	if ipgw == "<nil>" {
+		return append(actions,
+			ovs.Push(family.Dst),
+			ovs.Pop(family.Reg),
+			ovs.Resubmit(0, family.Fib),
+		)
+	}
+	return append(actions,
+		ovs.Load(ipgw.Hex(), family.Reg),
+		ovs.Resubmit(0, family.Fib),
+	)
+}
 
-	err := a.delFlows(&ovs.MatchFlow{
-		Cookie:   CookieIn,
-		Table:    TableNat,
-		Protocol: ovs.ProtocolIPv4,
+func (a *Composer) addRoute(vrf int, ipdst IPPrefix, ethsrc string, actions []ovs.Action) error {
+	// table=19 RIB
+	family := ipdst.Family()
+
+	a.program(&ovs.Flow{
+		Priority: 100 + ipdst.Prefixlen(),
+		Cookie:   a.cookies.Get(KindRoute, routeKey(vrf, ipdst)+"-"+ethsrc),
+		Table:    family.Rib,
+		Protocol: family.Protocol,
 		Matches: []ovs.Match{
-			ovs.ConnectionTrackingState(
-				ovs.SetState(ovs.CTStateTracked),
-				ovs.SetState(ovs.CTStateNew),
-			),
-			ovs.NetworkSource(source),
+			ovs.Metadata(uint64(vrf)),
+			family.Destination(ipdst.Str()),
+			ovs.DataLinkDestination(ethsrc),
 		},
+		Actions: actions,
 	})
-	if err == nil {
-		a.vsctl.RemoveBridge(a.brname, "other_config", ToKey("snat", source))
+	return nil
+}
+
+func (a *Composer) AddRoute(vrf int, ipdst IPPrefix, ipgw IPAddr, vlanif string) error {
+	log.Printf("Compose.AddRoute: %s -> %s on %s in %d", ipdst, ipgw, vlanif, vrf)
+	family := ipdst.Family()
+
+	ethsrc := a.findPortAddr(vlanif)
+	if err := a.addRoute(vrf, ipdst, ethsrc, a.nexthop(vrf, family, ipgw, vlanif)); err != nil {
+		return err
 	}
-	return err
+	// The route was a multipath one before.
+	a.releaseGroup(vrf, ipdst)
+	return nil
 }
 
-func ParseBind(protocol, data string) (string, uint16, error) {
-	var dAddr string
-	var dport uint16
+// A NextHop is one path of a multipath route.
+type NextHop struct {
+	Gw     IPAddr
+	Port   string
+	Weight int
+}
+
+func (a *Composer) AddMultiRoute(vrf int, ipdst IPPrefix, hops []NextHop) error {
+	log.Printf("Compose.AddMultiRoute: %s -> %+v in %d", ipdst, hops, vrf)
+	family := ipdst.Family()
+	key := routeKey(vrf, ipdst)
 
-	valus := strings.SplitN(data, ":", 2)
-	dAddr = valus[0]
-	if protocol == "icmp" {
-		return dAddr, 0, nil
+	group := &ovs.Group{
+		Type:            ovs.GroupSelect,
+		SelectionMethod: "hash",
+		Fields:          family.Hash,
 	}
-	if len(valus) != 2 {
-		return "", 0, fmt.Errorf("invalid destination: %s", data)
+	for _, hop := range hops {
+		group.Buckets = append(group.Buckets, &ovs.Bucket{
+			Weight:  hop.Weight,
+			Actions: a.nexthop(vrf, family, hop.Gw, hop.Port),
+		})
 	}
-	if _, err := fmt.Sscanf(valus[1], "%d", &dport); err != nil {
-		return "", 0, fmt.Errorf("invalid destination port: %v", err)
+
+	// Buckets of an installed group are replaced in place, so the
+	// flows of the route are kept when a next-hop comes or goes.
+	if id, ok := a.groups[key]; ok {
+		group.ID = id
+		if err := a.modGroup(group); err != nil {
+			log.Printf("Composer.AddMultiRoute: %v", err)
+			return err
+		}
+		return nil
+	}
+
+	a.nextId++
+	group.ID = a.nextId
+	if err := a.addGroup(group); err != nil {
+		log.Printf("Composer.AddMultiRoute: %v", err)
+		return err
 	}
-	return dAddr, dport, nil
+	a.groups[key] = group.ID
+
+	return a.addRoute(vrf, ipdst, a.findPortAddr(hops[0].Port), []ovs.Action{
+		ovs.GotoGroup(group.ID),
+	})
 }
 
-func (a *Composer) addDNAT(protocol, daddr string, dport uint16, toaddr string, toport uint16) error {
-	// to DNAT
-	matchs := []ovs.Match{
-		ovs.ConnectionTrackingState(
-			ovs.SetState(ovs.CTStateTracked),
-			ovs.SetState(ovs.CTStateNew),
-		),
-		ovs.NetworkDestination(daddr),
+func (a *Composer) releaseGroup(vrf int, ipdst IPPrefix) {
+	key := routeKey(vrf, ipdst)
+	if id, ok := a.groups[key]; ok {
+		a.retire(id)
+		delete(a.groups, key)
 	}
-	if protocol == "tcp" || protocol == "udp" {
-		matchs = append(matchs, ovs.TransportDestinationPort(dport))
+}
+
+func routeKey(vrf int, ipdst IPPrefix) string {
+	return fmt.Sprintf("%d-%s", vrf, ipdst)
+}
+
+func nexthopKey(id uint32) string {
+	return fmt.Sprintf("nh-%d", id)
+}
+
+// nexthopGroup returns the group of a kernel nexthop object, an empty
+// one is installed until the object is known.
+func (a *Composer) nexthopGroup(id uint32) (uint32, error) {
+	key := nexthopKey(id)
+	if gid, ok := a.groups[key]; ok {
+		return gid, nil
 	}
-	log.Printf("Compose.addDNAT: %s:%d -> %s:%d", daddr, dport, toaddr, toport)
-	if err := a.addFlow(&ovs.Flow{
-		Priority: 160,
-		Cookie:   CookieIn,
-		Table:    TableNat,
-		Protocol: ovs.Protocol(protocol),
-		Matches:  matchs,
-		Actions: []ovs.Action{
-			ovs.ConnectionTracking(fmt.Sprintf("commit,nat(dst=%s:%d),zone=10,table=%d", toaddr, toport, TableRib)),
-		},
-	}); err != nil {
-		return err
+
+	a.nextId++
+	group := &ovs.Group{
+		ID:   a.nextId,
+		Type: ovs.GroupSelect,
+	}
+	if err := a.addGroup(group); err != nil {
+		log.Printf("Composer.nexthopGroup: %v", err)
+		return 0, err
 	}
+	a.groups[key] = group.ID
+	return group.ID, nil
+}
 
-	// Hainpin to SNAT
-	matchs = []ovs.Match{
-		ovs.ConnectionTrackingState(
-			ovs.SetState(ovs.CTStateTracked),
-			ovs.SetState(ovs.CTStateNew),
-		),
-		ovs.NetworkDestination(daddr),
-		ovs.NetworkSource(toaddr),
+// AddNexthop installs a kernel nexthop object as a group shared by all
+// routes using it, so a change of the object rewrites only the group.
+// The group drops packets when no next-hop is given.
+func (a *Composer) AddNexthop(id uint32, hops []NextHop) error {
+	log.Printf("Compose.AddNexthop: %d -> %+v", id, hops)
+
+	gid, err := a.nexthopGroup(id)
+	if err != nil {
+		return err
 	}
-	if protocol == "tcp" || protocol == "udp" {
-		matchs = append(matchs, ovs.TransportDestinationPort(dport))
+	group := &ovs.Group{
+		ID:              gid,
+		Type:            ovs.GroupSelect,
+		SelectionMethod: "hash",
+		Fields:          FamilyV4.Hash,
+	}
+	for _, hop := range hops {
+		family := hop.Gw.Family()
+		group.Fields = family.Hash
+		// The routes of all VRFs share the group, so the metadata
+		// of the egress VRF is always loaded.
+		group.Buckets = append(group.Buckets, &ovs.Bucket{
+			Weight:  hop.Weight,
+			Actions: a.nexthop(-1, family, hop.Gw, hop.Port),
+		})
 	}
-	if err := a.addFlow(&ovs.Flow{
-		Priority: 162,
-		Cookie:   CookieIn,
-		Table:    TableNat,
-		Protocol: ovs.Protocol(protocol),
-		Matches:  matchs,
-		Actions: []ovs.Action{
-			ovs.ConnectionTracking(fmt.Sprintf("commit,nat(dst=%s:%d),zone=10", toaddr, toport)),
-			ovs.Resubmit(0, TableNat),
-		},
-	}); err != nil {
+	if err := a.modGroup(group); err != nil {
+		log.Printf("Composer.AddNexthop: %v", err)
 		return err
 	}
+	return nil
+}
 
-	// Hairpin unSNAT
-	matchs = []ovs.Match{
-		ovs.ConnectionTrackingState(
-			ovs.SetState(ovs.CTStateTracked),
-			ovs.SetState(ovs.CTStateReply),
-		),
-		ovs.NetworkDestination(toaddr),
-		ovs.NetworkSource(toaddr),
+func (a *Composer) DelNexthop(id uint32) error {
+	log.Printf("Compose.DelNexthop: %d", id)
+
+	key := nexthopKey(id)
+	if gid, ok := a.groups[key]; ok {
+		a.retire(gid)
+		delete(a.groups, key)
 	}
-	if protocol == "tcp" || protocol == "udp" {
-		matchs = append(matchs, ovs.TransportSourcePort(toport))
+	return nil
+}
+
+// AddNexthopRoute adds a route pointing at the group of a kernel nexthop
+// object.
+func (a *Composer) AddNexthopRoute(vrf int, ipdst IPPrefix, id uint32) error {
+	log.Printf("Compose.AddNexthopRoute: %s -> nhid %d in %d", ipdst, id, vrf)
+
+	gid, err := a.nexthopGroup(id)
+	if err != nil {
+		return err
 	}
-	if err := a.addFlow(&ovs.Flow{
-		Priority: 202,
-		Cookie:   CookieIn,
-		Table:    TableNat,
-		Protocol: ovs.Protocol(protocol),
-		Matches:  matchs,
-		Actions: []ovs.Action{
-			ovs.ClearCt(),
-			ovs.Resubmit(0, TableCt),
-		},
+	if err := a.addRoute(vrf, ipdst, DefaultVlanMac, []ovs.Action{
+		ovs.GotoGroup(gid),
 	}); err != nil {
 		return err
 	}
-	matchs = []ovs.Match{
-		ovs.ConnectionTrackingState(
-			ovs.SetState(ovs.CTStateTracked),
-			ovs.SetState(ovs.CTStateEstablished),
-		),
-		ovs.NetworkDestination(toaddr),
-		ovs.NetworkSource(toaddr),
-	}
-	if protocol == "tcp" || protocol == "udp" {
-		matchs = append(matchs, ovs.TransportDestinationPort(toport))
+	a.releaseGroup(vrf, ipdst)
+	return nil
+}
+
+func (a *Composer) DelNexthopRoute(vrf int, ipdst IPPrefix) error {
+	log.Printf("Compose.DelNexthopRoute: %s in %d", ipdst, vrf)
+	return a.delRoute(vrf, ipdst, DefaultVlanMac)
+}
+
+// Types of routes in the RIB.
+const (
+	RouteUnicast     = "unicast"
+	RouteBlackhole   = "blackhole"
+	RouteUnreachable = "unreachable"
+	RouteProhibit    = "prohibit"
+)
+
+// AddReject adds a route without next-hop. The packets of a blackhole
+// route are dropped, others are punted to the kernel untouched, which
+// answers with the ICMP destination unreachable.
+func (a *Composer) AddReject(vrf int, ipdst IPPrefix, typ string) error {
+	log.Printf("Compose.AddReject: %s %s in %d", typ, ipdst, vrf)
+
+	actions := a.punt()
+	if typ == RouteBlackhole {
+		actions = []ovs.Action{ovs.Drop()}
 	}
-	if err := a.addFlow(&ovs.Flow{
-		Priority: 202,
-		Cookie:   CookieIn,
-		Table:    TableNat,
-		Protocol: ovs.Protocol(protocol),
-		Matches:  matchs,
-		Actions: []ovs.Action{
-			ovs.ClearCt(),
-			ovs.Resubmit(0, TableCt),
-		},
-	}); err != nil {
+	if err := a.addRoute(vrf, ipdst, DefaultVlanMac, actions); err != nil {
 		return err
 	}
+	a.releaseGroup(vrf, ipdst)
 	return nil
 }
 
-func ToKey(prefix string, values ...string) string {
-	key := fmt.Sprintf("%s-%s", prefix, strings.Join(values, "-"))
-	key = strings.Replace(key, ":", "-", 1)
-	key = strings.Replace(key, "/", "-", 1)
-	return key
+func (a *Composer) DelReject(vrf int, ipdst IPPrefix) error {
+	log.Printf("Compose.DelReject: %s in %d", ipdst, vrf)
+	return a.delRoute(vrf, ipdst, DefaultVlanMac)
+}
+
+func (a *Composer) DelRoute(vrf int, ipdst IPPrefix, vlanif string) error {
+	log.Printf("Compose.DelRoute: %s on %s in %d", ipdst, vlanif, vrf)
+	return a.delRoute(vrf, ipdst, a.findPortAddr(vlanif))
 }
 
-func (a *Composer) AddDNAT(protocol, dest, destTo string) error {
-	daddr, dport, err := ParseBind(protocol, dest)
+func (a *Composer) delRoute(vrf int, ipdst IPPrefix, ethsrc string) error {
+	// table=19 RIB
+	key := routeKey(vrf, ipdst) + "-" + ethsrc
+	if cookie, ok := a.cookies.Find(KindRoute, key); ok {
+		if err := a.withdraw(cookie); err != nil {
+			return err
+		}
+		a.cookies.Release(KindRoute, key)
+	}
+	a.releaseGroup(vrf, ipdst)
+	return nil
+}
+
+func (a *Composer) addSNAT(data schema.SNAT) error {
+	nat, err := snatNat(data)
 	if err != nil {
 		return err
 	}
-	toaddr, toport, err := ParseBind(protocol, destTo)
+	log.Printf("Compose.addSNAT: %s -> %s", data.Source, nat)
+	flow := a.snatFlow(data.Source)
+	flow.Actions = []ovs.Action{
+		ovs.ConnectionTracking(fmt.Sprintf("commit,nat(%s),zone=%d,table=%d", nat, ZoneNat, TablePbr)),
+	}
+	return a.addFlow(flow)
+}
+
+// snatFlow returns the flow of the new sessions from the source of a
+// SNAT rule, without its actions.
+func (a *Composer) snatFlow(source string) *ovs.Flow {
+	return &ovs.Flow{
+		Priority: 50,
+		Cookie:   a.cookies.Get(KindSNAT, snatKey(source)),
+		Table:    TableNat,
+		Protocol: ovs.ProtocolIPv4,
+		Matches: []ovs.Match{
+			ovs.ConnectionTrackingState(
+				ovs.SetState(ovs.CTStateTracked),
+				ovs.SetState(ovs.CTStateNew),
+			),
+			ovs.NetworkSource(source),
+		},
+	}
+}
+
+// AddSNAT translates the source of a rule to an address or a pool of
+// them, or to the one of an interface it follows.
+func (a *Composer) AddSNAT(data schema.SNAT) error {
+	err := checkSNAT(data)
 	if err != nil {
 		return err
 	}
-	err = a.addDNAT(protocol, daddr, dport, toaddr, toport)
-	if err == nil {
-		key := ToKey("dnat", protocol, dest)
-		if protocol == "icmp" {
-			key = ToKey("dnat", protocol, daddr)
+	switch {
+	case data.SourceTo != "" && data.Interface != "":
+		return fmt.Errorf("both a source and an interface")
+	case data.Interface != "":
+		if a.findVlanId(data.Interface) == 0 {
+			return fmt.Errorf("%s is not a VLAN interface", data.Interface)
 		}
+		err = a.masquerade(data)
+	case data.SourceTo != "":
+		err = a.addSNAT(data)
+	default:
+		return fmt.Errorf("no source or interface")
+	}
+	if err == nil {
+		key := snatKey(data.Source)
+		value := snatValue(data)
 		a.vsctl.Set.Bridge(a.brname, ovs.BridgeOptions{
-			OtherConfig: map[string]string{key: destTo},
+			OtherConfig: map[string]string{key: value},
 		})
-		a.others[key] = destTo
+		a.others[key] = value
 	}
 	return err
 }
 
-func (a *Composer) delDNAT(protocol, daddr string, dport uint16, toaddr string, toport uint16) error {
-	log.Printf("Compose.DelDNAT: %s:%d", daddr, dport)
+func (a *Composer) DelSNAT(source string) error {
+	log.Printf("Compose.DelSNAT: %s", source)
 
-	// to DNAT
-	matchs := []ovs.Match{
-		ovs.ConnectionTrackingState(
-			ovs.SetState(ovs.CTStateTracked),
-			ovs.SetState(ovs.CTStateNew),
-		),
-		ovs.NetworkDestination(daddr),
+	key := snatKey(source)
+	// A masquerade has no flow while its interface has no address.
+	if cookie, ok := a.cookies.Find(KindSNAT, key); ok {
+		if err := a.delFlows(cookieMatch(cookie)); err != nil {
+			return err
+		}
+		a.cookies.Release(KindSNAT, key)
 	}
-	if protocol == "tcp" || protocol == "udp" {
-		matchs = append(matchs, ovs.TransportDestinationPort(dport))
+	if _, ok := a.others[key]; ok {
+		a.vsctl.RemoveBridge(a.brname, "other_config", key)
+		delete(a.others, key)
 	}
-	if err := a.delFlows(&ovs.MatchFlow{
-		Cookie:   CookieIn,
-		Table:    TableNat,
-		Protocol: ovs.Protocol(protocol),
-		Matches:  matchs,
-	}); err != nil {
+	return nil
+}
+
+// A portSpan is a part of the destination ports of a DNAT rule translated
+// by a single nat action, from first to last and to the port to on, or
+// keeping them if to is 0. It has no ports for ICMP and IP.
+type portSpan struct {
+	first, last, to uint16
+}
+
+// translated returns the ports of a span after the translation.
+func (s portSpan) translated() (uint16, uint16) {
+	if s.to == 0 {
+		return s.first, s.last
+	}
+	return s.to, s.to + s.last - s.first
+}
+
+// dnatSpans returns the spans of the destination ports of a DNAT rule. A
+// range keeping its ports is a single span, a range moved to other ports
+// is split by port to keep the offset of each one.
+func dnatSpans(dest, to Endpoint) ([]portSpan, error) {
+	if dest.Port == 0 {
+		return []portSpan{{}}, nil
+	}
+	last := max(dest.PortMax, dest.Port)
+	if dest.PortMax == 0 {
+		return []portSpan{{dest.Port, last, to.Port}}, nil
+	}
+	if to.Port == 0 || to.Port == dest.Port {
+		return []portSpan{{dest.Port, last, 0}}, nil
+	}
+	if int(to.Port)+int(last-dest.Port) > 65535 {
+		return nil, fmt.Errorf("ports of %s beyond 65535", to)
+	}
+	if int(last-dest.Port) >= DNATShiftMax {
+		return nil, fmt.Errorf("range of %s moved to other ports, %d ports at most", dest, DNATShiftMax)
+	}
+	var spans []portSpan
+	for port := int(dest.Port); port <= int(last); port++ {
+		spans = append(spans, portSpan{uint16(port), uint16(port), to.Port + uint16(port) - dest.Port})
+	}
+	return spans, nil
+}
+
+// portMatches returns the alternative matches of the source or destination
+// ports from first to last, a range takes a masked match for each of its
+// aligned blocks. A nil match is returned without ports.
+func portMatches(source bool, first, last uint16) ([]ovs.Match, error) {
+	switch {
+	case first == 0:
+		return []ovs.Match{nil}, nil
+	case first == last && source:
+		return []ovs.Match{ovs.TransportSourcePort(first)}, nil
+	case first == last:
+		return []ovs.Match{ovs.TransportDestinationPort(first)}, nil
+	}
+	ranger := ovs.TransportDestinationPortRange(first, last)
+	if source {
+		ranger = ovs.TransportSourcePortRange(first, last)
+	}
+	return ranger.MaskedPorts()
+}
+
+// withPort adds the match of a port, if any, to other matches.
+func withPort(matches []ovs.Match, port ovs.Match) []ovs.Match {
+	if port == nil {
+		return matches
+	}
+	return append(slices.Clone(matches), port)
+}
+
+// dnatBuckets returns a bucket for each backend of a DNAT rule in
+// rotation, the ones failing their health check are left out.
+func (a *Composer) dnatBuckets(key string, data schema.DNAT) []*ovs.Bucket {
+	var buckets []*ovs.Bucket
+	check := a.checks[key]
+	dest, _ := parseDest(data.Protocol, data.Dest)
+	for _, backend := range data.Backends {
+		if check != nil && !check.Up(backend.Address) {
+			continue
+		}
+		to, _ := parseTo(data.Protocol, backend.Address)
+		spans, _ := dnatSpans(dest, to)
+		to.Port = spans[0].to
+		buckets = append(buckets, &ovs.Bucket{
+			Weight: max(backend.Weight, 1),
+			Actions: []ovs.Action{
+				ovs.ConnectionTracking(fmt.Sprintf("commit,nat(dst=%s),zone=%d,table=%d", to, ZoneNat, TablePbr)),
+			},
+		})
+	}
+	return buckets
+}
+
+// dnatGroup installs the select group balancing the sessions of a DNAT
+// rule among its backends, or replaces its buckets.
+func (a *Composer) dnatGroup(key string, data schema.DNAT) (uint32, error) {
+	group := &ovs.Group{
+		Type:            ovs.GroupSelect,
+		SelectionMethod: "hash",
+		Fields:          FamilyV4.Hash,
+		Buckets:         a.dnatBuckets(key, data),
+	}
+	if id, ok := a.groups[key]; ok {
+		group.ID = id
+		return id, a.modGroup(group)
+	}
+	a.nextId++
+	group.ID = a.nextId
+	if err := a.addGroup(group); err != nil {
+		return 0, err
+	}
+	a.groups[key] = group.ID
+	return group.ID, nil
+}
+
+// checkDNAT validates the destinations and health check of a DNAT rule,
+// and returns the addresses of its backends.
+func checkDNAT(data schema.DNAT) ([]string, error) {
+	switch data.Protocol {
+	case "tcp", "udp", "icmp", "ip":
+	default:
+		return nil, fmt.Errorf("unknown protocol %q", data.Protocol)
+	}
+	dest, err := parseDest(data.Protocol, data.Dest)
+	if err != nil {
+		return nil, err
+	}
+	backends := data.Backends
+	if len(backends) == 0 {
+		backends = []schema.Backend{{Address: data.DestTo}}
+	}
+	var addrs []string
+	for _, backend := range backends {
+		to, err := parseTo(data.Protocol, backend.Address)
+		if err != nil {
+			return nil, err
+		}
+		spans, err := dnatSpans(dest, to)
+		if err != nil {
+			return nil, err
+		}
+		if len(data.Backends) > 0 && len(spans) > 1 {
+			return nil, fmt.Errorf("backend %s moves the ports of a range", backend.Address)
+		}
+		if data.Check != "" && to.Port == 0 {
+			return nil, fmt.Errorf("health check of backend %s without port", backend.Address)
+		}
+		addrs = append(addrs, backend.Address)
+	}
+	switch {
+	case data.Check == "":
+	case data.Check != "tcp" && data.Check != "http":
+		return nil, fmt.Errorf("unknown health check %q", data.Check)
+	case data.Protocol != "tcp":
+		return nil, fmt.Errorf("health check of a %s rule", data.Protocol)
+	case len(data.Backends) == 0:
+		return nil, fmt.Errorf("health check without backends")
+	}
+	return addrs, nil
+}
+
+func (a *Composer) addDNAT(key string, data schema.DNAT) error {
+	addrs, err := checkDNAT(data)
+	if err != nil {
 		return err
 	}
+	protocol := data.Protocol
+	dest, _ := parseDest(protocol, data.Dest)
 
-	// Hairpin to SNAT
-	matchs = []ovs.Match{
+	// A rule posted again drops the flows, group and checker of the
+	// old one first.
+	if _, ok := a.cookies.Find(KindDNAT, key); ok {
+		if err := a.delDNAT(key); err != nil {
+			return err
+		}
+	}
+	cookie := a.cookies.Get(KindDNAT, key)
+	// to DNAT
+	matchs := []ovs.Match{
 		ovs.ConnectionTrackingState(
 			ovs.SetState(ovs.CTStateTracked),
 			ovs.SetState(ovs.CTStateNew),
 		),
-		ovs.NetworkDestination(daddr),
-		ovs.NetworkSource(toaddr),
+		ovs.NetworkDestination(dest.Addr),
+	}
+	// Behind the rules of a port of the same address.
+	priority := 160
+	if protocol == "ip" {
+		priority = 158
+	}
+	log.Printf("Compose.addDNAT: %s %s -> %s", protocol, dest, addrs)
+	var flows []*ovs.Flow
+	if len(data.Backends) == 0 {
+		to, _ := parseTo(protocol, data.DestTo)
+		spans, _ := dnatSpans(dest, to)
+		for _, span := range spans {
+			ports, err := portMatches(false, span.first, span.last)
+			if err != nil {
+				return err
+			}
+			for _, port := range ports {
+				flows = append(flows, &ovs.Flow{
+					Matches: withPort(matchs, port),
+					Actions: []ovs.Action{
+						ovs.ConnectionTracking(fmt.Sprintf("commit,nat(dst=%s),zone=%d,table=%d",
+							Endpoint{Addr: to.Addr, Port: span.to}, ZoneNat, TablePbr)),
+					},
+				})
+			}
+		}
+	} else {
+		if data.Check != "" && a.OnHealth != nil {
+			check := &Checker{
+				ns:    a.ns,
+				Key:   key,
+				Check: data.Check,
+				Path:  data.CheckPath,
+				Addrs: addrs,
+				On:    a.OnHealth,
+			}
+			check.Start()
+			a.checks[key] = check
+		}
+		id, err := a.dnatGroup(key, data)
+		if err != nil {
+			log.Printf("Composer.addDNAT: %v", err)
+			return err
+		}
+		ports, err := portMatches(false, dest.Port, max(dest.PortMax, dest.Port))
+		if err != nil {
+			return err
+		}
+		for _, port := range ports {
+			flows = append(flows, &ovs.Flow{
+				Matches: withPort(matchs, port),
+				Actions: []ovs.Action{ovs.GotoGroup(id)},
+			})
+		}
 	}
-	if protocol == "tcp" || protocol == "udp" {
-		matchs = append(matchs, ovs.TransportDestinationPort(dport))
+	for _, flow := range flows {
+		flow.Priority = priority
+		flow.Cookie = cookie
+		flow.Table = TableNat
+		flow.Protocol = ovs.Protocol(protocol)
 	}
-	if err := a.delFlows(&ovs.MatchFlow{
-		Cookie:   CookieIn,
-		Table:    TableNat,
-		Protocol: ovs.Protocol(protocol),
-		Matches:  matchs,
-	}); err != nil {
-		return err
+
+	for _, addr := range addrs {
+		to, _ := parseTo(protocol, addr)
+		spans, _ := dnatSpans(dest, to)
+		for _, span := range spans {
+			hairpin, err := hairpinFlows(cookie, protocol, dest.Addr, to.Addr, span)
+			if err != nil {
+				return err
+			}
+			flows = append(flows, hairpin...)
+		}
+	}
+	// A range moved to other ports takes flows for each port.
+	return a.addFlows(flows...)
+}
+
+// hairpinFlows let a backend reach itself through the destination of its
+// DNAT rule.
+func hairpinFlows(cookie uint64, protocol, daddr, toaddr string, span portSpan) ([]*ovs.Flow, error) {
+	first, last := span.translated()
+	var flows []*ovs.Flow
+	// Hainpin to SNAT
+	ports, err := portMatches(false, span.first, span.last)
+	if err != nil {
+		return nil, err
+	}
+	for _, port := range ports {
+		flows = append(flows, &ovs.Flow{
+			Priority: 162,
+			Cookie:   cookie,
+			Table:    TableNat,
+			Protocol: ovs.Protocol(protocol),
+			Matches: withPort([]ovs.Match{
+				ovs.ConnectionTrackingState(
+					ovs.SetState(ovs.CTStateTracked),
+					ovs.SetState(ovs.CTStateNew),
+				),
+				ovs.NetworkDestination(daddr),
+				ovs.NetworkSource(toaddr),
+			}, port),
+			Actions: []ovs.Action{
+				ovs.ConnectionTracking(fmt.Sprintf("commit,nat(dst=%s),zone=%d", Endpoint{Addr: toaddr, Port: span.to}, ZoneNat)),
+				ovs.Resubmit(0, TableNat),
+			},
+		})
 	}
 
 	// Hairpin unSNAT
-	matchs = []ovs.Match{
-		ovs.ConnectionTrackingState(
-			ovs.SetState(ovs.CTStateTracked),
-			ovs.SetState(ovs.CTStateReply),
-		),
-		ovs.NetworkDestination(toaddr),
-		ovs.NetworkSource(toaddr),
+	ports, err = portMatches(true, first, last)
+	if err != nil {
+		return nil, err
 	}
-	if protocol == "tcp" || protocol == "udp" {
-		matchs = append(matchs, ovs.TransportSourcePort(toport))
+	for _, port := range ports {
+		flows = append(flows, &ovs.Flow{
+			Priority: 202,
+			Cookie:   cookie,
+			Table:    TableNat,
+			Protocol: ovs.Protocol(protocol),
+			Matches: withPort([]ovs.Match{
+				ovs.ConnectionTrackingState(
+					ovs.SetState(ovs.CTStateTracked),
+					ovs.SetState(ovs.CTStateReply),
+				),
+				ovs.NetworkDestination(toaddr),
+				ovs.NetworkSource(toaddr),
+			}, port),
+			Actions: []ovs.Action{
+				ovs.ClearCt(),
+				ovs.Resubmit(0, TableCt),
+			},
+		})
 	}
-	if err := a.delFlows(&ovs.MatchFlow{
-		Cookie:   CookieIn,
-		Table:    TableNat,
-		Protocol: ovs.Protocol(protocol),
-		Matches:  matchs,
-	}); err != nil {
-		return err
+	ports, err = portMatches(false, first, last)
+	if err != nil {
+		return nil, err
 	}
-	matchs = []ovs.Match{
-		ovs.ConnectionTrackingState(
-			ovs.SetState(ovs.CTStateTracked),
-			ovs.SetState(ovs.CTStateEstablished),
-		),
-		ovs.NetworkDestination(toaddr),
-		ovs.NetworkSource(toaddr),
+	for _, port := range ports {
+		flows = append(flows, &ovs.Flow{
+			Priority: 202,
+			Cookie:   cookie,
+			Table:    TableNat,
+			Protocol: ovs.Protocol(protocol),
+			Matches: withPort([]ovs.Match{
+				ovs.ConnectionTrackingState(
+					ovs.SetState(ovs.CTStateTracked),
+					ovs.SetState(ovs.CTStateEstablished),
+				),
+				ovs.NetworkDestination(toaddr),
+				ovs.NetworkSource(toaddr),
+			}, port),
+			Actions: []ovs.Action{
+				ovs.ClearCt(),
+				ovs.Resubmit(0, TableCt),
+			},
+		})
 	}
-	if protocol == "tcp" || protocol == "udp" {
-		matchs = append(matchs, ovs.TransportDestinationPort(toport))
+	return flows, nil
+}
+
+// EncodeOptions returns the options of an object as a value of the
+// other_config of the bridge. The values are separated by ';', as the
+// map of the bridge is split on ','.
+func EncodeOptions(options map[string]string) string {
+	var keys []string
+	for key := range options {
+		keys = append(keys, key)
 	}
-	if err := a.delFlows(&ovs.MatchFlow{
-		Cookie:   CookieIn,
-		Table:    TableNat,
-		Protocol: ovs.Protocol(protocol),
-		Matches:  matchs,
-	}); err != nil {
-		return err
+	sort.Strings(keys)
+
+	var values []string
+	for _, key := range keys {
+		values = append(values, key+"="+options[key])
 	}
+	return strings.Join(values, ";")
+}
 
-	return nil
+// DecodeOptions parses the options encoded by EncodeOptions.
+func DecodeOptions(value string) map[string]string {
+	options := make(map[string]string)
+	for _, pair := range strings.Split(value, ";") {
+		if kv := strings.SplitN(pair, "=", 2); len(kv) == 2 {
+			options[kv[0]] = kv[1]
+		}
+	}
+	return options
 }
 
-func (a *Composer) DelDNAT(protocol, dest string) error {
-	daddr, dport, err := ParseBind(protocol, dest)
-	if err != nil {
+// AddDNAT translates the destination of a rule to a single one, or
+// balances its sessions among weighted backends.
+func (a *Composer) AddDNAT(data schema.DNAT) error {
+	if data.DestTo != "" && len(data.Backends) > 0 {
+		return fmt.Errorf("both a destination and backends")
+	}
+	if data.DestTo == "" && len(data.Backends) == 0 {
+		return fmt.Errorf("no destination")
+	}
+	key := dnatKey(data)
+	err := a.addDNAT(key, data)
+	if err == nil {
+		value := dnatValue(data)
+		a.vsctl.Set.Bridge(a.brname, ovs.BridgeOptions{
+			OtherConfig: map[string]string{key: value},
+		})
+		a.others[key] = value
+	}
+	return err
+}
+
+// delDNAT removes the flows of a DNAT rule, the hairpin ones included,
+// and the group and health check of its backends.
+func (a *Composer) delDNAT(key string) error {
+	log.Printf("Compose.DelDNAT: %s", key)
+	if check, ok := a.checks[key]; ok {
+		check.Stop()
+		delete(a.checks, key)
+	}
+	cookie, ok := a.cookies.Find(KindDNAT, key)
+	if !ok {
+		return nil
+	}
+	if err := a.delFlows(cookieMatch(cookie)); err != nil {
 		return err
 	}
-	key := ToKey("dnat", protocol, dest)
-	if protocol == "icmp" {
-		key = ToKey("dnat", protocol, daddr)
+	a.cookies.Release(KindDNAT, key)
+	if id, ok := a.groups[key]; ok {
+		a.delGroups(id)
+		delete(a.groups, key)
 	}
-	if destTo, ok := a.others[key]; ok {
-		toaddr, toport, err := ParseBind(protocol, destTo)
-		if err != nil {
-			return err
-		}
-		if err = a.delDNAT(protocol, daddr, dport, toaddr, toport); err == nil {
+	return nil
+}
+
+func (a *Composer) DelDNAT(data schema.DNAT) error {
+	if _, err := parseDest(data.Protocol, data.Dest); err != nil {
+		return err
+	}
+	key := dnatKey(data)
+	if _, ok := a.others[key]; ok {
+		err := a.delDNAT(key)
+		if err == nil {
 			a.vsctl.RemoveBridge(a.brname, "other_config", key)
 			delete(a.others, key)
 		}
@@ -770,6 +1923,39 @@ func (a *Composer) DelDNAT(protocol, dest string) error {
 	return nil
 }
 
+// Rebalance puts the backends of a DNAT rule in or out of rotation after
+// their health check.
+func (a *Composer) Rebalance(key string) error {
+	value, ok := a.others[key]
+	if !ok {
+		return nil
+	}
+	data := dnatRule(strings.TrimPrefix(key, "dnat-"), value)
+	if len(data.Backends) == 0 {
+		return nil
+	}
+	if _, err := a.dnatGroup(key, data); err != nil {
+		log.Printf("Composer.Rebalance: %v", err)
+		return err
+	}
+	return nil
+}
+
+// backendStates sets the state of the health checked backends of a
+// DNAT rule.
+func (a *Composer) backendStates(key string, backends []schema.Backend) {
+	check, ok := a.checks[key]
+	if !ok {
+		return
+	}
+	for i := range backends {
+		backends[i].State = "down"
+		if check.Up(backends[i].Address) {
+			backends[i].State = "up"
+		}
+	}
+}
+
 func (a *Composer) ListSNAT() map[string]string {
 	results := map[string]string{}
 	for key, value := range a.others {
@@ -790,34 +1976,51 @@ func (a *Composer) ListDNAT() map[string]string {
 	return results
 }
 
-func (a *Composer) AddLocal(addr string) error {
-	log.Printf("Compose.AddLocal: %s", addr)
-	host := strings.SplitN(addr, "/", 2)[0]
-	return a.addFlow(&ovs.Flow{
+func (a *Composer) AddLocal(vrf int, addr string) error {
+	log.Printf("Compose.AddLocal: %s in %d", addr, vrf)
+	host := IPAddr(strings.SplitN(addr, "/", 2)[0])
+	family := host.Family()
+	cookie := a.cookies.Get(KindLocal, fmt.Sprintf("%d-%s", vrf, host))
+	a.program(&ovs.Flow{
 		Priority: 150,
-		Cookie:   CookieIn,
+		Cookie:   cookie,
 		Table:    TableNat,
-		Protocol: ovs.ProtocolIPv4,
+		Protocol: family.Protocol,
 		Matches: []ovs.Match{
-			ovs.NetworkDestination(host),
+			family.Destination(host.Str()),
 		},
 		Actions: []ovs.Action{
-			ovs.Resubmit(0, TableRib),
+			ovs.Resubmit(0, family.Rib),
 		},
 	})
-}
-
-func (a *Composer) DelLocal(addr string) error {
-	log.Printf("Compose.DelLocal: %s", addr)
-	host := strings.SplitN(addr, "/", 2)[0]
-	return a.delFlows(&ovs.MatchFlow{
-		Cookie:   CookieIn,
-		Table:    TableNat,
-		Protocol: ovs.ProtocolIPv4,
+	// Goes to the kernel before the TTL check and without the meter.
+	a.program(&ovs.Flow{
+		Priority: 310,
+		Cookie:   cookie,
+		Table:    family.Rib,
+		Protocol: family.Protocol,
 		Matches: []ovs.Match{
-			ovs.NetworkDestination(host),
+			ovs.Metadata(uint64(vrf)),
+			family.Destination(host.Str()),
+		},
+		Actions: []ovs.Action{
+			ovs.Resubmit(0, TableFdb),
 		},
 	})
+	return nil
+}
+
+func (a *Composer) DelLocal(vrf int, addr string) error {
+	log.Printf("Compose.DelLocal: %s in %d", addr, vrf)
+	host := IPAddr(strings.SplitN(addr, "/", 2)[0])
+	key := fmt.Sprintf("%d-%s", vrf, host)
+	cookie, ok := a.cookies.Find(KindLocal, key)
+	if !ok {
+		return nil
+	}
+	err := a.withdraw(cookie)
+	a.cookies.Release(KindLocal, key)
+	return err
 }
 
 type HWAddr string
@@ -826,15 +2029,90 @@ func (m HWAddr) Hex() string {
 	return fmt.Sprintf("0x%s", strings.Replace(string(m), ":", "", 5))
 }
 
+// An IPFamily holds the tables, protocol and fields used to forward one
+// address family through the pipeline.
+type IPFamily struct {
+	Protocol ovs.Protocol
+	Rib      int
+	Fib      int
+	Dst      string   // field of the destination address
+	Reg      string   // register holding the next-hop
+	Hash     []string // fields hashed to pick an ECMP next-hop
+}
+
+var (
+	FamilyV4 = IPFamily{
+		Protocol: ovs.ProtocolIPv4,
+		Rib:      TableRib,
+		Fib:      TableFib,
+		Dst:      "OXM_OF_IPV4_DST",
+		Reg:      "reg0",
+		Hash:     []string{"ip_src", "ip_dst", "nw_proto", "tcp_src", "tcp_dst", "udp_src", "udp_dst"},
+	}
+	FamilyV6 = IPFamily{
+		Protocol: ovs.ProtocolIPv6,
+		Rib:      TableRib6,
+		Fib:      TableFib6,
+		Dst:      "NXM_NX_IPV6_DST",
+		Reg:      "xxreg3",
+		Hash:     []string{"ipv6_src", "ipv6_dst", "nw_proto", "tcp_src", "tcp_dst", "udp_src", "udp_dst"},
+	}
+)
+
+func (f IPFamily) Destination(ip string) ovs.Match {
+	if f.Protocol == ovs.ProtocolIPv6 {
+		return ovs.IPv6Destination(ip)
+	}
+	return ovs.NetworkDestination(ip)
+}
+
+func (f IPFamily) Source(ip string) ovs.Match {
+	if f.Protocol == ovs.ProtocolIPv6 {
+		return ovs.IPv6Source(ip)
+	}
+	return ovs.NetworkSource(ip)
+}
+
+// Origin matches the source of a packet before NAT, IPv6 is never
+// translated.
+func (f IPFamily) Origin(ip string) ovs.Match {
+	if f.Protocol == ovs.ProtocolIPv6 {
+		return ovs.IPv6Source(ip)
+	}
+	if !strings.Contains(ip, "/") {
+		ip += "/32"
+	}
+	_, prefix, err := net.ParseCIDR(ip)
+	if err != nil {
+		return ovs.NetworkSource(ip)
+	}
+	addr, mask := prefix.IP.To4(), net.IP(prefix.Mask).To4()
+	return ovs.FieldMatch("reg3", fmt.Sprintf("0x%x/0x%x", []byte(addr), []byte(mask)))
+}
+
 type IPAddr string
 
 func (i IPAddr) Hex() string {
 	addr := net.ParseIP(string(i))
-	if addr != nil {
-		bytes := addr.To4()
+	if addr == nil {
+		return ""
+	}
+	if bytes := addr.To4(); bytes != nil {
 		return fmt.Sprintf("0x%02x%02x%02x%02x", bytes[0], bytes[1], bytes[2], bytes[3])
 	}
-	return ""
+	return fmt.Sprintf("0x%x", []byte(addr.To16()))
+}
+
+func (i IPAddr) IsV6() bool {
+	addr := net.ParseIP(string(i))
+	return addr != nil && addr.To4() == nil
+}
+
+func (i IPAddr) Family() IPFamily {
+	if i.IsV6() {
+		return FamilyV6
+	}
+	return FamilyV4
 }
 
 func (i IPAddr) Str() string {
@@ -847,6 +2125,18 @@ func (i IPPrefix) Str() string {
 	return string(i)
 }
 
+func (i IPPrefix) IsV6() bool {
+	_, ipnet, err := net.ParseCIDR(string(i))
+	return err == nil && ipnet.IP.To4() == nil
+}
+
+func (i IPPrefix) Family() IPFamily {
+	if i.IsV6() {
+		return FamilyV6
+	}
+	return FamilyV4
+}
+
 func (i IPPrefix) Prefixlen() int {
 	_, ipnet, err := net.ParseCIDR(string(i))
 	if err != nil {
@@ -0,0 +1,1078 @@
+package vrr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/luscis/openvrr/pkg/ovs"
+	"github.com/luscis/openvrr/pkg/schema"
+)
+
+// newTestComposer returns a staging Composer with all its maps on br0,
+// its ovs client runs the fakes of options and does nothing otherwise.
+func newTestComposer(t *testing.T, options ...ovs.OptionFunc) *Composer {
+	t.Helper()
+	nop := []ovs.OptionFunc{
+		ovs.Exec(func(cmd string, args ...string) ([]byte, error) {
+			return nil, nil
+		}),
+		ovs.Pipe(func(stdin io.Reader, cmd string, args ...string) ([]byte, error) {
+			return nil, nil
+		}),
+	}
+	a := &Composer{brname: "br0", staging: true}
+	a.initMaps()
+	a.client = ovs.New(append(nop, options...)...)
+	a.vsctl = a.client.VSwitch
+	a.ofctl = a.client.OpenFlow
+	a.queue = NewFlowQueue(a.brname, a.ofctl)
+	return a
+}
+
+func TestComposerDelFlows(t *testing.T) {
+	a := newTestComposer(t)
+	flow := func(cookie uint64, table int, dst string) *ovs.Flow {
+		return &ovs.Flow{
+			Priority: 100,
+			Cookie:   cookie,
+			Table:    table,
+			Protocol: ovs.ProtocolIPv4,
+			Matches:  []ovs.Match{ovs.NetworkDestination(dst)},
+		}
+	}
+	snat := uint64(KindSNAT<<CookieKindShift | 1)
+	dnat := uint64(KindDNAT<<CookieKindShift | 1)
+	a.addFlow(flow(snat, TableNat, "10.0.0.1"))
+	a.addFlow(flow(snat, TablePbr, "10.0.0.1"))
+	a.addFlow(flow(dnat, TableNat, "10.0.0.2"))
+	a.program(flow(CookieIn, TableRib, "10.0.0.3"))
+
+	a.delFlows(cookieMatch(snat))
+	if want, got := 2, len(a.flows); want != got {
+		t.Fatalf("unexpected flows:\n- want: %v\n-  got: %v", want, got)
+	}
+	if _, ok := a.owned[snat]; ok {
+		t.Fatalf("unexpected flows of cookie %x: %v", snat, a.owned[snat])
+	}
+	if want, got := 1, len(a.owned[dnat]); want != got {
+		t.Fatalf("unexpected flows of cookie %x:\n- want: %v\n-  got: %v", dnat, want, got)
+	}
+
+	a.delFlows(&ovs.MatchFlow{Table: TableRib})
+	if want, got := 1, len(a.flows); want != got {
+		t.Fatalf("unexpected flows:\n- want: %v\n-  got: %v", want, got)
+	}
+
+	a.withdraw(dnat)
+	if want, got := 0, len(a.flows)+len(a.owned); want != got {
+		t.Fatalf("unexpected flows:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestComposerSNAT(t *testing.T) {
+	tests := []struct {
+		desc  string
+		data  schema.SNAT
+		want  []string
+		value string
+		err   bool
+	}{
+		{
+			desc: "address",
+			data: schema.SNAT{Source: "192.168.2.0/24", SourceTo: "10.0.0.1"},
+			want: []string{
+				"priority=50,ip,ct_state=+trk+new,nw_src=192.168.2.0/24,table=12,idle_timeout=0,actions=ct(commit,nat(src=10.0.0.1),zone=10,table=15)",
+			},
+			value: "10.0.0.1",
+		},
+		{
+			desc: "pool",
+			data: schema.SNAT{Source: "192.168.2.0/24", SourceTo: "10.0.0.1-10.0.0.4"},
+			want: []string{
+				"priority=50,ip,ct_state=+trk+new,nw_src=192.168.2.0/24,table=12,idle_timeout=0,actions=ct(commit,nat(src=10.0.0.1-10.0.0.4),zone=10,table=15)",
+			},
+			value: "10.0.0.1-10.0.0.4",
+		},
+		{
+			desc: "pool with ports",
+			data: schema.SNAT{Source: "192.168.2.0/24", SourceTo: "10.0.0.1-10.0.0.4", Ports: "10000-20000", Mode: "hash", Persistent: true},
+			want: []string{
+				"priority=50,ip,ct_state=+trk+new,nw_src=192.168.2.0/24,table=12,idle_timeout=0,actions=ct(commit,nat(src=10.0.0.1-10.0.0.4:10000-20000,hash,persistent),zone=10,table=15)",
+			},
+			value: "mode=hash;persistent=true;ports=10000-20000;to=10.0.0.1-10.0.0.4",
+		},
+		{
+			desc: "port in the address",
+			data: schema.SNAT{Source: "192.168.2.0/24", SourceTo: "10.0.0.1:10000"},
+			err:  true,
+		},
+		{
+			desc: "reversed pool",
+			data: schema.SNAT{Source: "192.168.2.0/24", SourceTo: "10.0.0.4-10.0.0.1"},
+			err:  true,
+		},
+		{
+			desc: "unknown mode",
+			data: schema.SNAT{Source: "192.168.2.0/24", SourceTo: "10.0.0.1", Mode: "fully-random"},
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			a := newTestComposer(t)
+			if tt.err {
+				if err := a.AddSNAT(tt.data); err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			add := func(a *Composer) error {
+				return a.AddSNAT(tt.data)
+			}
+			if want, got := tt.want, addedFlows(t, a, add); !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected flows:\n- want: %v\n-  got: %v", want, got)
+			}
+			if want, got := tt.value, a.others[snatKey(tt.data.Source)]; want != got {
+				t.Fatalf("unexpected value:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
+
+func TestComposerAddDNATAgain(t *testing.T) {
+	a := newTestComposer(t)
+	data := schema.DNAT{Protocol: "tcp", Dest: "10.0.0.1:80", DestTo: "192.168.1.10:8080"}
+	key := dnatKey(data)
+	if err := a.addDNAT(key, data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	count := len(a.flows)
+
+	// Posted again to another server, none of the flows of the old one
+	// are left.
+	data.DestTo = "192.168.1.11:8080"
+	if err := a.addDNAT(key, data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want, got := count, len(a.flows); want != got {
+		t.Fatalf("unexpected flows:\n- want: %v\n-  got: %v", want, got)
+	}
+	for _, flow := range a.flows {
+		b, err := flow.MarshalText()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if strings.Contains(string(b), "192.168.1.10") {
+			t.Fatalf("unexpected flow of the old rule: %s", b)
+		}
+	}
+}
+
+func TestDNATSpans(t *testing.T) {
+	tests := []struct {
+		desc  string
+		dest  Endpoint
+		to    Endpoint
+		spans []portSpan
+		err   bool
+	}{
+		{
+			desc:  "no port",
+			dest:  Endpoint{Addr: "10.0.0.3"},
+			to:    Endpoint{Addr: "192.168.1.4"},
+			spans: []portSpan{{}},
+		},
+		{
+			desc:  "port",
+			dest:  Endpoint{Addr: "10.0.0.1", Port: 80},
+			to:    Endpoint{Addr: "192.168.1.2", Port: 8000},
+			spans: []portSpan{{80, 80, 8000}},
+		},
+		{
+			desc:  "range keeping its ports",
+			dest:  Endpoint{Addr: "10.0.0.1", Port: 10000, PortMax: 10999},
+			to:    Endpoint{Addr: "192.168.1.3"},
+			spans: []portSpan{{10000, 10999, 0}},
+		},
+		{
+			desc:  "range to its own start",
+			dest:  Endpoint{Addr: "10.0.0.1", Port: 10000, PortMax: 10999},
+			to:    Endpoint{Addr: "192.168.1.3", Port: 10000},
+			spans: []portSpan{{10000, 10999, 0}},
+		},
+		{
+			desc:  "range moved",
+			dest:  Endpoint{Addr: "10.0.0.1", Port: 80, PortMax: 82},
+			to:    Endpoint{Addr: "192.168.1.3", Port: 8080},
+			spans: []portSpan{{80, 80, 8080}, {81, 81, 8081}, {82, 82, 8082}},
+		},
+		{
+			desc: "range moved beyond 65535",
+			dest: Endpoint{Addr: "10.0.0.1", Port: 1000, PortMax: 1010},
+			to:   Endpoint{Addr: "192.168.1.3", Port: 65530},
+			err:  true,
+		},
+		{
+			desc: "range moved too large",
+			dest: Endpoint{Addr: "10.0.0.1", Port: 10000, PortMax: 10000 + DNATShiftMax},
+			to:   Endpoint{Addr: "192.168.1.3", Port: 30000},
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			spans, err := dnatSpans(tt.dest, tt.to)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(tt.spans, spans) {
+				t.Fatalf("unexpected spans:\n- want: %v\n-  got: %v", tt.spans, spans)
+			}
+		})
+	}
+	if spans, err := dnatSpans(
+		Endpoint{Addr: "10.0.0.1", Port: 10000, PortMax: 10000 + DNATShiftMax - 1},
+		Endpoint{Addr: "192.168.1.3", Port: 30000},
+	); err != nil || len(spans) != DNATShiftMax {
+		t.Fatalf("unexpected spans of the largest range: %d, %v", len(spans), err)
+	}
+}
+
+func TestPortMatches(t *testing.T) {
+	tests := []struct {
+		desc    string
+		source  bool
+		first   uint16
+		last    uint16
+		matches []string
+		err     bool
+	}{
+		{
+			desc:    "no port",
+			matches: []string{""},
+		},
+		{
+			desc:    "destination port",
+			first:   80,
+			last:    80,
+			matches: []string{"tp_dst=80"},
+		},
+		{
+			desc:    "source port",
+			source:  true,
+			first:   8080,
+			last:    8080,
+			matches: []string{"tp_src=8080"},
+		},
+		{
+			desc:    "aligned range",
+			first:   0x1000,
+			last:    0x1fff,
+			matches: []string{"tp_dst=0x1000/0xf000"},
+		},
+		{
+			desc:    "range of blocks",
+			source:  true,
+			first:   80,
+			last:    83,
+			matches: []string{"tp_src=0x0050/0xfffc"},
+		},
+		{
+			desc:    "unaligned range",
+			first:   81,
+			last:    84,
+			matches: []string{"tp_dst=0x0051/0xffff", "tp_dst=0x0052/0xfffe", "tp_dst=0x0054/0xffff"},
+		},
+		{
+			desc:  "reversed range",
+			first: 90,
+			last:  80,
+			err:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			matches, err := portMatches(tt.source, tt.first, tt.last)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var got []string
+			for _, match := range matches {
+				if match == nil {
+					got = append(got, "")
+					continue
+				}
+				b, err := match.MarshalText()
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				got = append(got, string(b))
+			}
+			if !reflect.DeepEqual(tt.matches, got) {
+				t.Fatalf("unexpected matches:\n- want: %v\n-  got: %v", tt.matches, got)
+			}
+		})
+	}
+}
+
+func TestComposerAddDNATBundle(t *testing.T) {
+	var execs, bundles []string
+	a := newTestComposer(t,
+		ovs.Exec(func(cmd string, args ...string) ([]byte, error) {
+			execs = append(execs, strings.Join(args, " "))
+			return nil, nil
+		}),
+		ovs.Pipe(func(stdin io.Reader, cmd string, args ...string) ([]byte, error) {
+			b, err := io.ReadAll(stdin)
+			bundles = append(bundles, string(b))
+			return nil, err
+		}),
+	)
+	a.staging = false
+	data := schema.DNAT{Protocol: "udp", Dest: "10.0.0.1:10000-10099", DestTo: "192.168.1.3:30000"}
+	if err := a.addDNAT(dnatKey(data), data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want, got := 0, len(execs); want != got {
+		t.Fatalf("unexpected calls:\n- want: %v\n-  got: %v", want, execs)
+	}
+	if want, got := 1, len(bundles); want != got {
+		t.Fatalf("unexpected bundles:\n- want: %v\n-  got: %v", want, got)
+	}
+	// A DNAT flow and three hairpin flows for each port.
+	if want, got := 400, strings.Count(bundles[0], "\n"); want != got {
+		t.Fatalf("unexpected flows in the bundle:\n- want: %v\n-  got: %v", want, got)
+	}
+	if want, got := 400, len(a.flows); want != got {
+		t.Fatalf("unexpected flows:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestComposerAlive(t *testing.T) {
+	tests := []struct {
+		desc  string
+		out   string
+		err   error
+		alive bool
+	}{
+		{
+			desc:  "watch flow in place",
+			out:   "NXST_AGGREGATE reply (xid=0x4): packet_count=0 byte_count=0 flow_count=1",
+			alive: true,
+		},
+		{
+			desc:  "flows lost",
+			out:   "NXST_AGGREGATE reply (xid=0x4): packet_count=0 byte_count=0 flow_count=0",
+			alive: false,
+		},
+		{
+			desc:  "vswitchd not answering",
+			err:   errors.New("ovs-ofctl: br0 is not a bridge or a socket"),
+			alive: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			a := newTestComposer(t, ovs.Exec(func(cmd string, args ...string) ([]byte, error) {
+				return []byte(tt.out), tt.err
+			}))
+			if want, got := tt.alive, a.Alive(); want != got {
+				t.Fatalf("unexpected alive:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
+
+func TestComposerReconcile(t *testing.T) {
+	// The flows staged below as ovs-ofctl dumps them with OpenFlow 1.5.
+	dump := []string{
+		"OFPST_FLOW reply (OF1.5) (xid=0x2):",
+		" cookie=0x67db2257fdf40, duration=31.012s, table=10, n_packets=12, n_bytes=1008, idle_age=2, priority=36864,ip,reg2=0/0x1,nw_src=192.168.1.0/24 actions=set_field:0x1/0x1->reg2,ct(commit,table=10,zone=1000)",
+		" cookie=0x51b7e7e5511e0, duration=31.012s, table=12, n_packets=0, n_bytes=0, priority=160,ct_state=+new+trk,tcp,nw_dst=10.0.0.1,tp_dst=80 actions=ct(commit,table=15,zone=10,nat(dst=192.168.1.10:8080))",
+		" cookie=0x51b7e7e5511e0, duration=31.012s, table=12, n_packets=0, n_bytes=0, priority=162,ct_state=+new+trk,tcp,nw_src=192.168.1.10,nw_dst=10.0.0.1,tp_dst=80 actions=ct(commit,zone=10,nat(dst=192.168.1.10:8080)),resubmit(,12)",
+		" cookie=0x51b7e7e5511e0, duration=31.012s, table=12, n_packets=0, n_bytes=0, priority=202,ct_state=+est+trk,tcp,nw_src=192.168.1.10,nw_dst=192.168.1.10,tp_dst=8080 actions=ct_clear,resubmit(,10)",
+		" cookie=0x51b7e7e5511e0, duration=31.012s, table=12, n_packets=0, n_bytes=0, priority=202,ct_state=+rpl+trk,tcp,nw_src=192.168.1.10,nw_dst=192.168.1.10,tp_src=8080 actions=ct_clear,resubmit(,10)",
+		" cookie=0x40e5af62d48c7, duration=31.012s, table=12, n_packets=0, n_bytes=0, priority=50,ct_state=+new+trk,ip,nw_src=192.168.2.0/24 actions=drop",
+		" cookie=0x67db2257fdf40, duration=31.012s, table=15, n_packets=12, n_bytes=1008, idle_age=2, priority=36864,ct_state=+rpl+trk,ip,reg2=0/0x1,nw_dst=192.168.1.0/24 actions=set_field:0x1/0x1->reg2,ct(table=15,zone=1000)",
+		" cookie=0x2024, duration=31.012s, table=250, n_packets=0, n_bytes=0, priority=0 actions=drop",
+	}
+	tests := []struct {
+		desc    string
+		dump    []string
+		err     error
+		adds    int
+		dels    int
+		staging bool
+	}{
+		{
+			desc: "flows in place",
+			dump: dump,
+		},
+		{
+			desc: "stale flow",
+			dump: append(slices.Clone(dump),
+				" cookie=0x10000000000002, duration=31.012s, table=19, n_packets=0, n_bytes=0, priority=116,ip,metadata=0,nw_dst=10.9.0.0/16 actions=resubmit(,20)"),
+			dels: 1,
+		},
+		{
+			desc: "other actions",
+			dump: append(slices.Clone(dump[:6]),
+				" cookie=0x40e5af62d48c7, duration=31.012s, table=12, n_packets=0, n_bytes=0, priority=50,ct_state=+new+trk,ip,nw_src=192.168.2.0/24 actions=ct(commit,table=15,zone=10,nat(src=10.10.10.1))",
+				dump[7], dump[8]),
+			adds: 1,
+			dels: 1,
+		},
+		{
+			desc:    "dump failed",
+			err:     errors.New("ovs-ofctl: br0 is not a bridge or a socket"),
+			staging: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			var bundles [][]string
+			a := newTestComposer(t,
+				ovs.Exec(func(cmd string, args ...string) ([]byte, error) {
+					return []byte(strings.Join(tt.dump, "\n")), tt.err
+				}),
+				ovs.Pipe(func(stdin io.Reader, cmd string, args ...string) ([]byte, error) {
+					b, err := io.ReadAll(stdin)
+					bundles = append(bundles, strings.Split(strings.TrimSpace(string(b)), "\n"))
+					return nil, err
+				}),
+			)
+			a.subnets["vlan10"] = []string{"192.168.1.0/24"}
+			if err := a.addQuota(schema.ConntrackLimit{Interface: "vlan10", Zone: 1000, Limit: 100}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			data := schema.DNAT{Protocol: "tcp", Dest: "10.0.0.1:80", DestTo: "192.168.1.10:8080"}
+			if err := a.addDNAT(dnatKey(data), data); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := a.masquerade(schema.SNAT{Source: "192.168.2.0/24", Interface: "vlan11"}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			a.addFlow(&ovs.Flow{
+				Priority: 0,
+				Cookie:   CookieWatch,
+				Table:    TableWatch,
+				Actions:  []ovs.Action{ovs.Drop()},
+			})
+
+			if err := a.Reconcile(); (err != nil) != (tt.err != nil) {
+				t.Fatalf("unexpected error:\n- want: %v\n-  got: %v", tt.err, err)
+			}
+			var adds, dels int
+			for _, bundle := range bundles {
+				for _, line := range bundle {
+					if strings.HasPrefix(line, "add ") {
+						adds++
+					} else if strings.HasPrefix(line, "delete ") {
+						dels++
+					}
+				}
+			}
+			if want, got := tt.adds, adds; want != got {
+				t.Fatalf("unexpected adds:\n- want: %v\n-  got: %v", want, bundles)
+			}
+			if want, got := tt.dels, dels; want != got {
+				t.Fatalf("unexpected deletes:\n- want: %v\n-  got: %v", want, bundles)
+			}
+			if want, got := tt.staging, a.staging; want != got {
+				t.Fatalf("unexpected staging:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
+
+// tableFlows returns the flows kept in a table at a priority, sorted.
+func tableFlows(t *testing.T, a *Composer, table, priority int) []string {
+	t.Helper()
+	var flows []string
+	for _, flow := range a.flows {
+		if flow.Table != table || flow.Priority != priority {
+			continue
+		}
+		b, err := flow.MarshalText()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		flows = append(flows, string(b))
+	}
+	sort.Strings(flows)
+	return flows
+}
+
+// addedFlows returns the flows kept or replaced by add, sorted and
+// without their cookie, a hash of the key of their object.
+func addedFlows(t *testing.T, a *Composer, add func(a *Composer) error) []string {
+	t.Helper()
+	old := maps.Clone(a.flows)
+	if err := add(a); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var flows []string
+	for key, flow := range a.flows {
+		if old[key] == flow {
+			continue
+		}
+		b, err := flow.MarshalText()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		text := string(b)
+		flows = append(flows, text[:strings.Index(text, ",cookie=")]+text[strings.Index(text, ",actions="):])
+	}
+	sort.Strings(flows)
+	return flows
+}
+
+func TestComposerGlean(t *testing.T) {
+	a := newTestComposer(t)
+	a.pipeline()
+
+	// Back to the ingress interface through the FDB, with the VLAN and
+	// the MAC of the router, so the kernel routes it from there.
+	tests := []struct {
+		desc  string
+		table int
+		want  []string
+	}{
+		{
+			desc:  "ipv4",
+			table: TableFib,
+			want: []string{
+				"priority=1,ip,dl_dst=00:00:00:00:20:15,table=20,idle_timeout=0,cookie=0x0000000000002021,actions=meter:2,resubmit(,30)",
+			},
+		},
+		{
+			desc:  "ipv6",
+			table: TableFib6,
+			want: []string{
+				"priority=1,ipv6,dl_dst=00:00:00:00:20:15,table=22,idle_timeout=0,cookie=0x0000000000002021,actions=meter:2,resubmit(,30)",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if want, got := tt.want, tableFlows(t, a, tt.table, 1); !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected flows:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
+
+func TestComposerPunt(t *testing.T) {
+	a := newTestComposer(t)
+	a.pipeline()
+
+	// Routed packets the kernel answers with ICMP errors, under the
+	// punt meter.
+	tests := []struct {
+		desc     string
+		table    int
+		priority int
+		want     []string
+	}{
+		{
+			desc:     "ttl exceeded",
+			table:    TableRib,
+			priority: 300,
+			want: []string{
+				"priority=300,ip,nw_ttl=0,dl_dst=00:00:00:00:20:15,table=19,idle_timeout=0,cookie=0x0000000000002021,actions=meter:1,resubmit(,30)",
+				"priority=300,ip,nw_ttl=1,dl_dst=00:00:00:00:20:15,table=19,idle_timeout=0,cookie=0x0000000000002021,actions=meter:1,resubmit(,30)",
+			},
+		},
+		{
+			desc:     "hop limit exceeded",
+			table:    TableRib6,
+			priority: 300,
+			want: []string{
+				"priority=300,ipv6,nw_ttl=0,dl_dst=00:00:00:00:20:15,table=21,idle_timeout=0,cookie=0x0000000000002021,actions=meter:1,resubmit(,30)",
+				"priority=300,ipv6,nw_ttl=1,dl_dst=00:00:00:00:20:15,table=21,idle_timeout=0,cookie=0x0000000000002021,actions=meter:1,resubmit(,30)",
+			},
+		},
+		{
+			desc:     "no route",
+			table:    TableRib,
+			priority: 1,
+			want: []string{
+				"priority=1,ip,dl_dst=00:00:00:00:20:15,table=19,idle_timeout=0,cookie=0x0000000000002021,actions=meter:1,resubmit(,30)",
+			},
+		},
+		{
+			desc:     "no ipv6 route",
+			table:    TableRib6,
+			priority: 1,
+			want: []string{
+				"priority=1,ipv6,dl_dst=00:00:00:00:20:15,table=21,idle_timeout=0,cookie=0x0000000000002021,actions=meter:1,resubmit(,30)",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if want, got := tt.want, tableFlows(t, a, tt.table, tt.priority); !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected flows:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
+
+func TestComposerMeters(t *testing.T) {
+	var meters []string
+	a := newTestComposer(t,
+		ovs.Exec(func(cmd string, args ...string) ([]byte, error) {
+			if args[0] == "add-meter" {
+				meters = append(meters, args[2])
+			}
+			return nil, nil
+		}),
+	)
+	if err := a.setup(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{
+		"meter=1,pktps,burst,band=type=drop,rate=100,burst_size=20",
+		"meter=2,pktps,burst,band=type=drop,rate=100,burst_size=20",
+	}
+	if got := meters; !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected meters:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+func TestComposerIPv6(t *testing.T) {
+	tests := []struct {
+		desc string
+		add  func(a *Composer) error
+		want []string
+	}{
+		{
+			desc: "ipv4 host",
+			add: func(a *Composer) error {
+				return a.AddHost(0, "192.168.1.10", "00:11:22:33:44:55", "vlan10")
+			},
+			want: []string{
+				"priority=100,ip,metadata=0x0,reg0=0xc0a8010a,dl_dst=00:00:00:00:20:15,table=20,idle_timeout=0,actions=push:NXM_OF_ETH_DST,pop:NXM_OF_ETH_SRC,load:0x001122334455->NXM_OF_ETH_DST,load:0xa->NXM_OF_VLAN_TCI,load:0x800a->NXM_OF_IN_PORT,dec_ttl,resubmit(,30)",
+			},
+		},
+		{
+			desc: "ipv6 host",
+			add: func(a *Composer) error {
+				return a.AddHost(0, "fd00::10", "00:11:22:33:44:55", "vlan10")
+			},
+			want: []string{
+				"priority=100,ipv6,metadata=0x0,xxreg3=0xfd000000000000000000000000000010,dl_dst=00:00:00:00:20:15,table=22,idle_timeout=0,actions=push:NXM_OF_ETH_DST,pop:NXM_OF_ETH_SRC,load:0x001122334455->NXM_OF_ETH_DST,load:0xa->NXM_OF_VLAN_TCI,load:0x800a->NXM_OF_IN_PORT,dec_ttl,resubmit(,30)",
+			},
+		},
+		{
+			desc: "ipv4 route",
+			add: func(a *Composer) error {
+				return a.AddRoute(0, "10.1.0.0/16", "192.168.1.254", "vlan10")
+			},
+			want: []string{
+				"priority=116,ip,metadata=0x0,nw_dst=10.1.0.0/16,dl_dst=00:00:00:00:20:15,table=19,idle_timeout=0,actions=load:0xc0a801fe->reg0,resubmit(,20)",
+			},
+		},
+		{
+			desc: "ipv6 route",
+			add: func(a *Composer) error {
+				return a.AddRoute(0, "fd01::/48", "fd00::1", "vlan10")
+			},
+			want: []string{
+				"priority=148,ipv6,metadata=0x0,ipv6_dst=fd01::/48,dl_dst=00:00:00:00:20:15,table=21,idle_timeout=0,actions=load:0xfd000000000000000000000000000001->xxreg3,resubmit(,22)",
+			},
+		},
+		{
+			desc: "ipv6 connected route",
+			add: func(a *Composer) error {
+				return a.AddRoute(0, "fd00::/64", "<nil>", "vlan10")
+			},
+			want: []string{
+				"priority=164,ipv6,metadata=0x0,ipv6_dst=fd00::/64,dl_dst=00:00:00:00:20:15,table=21,idle_timeout=0,actions=push:NXM_NX_IPV6_DST,pop:xxreg3,resubmit(,22)",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if want, got := tt.want, addedFlows(t, newTestComposer(t), tt.add); !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected flows:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
+
+func TestComposerND(t *testing.T) {
+	a := newTestComposer(t)
+	a.pipeline()
+
+	// Neighbor discovery is left to the kernel, whatever the VLAN.
+	want := []string{
+		"priority=110,icmp6,icmpv6_type=133,table=0,idle_timeout=0,cookie=0x0000000000002021,actions=normal",
+		"priority=110,icmp6,icmpv6_type=134,table=0,idle_timeout=0,cookie=0x0000000000002021,actions=normal",
+		"priority=110,icmp6,icmpv6_type=135,table=0,idle_timeout=0,cookie=0x0000000000002021,actions=normal",
+		"priority=110,icmp6,icmpv6_type=136,table=0,idle_timeout=0,cookie=0x0000000000002021,actions=normal",
+	}
+	if got := tableFlows(t, a, TableIn, 110); !reflect.DeepEqual(want, got) {
+		t.Fatalf("unexpected flows:\n- want: %v\n-  got: %v", want, got)
+	}
+}
+
+// vsctlPorts fakes the ports of br0 in 'ovs-vsctl', as their tag and
+// ofport by name.
+func vsctlPorts(ports map[string][2]int) ovs.OptionFunc {
+	return ovs.Exec(func(cmd string, args ...string) ([]byte, error) {
+		if cmd != "ovs-vsctl" {
+			return nil, nil
+		}
+		name := args[len(args)-1]
+		switch {
+		case slices.Contains(args, "list-ports"):
+			names := slices.Sorted(maps.Keys(ports))
+			return []byte(strings.Join(names, "\n") + "\n"), nil
+		case slices.Contains(args, "port"):
+			return []byte(fmt.Sprintf("name : %s\ntag : %d\n", name, ports[name][0])), nil
+		case slices.Contains(args, "interface"):
+			return []byte(fmt.Sprintf("ofport : %d\n", ports[name][1])), nil
+		}
+		return nil, nil
+	})
+}
+
+func TestComposerVrf(t *testing.T) {
+	ports := vsctlPorts(map[string][2]int{
+		"eth1":   {10, 1},
+		"eth2":   {11, 2},
+		"vlan10": {10, 32778},
+	})
+	tests := []struct {
+		desc string
+		vrfs map[string]int
+		add  func(a *Composer) error
+		want []string
+	}{
+		{
+			desc: "bind",
+			add: func(a *Composer) error {
+				return a.SetVrf("vlan10", 3)
+			},
+			want: []string{
+				"priority=105,ip,dl_vlan=10,table=0,idle_timeout=0,actions=load:0x3->OXM_OF_METADATA[],resubmit(,19)",
+				"priority=105,ip,in_port=1,table=0,idle_timeout=0,actions=load:0x3->OXM_OF_METADATA[],resubmit(,19)",
+				"priority=105,ipv6,dl_vlan=10,table=0,idle_timeout=0,actions=load:0x3->OXM_OF_METADATA[],resubmit(,21)",
+				"priority=105,ipv6,in_port=1,table=0,idle_timeout=0,actions=load:0x3->OXM_OF_METADATA[],resubmit(,21)",
+			},
+		},
+		{
+			desc: "host",
+			vrfs: map[string]int{"vlan10": 3},
+			add: func(a *Composer) error {
+				return a.AddHost(3, "192.168.1.10", "00:11:22:33:44:55", "vlan10")
+			},
+			want: []string{
+				"priority=100,ip,metadata=0x3,reg0=0xc0a8010a,dl_dst=00:00:00:00:20:15,table=20,idle_timeout=0,actions=push:NXM_OF_ETH_DST,pop:NXM_OF_ETH_SRC,load:0x001122334455->NXM_OF_ETH_DST,load:0xa->NXM_OF_VLAN_TCI,load:0x800a->NXM_OF_IN_PORT,dec_ttl,resubmit(,30)",
+			},
+		},
+		{
+			desc: "route",
+			vrfs: map[string]int{"vlan10": 3},
+			add: func(a *Composer) error {
+				return a.AddRoute(3, "10.1.0.0/16", "192.168.1.254", "vlan10")
+			},
+			want: []string{
+				"priority=116,ip,metadata=0x3,nw_dst=10.1.0.0/16,dl_dst=00:00:00:00:20:15,table=19,idle_timeout=0,actions=load:0xc0a801fe->reg0,resubmit(,20)",
+			},
+		},
+		{
+			// The egress interface is in the main table.
+			desc: "leaked route",
+			add: func(a *Composer) error {
+				return a.AddRoute(3, "10.1.0.0/16", "192.168.1.254", "vlan10")
+			},
+			want: []string{
+				"priority=116,ip,metadata=0x3,nw_dst=10.1.0.0/16,dl_dst=00:00:00:00:20:15,table=19,idle_timeout=0,actions=load:0x0->OXM_OF_METADATA[],load:0xc0a801fe->reg0,resubmit(,20)",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			a := newTestComposer(t, ports)
+			maps.Copy(a.vrfs, tt.vrfs)
+			if want, got := tt.want, addedFlows(t, a, tt.add); !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected flows:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+
+	t.Run("unbind", func(t *testing.T) {
+		a := newTestComposer(t, ports)
+		if err := a.SetVrf("vlan10", 3); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := a.SetVrf("vlan10", 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := tableFlows(t, a, TableIn, 105); len(got) != 0 {
+			t.Fatalf("unexpected flows: %v", got)
+		}
+	})
+}
+
+func TestComposerRules(t *testing.T) {
+	ports := vsctlPorts(map[string][2]int{
+		"eth1":   {10, 1},
+		"vlan10": {10, 32778},
+	})
+	tests := []struct {
+		desc  string
+		vrfs  map[string]int
+		rules []PolicyRule
+		want  []string
+	}{
+		{
+			// The source before SNAT is in reg3.
+			desc: "source",
+			rules: []PolicyRule{
+				{Priority: 100, Family: FamilyV4, Src: "192.168.2.0/24", Table: 100},
+			},
+			want: []string{
+				"priority=2,ip,metadata=0x64,table=19,idle_timeout=0,actions=load:0x0->OXM_OF_METADATA[],resubmit(,19)",
+				"priority=32668,ip,reg3=0xc0a80200/0xffffff00,table=15,idle_timeout=0,actions=load:0x64->OXM_OF_METADATA[],resubmit(,19)",
+			},
+		},
+		{
+			desc: "destination from vlan10",
+			rules: []PolicyRule{
+				{Priority: 200, Family: FamilyV4, Dst: "10.1.0.0/16", Iif: "vlan10", Table: 100},
+			},
+			want: []string{
+				"priority=2,ip,metadata=0x64,table=19,idle_timeout=0,actions=load:0x0->OXM_OF_METADATA[],resubmit(,19)",
+				"priority=32568,ip,dl_vlan=10,nw_dst=10.1.0.0/16,table=15,idle_timeout=0,actions=load:0x64->OXM_OF_METADATA[],resubmit(,19)",
+				"priority=32568,ip,in_port=1,nw_dst=10.1.0.0/16,table=15,idle_timeout=0,actions=load:0x64->OXM_OF_METADATA[],resubmit(,19)",
+			},
+		},
+		{
+			desc: "fwmark and tos",
+			rules: []PolicyRule{
+				{Priority: 300, Family: FamilyV4, Mark: 0x1, Mask: 0xff, Tos: 0x10, Table: 100},
+			},
+			want: []string{
+				"priority=2,ip,metadata=0x64,table=19,idle_timeout=0,actions=load:0x0->OXM_OF_METADATA[],resubmit(,19)",
+				"priority=32468,ip,pkt_mark=0x1/0xff,ip_dscp=4,table=15,idle_timeout=0,actions=load:0x64->OXM_OF_METADATA[],resubmit(,19)",
+			},
+		},
+		{
+			desc: "ipv6 source",
+			rules: []PolicyRule{
+				{Priority: 100, Family: FamilyV6, Src: "fd00::/64", Table: 100},
+			},
+			want: []string{
+				"priority=2,ipv6,metadata=0x64,table=21,idle_timeout=0,actions=load:0x0->OXM_OF_METADATA[],resubmit(,21)",
+				"priority=32668,ipv6,ipv6_src=fd00::/64,table=15,idle_timeout=0,actions=load:0x64->OXM_OF_METADATA[],resubmit(,21)",
+			},
+		},
+		{
+			// The table of a VRF has no fallback to the main one.
+			desc: "vrf table",
+			vrfs: map[string]int{"vlan11": 100},
+			rules: []PolicyRule{
+				{Priority: 100, Family: FamilyV4, Src: "192.168.2.0/24", Table: 100},
+			},
+			want: []string{
+				"priority=32668,ip,reg3=0xc0a80200/0xffffff00,table=15,idle_timeout=0,actions=load:0x64->OXM_OF_METADATA[],resubmit(,19)",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			a := newTestComposer(t, ports)
+			maps.Copy(a.vrfs, tt.vrfs)
+			add := func(a *Composer) error {
+				return a.SetRules(tt.rules)
+			}
+			if want, got := tt.want, addedFlows(t, a, add); !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected flows:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
+
+func TestComposerReject(t *testing.T) {
+	tests := []struct {
+		desc  string
+		vrf   int
+		ipdst IPPrefix
+		typ   string
+		want  []string
+	}{
+		{
+			desc:  "blackhole",
+			ipdst: "10.2.0.0/16",
+			typ:   RouteBlackhole,
+			want: []string{
+				"priority=116,ip,metadata=0x0,nw_dst=10.2.0.0/16,dl_dst=00:00:00:00:20:15,table=19,idle_timeout=0,actions=drop",
+			},
+		},
+		{
+			// The kernel answers with the ICMP destination unreachable.
+			desc:  "unreachable",
+			ipdst: "10.3.0.0/16",
+			typ:   RouteUnreachable,
+			want: []string{
+				"priority=116,ip,metadata=0x0,nw_dst=10.3.0.0/16,dl_dst=00:00:00:00:20:15,table=19,idle_timeout=0,actions=meter:1,resubmit(,30)",
+			},
+		},
+		{
+			desc:  "ipv6 prohibit in a vrf",
+			vrf:   3,
+			ipdst: "fd02::/48",
+			typ:   RouteProhibit,
+			want: []string{
+				"priority=148,ipv6,metadata=0x3,ipv6_dst=fd02::/48,dl_dst=00:00:00:00:20:15,table=21,idle_timeout=0,actions=meter:1,resubmit(,30)",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			add := func(a *Composer) error {
+				return a.AddReject(tt.vrf, tt.ipdst, tt.typ)
+			}
+			a := newTestComposer(t)
+			if want, got := tt.want, addedFlows(t, a, add); !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected flows:\n- want: %v\n-  got: %v", want, got)
+			}
+			if err := a.DelReject(tt.vrf, tt.ipdst); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if want, got := 0, len(a.flows); want != got {
+				t.Fatalf("unexpected flows:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
+
+func TestComposerNexthop(t *testing.T) {
+	tests := []struct {
+		desc   string
+		add    func(a *Composer) error
+		want   []string
+		groups []string
+	}{
+		{
+			// An empty group drops packets until the object is known.
+			desc: "route before its object",
+			add: func(a *Composer) error {
+				return a.AddNexthopRoute(0, "10.1.0.0/16", 1)
+			},
+			want: []string{
+				"priority=116,ip,metadata=0x0,nw_dst=10.1.0.0/16,dl_dst=00:00:00:00:20:15,table=19,idle_timeout=0,actions=group:1",
+			},
+			groups: []string{
+				"group_id=1,type=select",
+			},
+		},
+		{
+			desc: "object",
+			add: func(a *Composer) error {
+				if err := a.AddNexthop(1, []NextHop{
+					{Gw: "192.168.10.254", Port: "vlan10", Weight: 1},
+				}); err != nil {
+					return err
+				}
+				return a.AddNexthopRoute(0, "10.1.0.0/16", 1)
+			},
+			want: []string{
+				"priority=116,ip,metadata=0x0,nw_dst=10.1.0.0/16,dl_dst=00:00:00:00:20:15,table=19,idle_timeout=0,actions=group:1",
+			},
+			groups: []string{
+				"group_id=1,type=select,selection_method=hash,fields(ip_src,ip_dst,nw_proto,tcp_src,tcp_dst,udp_src,udp_dst),bucket=weight:1,actions=load:0x0->OXM_OF_METADATA[],load:0xc0a80afe->reg0,resubmit(,20)",
+			},
+		},
+		{
+			desc: "group object in a vrf",
+			add: func(a *Composer) error {
+				// Each bucket loads the VRF of its interface.
+				a.vrfs["vlan11"] = 3
+				if err := a.AddNexthop(1, []NextHop{
+					{Gw: "192.168.10.254", Port: "vlan10", Weight: 1},
+					{Gw: "192.168.11.254", Port: "vlan11", Weight: 2},
+				}); err != nil {
+					return err
+				}
+				return a.AddNexthopRoute(3, "10.1.0.0/16", 1)
+			},
+			want: []string{
+				"priority=116,ip,metadata=0x3,nw_dst=10.1.0.0/16,dl_dst=00:00:00:00:20:15,table=19,idle_timeout=0,actions=group:1",
+			},
+			groups: []string{
+				"group_id=1,type=select,selection_method=hash,fields(ip_src,ip_dst,nw_proto,tcp_src,tcp_dst,udp_src,udp_dst),bucket=weight:1,actions=load:0x0->OXM_OF_METADATA[],load:0xc0a80afe->reg0,resubmit(,20),bucket=weight:2,actions=load:0x3->OXM_OF_METADATA[],load:0xc0a80bfe->reg0,resubmit(,20)",
+			},
+		},
+		{
+			desc: "ipv6 object",
+			add: func(a *Composer) error {
+				if err := a.AddNexthop(1, []NextHop{
+					{Gw: "fd00::1", Port: "vlan10", Weight: 1},
+				}); err != nil {
+					return err
+				}
+				return a.AddNexthopRoute(0, "fd01::/48", 1)
+			},
+			want: []string{
+				"priority=148,ipv6,metadata=0x0,ipv6_dst=fd01::/48,dl_dst=00:00:00:00:20:15,table=21,idle_timeout=0,actions=group:1",
+			},
+			groups: []string{
+				"group_id=1,type=select,selection_method=hash,fields(ipv6_src,ipv6_dst,nw_proto,tcp_src,tcp_dst,udp_src,udp_dst),bucket=weight:1,actions=load:0x0->OXM_OF_METADATA[],load:0xfd000000000000000000000000000001->xxreg3,resubmit(,22)",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			groups := make(map[string]string)
+			a := newTestComposer(t, ovs.Exec(func(cmd string, args ...string) ([]byte, error) {
+				if len(args) > 2 && (args[0] == "add-group" || args[0] == "mod-group") {
+					id, _, _ := strings.Cut(args[2], ",")
+					groups[id] = args[2]
+				}
+				return nil, nil
+			}))
+			if want, got := tt.want, addedFlows(t, a, tt.add); !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected flows:\n- want: %v\n-  got: %v", want, got)
+			}
+			if want, got := tt.groups, slices.Sorted(maps.Values(groups)); !reflect.DeepEqual(want, got) {
+				t.Fatalf("unexpected groups:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}
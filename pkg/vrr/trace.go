@@ -0,0 +1,185 @@
+package vrr
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/luscis/openvrr/pkg/ovs"
+	"github.com/luscis/openvrr/pkg/schema"
+)
+
+// Stages of the pipeline by their tables.
+var stages = map[int]string{
+	TableIn:    "IN",
+	TableCt:    "CT",
+	TableNat:   "NAT",
+	TablePbr:   "PBR",
+	TableRib:   "RIB",
+	TableRib6:  "RIB",
+	TableFib:   "FIB",
+	TableFib6:  "FIB",
+	TableFdb:   "FDB",
+	TableWatch: "WATCH",
+}
+
+// Names of the kinds of objects owning flows.
+var kinds = map[uint64]string{
+	KindRoute:  "route",
+	KindHost:   "host",
+	KindLocal:  "local",
+	KindSNAT:   "snat",
+	KindDNAT:   "dnat",
+	KindQuota:  "quota",
+	KindStatic: "staticnat",
+}
+
+// packet returns the protocol and matches of a packet received on a port
+// and sent to the gateway.
+func (a *Composer) packet(data schema.Packet) (ovs.Protocol, []ovs.Match, error) {
+	src, dst := IPAddr(data.Source), IPAddr(data.Dest)
+	if src.Hex() == "" || dst.Hex() == "" {
+		return "", nil, fmt.Errorf("invalid source %q or destination %q", data.Source, data.Dest)
+	}
+	if src.IsV6() != dst.IsV6() {
+		return "", nil, fmt.Errorf("source %s and destination %s of different families", src, dst)
+	}
+	family := dst.Family()
+
+	protocol := family.Protocol
+	switch data.Protocol {
+	case "", "ip":
+	case "tcp":
+		protocol = ovs.ProtocolTCPv4
+		if dst.IsV6() {
+			protocol = ovs.ProtocolTCPv6
+		}
+	case "udp":
+		protocol = ovs.ProtocolUDPv4
+		if dst.IsV6() {
+			protocol = ovs.ProtocolUDPv6
+		}
+	case "icmp":
+		protocol = ovs.ProtocolICMPv4
+		if dst.IsV6() {
+			protocol = ovs.ProtocolICMPv6
+		}
+	default:
+		return "", nil, fmt.Errorf("unknown protocol %q", data.Protocol)
+	}
+
+	port, err := a.vsctl.Get.Port(data.InPort)
+	if err != nil {
+		return "", nil, err
+	}
+	matches := []ovs.Match{
+		ovs.InPortMatch(port.OfPort),
+		ovs.DataLinkDestination(DefaultVlanMac),
+	}
+	if data.Vlan > 0 {
+		matches = append(matches, ovs.DataLinkVLAN(data.Vlan))
+	}
+	matches = append(matches, family.Source(src.Str()), family.Destination(dst.Str()))
+	if data.DestPort > 0 {
+		if data.Protocol != "tcp" && data.Protocol != "udp" {
+			return "", nil, fmt.Errorf("destination port without tcp or udp")
+		}
+		matches = append(matches, ovs.TransportDestinationPort(uint16(data.DestPort)))
+	}
+	return protocol, matches, nil
+}
+
+// Trace runs a packet through the pipeline with ofproto/trace.
+func (a *Composer) Trace(data schema.Packet) (*ovs.ProtoTrace, error) {
+	protocol, matches, err := a.packet(data)
+	if err != nil {
+		return nil, err
+	}
+	return a.client.App.ProtoTrace(a.brname, protocol, matches)
+}
+
+// natRule describes the NAT rule of a key in others.
+func (a *Composer) natRule(key string) string {
+	value := a.others[key]
+	if short, found := strings.CutPrefix(key, "snat-"); found {
+		data := snatRule(short, value)
+		to := data.SourceTo
+		if data.Interface != "" {
+			to = "interface " + data.Interface
+		}
+		if data.Ports != "" {
+			to += " ports " + data.Ports
+		}
+		return fmt.Sprintf("snat %s to %s", data.Source, to)
+	}
+	if external, found := strings.CutPrefix(key, "staticnat-"); found {
+		return fmt.Sprintf("staticnat %s <-> %s", external, value)
+	}
+	if short, found := strings.CutPrefix(key, "dnat-"); found {
+		data := dnatRule(short, value)
+		to := data.DestTo
+		if len(data.Backends) > 0 {
+			var addrs []string
+			for _, backend := range data.Backends {
+				addrs = append(addrs, backend.Address)
+			}
+			to = strings.Join(addrs, " ")
+		}
+		return fmt.Sprintf("dnat %s %s to %s", data.Protocol, data.Dest, to)
+	}
+	return ""
+}
+
+// ownerKey returns the key in the forward table of the route or host
+// owning a flow, its cookie key ends with the port or source address.
+func ownerKey(key string) string {
+	if i := strings.LastIndex(key, "-"); i > 0 {
+		return key[:i]
+	}
+	return key
+}
+
+// Trace reports the stage of each flow a packet hits in the pipeline, and
+// the route, neighbor and NAT rule owning those flows.
+func (v *Gateway) Trace(data schema.Packet) (schema.Trace, error) {
+	trace := schema.Trace{Packet: data}
+	pt, err := v.scomo.Trace(data)
+	if err != nil {
+		return trace, err
+	}
+
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+
+	for _, step := range pt.Steps {
+		item := schema.TraceStep{
+			Stage:    stages[step.Table],
+			Table:    step.Table,
+			Priority: step.Priority,
+			Match:    step.Match,
+			Actions:  step.Actions,
+		}
+		kind, key := v.scomo.cookies.Owner(step.Cookie)
+		if key != "" {
+			item.Owner = kinds[kind] + " " + key
+		}
+		switch {
+		case key == "":
+		case kind == KindRoute:
+			if value, ok := v.forward[ownerKey(key)]; ok {
+				value = v.expand(value)
+				trace.Route = &value
+			}
+		case kind == KindHost:
+			if value, ok := v.forward[ownerKey(key)]; ok && value.LLAddr != "" {
+				trace.Neighbor = &value
+			}
+		case kind == KindSNAT, kind == KindDNAT, kind == KindStatic:
+			trace.NAT = v.scomo.natRule(key)
+		}
+		trace.Steps = append(trace.Steps, item)
+	}
+	if actions, ok := pt.DataPathActions.(fmt.Stringer); ok {
+		trace.Actions = actions.String()
+	}
+	return trace, nil
+}
@@ -0,0 +1,88 @@
+package vrr
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/luscis/openvrr/pkg/schema"
+)
+
+func TestComposerPacket(t *testing.T) {
+	tests := []struct {
+		desc string
+		data schema.Packet
+		want string
+		err  bool
+	}{
+		{
+			desc: "ip on an access port",
+			data: schema.Packet{InPort: "eth1", Source: "192.168.1.10", Dest: "10.1.0.1"},
+			want: "ip,in_port=1,dl_dst=00:00:00:00:20:15,nw_src=192.168.1.10,nw_dst=10.1.0.1",
+		},
+		{
+			desc: "tcp on a trunk",
+			data: schema.Packet{InPort: "eth2", Vlan: 10, Source: "192.168.1.10", Dest: "10.1.0.1", Protocol: "tcp", DestPort: 80},
+			want: "tcp,in_port=2,dl_dst=00:00:00:00:20:15,dl_vlan=10,nw_src=192.168.1.10,nw_dst=10.1.0.1,tp_dst=80",
+		},
+		{
+			desc: "ipv6 udp",
+			data: schema.Packet{InPort: "eth1", Source: "fd00::10", Dest: "fd01::1", Protocol: "udp", DestPort: 53},
+			want: "udp6,in_port=1,dl_dst=00:00:00:00:20:15,ipv6_src=fd00::10,ipv6_dst=fd01::1,tp_dst=53",
+		},
+		{
+			desc: "icmp",
+			data: schema.Packet{InPort: "eth1", Source: "192.168.1.10", Dest: "10.1.0.1", Protocol: "icmp"},
+			want: "icmp,in_port=1,dl_dst=00:00:00:00:20:15,nw_src=192.168.1.10,nw_dst=10.1.0.1",
+		},
+		{
+			desc: "invalid destination",
+			data: schema.Packet{InPort: "eth1", Source: "192.168.1.10", Dest: "10.1.0"},
+			err:  true,
+		},
+		{
+			desc: "families mixed",
+			data: schema.Packet{InPort: "eth1", Source: "192.168.1.10", Dest: "fd01::1"},
+			err:  true,
+		},
+		{
+			desc: "unknown protocol",
+			data: schema.Packet{InPort: "eth1", Source: "192.168.1.10", Dest: "10.1.0.1", Protocol: "sctp"},
+			err:  true,
+		},
+		{
+			desc: "port without tcp or udp",
+			data: schema.Packet{InPort: "eth1", Source: "192.168.1.10", Dest: "10.1.0.1", DestPort: 80},
+			err:  true,
+		},
+	}
+
+	a := newTestComposer(t, vsctlPorts(map[string][2]int{
+		"eth1": {10, 1},
+		"eth2": {0, 2},
+	}))
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			protocol, matches, err := a.packet(tt.data)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := []string{string(protocol)}
+			for _, match := range matches {
+				b, err := match.MarshalText()
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				got = append(got, string(b))
+			}
+			if want, got := tt.want, strings.Join(got, ","); want != got {
+				t.Fatalf("unexpected packet:\n- want: %v\n-  got: %v", want, got)
+			}
+		})
+	}
+}